@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
@@ -14,6 +15,9 @@ import (
 
 func main() {
 	configPath := "./config.toml"
+	backupPath := flag.String("backup", "", "write an online backup of the database to this file and exit")
+	restorePath := flag.String("restore", "", "restore the database from this backup file and exit (stop the server first)")
+	flag.Parse()
 
 	// Initialize basic logger for startup
 	logger := logrus.New()
@@ -39,6 +43,22 @@ func main() {
 	}
 	defer db.Close()
 
+	// One-shot maintenance commands
+	if *backupPath != "" {
+		if err := db.Backup(*backupPath); err != nil {
+			logger.WithError(err).Fatal("Backup failed")
+		}
+		logger.WithField("backup", *backupPath).Info("Backup written")
+		return
+	}
+	if *restorePath != "" {
+		if err := db.Restore(*restorePath); err != nil {
+			logger.WithError(err).Fatal("Restore failed")
+		}
+		logger.WithField("backup", *restorePath).Info("Database restored")
+		return
+	}
+
 	// Create and configure the music server
 	musicServer, err := server.NewMusicServer(cfg, db)
 	if err != nil {
@@ -52,10 +72,10 @@ func main() {
 
 	// Check track count and warn if empty
 	if cfg.Music.ScanOnStartup {
-		tracks, err := db.GetAllTracks()
+		count, err := db.CountTracks(database.StatsScope{All: true})
 		if err != nil {
 			logger.WithError(err).Warn("Could not get track count")
-		} else if len(tracks) == 0 {
+		} else if count == 0 {
 			logger.WithField("supported_formats", cfg.Music.SupportedFormats).Warn("No supported audio files found in music directory")
 		}
 	}
@@ -332,6 +332,25 @@ func (s *Service) handleUserDeletion(username string) {
 	s.logger.WithField("username", username).Info("Invalidated all sessions for deleted user")
 }
 
+// IsAdmin reports whether the given user has the admin role. When
+// authentication is disabled every caller is treated as an administrator.
+func (s *Service) IsAdmin(username string) bool {
+	if !s.enabled {
+		return true
+	}
+	user := s.userStore.GetUser(username)
+	return user != nil && user.Role == "admin"
+}
+
+// UserExists reports whether username names a user. With auth disabled
+// there are no accounts to check, so every name is accepted.
+func (s *Service) UserExists(username string) bool {
+	if !s.enabled {
+		return true
+	}
+	return s.userStore.GetUser(username) != nil
+}
+
 // GetUserFolderManager returns the user folder manager
 func (s *Service) GetUserFolderManager() *UserFolderManager {
 	return s.userFolderManager
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"staccato/internal/metadata"
+
 	"github.com/BurntSushi/toml"
 )
 
@@ -35,6 +38,12 @@ type ServerConfig struct {
 type DatabaseConfig struct {
 	Path           string `toml:"path"`
 	MaxConnections int    `toml:"max_connections"`
+
+	// BackupDir receives online backups. BackupIntervalHours > 0 writes one
+	// on a schedule; BackupRetention is how many to keep (0 keeps all).
+	BackupDir           string `toml:"backup_dir"`
+	BackupIntervalHours int    `toml:"backup_interval_hours"`
+	BackupRetention     int    `toml:"backup_retention"`
 }
 
 // MusicConfig contains music library configuration.
@@ -43,6 +52,47 @@ type MusicConfig struct {
 	SupportedFormats []string `toml:"supported_formats"`
 	WatchForChanges  bool     `toml:"watch_for_changes"`
 	ScanOnStartup    bool     `toml:"scan_on_startup"`
+
+	// Fingerprinting enables the background acoustic fingerprint job used for
+	// near-duplicate detection. Off by default: it decodes every track.
+	Fingerprinting     bool `toml:"fingerprinting"`
+	FingerprintWorkers int  `toml:"fingerprint_workers"`
+
+	// ScanIOWorkers is how many files a library scan reads concurrently,
+	// independent of the CPU-bound parsing workers (one per CPU). Lower it
+	// for spinning disks, raise it for high-latency network mounts.
+	ScanIOWorkers int `toml:"scan_io_workers"`
+
+	// TrashRetentionDays is how long deleted tracks stay in a library's
+	// .trash directory before they are purged (0 keeps them until purged by
+	// hand).
+	TrashRetentionDays int `toml:"trash_retention_days"`
+
+	// PathTemplates infer missing tags from file paths, tried in order, e.g.
+	// "{artist}/{album}/{track} - {title}".
+	PathTemplates []string `toml:"path_templates"`
+
+	// OrganizeTemplate names files when the library is organized, e.g.
+	// "{albumartist}/{year} - {album}/{disc}{track:02} {title}".
+	OrganizeTemplate string `toml:"organize_template"`
+
+	// ArtistSeparators split artist, composer and remixer tags naming several
+	// artists; FeaturingPatterns are the words introducing featured artists,
+	// as in "Artist feat. Guest". Each credited artist is browsable on its
+	// own, while the tag is still displayed as written.
+	ArtistSeparators  []string `toml:"artist_separators"`
+	FeaturingPatterns []string `toml:"featuring_patterns"`
+
+	// SortArticles are leading words ignored when ordering names without a
+	// sort tag, so "The Beatles" sorts under B.
+	SortArticles []string `toml:"sort_articles"`
+
+	// SyncPlaylistFiles mirrors M3U/M3U8, PLS and XSPF files found in the
+	// library as read-only playlists, kept in sync by scans and the watcher.
+	// It is off by default since those of the main library are public.
+	// PlaylistWriteBack makes them editable, writing edits back to the file.
+	SyncPlaylistFiles bool `toml:"sync_playlist_files"`
+	PlaylistWriteBack bool `toml:"playlist_write_back"`
 }
 
 // LoggingConfig contains logging configuration.
@@ -98,14 +148,24 @@ func DefaultConfig() *Config {
 			IdleTimeout:  120,
 		},
 		Database: DatabaseConfig{
-			Path:           "./staccato.db",
-			MaxConnections: 10,
+			Path:            "./staccato.db",
+			MaxConnections:  10,
+			BackupDir:       "./backups",
+			BackupRetention: 7,
 		},
 		Music: MusicConfig{
-			LibraryPath:      "./music",
-			SupportedFormats: []string{".flac", ".mp3", ".wav", ".m4a"},
-			WatchForChanges:  true,
-			ScanOnStartup:    true,
+			LibraryPath:        "./music",
+			SupportedFormats:   []string{".flac", ".mp3", ".wav", ".m4a"},
+			WatchForChanges:    true,
+			ScanOnStartup:      true,
+			Fingerprinting:     false,
+			FingerprintWorkers: 1,
+			ScanIOWorkers:      4,
+			TrashRetentionDays: 30,
+			OrganizeTemplate:   "{albumartist}/{year} - {album}/{disc}{track:02} {title}",
+			ArtistSeparators:   append([]string(nil), metadata.DefaultArtistSeparators...),
+			FeaturingPatterns:  append([]string(nil), metadata.DefaultFeaturingPatterns...),
+			SortArticles:       append([]string(nil), metadata.DefaultSortArticles...),
 		},
 		Logging: LoggingConfig{
 			Level:          "info",
@@ -231,6 +291,12 @@ func (c *Config) Validate() error {
 	if c.Database.MaxConnections < 1 {
 		return fmt.Errorf("database max connections must be at least 1")
 	}
+	if c.Database.BackupIntervalHours < 0 || c.Database.BackupRetention < 0 {
+		return fmt.Errorf("database backup interval and retention cannot be negative")
+	}
+	if c.Database.BackupIntervalHours > 0 && c.Database.BackupDir == "" {
+		return fmt.Errorf("database backup_dir is required for scheduled backups")
+	}
 
 	// Validate music config
 	if c.Music.LibraryPath == "" {
@@ -239,6 +305,40 @@ func (c *Config) Validate() error {
 	if len(c.Music.SupportedFormats) == 0 {
 		return fmt.Errorf("at least one supported audio format must be specified")
 	}
+	if c.Music.FingerprintWorkers < 0 {
+		return fmt.Errorf("fingerprint workers cannot be negative")
+	}
+	if c.Music.ScanIOWorkers < 0 {
+		return fmt.Errorf("scan I/O workers cannot be negative")
+	}
+	if c.Music.TrashRetentionDays < 0 {
+		return fmt.Errorf("trash retention days cannot be negative")
+	}
+	for _, template := range c.Music.PathTemplates {
+		if _, err := metadata.ParsePathTemplate(template); err != nil {
+			return err
+		}
+	}
+	for _, sep := range c.Music.ArtistSeparators {
+		if sep == "" {
+			return fmt.Errorf("artist separators cannot be empty")
+		}
+	}
+	for _, pattern := range c.Music.FeaturingPatterns {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("featuring patterns cannot be empty")
+		}
+	}
+	for _, article := range c.Music.SortArticles {
+		if strings.TrimSpace(article) == "" {
+			return fmt.Errorf("sort articles cannot be empty")
+		}
+	}
+	if c.Music.OrganizeTemplate != "" {
+		if _, err := metadata.ParseNamingTemplate(c.Music.OrganizeTemplate); err != nil {
+			return err
+		}
+	}
 
 	// Validate logging config
 	validLogLevels := map[string]bool{
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+)
+
+// roleOrder sorts an artist's roles, most prominent first.
+var roleOrder = map[string]int{
+	models.RoleMain:     0,
+	models.RoleFeatured: 1,
+	models.RoleRemixer:  2,
+	models.RoleComposer: 3,
+}
+
+// execQuerier is implemented by *sql.DB and *sql.Tx.
+type execQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// SetCreditParser sets how artist tags are split into track_artists rows.
+// Call before ingestion starts, then RebuildTrackArtists so existing tracks
+// are parsed the same way.
+func (db *Database) SetCreditParser(parser *metadata.CreditParser) {
+	db.credits = parser
+}
+
+// replaceTrackArtists reparses a track's credits from its tags, with any
+// override applied, and replaces its track_artists rows.
+func (db *Database) replaceTrackArtists(q execQuerier, trackID int) error {
+	if _, err := q.Exec("DELETE FROM track_artists WHERE track_id = ?", trackID); err != nil {
+		return err
+	}
+	track, err := scanTrack(q.QueryRow(`
+		SELECT `+trackColumns("")+`
+		FROM track_view WHERE id = ?`, trackID))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return insertTrackArtists(q, trackID, db.credits.Parse(track))
+}
+
+// insertTrackArtists writes credits for a track whose rows were cleared.
+func insertTrackArtists(q execQuerier, trackID int, credits []models.ArtistCredit) error {
+	for i, credit := range credits {
+		if _, err := q.Exec(`
+			INSERT OR IGNORE INTO track_artists (track_id, name, role, position)
+			VALUES (?, ?, ?, ?)`, trackID, credit.Name, credit.Role, i); err != nil {
+			return fmt.Errorf("failed to save artist credits for track %d: %w", trackID, err)
+		}
+	}
+	return nil
+}
+
+// RebuildTrackArtists reparses the credits of every track, e.g. after the
+// separators changed, and returns how many credits were stored.
+func (db *Database) RebuildTrackArtists() (int, error) {
+	tracks, err := db.queryTracks(`
+		SELECT ` + trackColumns("") + `
+		FROM track_view`)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM track_artists"); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, track := range tracks {
+		credits := db.credits.Parse(track)
+		if err := insertTrackArtists(tx, track.ID, credits); err != nil {
+			return 0, err
+		}
+		count += len(credits)
+	}
+	return count, tx.Commit()
+}
+
+// GetTrackArtists returns a track's credits in tag order.
+func (db *Database) GetTrackArtists(trackID int) ([]models.ArtistCredit, error) {
+	rows, err := db.conn.Query(`
+		SELECT name, role FROM track_artists
+		WHERE track_id = ?
+		ORDER BY position`, trackID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []models.ArtistCredit{}
+	for rows.Next() {
+		var c models.ArtistCredit
+		if err := rows.Scan(&c.Name, &c.Role); err != nil {
+			return nil, err
+		}
+		credits = append(credits, c)
+	}
+	return credits, rows.Err()
+}
+
+// GetArtists returns every artist credited on a track in the scope, in sort
+// name order. Names differing only in case are one artist.
+func (db *Database) GetArtists(scope StatsScope) ([]models.Artist, error) {
+	cond, args := scope.where()
+	rows, err := db.conn.Query(`
+		SELECT ta.name, COUNT(DISTINCT ta.track_id), GROUP_CONCAT(DISTINCT ta.role)
+		FROM track_artists ta
+		JOIN track_view ON track_view.id = ta.track_id
+		WHERE `+cond+`
+		GROUP BY ta.name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byName := map[string]models.Artist{}
+	for rows.Next() {
+		var a models.Artist
+		var roles string
+		if err := rows.Scan(&a.Name, &a.TrackCount, &roles); err != nil {
+			return nil, err
+		}
+		a.Roles = strings.Split(roles, ",")
+		sort.Slice(a.Roles, func(i, j int) bool { return roleOrder[a.Roles[i]] < roleOrder[a.Roles[j]] })
+		byName[a.Name] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(byName))
+	artists := make([]models.Artist, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names) // ties ("The X", "X") in a fixed order
+	db.sorter.SortStrings(names)
+	for _, name := range names {
+		artists = append(artists, byName[name])
+	}
+	return artists, nil
+}
+
+// GetTracksByArtist returns the tracks in the scope crediting name (in any
+// letter case), ordered by artist/album/track/title. A non-empty role only
+// matches credits with that role.
+func (db *Database) GetTracksByArtist(name, role string, scope StatsScope) ([]models.Track, error) {
+	cond, args := scope.where()
+	credit := "name = ?"
+	creditArgs := []interface{}{name}
+	if role != "" {
+		credit += " AND role = ?"
+		creditArgs = append(creditArgs, role)
+	}
+	return db.querySortedTracks(metadata.ByArtist, `
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE id IN (SELECT track_id FROM track_artists WHERE `+credit+`) AND `+cond, append(creditArgs, args...)...)
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SchemaVersion is stored in PRAGMA user_version once migrations have run.
+// Bump it whenever runMigrations gains a step.
+const SchemaVersion = 16
+
+const (
+	backupPrefix = "staccato-"
+	backupSuffix = ".db"
+)
+
+// ErrInvalidBackup is returned (wrapped) when a file cannot be restored.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// BackupInfo describes a backup file in the backup directory.
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Backup writes a consistent copy of the live database to destPath with
+// VACUUM INTO. It is safe while the server runs (including in WAL mode) and
+// fails if destPath exists.
+func (db *Database) Backup(destPath string) error {
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("backup destination %s already exists", destPath)
+	}
+	if _, err := db.conn.Exec("VACUUM INTO ?", destPath); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// BackupToDir writes a timestamped backup into dir, then deletes the oldest
+// backups beyond keep (0 keeps all).
+func (db *Database) BackupToDir(dir string, keep int) (BackupInfo, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s%s-%03d%s", backupPrefix, now.Format("20060102-150405"), now.Nanosecond()/int(time.Millisecond), backupSuffix)
+	path := filepath.Join(dir, name)
+	if err := db.Backup(path); err != nil {
+		return BackupInfo{}, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	if removed, err := PruneBackups(dir, keep); err != nil {
+		db.logger.WithError(err).Warn("Failed to prune old backups")
+	} else if len(removed) > 0 {
+		db.logger.WithField("removed", removed).Info("Pruned old backups")
+	}
+	return BackupInfo{Name: name, Size: st.Size(), CreatedAt: st.ModTime()}, nil
+}
+
+// IsBackupName reports whether name looks like a file written by
+// BackupToDir. Callers use it to reject paths from requests.
+func IsBackupName(name string) bool {
+	return filepath.Base(name) == name && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix)
+}
+
+// ListBackups returns the backups in dir, newest first. A missing directory
+// has no backups.
+func ListBackups(dir string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || !IsBackupName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{Name: entry.Name(), Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+	// Names embed the timestamp, so they sort chronologically
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// PruneBackups deletes all but the newest keep backups in dir and returns
+// the names removed. keep of 0 or less keeps everything.
+func PruneBackups(dir string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	backups, err := ListBackups(dir)
+	if err != nil || len(backups) <= keep {
+		return nil, err
+	}
+	var removed []string
+	for _, b := range backups[keep:] {
+		if err := os.Remove(filepath.Join(dir, b.Name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.Name)
+	}
+	return removed, nil
+}
+
+// ValidateBackup checks that path is an intact Staccato database this
+// version can restore and returns its schema version. Backups taken before
+// versions were recorded report 0 and are upgraded by the usual migrations.
+func ValidateBackup(path string) (int, error) {
+	header := make([]byte, 16)
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Read(header)
+	f.Close()
+	if err != nil || string(header) != "SQLite format 3\x00" {
+		return 0, fmt.Errorf("%w: not an SQLite database", ErrInvalidBackup)
+	}
+
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var check string
+	if err := conn.QueryRow("PRAGMA quick_check").Scan(&check); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, check)
+	}
+
+	var hasTracks bool
+	if err := conn.QueryRow(`SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'tracks'`).Scan(&hasTracks); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if !hasTracks {
+		return 0, fmt.Errorf("%w: not a Staccato database", ErrInvalidBackup)
+	}
+
+	var version int
+	if err := conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if version > SchemaVersion {
+		return version, fmt.Errorf("%w: schema version %d is newer than this server's %d", ErrInvalidBackup, version, SchemaVersion)
+	}
+	return version, nil
+}
+
+// Restore validates the backup at path and copies it over the live database
+// with SQLite's online backup API, so open connections see the restored data
+// without a restart. Migrations then run as at startup.
+func (db *Database) Restore(path string) error {
+	version, err := ValidateBackup(path)
+	if err != nil {
+		return err
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	ctx := context.Background()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	destConn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	err = destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			dest, ok1 := destDriver.(*sqlite3.SQLiteConn)
+			source, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return errors.New("unexpected sqlite driver connection")
+			}
+			backup, err := dest.Backup("main", source, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+
+	// Bring older backups up to date (idempotent for current ones)
+	if err := db.createTables(); err != nil {
+		return fmt.Errorf("failed to migrate restored database: %w", err)
+	}
+	// Credits are derived data; reparse them with this server's separators
+	if _, err := db.RebuildTrackArtists(); err != nil {
+		return fmt.Errorf("failed to rebuild artist credits: %w", err)
+	}
+	db.logger.WithField("backup", path).WithField("schema_version", version).Info("Database restored")
+	return nil
+}
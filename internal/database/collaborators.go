@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql"
+
+	"staccato/pkg/models"
+)
+
+// GetPlaylistCollaborators returns the users given a role on a playlist,
+// by name.
+func (db *Database) GetPlaylistCollaborators(playlistID int) ([]models.PlaylistCollaborator, error) {
+	rows, err := db.conn.Query(`
+		SELECT username, role, COALESCE(added_by, ''), added_at
+		FROM playlist_collaborators
+		WHERE playlist_id = ?
+		ORDER BY username`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collaborators []models.PlaylistCollaborator
+	for rows.Next() {
+		var c models.PlaylistCollaborator
+		if err := rows.Scan(&c.Username, &c.Role, &c.AddedBy, &c.AddedAt); err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, c)
+	}
+	return collaborators, rows.Err()
+}
+
+// GetPlaylistRole returns the role user was given on a playlist, or "" if
+// they are not a collaborator.
+func (db *Database) GetPlaylistRole(playlistID int, user string) (string, error) {
+	var role string
+	err := db.conn.QueryRow(`
+		SELECT role FROM playlist_collaborators
+		WHERE playlist_id = ? AND username = ?`, playlistID, user).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// GetPlaylistRoles returns the roles user was given, by playlist ID.
+func (db *Database) GetPlaylistRoles(user string) (map[int]string, error) {
+	rows, err := db.conn.Query(`
+		SELECT playlist_id, role FROM playlist_collaborators
+		WHERE username = ?`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var role string
+		if err := rows.Scan(&id, &role); err != nil {
+			return nil, err
+		}
+		roles[id] = role
+	}
+	return roles, rows.Err()
+}
+
+// SetPlaylistCollaborator gives user a role on a playlist, or changes the
+// role they have. addedBy is recorded when the user is first added.
+func (db *Database) SetPlaylistCollaborator(playlistID int, user, role, addedBy string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO playlist_collaborators (playlist_id, username, role, added_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (playlist_id, username) DO UPDATE SET role = excluded.role`,
+		playlistID, user, role, nullString(addedBy))
+	return err
+}
+
+// RemovePlaylistCollaborator takes user's role on a playlist away and
+// reports whether they had one.
+func (db *Database) RemovePlaylistCollaborator(playlistID int, user string) (bool, error) {
+	result, err := db.conn.Exec(`
+		DELETE FROM playlist_collaborators
+		WHERE playlist_id = ? AND username = ?`, playlistID, user)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveCollaborations takes every playlist role away from user, whose
+// account was deleted.
+func (db *Database) RemoveCollaborations(user string) error {
+	_, err := db.conn.Exec("DELETE FROM playlist_collaborators WHERE username = ?", user)
+	return err
+}
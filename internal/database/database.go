@@ -2,9 +2,13 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"staccato/internal/metadata"
 	"staccato/pkg/models"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -15,15 +19,13 @@ import (
 // interacting with the application's persistent store. It is safe for
 // concurrent use because the underlying *sql.DB is concurrency-safe.
 type Database struct {
-	conn   *sql.DB
-	logger *logrus.Logger
-
-	// Track if owner column exists (for handling migrations)
-	hasOwnerColumn bool
+	conn    *sql.DB
+	logger  *logrus.Logger
+	credits *metadata.CreditParser // splits artist tags into track_artists
+	sorter  *metadata.Sorter       // orders track listings
 
 	// Prepared statements for better performance
-	insertTrackStmt  *sql.Stmt
-	updateTrackStmt  *sql.Stmt
+	upsertTrackStmt  *sql.Stmt
 	getTrackByIDStmt *sql.Stmt
 	trackExistsStmt  *sql.Stmt
 	removeTrackStmt  *sql.Stmt
@@ -66,8 +68,10 @@ func NewDatabase(dbPath string) (*Database, error) {
 	}
 
 	db := &Database{
-		conn:   conn,
-		logger: logger,
+		conn:    conn,
+		logger:  logger,
+		credits: metadata.NewCreditParser(nil, nil),
+		sorter:  metadata.NewSorter(nil),
 	}
 
 	if err := db.createTables(); err != nil {
@@ -114,16 +118,7 @@ func (db *Database) createTables() error {
 	);`
 
 	// Create playlist_tracks junction table
-	playlistTracksTable := `
-	CREATE TABLE IF NOT EXISTS playlist_tracks (
-		playlist_id INTEGER,
-		track_id INTEGER,
-		position INTEGER,
-		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
-		FOREIGN KEY (track_id) REFERENCES tracks(id) ON DELETE CASCADE,
-		PRIMARY KEY (playlist_id, track_id)
-	);`
+	playlistTracksTable := playlistTracksSQL
 
 	// Create download_jobs table (for persistence of downloads)
 	downloadJobsTable := `
@@ -142,6 +137,92 @@ func (db *Database) createTables() error {
 		completed_at DATETIME
 	);`
 
+	// Create track_fingerprints table (acoustic fingerprints for duplicate detection)
+	fingerprintsTable := `
+	CREATE TABLE IF NOT EXISTS track_fingerprints (
+		track_id INTEGER PRIMARY KEY,
+		fingerprint BLOB,
+		file_size INTEGER NOT NULL,
+		error TEXT,
+		computed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	);`
+
+	// Create track_overrides table (metadata layered over file tags, survives rescans)
+	overridesTable := `
+	CREATE TABLE IF NOT EXISTS track_overrides (
+		track_id INTEGER PRIMARY KEY,
+		title TEXT,
+		artist TEXT,
+		album TEXT,
+		track_number INTEGER,
+		album_art_id TEXT,
+		album_art BLOB,
+		updated_by TEXT,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	);`
+
+	// Create track_plays table (how often and when tracks were streamed)
+	playsTable := `
+	CREATE TABLE IF NOT EXISTS track_plays (
+		track_id INTEGER PRIMARY KEY,
+		play_count INTEGER NOT NULL DEFAULT 0,
+		last_played DATETIME,
+		FOREIGN KEY (track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	);`
+
+	// Create track_artists table (individual artist credits parsed from tags)
+	trackArtistsTable := `
+	CREATE TABLE IF NOT EXISTS track_artists (
+		track_id INTEGER NOT NULL,
+		name TEXT NOT NULL COLLATE NOCASE,
+		role TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (track_id, role, name),
+		FOREIGN KEY (track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	);`
+
+	// Create trash table (deleted files kept in a library's .trash directory)
+	trashTable := `
+	CREATE TABLE IF NOT EXISTS trash (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		original_path TEXT NOT NULL,
+		trash_path TEXT NOT NULL UNIQUE,
+		owner TEXT,
+		title TEXT,
+		artist TEXT,
+		album TEXT,
+		file_size INTEGER,
+		deleted_by TEXT,
+		deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Create playlist_collaborators table (users given a role on a playlist)
+	collaboratorsTable := `
+	CREATE TABLE IF NOT EXISTS playlist_collaborators (
+		playlist_id INTEGER NOT NULL,
+		username TEXT NOT NULL,
+		role TEXT NOT NULL,
+		added_by TEXT,
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (playlist_id, username),
+		FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE
+	);`
+
+	// Create playlist_folders table (nested folders of one owner's playlists)
+	foldersTable := `
+	CREATE TABLE IF NOT EXISTS playlist_folders (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		parent_id INTEGER,
+		owner TEXT,
+		visibility TEXT,
+		position INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (parent_id) REFERENCES playlist_folders(id)
+	);`
+
 	// Create indices for better performance
 	indices := []string{
 		"CREATE INDEX IF NOT EXISTS idx_tracks_artist ON tracks(artist);",
@@ -154,9 +235,14 @@ func (db *Database) createTables() error {
 		"CREATE INDEX IF NOT EXISTS idx_playlist_tracks_position ON playlist_tracks(playlist_id, position);",
 		"CREATE INDEX IF NOT EXISTS idx_download_jobs_status ON download_jobs(status);",      // Status queries
 		"CREATE INDEX IF NOT EXISTS idx_download_jobs_created ON download_jobs(created_at);", // Time-based queries
+		"CREATE INDEX IF NOT EXISTS idx_track_overrides_art ON track_overrides(album_art_id);",
+		"CREATE INDEX IF NOT EXISTS idx_trash_deleted ON trash(deleted_at);",
+		"CREATE INDEX IF NOT EXISTS idx_track_artists_name ON track_artists(name);",
+		"CREATE INDEX IF NOT EXISTS idx_playlist_collaborators_user ON playlist_collaborators(username);",
+		"CREATE INDEX IF NOT EXISTS idx_playlist_folders_parent ON playlist_folders(parent_id);",
 	}
 
-	tables := []string{tracksTable, playlistsTable, playlistTracksTable, downloadJobsTable}
+	tables := []string{tracksTable, playlistsTable, playlistTracksTable, downloadJobsTable, fingerprintsTable, overridesTable, playsTable, trashTable, trackArtistsTable, collaboratorsTable, foldersTable}
 	for _, table := range tables {
 		if _, err := db.conn.Exec(table); err != nil {
 			return err
@@ -224,60 +310,300 @@ func (db *Database) runMigrations() error {
 		db.logger.Info("Added owner column and index to tracks table")
 	}
 
+	// Migration 3: Add cue sheet columns for virtual tracks (segments of a
+	// single-file album rip)
+	for _, col := range []struct{ name, def string }{
+		{"source_path", "TEXT"},
+		{"cue_start", "INTEGER DEFAULT 0"},
+		{"cue_end", "INTEGER DEFAULT 0"},
+	} {
+		if err = db.addColumnIfMissing("tracks", col.name, col.def); err != nil {
+			return err
+		}
+	}
+	if _, err = db.conn.Exec("CREATE INDEX IF NOT EXISTS idx_tracks_source_path ON tracks(source_path)"); err != nil {
+		return err
+	}
+
+	// Migration 5: Add disc number and year, used by the organizer's naming
+	// templates. Runs before the view is recreated so the view includes them
+	for _, col := range []string{"disc", "year"} {
+		if err = db.addColumnIfMissing("tracks", col, "INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+
+	// Migration 6: Add album artist and compilation flag, which key album
+	// grouping. Also runs before the view is recreated
+	if err = db.addColumnIfMissing("tracks", "album_artist", "TEXT"); err != nil {
+		return err
+	}
+	if err = db.addColumnIfMissing("tracks", "compilation", "BOOLEAN DEFAULT FALSE"); err != nil {
+		return err
+	}
+
+	// Migration 7: Add composer and remixer tags, parsed into track_artists
+	for _, col := range []string{"composer", "remixer"} {
+		if err = db.addColumnIfMissing("tracks", col, "TEXT"); err != nil {
+			return err
+		}
+	}
+
+	// Migration 8: Add sort tags, which order track lists
+	for _, col := range []string{"artist_sort", "album_artist_sort", "album_sort", "title_sort"} {
+		if err = db.addColumnIfMissing("tracks", col, "TEXT"); err != nil {
+			return err
+		}
+	}
+
+	// Migration 9: Add playlist owner and visibility. Playlists from before
+	// ownership stay visible to every user.
+	if err = db.addColumnIfMissing("playlists", "owner", "TEXT"); err != nil {
+		return err
+	}
+	if err = db.addColumnIfMissing("playlists", "visibility", "TEXT DEFAULT 'public'"); err != nil {
+		return err
+	}
+
+	// Migration 10: Give playlist entries their own IDs in place of the
+	// (playlist_id, track_id) primary key
+	if err = db.rebuildPlaylistTracks(); err != nil {
+		return err
+	}
+
+	// Migration 11: Link playlists mirrored from playlist files in the
+	// library to their file
+	if err = db.addColumnIfMissing("playlists", "file_path", "TEXT"); err != nil {
+		return err
+	}
+	if _, err = db.conn.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_playlists_file_path ON playlists(file_path)"); err != nil {
+		return err
+	}
+
+	// Migration 12: Add the genre tag, which smart playlist rules match on
+	if err = db.addColumnIfMissing("tracks", "genre", "TEXT"); err != nil {
+		return err
+	}
+
+	// Migration 13: Add smart playlist rules, stored as JSON
+	if err = db.addColumnIfMissing("playlists", "smart_rules", "TEXT"); err != nil {
+		return err
+	}
+
+	// Migration 14: Record who added each playlist entry
+	if err = db.addColumnIfMissing("playlist_tracks", "added_by", "TEXT"); err != nil {
+		return err
+	}
+
+	// Migration 15: File playlists in folders, ordered within them
+	if err = db.addColumnIfMissing("playlists", "folder_id", "INTEGER REFERENCES playlist_folders(id)"); err != nil {
+		return err
+	}
+	if err = db.addColumnIfMissing("playlists", "position", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err = db.conn.Exec("CREATE INDEX IF NOT EXISTS idx_playlists_folder ON playlists(folder_id)"); err != nil {
+		return err
+	}
+
+	// Migration 16: Store the codec, which the container alone doesn't tell
+	// (ALAC or AAC in .m4a, FLAC or Vorbis in .ogg)
+	if err = db.addColumnIfMissing("tracks", "codec", "TEXT"); err != nil {
+		return err
+	}
+
+	// Final step, run on every start after all migrations (it was migration
+	// 4 before later ones were added): (re)create track_view, which layers
+	// track_overrides over the values read from files. Read queries select
+	// from it; writes go to tracks. Recreating it picks up columns added by
+	// migrations.
+	if _, err = db.conn.Exec("DROP VIEW IF EXISTS track_view"); err != nil {
+		return err
+	}
+	if _, err = db.conn.Exec(trackViewSQL); err != nil {
+		return err
+	}
+
+	if _, err = db.conn.Exec("CREATE INDEX IF NOT EXISTS idx_tracks_album_artist ON tracks(album_artist, album)"); err != nil {
+		return err
+	}
+
+	// Record the schema version so backups can be checked before a restore
+	if _, err = db.conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// prepareStatements prepares commonly used SQL statements for better performance
-func (db *Database) prepareStatements() error {
-	var err error
+// rebuildPlaylistTracks copies a playlist_tracks table without entry IDs
+// into a new one, keeping entry order and dropping entries of deleted
+// playlists or tracks. SQLite cannot change a primary key in place.
+func (db *Database) rebuildPlaylistTracks() error {
+	var hasID bool
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('playlist_tracks')
+		WHERE name = 'id'`).Scan(&hasID)
+	if err != nil || hasID {
+		return err
+	}
 
-	// Check if owner column exists before preparing statements with it
-	err = db.conn.QueryRow(`
-		SELECT COUNT(*) > 0 
-		FROM pragma_table_info('tracks') 
-		WHERE name = 'owner'`).Scan(&db.hasOwnerColumn)
+	tx, err := db.conn.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to check for owner column: %w", err)
+		return err
 	}
-
-	// Insert track statement
-	if db.hasOwnerColumn {
-		db.insertTrackStmt, err = db.conn.Prepare(`
-			INSERT INTO tracks (title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, owner)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
-	} else {
-		db.insertTrackStmt, err = db.conn.Prepare(`
-			INSERT INTO tracks (title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	defer tx.Rollback()
+
+	statements := []string{
+		"ALTER TABLE playlist_tracks RENAME TO playlist_tracks_old",
+		playlistTracksSQL,
+		`INSERT INTO playlist_tracks (playlist_id, track_id, position, added_at)
+			SELECT playlist_id, track_id, position, added_at
+			FROM playlist_tracks_old
+			WHERE playlist_id IN (SELECT id FROM playlists) AND track_id IN (SELECT id FROM tracks)
+			ORDER BY playlist_id, position`,
+		"DROP TABLE playlist_tracks_old",
+		"CREATE INDEX IF NOT EXISTS idx_playlist_tracks_playlist ON playlist_tracks(playlist_id);",
+		"CREATE INDEX IF NOT EXISTS idx_playlist_tracks_position ON playlist_tracks(playlist_id, position);",
 	}
-	if err != nil {
-		return fmt.Errorf("failed to prepare insert track statement: %w", err)
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to rebuild playlist_tracks: %w", err)
+		}
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.logger.Info("Added IDs to playlist entries")
+	return nil
+}
 
-	// Update track statement
-	if db.hasOwnerColumn {
-		db.updateTrackStmt, err = db.conn.Prepare(`
-			UPDATE tracks SET title = ?, artist = ?, album = ?, track_number = ?, duration = ?, file_size = ?, has_album_art = ?, album_art_id = ?, owner = ?
-			WHERE id = ?`)
-	} else {
-		db.updateTrackStmt, err = db.conn.Prepare(`
-			UPDATE tracks SET title = ?, artist = ?, album = ?, track_number = ?, duration = ?, file_size = ?, has_album_art = ?, album_art_id = ?
-			WHERE id = ?`)
+// addColumnIfMissing adds a column to a table unless it already exists.
+func (db *Database) addColumnIfMissing(table, column, definition string) error {
+	var exists bool
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info(?)
+		WHERE name = ?`, table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	if _, err = db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return err
 	}
+	db.logger.WithField("table", table).WithField("column", column).Info("Added column")
+	return nil
+}
+
+// playlistTracksSQL defines playlist_tracks, whose rows are playlist
+// entries. An entry has its own ID, so a track can appear more than once.
+const playlistTracksSQL = `
+	CREATE TABLE IF NOT EXISTS playlist_tracks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playlist_id INTEGER NOT NULL,
+		track_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		added_by TEXT,
+		FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+		FOREIGN KEY (track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	);`
+
+// mainArtistSQL selects the first main artist credit of the tracks row t
+// from track_artists: its artist without featured artists.
+const mainArtistSQL = `(SELECT ta.name FROM track_artists ta
+			WHERE ta.track_id = t.id AND ta.role = '` + models.RoleMain + `'
+			ORDER BY ta.position LIMIT 1)`
+
+// trackViewSQL defines track_view: every tracks column, with title, artist,
+// album, track number and album art replaced by any non-NULL override, plus
+// main_artist (see mainArtistSQL), album_group: the artist albums are
+// grouped under (see models.Track.AlbumGroupArtist), and its play_count and
+// last_played from track_plays. Sort tags are dropped where an override
+// replaced the value they sort.
+const trackViewSQL = `
+	CREATE VIEW track_view AS
+	SELECT t.id,
+		COALESCE(o.title, t.title) AS title,
+		COALESCE(o.artist, t.artist) AS artist,
+		COALESCE(o.album, t.album) AS album,
+		t.album_artist,
+		t.compilation,
+		` + mainArtistSQL + ` AS main_artist,
+		CASE
+			WHEN t.compilation THEN '` + models.VariousArtists + `'
+			ELSE COALESCE(NULLIF(t.album_artist, ''), ` + mainArtistSQL + `, o.artist, t.artist)
+		END AS album_group,
+		t.composer,
+		t.remixer,
+		t.genre,
+		CASE WHEN o.artist IS NULL THEN t.artist_sort END AS artist_sort,
+		t.album_artist_sort,
+		CASE WHEN o.album IS NULL THEN t.album_sort END AS album_sort,
+		CASE WHEN o.title IS NULL THEN t.title_sort END AS title_sort,
+		COALESCE(o.track_number, t.track_number) AS track_number,
+		t.disc,
+		t.year,
+		t.duration,
+		t.file_path,
+		t.file_size,
+		t.codec,
+		CASE WHEN o.album_art_id IS NOT NULL THEN 1 ELSE t.has_album_art END AS has_album_art,
+		COALESCE(o.album_art_id, t.album_art_id) AS album_art_id,
+		t.owner,
+		t.source_path,
+		t.cue_start,
+		t.cue_end,
+		t.created_at,
+		COALESCE(p.play_count, 0) AS play_count,
+		p.last_played
+	FROM tracks t
+	LEFT JOIN track_overrides o ON o.track_id = t.id
+	LEFT JOIN track_plays p ON p.track_id = t.id`
+
+// prepareStatements prepares commonly used SQL statements for better performance
+func (db *Database) prepareStatements() error {
+	var err error
+
+	// Upsert track statement (matched by file_path; keeps id and created_at)
+	db.upsertTrackStmt, err = db.conn.Prepare(`
+		INSERT INTO tracks (title, artist, album, album_artist, compilation, composer, remixer, genre, artist_sort, album_artist_sort, album_sort, title_sort, track_number, disc, year, duration, file_path, file_size, codec, has_album_art, album_art_id, owner, source_path, cue_start, cue_end)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(file_path) DO UPDATE SET
+			title=excluded.title,
+			artist=excluded.artist,
+			album=excluded.album,
+			album_artist=excluded.album_artist,
+			compilation=excluded.compilation,
+			composer=excluded.composer,
+			remixer=excluded.remixer,
+			genre=excluded.genre,
+			artist_sort=excluded.artist_sort,
+			album_artist_sort=excluded.album_artist_sort,
+			album_sort=excluded.album_sort,
+			title_sort=excluded.title_sort,
+			track_number=excluded.track_number,
+			disc=excluded.disc,
+			year=excluded.year,
+			duration=excluded.duration,
+			file_size=excluded.file_size,
+			codec=excluded.codec,
+			has_album_art=excluded.has_album_art,
+			album_art_id=excluded.album_art_id,
+			owner=excluded.owner,
+			source_path=excluded.source_path,
+			cue_start=excluded.cue_start,
+			cue_end=excluded.cue_end
+		RETURNING id`)
 	if err != nil {
-		return fmt.Errorf("failed to prepare update track statement: %w", err)
+		return fmt.Errorf("failed to prepare upsert track statement: %w", err)
 	}
 
 	// Get track by ID statement
-	if db.hasOwnerColumn {
-		db.getTrackByIDStmt, err = db.conn.Prepare(`
-			SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, owner
-			FROM tracks WHERE id = ?`)
-	} else {
-		db.getTrackByIDStmt, err = db.conn.Prepare(`
-			SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id
-			FROM tracks WHERE id = ?`)
-	}
+	db.getTrackByIDStmt, err = db.conn.Prepare(`
+		SELECT ` + trackColumns("") + `
+		FROM track_view WHERE id = ?`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare get track by ID statement: %w", err)
 	}
@@ -297,19 +623,10 @@ func (db *Database) prepareStatements() error {
 	}
 
 	// Search tracks statement
-	if db.hasOwnerColumn {
-		db.searchTracksStmt, err = db.conn.Prepare(`
-			SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, owner
-			FROM tracks
-			WHERE title LIKE ? OR artist LIKE ? OR album LIKE ?
-			ORDER BY artist, album, track_number, title`)
-	} else {
-		db.searchTracksStmt, err = db.conn.Prepare(`
-			SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id
-			FROM tracks
-			WHERE title LIKE ? OR artist LIKE ? OR album LIKE ?
-			ORDER BY artist, album, track_number, title`)
-	}
+	db.searchTracksStmt, err = db.conn.Prepare(`
+		SELECT ` + trackColumns("") + `
+		FROM track_view
+		WHERE ` + searchCondition)
 	if err != nil {
 		return fmt.Errorf("failed to prepare search tracks statement: %w", err)
 	}
@@ -318,245 +635,104 @@ func (db *Database) prepareStatements() error {
 }
 
 // InsertTrack inserts a new track or updates an existing track (matched by
-// file_path) returning the track's database ID.
+// file_path) returning the track's database ID. Scans should use BulkIngest.
 func (db *Database) InsertTrack(track models.Track) (int, error) {
-	// Check if track already exists using prepared statement
-	var existingID int
-	err := db.conn.QueryRow("SELECT id FROM tracks WHERE file_path = ?", track.FilePath).Scan(&existingID)
+	id, err := upsertTrack(db.upsertTrackStmt, track)
 	if err == nil {
-		// Track exists, update it using prepared statement
-		if db.hasOwnerColumn {
-			_, err = db.updateTrackStmt.Exec(
-				track.Title, track.Artist, track.Album, track.TrackNumber,
-				track.Duration, track.FileSize, track.HasAlbumArt, track.AlbumArtID, track.Owner,
-				existingID)
-		} else {
-			_, err = db.updateTrackStmt.Exec(
-				track.Title, track.Artist, track.Album, track.TrackNumber,
-				track.Duration, track.FileSize, track.HasAlbumArt, track.AlbumArtID,
-				existingID)
-		}
-		if err != nil {
-			db.logger.WithError(err).WithField("track_id", existingID).Error("Failed to update existing track")
-		}
-		return existingID, err
-	}
-
-	// Insert new track using prepared statement
-	var result sql.Result
-	if db.hasOwnerColumn {
-		result, err = db.insertTrackStmt.Exec(
-			track.Title, track.Artist, track.Album, track.TrackNumber,
-			track.Duration, track.FilePath, track.FileSize, track.HasAlbumArt, track.AlbumArtID, track.Owner)
-	} else {
-		result, err = db.insertTrackStmt.Exec(
-			track.Title, track.Artist, track.Album, track.TrackNumber,
-			track.Duration, track.FilePath, track.FileSize, track.HasAlbumArt, track.AlbumArtID)
+		err = db.replaceTrackArtists(db.conn, id)
 	}
-
-	if err != nil {
-		db.logger.WithError(err).WithField("file_path", track.FilePath).Error("Failed to insert new track")
-		return 0, err
-	}
-
-	id, err := result.LastInsertId()
 	if err != nil {
-		db.logger.WithError(err).Error("Failed to get last insert ID")
-		return 0, err
+		db.logger.WithError(err).WithField("file_path", track.FilePath).Error("Failed to upsert track")
 	}
+	return id, err
+}
 
-	return int(id), nil
+// upsertTrack runs the upsert statement (possibly bound to a transaction).
+func upsertTrack(stmt *sql.Stmt, track models.Track) (int, error) {
+	var id int
+	err := stmt.QueryRow(
+		track.Title, track.Artist, track.Album, nullString(track.AlbumArtist), track.Compilation,
+		nullString(track.Composer), nullString(track.Remixer), nullString(track.Genre),
+		nullString(track.ArtistSort), nullString(track.AlbumArtistSort), nullString(track.AlbumSort), nullString(track.TitleSort),
+		track.TrackNumber, track.Disc, track.Year,
+		track.Duration, track.FilePath, track.FileSize, nullString(track.Codec), track.HasAlbumArt, track.AlbumArtID, track.Owner,
+		nullString(track.SourcePath), track.CueStart, track.CueEnd).Scan(&id)
+	return id, err
 }
 
 // GetAllTracks returns all tracks ordered by artist/album/track/title.
 func (db *Database) GetAllTracks() ([]models.Track, error) {
-	var query string
-	if db.hasOwnerColumn {
-		query = `
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-		FROM tracks
-		ORDER BY artist, album, track_number, title`
-	} else {
-		query = `
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id
-		FROM tracks
-		ORDER BY artist, album, track_number, title`
-	}
-
-	rows, err := db.conn.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return scanTrackRows(rows)
+	return db.querySortedTracks(metadata.ByArtist, `
+		SELECT `+trackColumns("")+`
+		FROM track_view`)
 }
 
 // GetMainLibraryTracks returns only tracks from the main library (with empty/null owner) ordered by artist/album/track/title.
 func (db *Database) GetMainLibraryTracks() ([]models.Track, error) {
-	var query string
-	if db.hasOwnerColumn {
-		query = `
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-		FROM tracks
-		WHERE owner IS NULL OR owner = ''
-		ORDER BY artist, album, track_number, title`
-	} else {
-		query = `
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id
-		FROM tracks
-		ORDER BY artist, album, track_number, title`
-	}
-
-	rows, err := db.conn.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return scanTrackRows(rows)
+	return db.querySortedTracks(metadata.ByArtist, `
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE owner IS NULL OR owner = ''`)
 }
 
 // GetTracksByOwner returns all tracks for a specific user ordered by artist/album/track/title.
 func (db *Database) GetTracksByOwner(owner string) ([]models.Track, error) {
-	if !db.hasOwnerColumn {
-		// If no owner column, return empty result for user-specific queries
-		return []models.Track{}, nil
-	}
+	return db.querySortedTracks(metadata.ByArtist, `
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE owner = ?`, owner)
+}
 
-	rows, err := db.conn.Query(`
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-		FROM tracks
-		WHERE owner = ?
-		ORDER BY artist, album, track_number, title`, owner)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return scanTrackRows(rows)
+// GetTracksInScope returns the tracks in the scope, in no particular order.
+func (db *Database) GetTracksInScope(scope StatsScope) ([]models.Track, error) {
+	cond, args := scope.where()
+	return db.queryTracks(`
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE `+cond, args...)
 }
 
 // GetTracksSortedByAlbum returns all tracks ordered by album/track/title.
 func (db *Database) GetTracksSortedByAlbum() ([]models.Track, error) {
-	var query string
-	if db.hasOwnerColumn {
-		query = `
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-		FROM tracks
-		ORDER BY album, track_number, title`
-	} else {
-		query = `
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id
-		FROM tracks
-		ORDER BY album, track_number, title`
-	}
-
-	rows, err := db.conn.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return scanTrackRows(rows)
+	return db.querySortedTracks(metadata.ByAlbum, `
+		SELECT `+trackColumns("")+`
+		FROM track_view`)
 }
 
 // GetMainLibraryTracksSortedByAlbum returns only tracks from the main library (with empty/null owner) ordered by album/track/title.
 func (db *Database) GetMainLibraryTracksSortedByAlbum() ([]models.Track, error) {
-	var query string
-	if db.hasOwnerColumn {
-		query = `
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-		FROM tracks
-		WHERE owner IS NULL OR owner = ''
-		ORDER BY album, track_number, title`
-	} else {
-		query = `
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id
-		FROM tracks
-		ORDER BY album, track_number, title`
-	}
-
-	rows, err := db.conn.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return scanTrackRows(rows)
+	return db.querySortedTracks(metadata.ByAlbum, `
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE owner IS NULL OR owner = ''`)
 }
 
 // GetTracksSortedByAlbumForOwner returns tracks for a specific user ordered by album/track/title.
 func (db *Database) GetTracksSortedByAlbumForOwner(owner string) ([]models.Track, error) {
-	if !db.hasOwnerColumn {
-		// If no owner column, return empty result for user-specific queries
-		return []models.Track{}, nil
-	}
-
-	rows, err := db.conn.Query(`
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-		FROM tracks
-		WHERE owner = ?
-		ORDER BY album, track_number, title`, owner)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return scanTrackRows(rows)
+	return db.querySortedTracks(metadata.ByAlbum, `
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE owner = ?`, owner)
 }
 
 // GetTrackByID returns a single track by its ID.
 func (db *Database) GetTrackByID(id int) (*models.Track, error) {
-	var track models.Track
-	var albumArtID sql.NullString
-
-	if db.hasOwnerColumn {
-		err := db.getTrackByIDStmt.QueryRow(id).Scan(
-			&track.ID, &track.Title, &track.Artist, &track.Album,
-			&track.TrackNumber, &track.Duration, &track.FilePath,
-			&track.FileSize, &track.HasAlbumArt, &albumArtID, &track.Owner)
-
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return nil, fmt.Errorf("track with ID %d not found", id)
-			}
-			db.logger.WithError(err).WithField("track_id", id).Error("Failed to get track by ID")
-			return nil, err
-		}
-	} else {
-		err := db.getTrackByIDStmt.QueryRow(id).Scan(
-			&track.ID, &track.Title, &track.Artist, &track.Album,
-			&track.TrackNumber, &track.Duration, &track.FilePath,
-			&track.FileSize, &track.HasAlbumArt, &albumArtID)
-
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return nil, fmt.Errorf("track with ID %d not found", id)
-			}
-			db.logger.WithError(err).WithField("track_id", id).Error("Failed to get track by ID")
-			return nil, err
+	track, err := scanTrack(db.getTrackByIDStmt.QueryRow(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("track with ID %d not found", id)
 		}
-		track.Owner = "" // Set empty owner for backward compatibility
-	}
-
-	if albumArtID.Valid {
-		track.AlbumArtID = albumArtID.String
+		db.logger.WithError(err).WithField("track_id", id).Error("Failed to get track by ID")
+		return nil, err
 	}
 	return &track, nil
 }
 
 // GetTrackByIDForOwner returns a track only if it belongs to the specified owner.
 func (db *Database) GetTrackByIDForOwner(id int, owner string) (*models.Track, error) {
-	if !db.hasOwnerColumn {
-		// If no owner column, fall back to regular GetTrackByID
-		return db.GetTrackByID(id)
-	}
-
-	var track models.Track
-	var albumArtID sql.NullString
-
-	err := db.conn.QueryRow(`
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-		FROM tracks WHERE id = ? AND owner = ?`, id, owner).Scan(
-		&track.ID, &track.Title, &track.Artist, &track.Album,
-		&track.TrackNumber, &track.Duration, &track.FilePath,
-		&track.FileSize, &track.HasAlbumArt, &albumArtID, &track.Owner)
-
+	track, err := scanTrack(db.conn.QueryRow(`
+		SELECT `+trackColumns("")+`
+		FROM track_view WHERE id = ? AND owner = ?`, id, owner))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("track with ID %d not found for user %s", id, owner)
@@ -564,49 +740,33 @@ func (db *Database) GetTrackByIDForOwner(id int, owner string) (*models.Track, e
 		db.logger.WithError(err).WithField("track_id", id).WithField("owner", owner).Error("Failed to get track by ID for owner")
 		return nil, err
 	}
-
-	if albumArtID.Valid {
-		track.AlbumArtID = albumArtID.String
-	}
 	return &track, nil
 }
 
 // GetMainLibraryTrackByID returns a track only if it belongs to the main library (empty/null owner).
 func (db *Database) GetMainLibraryTrackByID(id int) (*models.Track, error) {
-	var track models.Track
-	var albumArtID sql.NullString
-
-	if db.hasOwnerColumn {
-		err := db.conn.QueryRow(`
-			SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-			FROM tracks WHERE id = ? AND (owner IS NULL OR owner = '')`, id).Scan(
-			&track.ID, &track.Title, &track.Artist, &track.Album,
-			&track.TrackNumber, &track.Duration, &track.FilePath,
-			&track.FileSize, &track.HasAlbumArt, &albumArtID, &track.Owner)
-
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return nil, fmt.Errorf("track with ID %d not found in main library", id)
-			}
-			db.logger.WithError(err).WithField("track_id", id).Error("Failed to get main library track by ID")
-			return nil, err
+	track, err := scanTrack(db.conn.QueryRow(`
+		SELECT `+trackColumns("")+`
+		FROM track_view WHERE id = ? AND (owner IS NULL OR owner = '')`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("track with ID %d not found in main library", id)
 		}
-	} else {
-		// If no owner column exists, all tracks are main library tracks
-		return db.GetTrackByID(id)
-	}
-
-	if albumArtID.Valid {
-		track.AlbumArtID = albumArtID.String
+		db.logger.WithError(err).WithField("track_id", id).Error("Failed to get main library track by ID")
+		return nil, err
 	}
 	return &track, nil
 }
 
-// CreatePlaylist inserts a new playlist and returns its ID.
-func (db *Database) CreatePlaylist(name, description string) (int, error) {
+// ErrPlaylistNotFound is returned when a playlist ID does not exist.
+var ErrPlaylistNotFound = errors.New("playlist not found")
+
+// CreatePlaylist inserts a new playlist owned by owner (empty without auth)
+// and returns its ID.
+func (db *Database) CreatePlaylist(name, description, owner, visibility string) (int, error) {
 	result, err := db.conn.Exec(`
-		INSERT INTO playlists (name, description)
-		VALUES (?, ?)`, name, description)
+		INSERT INTO playlists (name, description, owner, visibility)
+		VALUES (?, ?, ?, ?)`, name, description, nullString(owner), visibility)
 
 	if err != nil {
 		return 0, err
@@ -616,15 +776,25 @@ func (db *Database) CreatePlaylist(name, description string) (int, error) {
 	return int(id), err
 }
 
-// GetAllPlaylists returns all playlists along with derived track counts.
-func (db *Database) GetAllPlaylists() ([]models.Playlist, error) {
-	rows, err := db.conn.Query(`
-		SELECT p.id, p.name, p.description, p.cover_path, p.created_at,
-			   COALESCE(COUNT(pt.track_id), 0) as track_count
+// playlistVisibility is the visibility in effect for a playlist p: its
+// folder's, joined as fv from folderVisibilityCTE, or its own.
+const playlistVisibility = "COALESCE(fv.visibility, p.visibility, 'public')"
+
+// queryPlaylists returns the playlists matching cond, newest first, along
+// with derived track counts. cond may use the effective visibility as
+// playlistVisibility.
+func (db *Database) queryPlaylists(cond string, args ...interface{}) ([]models.Playlist, error) {
+	rows, err := db.conn.Query(folderVisibilityCTE+`
+		SELECT p.id, p.name, p.description, p.cover_path, COALESCE(p.owner, ''),
+			   `+playlistVisibility+`, p.created_at,
+			   COALESCE(COUNT(pt.track_id), 0) as track_count, COALESCE(p.file_path, ''),
+			   COALESCE(p.smart_rules, ''), COALESCE(p.folder_id, 0), p.position
 		FROM playlists p
 		LEFT JOIN playlist_tracks pt ON p.id = pt.playlist_id
-		GROUP BY p.id, p.name, p.description, p.cover_path, p.created_at
-		ORDER BY p.created_at DESC`)
+		LEFT JOIN folder_visibility fv ON fv.id = p.folder_id
+		WHERE `+cond+`
+		GROUP BY p.id
+		ORDER BY p.created_at DESC`, args...)
 
 	if err != nil {
 		return nil, err
@@ -635,74 +805,79 @@ func (db *Database) GetAllPlaylists() ([]models.Playlist, error) {
 	for rows.Next() {
 		var playlist models.Playlist
 		var coverPath sql.NullString
+		var rules string
 		err := rows.Scan(&playlist.ID, &playlist.Name, &playlist.Description,
-			&coverPath, &playlist.CreatedAt, &playlist.TrackCount)
+			&coverPath, &playlist.Owner, &playlist.Visibility, &playlist.CreatedAt, &playlist.TrackCount,
+			&playlist.FilePath, &rules, &playlist.FolderID, &playlist.Position)
 		if err != nil {
 			return nil, err
 		}
+		playlist.FileBacked = playlist.FilePath != ""
+		if rules != "" {
+			playlist.Smart = true
+			playlist.Rules = &models.SmartRules{}
+			if err := json.Unmarshal([]byte(rules), playlist.Rules); err != nil {
+				return nil, fmt.Errorf("playlist %d has unreadable smart rules: %w", playlist.ID, err)
+			}
+		}
 		if coverPath.Valid {
 			playlist.CoverPath = coverPath.String
 		}
 		playlists = append(playlists, playlist)
 	}
 
-	return playlists, nil
+	return playlists, rows.Err()
 }
 
-// GetPlaylistTracks returns tracks for a playlist ordered by stored position.
-func (db *Database) GetPlaylistTracks(playlistID int) ([]models.Track, error) {
-	var query string
-	if db.hasOwnerColumn {
-		query = `
-		SELECT t.id, t.title, t.artist, t.album, t.track_number, t.duration, t.file_path, t.file_size, t.has_album_art, t.album_art_id, COALESCE(t.owner, '') as owner
-		FROM tracks t
-		JOIN playlist_tracks pt ON t.id = pt.track_id
-		WHERE pt.playlist_id = ?
-		ORDER BY pt.position`
-	} else {
-		query = `
-		SELECT t.id, t.title, t.artist, t.album, t.track_number, t.duration, t.file_path, t.file_size, t.has_album_art, t.album_art_id
-		FROM tracks t
-		JOIN playlist_tracks pt ON t.id = pt.track_id
-		WHERE pt.playlist_id = ?
-		ORDER BY pt.position`
-	}
+// GetAllPlaylists returns every user's playlists along with derived track counts.
+func (db *Database) GetAllPlaylists() ([]models.Playlist, error) {
+	return db.queryPlaylists("1 = 1")
+}
+
+// GetPlaylistsVisibleTo returns the playlists listed for user: their own,
+// those they collaborate on and public ones.
+func (db *Database) GetPlaylistsVisibleTo(user string) ([]models.Playlist, error) {
+	return db.queryPlaylists(`COALESCE(p.owner, '') = ? OR `+playlistVisibility+` = ?
+		OR p.id IN (SELECT playlist_id FROM playlist_collaborators WHERE username = ?)`,
+		user, models.VisibilityPublic, user)
+}
 
-	rows, err := db.conn.Query(query, playlistID)
+// GetPlaylist returns a playlist by ID, or ErrPlaylistNotFound.
+func (db *Database) GetPlaylist(id int) (*models.Playlist, error) {
+	playlists, err := db.queryPlaylists("p.id = ?", id)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	return scanTrackRows(rows)
-}
-
-// AddTrackToPlaylist appends a track to the end of a playlist (if not already present).
-func (db *Database) AddTrackToPlaylist(playlistID, trackID int) error {
-	// Get the next position
-	var maxPosition sql.NullInt64
-	err := db.conn.QueryRow(`
-		SELECT MAX(position) FROM playlist_tracks WHERE playlist_id = ?`,
-		playlistID).Scan(&maxPosition)
-
-	if err != nil && err != sql.ErrNoRows {
-		return err
+	if len(playlists) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrPlaylistNotFound, id)
 	}
+	return &playlists[0], nil
+}
 
-	position := 1
-	if maxPosition.Valid {
-		position = int(maxPosition.Int64) + 1
-	}
+// GetPlaylistTracks returns tracks for a playlist ordered by stored position.
+func (db *Database) GetPlaylistTracks(playlistID int) ([]models.Track, error) {
+	return db.queryTracks(`
+		SELECT `+trackColumns("t")+`
+		FROM track_view t
+		JOIN playlist_tracks pt ON t.id = pt.track_id
+		WHERE pt.playlist_id = ?
+		ORDER BY pt.position`, playlistID)
+}
 
-	_, err = db.conn.Exec(`
-		INSERT INTO playlist_tracks (playlist_id, track_id, position)
-		VALUES (?, ?, ?)
-		ON CONFLICT(playlist_id, track_id) DO NOTHING`,
-		playlistID, trackID, position)
+// AddTrackToPlaylist appends a track to the end of a playlist, even if it is
+// already in it, recording addedBy as who added it. The position is
+// computed in the insert itself, so concurrent adds each get their own.
+func (db *Database) AddTrackToPlaylist(playlistID, trackID int, addedBy string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO playlist_tracks (playlist_id, track_id, position, added_by)
+		SELECT ?, ?, COALESCE(MAX(position), 0) + 1, ?
+		FROM playlist_tracks WHERE playlist_id = ?`,
+		playlistID, trackID, nullString(addedBy), playlistID)
 
 	return err
 }
 
-// RemoveTrackFromPlaylist removes a specific track from the given playlist.
+// RemoveTrackFromPlaylist removes every entry of a track from the given playlist.
 func (db *Database) RemoveTrackFromPlaylist(playlistID, trackID int) error {
 	_, err := db.conn.Exec(`
 		DELETE FROM playlist_tracks 
@@ -718,74 +893,73 @@ func (db *Database) DeletePlaylist(playlistID int) error {
 	return err
 }
 
-// UpdatePlaylist updates playlist metadata (name, description, cover path).
-func (db *Database) UpdatePlaylist(playlistID int, name, description, coverPath string) error {
+// UpdatePlaylist updates playlist metadata (name, description, cover path,
+// visibility). An empty visibility keeps the playlist's own.
+func (db *Database) UpdatePlaylist(playlistID int, name, description, coverPath, visibility string) error {
 	_, err := db.conn.Exec(`
 		UPDATE playlists 
-		SET name = ?, description = ?, cover_path = ?
+		SET name = ?, description = ?, cover_path = ?, visibility = COALESCE(NULLIF(?, ''), visibility)
 		WHERE id = ?`,
-		name, description, coverPath, playlistID)
+		name, description, coverPath, visibility, playlistID)
 	return err
 }
 
-// SearchTracks performs a simple LIKE-based search over title, artist and album.
+// searchCondition matches tracks whose title, artist string, album or any
+// credited artist contains the search term; it takes the term four times.
+const searchCondition = `(title LIKE ? OR artist LIKE ? OR album LIKE ?
+	OR id IN (SELECT track_id FROM track_artists WHERE name LIKE ?))`
+
+// SearchTracks performs a simple LIKE-based search over title, artist, album
+// and artist credits.
 func (db *Database) SearchTracks(query string) ([]models.Track, error) {
 	searchQuery := "%" + query + "%"
-	rows, err := db.searchTracksStmt.Query(searchQuery, searchQuery, searchQuery)
+	rows, err := db.searchTracksStmt.Query(searchQuery, searchQuery, searchQuery, searchQuery)
 	if err != nil {
 		db.logger.WithError(err).WithField("query", query).Error("Failed to search tracks")
 		return nil, err
 	}
 	defer rows.Close()
-	return scanTrackRows(rows)
+	tracks, err := scanTrackRows(rows)
+	if err == nil {
+		db.sorter.SortTracks(tracks, metadata.ByArtist)
+	}
+	return tracks, err
 }
 
 // SearchMainLibraryTracks performs a search only on tracks from the main library (with empty/null owner).
 func (db *Database) SearchMainLibraryTracks(query string) ([]models.Track, error) {
 	searchQuery := "%" + query + "%"
-	var querySQL string
-	if db.hasOwnerColumn {
-		querySQL = `
-			SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-			FROM tracks
-			WHERE (title LIKE ? OR artist LIKE ? OR album LIKE ?) AND (owner IS NULL OR owner = '')
-			ORDER BY artist, album, track_number, title`
-	} else {
-		querySQL = `
-			SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id
-			FROM tracks
-			WHERE title LIKE ? OR artist LIKE ? OR album LIKE ?
-			ORDER BY artist, album, track_number, title`
-	}
-
-	rows, err := db.conn.Query(querySQL, searchQuery, searchQuery, searchQuery)
+	tracks, err := db.querySortedTracks(metadata.ByArtist, `
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE `+searchCondition+` AND (owner IS NULL OR owner = '')`, searchQuery, searchQuery, searchQuery, searchQuery)
 	if err != nil {
 		db.logger.WithError(err).WithField("query", query).Error("Failed to search main library tracks")
-		return nil, err
 	}
-	defer rows.Close()
-	return scanTrackRows(rows)
+	return tracks, err
 }
 
 // SearchTracksForOwner performs a search for tracks belonging to a specific user.
 func (db *Database) SearchTracksForOwner(query, owner string) ([]models.Track, error) {
-	if !db.hasOwnerColumn {
-		// If no owner column, return empty result for user-specific queries
-		return []models.Track{}, nil
-	}
-
 	searchQuery := "%" + query + "%"
-	rows, err := db.conn.Query(`
-		SELECT id, title, artist, album, track_number, duration, file_path, file_size, has_album_art, album_art_id, COALESCE(owner, '') as owner
-		FROM tracks
-		WHERE (title LIKE ? OR artist LIKE ? OR album LIKE ?) AND owner = ?
-		ORDER BY artist, album, track_number, title`, searchQuery, searchQuery, searchQuery, owner)
+	tracks, err := db.querySortedTracks(metadata.ByArtist, `
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE `+searchCondition+` AND owner = ?`, searchQuery, searchQuery, searchQuery, searchQuery, owner)
 	if err != nil {
 		db.logger.WithError(err).WithField("query", query).WithField("owner", owner).Error("Failed to search tracks for owner")
-		return nil, err
 	}
-	defer rows.Close()
-	return scanTrackRows(rows)
+	return tracks, err
+}
+
+// SampleTracks returns up to limit random tracks, preferring tracks whose
+// artist or album fell back to the "Unknown" placeholders.
+func (db *Database) SampleTracks(limit int) ([]models.Track, error) {
+	return db.queryTracks(`
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		ORDER BY (artist = 'Unknown Artist' OR album = 'Unknown Album') DESC, RANDOM()
+		LIMIT ?`, limit)
 }
 
 // RemoveTrackByPath deletes a track row identified by its file path.
@@ -797,6 +971,19 @@ func (db *Database) RemoveTrackByPath(filePath string) error {
 	return err
 }
 
+// UpdateTrackPath changes a track's file_path in place, keeping its ID,
+// overrides and playlist membership.
+func (db *Database) UpdateTrackPath(id int, filePath string) error {
+	result, err := db.conn.Exec("UPDATE tracks SET file_path = ? WHERE id = ?", filePath, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("track with ID %d not found", id)
+	}
+	return nil
+}
+
 // TrackExists returns true if a track exists with the given file path.
 func (db *Database) TrackExists(filePath string) (bool, error) {
 	var count int
@@ -808,13 +995,62 @@ func (db *Database) TrackExists(filePath string) (bool, error) {
 	return count > 0, nil
 }
 
-// DeleteTracksByOwner removes all tracks belonging to a specific user
-func (db *Database) DeleteTracksByOwner(owner string) error {
-	if !db.hasOwnerColumn {
-		// If no owner column exists, nothing to delete
-		return nil
+// RemoveTracksBySource deletes the virtual tracks cut from an audio file and
+// returns how many were removed.
+func (db *Database) RemoveTracksBySource(sourcePath string) (int64, error) {
+	result, err := db.conn.Exec("DELETE FROM tracks WHERE source_path = ?", sourcePath)
+	if err != nil {
+		db.logger.WithError(err).WithField("source_path", sourcePath).Error("Failed to remove tracks by source")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetCueSheetSources returns the audio files a cue sheet's tracks are cut from.
+func (db *Database) GetCueSheetSources(cuePath string) ([]string, error) {
+	rows, err := db.conn.Query(`
+		SELECT DISTINCT source_path FROM tracks
+		WHERE source_path IS NOT NULL AND file_path >= ? AND file_path < ?`,
+		cuePath+"#", cuePath+"$")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []string
+	for rows.Next() {
+		var source string
+		if err := rows.Scan(&source); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+// PruneCueTracks deletes the virtual tracks of a cue sheet whose paths are
+// not in keep (e.g. tracks dropped from an edited sheet). An empty keep
+// removes every track of the sheet.
+func (db *Database) PruneCueTracks(cuePath string, keep []string) (int64, error) {
+	// "<cue>#" <= file_path < "<cue>$" selects every "<cue>#NN" path.
+	query := "DELETE FROM tracks WHERE source_path IS NOT NULL AND file_path >= ? AND file_path < ?"
+	args := []interface{}{cuePath + "#", cuePath + "$"}
+	if len(keep) > 0 {
+		query += " AND file_path NOT IN (?" + strings.Repeat(", ?", len(keep)-1) + ")"
+		for _, p := range keep {
+			args = append(args, p)
+		}
 	}
+	result, err := db.conn.Exec(query, args...)
+	if err != nil {
+		db.logger.WithError(err).WithField("cue_path", cuePath).Error("Failed to prune cue tracks")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
 
+// DeleteTracksByOwner removes all tracks belonging to a specific user
+func (db *Database) DeleteTracksByOwner(owner string) error {
 	result, err := db.conn.Exec("DELETE FROM tracks WHERE owner = ?", owner)
 	if err != nil {
 		db.logger.WithError(err).WithField("owner", owner).Error("Failed to delete tracks by owner")
@@ -835,8 +1071,7 @@ func (db *Database) DeleteTracksByOwner(owner string) error {
 func (db *Database) Close() error {
 	// Close prepared statements
 	statements := []*sql.Stmt{
-		db.insertTrackStmt,
-		db.updateTrackStmt,
+		db.upsertTrackStmt,
 		db.getTrackByIDStmt,
 		db.trackExistsStmt,
 		db.removeTrackStmt,
@@ -905,46 +1140,96 @@ func (db *Database) GetAllDownloadJobs() ([]map[string]interface{}, error) {
 	return jobs, nil
 }
 
+// trackColumnNames lists the track_view columns read by scanTrack, in order.
+var trackColumnNames = []string{
+	"id", "title", "artist", "album", "album_artist", "compilation", "main_artist", "composer", "remixer", "genre",
+	"artist_sort", "album_artist_sort", "album_sort", "title_sort", "track_number", "disc", "year", "duration", "file_path", "file_size",
+	"codec", "has_album_art", "album_art_id", "owner", "source_path", "cue_start", "cue_end",
+}
+
+// trackColumns returns the SELECT list matching scanTrack, qualified with
+// alias when the query joins other tables.
+func trackColumns(alias string) string {
+	prefix := ""
+	if alias != "" {
+		prefix = alias + "."
+	}
+	cols := make([]string, len(trackColumnNames))
+	for i, name := range trackColumnNames {
+		switch name {
+		case "album_artist", "main_artist", "composer", "remixer", "genre", "artist_sort", "album_artist_sort", "album_sort", "title_sort",
+			"codec", "album_art_id", "owner", "source_path":
+			cols[i] = "COALESCE(" + prefix + name + ", '') AS " + name
+		default:
+			cols[i] = prefix + name
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
+// nullString maps "" to NULL for optional text columns.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTrack scans one row selected with trackColumns; extra receives any
+// columns the query appends after them.
+func scanTrack(row rowScanner, extra ...interface{}) (models.Track, error) {
+	var track models.Track
+	dest := append([]interface{}{
+		&track.ID, &track.Title, &track.Artist, &track.Album, &track.AlbumArtist, &track.Compilation, &track.MainArtist,
+		&track.Composer, &track.Remixer, &track.Genre,
+		&track.ArtistSort, &track.AlbumArtistSort, &track.AlbumSort, &track.TitleSort,
+		&track.TrackNumber, &track.Disc, &track.Year, &track.Duration, &track.FilePath, &track.FileSize,
+		&track.Codec, &track.HasAlbumArt, &track.AlbumArtID, &track.Owner,
+		&track.SourcePath, &track.CueStart, &track.CueEnd,
+	}, extra...)
+	err := row.Scan(dest...)
+	return track, err
+}
+
+// queryTracks runs a query selecting trackColumns and scans the results.
+func (db *Database) queryTracks(query string, args ...interface{}) ([]models.Track, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTrackRows(rows)
+}
+
+// SetSorter sets how track listings are ordered. Call before serving.
+func (db *Database) SetSorter(sorter *metadata.Sorter) {
+	db.sorter = sorter
+}
+
+// querySortedTracks is queryTracks for listings, ordering the results with
+// the configured Sorter: SQLite's ORDER BY knows neither sort tags nor
+// Unicode collation.
+func (db *Database) querySortedTracks(order metadata.TrackOrder, query string, args ...interface{}) ([]models.Track, error) {
+	tracks, err := db.queryTracks(query, args...)
+	if err == nil {
+		db.sorter.SortTracks(tracks, order)
+	}
+	return tracks, err
+}
+
 // scanTrackRows scans standard track result sets into a slice of models.Track.
 // It centralizes row iteration logic to reduce duplication across query
 // helpers. Callers must have already deferred rows.Close().
 func scanTrackRows(rows *sql.Rows) ([]models.Track, error) {
 	var tracks []models.Track
 	for rows.Next() {
-		var track models.Track
-		var albumArtID sql.NullString
-
-		// Get column names to determine if owner column exists
-		columns, err := rows.Columns()
+		track, err := scanTrack(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		hasOwner := false
-		for _, col := range columns {
-			if col == "owner" {
-				hasOwner = true
-				break
-			}
-		}
-
-		if hasOwner {
-			if err := rows.Scan(&track.ID, &track.Title, &track.Artist, &track.Album,
-				&track.TrackNumber, &track.Duration, &track.FilePath, &track.FileSize, &track.HasAlbumArt, &albumArtID, &track.Owner); err != nil {
-				return nil, err
-			}
-		} else {
-			if err := rows.Scan(&track.ID, &track.Title, &track.Artist, &track.Album,
-				&track.TrackNumber, &track.Duration, &track.FilePath, &track.FileSize, &track.HasAlbumArt, &albumArtID); err != nil {
-				return nil, err
-			}
-			track.Owner = "" // Set empty owner for backward compatibility
-		}
-
-		if albumArtID.Valid {
-			track.AlbumArtID = albumArtID.String
-		}
 		tracks = append(tracks, track)
 	}
-	return tracks, nil
+	return tracks, rows.Err()
 }
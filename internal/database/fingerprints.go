@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+
+	"staccato/pkg/models"
+)
+
+// TrackFingerprint pairs a track with its stored acoustic fingerprint.
+type TrackFingerprint struct {
+	Track       models.Track
+	Fingerprint []byte
+}
+
+// GetTracksNeedingFingerprint returns up to limit tracks that have no
+// fingerprint yet or whose file size changed since it was computed. Virtual
+// (cue sheet) tracks are not fingerprinted.
+func (db *Database) GetTracksNeedingFingerprint(limit int) ([]models.Track, error) {
+	return db.queryTracks(`
+		SELECT `+trackColumns("t")+`
+		FROM track_view t
+		LEFT JOIN track_fingerprints f ON f.track_id = t.id
+		WHERE t.source_path IS NULL AND (f.track_id IS NULL OR f.file_size != t.file_size)
+		ORDER BY t.id
+		LIMIT ?`, limit)
+}
+
+// SaveFingerprint stores (or replaces) the fingerprint for a track. A non-empty
+// errMsg records a failed attempt so the track is not retried until its file
+// changes.
+func (db *Database) SaveFingerprint(trackID int, fileSize int64, fingerprint []byte, errMsg string) error {
+	var errValue sql.NullString
+	if errMsg != "" {
+		errValue = sql.NullString{String: errMsg, Valid: true}
+	}
+	_, err := db.conn.Exec(`
+		INSERT INTO track_fingerprints (track_id, fingerprint, file_size, error, computed_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(track_id) DO UPDATE SET
+			fingerprint=excluded.fingerprint,
+			file_size=excluded.file_size,
+			error=excluded.error,
+			computed_at=excluded.computed_at`,
+		trackID, fingerprint, fileSize, errValue)
+	if err != nil {
+		db.logger.WithError(err).WithField("track_id", trackID).Error("Failed to save fingerprint")
+	}
+	return err
+}
+
+// GetAllFingerprints returns every track that has a usable fingerprint,
+// ordered by duration so callers can cheaply window candidate pairs.
+func (db *Database) GetAllFingerprints() ([]TrackFingerprint, error) {
+	rows, err := db.conn.Query(`
+		SELECT ` + trackColumns("t") + `, f.fingerprint
+		FROM track_view t
+		JOIN track_fingerprints f ON f.track_id = t.id
+		WHERE f.fingerprint IS NOT NULL AND f.file_size = t.file_size
+		ORDER BY t.duration, t.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []TrackFingerprint
+	for rows.Next() {
+		var tf TrackFingerprint
+		var err error
+		if tf.Track, err = scanTrack(rows, &tf.Fingerprint); err != nil {
+			return nil, err
+		}
+		result = append(result, tf)
+	}
+	return result, rows.Err()
+}
+
+// CountPendingFingerprints returns how many tracks still await fingerprinting.
+func (db *Database) CountPendingFingerprints() (int, error) {
+	var count int
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*)
+		FROM track_view t
+		LEFT JOIN track_fingerprints f ON f.track_id = t.id
+		WHERE t.source_path IS NULL AND (f.track_id IS NULL OR f.file_size != t.file_size)`).Scan(&count)
+	return count, err
+}
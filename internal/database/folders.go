@@ -0,0 +1,439 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"staccato/pkg/models"
+)
+
+// ErrFolderNotFound is returned when a playlist folder does not exist.
+var ErrFolderNotFound = errors.New("playlist folder not found")
+
+// ErrInvalidFolderChange is returned when a folder would be nested too
+// deep or inside itself, hold another owner's folders or playlists, or a
+// top-level folder would inherit its visibility. Nothing is changed.
+var ErrInvalidFolderChange = errors.New("invalid playlist folder change")
+
+// MaxPlaylistFolderDepth bounds how deep playlist folders nest: a top-level
+// folder has depth 1.
+const MaxPlaylistFolderDepth = 8
+
+// folderVisibilityCTE computes the visibility in effect for every folder
+// as folder_visibility: its own or, for a folder inheriting it, its
+// parent's.
+const folderVisibilityCTE = `
+	WITH RECURSIVE folder_visibility(id, visibility) AS (
+		SELECT id, COALESCE(visibility, 'private') FROM playlist_folders WHERE parent_id IS NULL
+		UNION ALL
+		SELECT f.id, COALESCE(f.visibility, fv.visibility)
+		FROM playlist_folders f JOIN folder_visibility fv ON f.parent_id = fv.id
+	)`
+
+// queryFolders returns the playlist folders matching cond, in order.
+func (db *Database) queryFolders(cond string, args ...interface{}) ([]models.PlaylistFolder, error) {
+	rows, err := db.conn.Query(folderVisibilityCTE+`
+		SELECT f.id, f.name, COALESCE(f.parent_id, 0), COALESCE(f.owner, ''),
+			   fv.visibility, f.visibility IS NULL, f.position, f.created_at
+		FROM playlist_folders f
+		JOIN folder_visibility fv ON fv.id = f.id
+		WHERE `+cond+`
+		ORDER BY f.position, f.name COLLATE NOCASE, f.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []models.PlaylistFolder
+	for rows.Next() {
+		var f models.PlaylistFolder
+		if err := rows.Scan(&f.ID, &f.Name, &f.ParentID, &f.Owner, &f.Visibility,
+			&f.InheritsVisibility, &f.Position, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+// GetPlaylistFolder returns a playlist folder by ID, or ErrFolderNotFound.
+func (db *Database) GetPlaylistFolder(id int) (*models.PlaylistFolder, error) {
+	folders, err := db.queryFolders("f.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrFolderNotFound, id)
+	}
+	return &folders[0], nil
+}
+
+// GetAllPlaylistFolders returns every user's playlist folders.
+func (db *Database) GetAllPlaylistFolders() ([]models.PlaylistFolder, error) {
+	return db.queryFolders("1 = 1")
+}
+
+// GetPlaylistFoldersVisibleTo returns the folders listed for user: their
+// own and public ones.
+func (db *Database) GetPlaylistFoldersVisibleTo(user string) ([]models.PlaylistFolder, error) {
+	return db.queryFolders("COALESCE(f.owner, '') = ? OR fv.visibility = ?", user, models.VisibilityPublic)
+}
+
+// GetPlaylistFolderChildren returns the folders directly in a folder, or
+// every top-level folder for parentID 0.
+func (db *Database) GetPlaylistFolderChildren(parentID int) ([]models.PlaylistFolder, error) {
+	return db.queryFolders("COALESCE(f.parent_id, 0) = ?", parentID)
+}
+
+// GetPlaylistsInFolder returns the playlists directly in a folder.
+func (db *Database) GetPlaylistsInFolder(folderID int) ([]models.Playlist, error) {
+	return db.queryPlaylists("p.folder_id = ?", folderID)
+}
+
+// folderRow is a playlist_folders row as needed to check a change.
+type folderRow struct {
+	parent     int
+	owner      string
+	visibility sql.NullString
+}
+
+// folderTree holds every playlist folder by ID.
+type folderTree map[int]folderRow
+
+// loadFolderTree reads every playlist folder.
+func loadFolderTree(q queryer) (folderTree, error) {
+	rows, err := q.Query("SELECT id, COALESCE(parent_id, 0), COALESCE(owner, ''), visibility FROM playlist_folders")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tree := folderTree{}
+	for rows.Next() {
+		var id int
+		var f folderRow
+		if err := rows.Scan(&id, &f.parent, &f.owner, &f.visibility); err != nil {
+			return nil, err
+		}
+		tree[id] = f
+	}
+	return tree, rows.Err()
+}
+
+// depth returns how deep folder id is nested; 0 for the top level.
+func (t folderTree) depth(id int) int {
+	depth := 0
+	for ; id != 0 && depth <= len(t); id = t[id].parent {
+		depth++
+	}
+	return depth
+}
+
+// height returns the depth of the deepest folder in folder id, counting
+// id itself as 1.
+func (t folderTree) height(id int) int {
+	height := 1
+	for child, f := range t {
+		if f.parent == id {
+			if h := t.height(child) + 1; h > height {
+				height = h
+			}
+		}
+	}
+	return height
+}
+
+// contains reports whether folder id is ancestor or inside it.
+func (t folderTree) contains(ancestor, id int) bool {
+	for depth := 0; id != 0 && depth <= len(t); id, depth = t[id].parent, depth+1 {
+		if id == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// visibility returns the visibility in effect for folder id.
+func (t folderTree) visibility(id int) string {
+	for depth := 0; id != 0 && depth <= len(t); id, depth = t[id].parent, depth+1 {
+		if v := t[id].visibility; v.Valid {
+			return v.String
+		}
+	}
+	return models.VisibilityPrivate
+}
+
+// nullID stores a zero ID as NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// folderVisibility stores FolderVisibilityInherit as NULL.
+func folderVisibility(visibility string) sql.NullString {
+	return sql.NullString{String: visibility, Valid: visibility != models.FolderVisibilityInherit}
+}
+
+// placeAt files row id of table (playlists or playlist_folders) under
+// parentID in parentColumn, at 0-based position among its siblings of the
+// same owner, or after them when position is nil, and renumbers them.
+// Siblings are first ordered by their stored position, then by orderBy.
+func placeAt(tx *sql.Tx, table, parentColumn, orderBy string, parentID int, owner string, id int, position *int) error {
+	rows, err := tx.Query(`
+		SELECT id FROM `+table+`
+		WHERE COALESCE(`+parentColumn+`, 0) = ? AND COALESCE(owner, '') = ? AND id != ?
+		ORDER BY position, `+orderBy, parentID, owner, id)
+	if err != nil {
+		return err
+	}
+	var siblings []int
+	for rows.Next() {
+		var sibling int
+		if err := rows.Scan(&sibling); err != nil {
+			rows.Close()
+			return err
+		}
+		siblings = append(siblings, sibling)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	index := len(siblings)
+	if position != nil {
+		if *position < 0 || *position > len(siblings) {
+			return fmt.Errorf("%w: position must be between 0 and %d", ErrInvalidFolderChange, len(siblings))
+		}
+		index = *position
+	}
+	siblings = append(siblings, 0)
+	copy(siblings[index+1:], siblings[index:])
+	siblings[index] = id
+
+	for i, sibling := range siblings {
+		if _, err := tx.Exec(`UPDATE `+table+` SET `+parentColumn+` = ?, position = ? WHERE id = ?`,
+			nullID(parentID), i+1, sibling); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sibling orders for placeAt after the stored position.
+const (
+	playlistOrder = "created_at DESC, id DESC"
+	folderOrder   = "name COLLATE NOCASE, id"
+)
+
+// CreatePlaylistFolder inserts a folder of owner's after the others in
+// parentID (0 for the top level) and returns its ID. visibility may be
+// FolderVisibilityInherit in a parent folder.
+func (db *Database) CreatePlaylistFolder(name, owner, visibility string, parentID int) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tree, err := loadFolderTree(tx)
+	if err != nil {
+		return 0, err
+	}
+	if parentID != 0 {
+		parent, ok := tree[parentID]
+		if !ok {
+			return 0, fmt.Errorf("%w: %d", ErrFolderNotFound, parentID)
+		}
+		if parent.owner != owner {
+			return 0, fmt.Errorf("%w: the parent folder has another owner", ErrInvalidFolderChange)
+		}
+		if tree.depth(parentID) >= MaxPlaylistFolderDepth {
+			return 0, fmt.Errorf("%w: folders nest at most %d deep", ErrInvalidFolderChange, MaxPlaylistFolderDepth)
+		}
+	} else if visibility == models.FolderVisibilityInherit {
+		return 0, fmt.Errorf("%w: top-level folders have nothing to inherit visibility from", ErrInvalidFolderChange)
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO playlist_folders (name, parent_id, owner, visibility)
+		VALUES (?, ?, ?, ?)`, name, nullID(parentID), nullString(owner), folderVisibility(visibility))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := placeAt(tx, "playlist_folders", "parent_id", folderOrder, parentID, owner, int(id), nil); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// UpdatePlaylistFolder renames a folder and sets its visibility, which may
+// be FolderVisibilityInherit for a nested folder. An empty name or
+// visibility keeps the current one.
+func (db *Database) UpdatePlaylistFolder(id int, name, visibility string) error {
+	folder, err := db.GetPlaylistFolder(id)
+	if err != nil {
+		return err
+	}
+	if visibility == models.FolderVisibilityInherit && folder.ParentID == 0 {
+		return fmt.Errorf("%w: top-level folders have nothing to inherit visibility from", ErrInvalidFolderChange)
+	}
+	if name != "" {
+		if _, err := db.conn.Exec("UPDATE playlist_folders SET name = ? WHERE id = ?", name, id); err != nil {
+			return err
+		}
+	}
+	if visibility != "" {
+		if _, err := db.conn.Exec("UPDATE playlist_folders SET visibility = ? WHERE id = ?", folderVisibility(visibility), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MovePlaylistFolder moves a folder, with everything in it, into parentID
+// (0 for the top level) at 0-based position among the folders there, or
+// after them when position is nil. A folder moved to the top level keeps
+// the visibility it inherited.
+func (db *Database) MovePlaylistFolder(id, parentID int, position *int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tree, err := loadFolderTree(tx)
+	if err != nil {
+		return err
+	}
+	folder, ok := tree[id]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrFolderNotFound, id)
+	}
+	if parentID != 0 {
+		parent, ok := tree[parentID]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrFolderNotFound, parentID)
+		}
+		if parent.owner != folder.owner {
+			return fmt.Errorf("%w: the parent folder has another owner", ErrInvalidFolderChange)
+		}
+		if tree.contains(id, parentID) {
+			return fmt.Errorf("%w: a folder cannot be moved into itself", ErrInvalidFolderChange)
+		}
+		if tree.depth(parentID)+tree.height(id) > MaxPlaylistFolderDepth {
+			return fmt.Errorf("%w: folders nest at most %d deep", ErrInvalidFolderChange, MaxPlaylistFolderDepth)
+		}
+	} else if !folder.visibility.Valid {
+		if _, err := tx.Exec("UPDATE playlist_folders SET visibility = ? WHERE id = ?", tree.visibility(id), id); err != nil {
+			return err
+		}
+	}
+
+	if err := placeAt(tx, "playlist_folders", "parent_id", folderOrder, parentID, folder.owner, id, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MovePlaylist files a playlist in folderID (0 for none) at 0-based
+// position among the playlists there, or after them when position is nil.
+// The folder must have the playlist's owner.
+func (db *Database) MovePlaylist(playlistID, folderID int, position *int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner string
+	err = tx.QueryRow("SELECT COALESCE(owner, '') FROM playlists WHERE id = ?", playlistID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %d", ErrPlaylistNotFound, playlistID)
+	}
+	if err != nil {
+		return err
+	}
+	if folderID != 0 {
+		var folderOwner string
+		err = tx.QueryRow("SELECT COALESCE(owner, '') FROM playlist_folders WHERE id = ?", folderID).Scan(&folderOwner)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", ErrFolderNotFound, folderID)
+		}
+		if err != nil {
+			return err
+		}
+		if folderOwner != owner {
+			return fmt.Errorf("%w: the folder has another owner than the playlist", ErrInvalidFolderChange)
+		}
+	}
+
+	if err := placeAt(tx, "playlists", "folder_id", playlistOrder, folderID, owner, playlistID, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeletePlaylistFolder deletes a folder, moving its folders and playlists
+// into its parent after the ones already there. Folders that inherited its
+// visibility and end up at the top level keep it.
+func (db *Database) DeletePlaylistFolder(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tree, err := loadFolderTree(tx)
+	if err != nil {
+		return err
+	}
+	folder, ok := tree[id]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrFolderNotFound, id)
+	}
+
+	moves := []struct{ table, parentColumn, orderBy string }{
+		{"playlist_folders", "parent_id", folderOrder},
+		{"playlists", "folder_id", playlistOrder},
+	}
+	for _, m := range moves {
+		var children []int
+		rows, err := tx.Query(`SELECT id FROM `+m.table+` WHERE `+m.parentColumn+` = ? ORDER BY position, `+m.orderBy, id)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var child int
+			if err := rows.Scan(&child); err != nil {
+				rows.Close()
+				return err
+			}
+			children = append(children, child)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			if m.table == "playlist_folders" && folder.parent == 0 && !tree[child].visibility.Valid {
+				if _, err := tx.Exec("UPDATE playlist_folders SET visibility = ? WHERE id = ?", tree.visibility(id), child); err != nil {
+					return err
+				}
+			}
+			if err := placeAt(tx, m.table, m.parentColumn, m.orderBy, folder.parent, folder.owner, child, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM playlist_folders WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"staccato/pkg/models"
+)
+
+const (
+	// DefaultIngestBatchSize is the number of tracks committed per transaction.
+	DefaultIngestBatchSize = 500
+	// ingestFlushInterval commits a partial batch when extraction is slow, so
+	// a long scan's progress shows up in the library as it goes.
+	ingestFlushInterval = time.Second
+)
+
+// UpsertTracks inserts or updates tracks (matched by file_path) in a single
+// transaction and returns their IDs in order. Nothing is written if any
+// track fails.
+func (db *Database) UpsertTracks(tracks []models.Track) ([]int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt := tx.Stmt(db.upsertTrackStmt)
+	defer stmt.Close()
+
+	ids := make([]int, len(tracks))
+	for i, track := range tracks {
+		if ids[i], err = upsertTrack(stmt, track); err != nil {
+			return nil, fmt.Errorf("failed to upsert %s: %w", track.FilePath, err)
+		}
+		if err = db.replaceTrackArtists(tx, ids[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit tracks: %w", err)
+	}
+	return ids, nil
+}
+
+// BulkIngest feeds tracks from many extraction workers to a single writer
+// goroutine that upserts them in batched transactions, so workers never
+// contend for SQLite's write lock. Create it with NewBulkIngest and always
+// call Close.
+type BulkIngest struct {
+	db        *Database
+	tracks    chan models.Track
+	batchSize int
+	done      chan struct{}
+	written   int64
+	failed    int64
+	err       error // first failure, read after done is closed
+}
+
+// NewBulkIngest starts the writer goroutine. A batchSize below 1 uses
+// DefaultIngestBatchSize.
+func (db *Database) NewBulkIngest(batchSize int) *BulkIngest {
+	if batchSize < 1 {
+		batchSize = DefaultIngestBatchSize
+	}
+	b := &BulkIngest{
+		db:        db,
+		tracks:    make(chan models.Track, batchSize),
+		batchSize: batchSize,
+		done:      make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add queues a track for writing. It blocks while the writer is a full
+// batch behind. Safe for concurrent use; must not be called after Close.
+func (b *BulkIngest) Add(track models.Track) {
+	b.tracks <- track
+}
+
+// Written returns the number of tracks committed so far.
+func (b *BulkIngest) Written() int {
+	return int(atomic.LoadInt64(&b.written))
+}
+
+// Close flushes the remaining tracks, stops the writer and returns the
+// number of tracks written and the first error encountered, if any.
+func (b *BulkIngest) Close() (int, error) {
+	close(b.tracks)
+	<-b.done
+	if b.err != nil {
+		return b.Written(), fmt.Errorf("%d tracks failed to ingest, first error: %w", b.failed, b.err)
+	}
+	return b.Written(), nil
+}
+
+func (b *BulkIngest) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(ingestFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Track, 0, b.batchSize)
+	for {
+		select {
+		case track, ok := <-b.tracks:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, track)
+			if len(batch) >= b.batchSize {
+				b.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				b.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush commits a batch. If the transaction fails the tracks are retried one
+// at a time so a single bad row does not drop the rest of the batch.
+func (b *BulkIngest) flush(batch []models.Track) {
+	if len(batch) == 0 {
+		return
+	}
+	_, err := b.db.UpsertTracks(batch)
+	if err == nil {
+		atomic.AddInt64(&b.written, int64(len(batch)))
+		return
+	}
+	b.db.logger.WithError(err).WithField("batch_size", len(batch)).Warn("Batch ingest failed, retrying tracks individually")
+	for _, track := range batch {
+		if _, err := b.db.InsertTrack(track); err != nil {
+			b.failed++
+			if b.err == nil {
+				b.err = err
+			}
+			continue
+		}
+		atomic.AddInt64(&b.written, 1)
+	}
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+
+	"staccato/pkg/models"
+)
+
+// SetTrackOverride replaces the override for a track. art, when non-nil,
+// becomes the track's album art (ID is the hex MD5 of the data, like embedded
+// art); nil removes any art override.
+func (db *Database) SetTrackOverride(override models.TrackOverride, art []byte) error {
+	var artID sql.NullString
+	if art != nil {
+		artID = sql.NullString{String: fmt.Sprintf("%x", md5.Sum(art)), Valid: true}
+	}
+	_, err := db.conn.Exec(`
+		INSERT INTO track_overrides (track_id, title, artist, album, track_number, album_art_id, album_art, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(track_id) DO UPDATE SET
+			title=excluded.title,
+			artist=excluded.artist,
+			album=excluded.album,
+			track_number=excluded.track_number,
+			album_art_id=excluded.album_art_id,
+			album_art=excluded.album_art,
+			updated_by=excluded.updated_by,
+			updated_at=excluded.updated_at`,
+		override.TrackID, override.Title, override.Artist, override.Album, override.TrackNumber,
+		artID, art, override.UpdatedBy)
+	if err == nil {
+		err = db.replaceTrackArtists(db.conn, override.TrackID)
+	}
+	if err != nil {
+		db.logger.WithError(err).WithField("track_id", override.TrackID).Error("Failed to save track override")
+	}
+	return err
+}
+
+// GetTrackOverride returns the override for a track, or nil if it has none.
+func (db *Database) GetTrackOverride(trackID int) (*models.TrackOverride, error) {
+	rows, err := db.conn.Query(`
+		SELECT track_id, title, artist, album, track_number, album_art_id, COALESCE(updated_by, ''), updated_at
+		FROM track_overrides WHERE track_id = ?`, trackID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides, err := scanOverrideRows(rows)
+	if err != nil || len(overrides) == 0 {
+		return nil, err
+	}
+	return &overrides[0], nil
+}
+
+// DeleteTrackOverride removes a track's override so file tags apply again.
+func (db *Database) DeleteTrackOverride(trackID int) error {
+	if _, err := db.conn.Exec("DELETE FROM track_overrides WHERE track_id = ?", trackID); err != nil {
+		return err
+	}
+	return db.replaceTrackArtists(db.conn, trackID)
+}
+
+// GetOverrideArt returns album art stored with an override by its ID.
+func (db *Database) GetOverrideArt(artID string) ([]byte, bool) {
+	var data []byte
+	err := db.conn.QueryRow(`
+		SELECT album_art FROM track_overrides WHERE album_art_id = ? LIMIT 1`, artID).Scan(&data)
+	if err != nil || data == nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// GetOverridesForExport returns every override on tracks in the scope with
+// its album art inlined. Path holds the track's file_path; callers make it
+// relative to the library root.
+func (db *Database) GetOverridesForExport(scope StatsScope) ([]models.TrackOverrideExport, error) {
+	cond, args := scope.where()
+	rows, err := db.conn.Query(`
+		SELECT t.file_path, COALESCE(t.owner, ''), o.album_art,
+			o.track_id, o.title, o.artist, o.album, o.track_number, o.album_art_id, COALESCE(o.updated_by, ''), o.updated_at
+		FROM track_overrides o
+		JOIN tracks t ON t.id = o.track_id
+		WHERE `+cond+`
+		ORDER BY t.file_path`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []models.TrackOverrideExport{}
+	for rows.Next() {
+		var e models.TrackOverrideExport
+		var artID sql.NullString
+		if err := rows.Scan(&e.Path, &e.Owner, &e.AlbumArt,
+			&e.TrackID, &e.Title, &e.Artist, &e.Album, &e.TrackNumber, &artID, &e.UpdatedBy, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		e.AlbumArtID = artID.String
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// scanOverrideRows converts rows of track_overrides columns into models.
+func scanOverrideRows(rows *sql.Rows) ([]models.TrackOverride, error) {
+	var overrides []models.TrackOverride
+	for rows.Next() {
+		var o models.TrackOverride
+		var artID sql.NullString
+		if err := rows.Scan(&o.TrackID, &o.Title, &o.Artist, &o.Album, &o.TrackNumber, &artID, &o.UpdatedBy, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		o.AlbumArtID = artID.String
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
@@ -0,0 +1,335 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+)
+
+// ErrInvalidPlaylistEdit is returned when a playlist edit names an entry
+// that is not in the playlist, a position out of range or an unknown
+// operation or sort field. Nothing is changed.
+var ErrInvalidPlaylistEdit = errors.New("invalid playlist edit")
+
+// Playlist sort fields accepted by SortPlaylist.
+const (
+	PlaylistSortTitle    = "title"
+	PlaylistSortArtist   = "artist"
+	PlaylistSortAlbum    = "album"
+	PlaylistSortYear     = "year"
+	PlaylistSortDuration = "duration"
+	PlaylistSortAdded    = "added"
+)
+
+// playlistEntry is a playlist_tracks row being edited. A zero id is an entry
+// not yet stored.
+type playlistEntry struct {
+	id      int
+	addedAt sql.NullTime
+	addedBy string
+	track   models.Track
+}
+
+// GetPlaylistEntries returns a playlist's entries in order. Entries whose
+// track was deleted are left out.
+func (db *Database) GetPlaylistEntries(playlistID int) ([]models.PlaylistEntry, error) {
+	entries, err := loadPlaylistEntries(db.conn, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]models.PlaylistEntry, len(entries))
+	for i, e := range entries {
+		result[i] = models.PlaylistEntry{Track: e.track, EntryID: e.id, AddedBy: e.addedBy}
+		if e.addedAt.Valid {
+			addedAt := e.addedAt.Time
+			result[i].AddedAt = &addedAt
+		}
+	}
+	return result, nil
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// loadPlaylistEntries reads a playlist's entries in order.
+func loadPlaylistEntries(q queryer, playlistID int) ([]playlistEntry, error) {
+	rows, err := q.Query(`
+		SELECT `+trackColumns("t")+`, pt.id, pt.added_at, COALESCE(pt.added_by, '')
+		FROM track_view t
+		JOIN playlist_tracks pt ON t.id = pt.track_id
+		WHERE pt.playlist_id = ?
+		ORDER BY pt.position, pt.id`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []playlistEntry
+	for rows.Next() {
+		var e playlistEntry
+		if e.track, err = scanTrack(rows, &e.id, &e.addedAt, &e.addedBy); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// rearrangePlaylist loads a playlist's entries, lets change return their new
+// list and stores it in one transaction: entries left out are deleted, new
+// ones inserted and every entry renumbered.
+func (db *Database) rearrangePlaylist(playlistID int, change func([]playlistEntry) ([]playlistEntry, error)) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := loadPlaylistEntries(tx, playlistID)
+	if err != nil {
+		return err
+	}
+	after, err := change(append([]playlistEntry(nil), before...))
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[int]bool, len(after))
+	for _, e := range after {
+		kept[e.id] = true
+	}
+	for _, e := range before {
+		if !kept[e.id] {
+			if _, err := tx.Exec("DELETE FROM playlist_tracks WHERE id = ?", e.id); err != nil {
+				return err
+			}
+		}
+	}
+	// Entries of deleted tracks were not loaded; drop them with the rest
+	if _, err := tx.Exec(`
+		DELETE FROM playlist_tracks
+		WHERE playlist_id = ? AND track_id NOT IN (SELECT id FROM tracks)`, playlistID); err != nil {
+		return err
+	}
+
+	for i, e := range after {
+		if e.id == 0 {
+			_, err = tx.Exec(`
+				INSERT INTO playlist_tracks (playlist_id, track_id, position, added_by)
+				VALUES (?, ?, ?, ?)`, playlistID, e.track.ID, i+1, nullString(e.addedBy))
+		} else {
+			_, err = tx.Exec(`
+				UPDATE playlist_tracks SET track_id = ?, position = ?
+				WHERE id = ?`, e.track.ID, i+1, e.id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save playlist entry: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// EditPlaylist applies ops to a playlist in order, all or none: if one is
+// invalid, an error wrapping ErrInvalidPlaylistEdit is returned and the
+// playlist is unchanged. Inserted entries record addedBy as who added them.
+// Track IDs are not checked; callers validate access to inserted tracks.
+func (db *Database) EditPlaylist(playlistID int, ops []models.PlaylistOp, addedBy string) error {
+	return db.rearrangePlaylist(playlistID, func(entries []playlistEntry) ([]playlistEntry, error) {
+		for i, op := range ops {
+			var err error
+			if entries, err = applyPlaylistOp(entries, op, addedBy); err != nil {
+				return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPlaylistEdit, i+1, err)
+			}
+		}
+		return entries, nil
+	})
+}
+
+// applyPlaylistOp applies one edit operation to entries, by addedBy.
+func applyPlaylistOp(entries []playlistEntry, op models.PlaylistOp, addedBy string) ([]playlistEntry, error) {
+	index := -1
+	if op.Op != models.PlaylistOpInsert {
+		for i, e := range entries {
+			if e.id == op.EntryID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("entry %d is not in the playlist", op.EntryID)
+		}
+	}
+	if (op.Op == models.PlaylistOpInsert || op.Op == models.PlaylistOpReplace) && op.TrackID <= 0 {
+		return nil, fmt.Errorf("%s needs a trackId", op.Op)
+	}
+
+	switch op.Op {
+	case models.PlaylistOpMove:
+		if op.Position == nil || *op.Position < 0 || *op.Position >= len(entries) {
+			return nil, fmt.Errorf("move position must be between 0 and %d", len(entries)-1)
+		}
+		e := entries[index]
+		entries = append(entries[:index], entries[index+1:]...)
+		return insertEntry(entries, *op.Position, e), nil
+	case models.PlaylistOpInsert:
+		position := len(entries)
+		if op.Position != nil {
+			if *op.Position < 0 || *op.Position > len(entries) {
+				return nil, fmt.Errorf("insert position must be between 0 and %d", len(entries))
+			}
+			position = *op.Position
+		}
+		return insertEntry(entries, position, playlistEntry{track: models.Track{ID: op.TrackID}, addedBy: addedBy}), nil
+	case models.PlaylistOpRemove:
+		return append(entries[:index], entries[index+1:]...), nil
+	case models.PlaylistOpReplace:
+		entries[index].track = models.Track{ID: op.TrackID}
+		return entries, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// insertEntry inserts e into entries at index.
+func insertEntry(entries []playlistEntry, index int, e playlistEntry) []playlistEntry {
+	entries = append(entries, playlistEntry{})
+	copy(entries[index+1:], entries[index:])
+	entries[index] = e
+	return entries
+}
+
+// SortPlaylist reorders a playlist by one of the PlaylistSort fields. Text
+// fields sort like track listings (sort tags, no leading articles); entries
+// that tie keep their order.
+func (db *Database) SortPlaylist(playlistID int, field string, descending bool) error {
+	var numeric func(e playlistEntry) int64
+	var order metadata.TrackOrder
+	switch field {
+	case PlaylistSortTitle:
+		order = metadata.ByTitle
+	case PlaylistSortArtist:
+		order = metadata.ByArtist
+	case PlaylistSortAlbum:
+		order = metadata.ByAlbum
+	case PlaylistSortYear:
+		numeric = func(e playlistEntry) int64 { return int64(e.track.Year) }
+	case PlaylistSortDuration:
+		numeric = func(e playlistEntry) int64 { return int64(e.track.Duration) }
+	case PlaylistSortAdded:
+		numeric = func(e playlistEntry) int64 { return e.addedAt.Time.UnixNano() }
+	default:
+		return fmt.Errorf("%w: unknown sort field %q", ErrInvalidPlaylistEdit, field)
+	}
+
+	return db.rearrangePlaylist(playlistID, func(entries []playlistEntry) ([]playlistEntry, error) {
+		sorted := make([]playlistEntry, 0, len(entries))
+		if numeric != nil {
+			sorted = append(sorted, entries...)
+			sort.SliceStable(sorted, func(i, j int) bool { return numeric(sorted[i]) < numeric(sorted[j]) })
+		} else {
+			tracks := make([]models.Track, len(entries))
+			for i, e := range entries {
+				tracks[i] = e.track
+			}
+			for _, i := range db.sorter.SortIndexes(tracks, order) {
+				sorted = append(sorted, entries[i])
+			}
+		}
+		if descending {
+			for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+				sorted[i], sorted[j] = sorted[j], sorted[i]
+			}
+		}
+		return sorted, nil
+	})
+}
+
+// ShufflePlaylist puts a playlist's entries in random order.
+func (db *Database) ShufflePlaylist(playlistID int) error {
+	return db.rearrangePlaylist(playlistID, func(entries []playlistEntry) ([]playlistEntry, error) {
+		rand.Shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+		return entries, nil
+	})
+}
+
+// DedupePlaylist removes every entry of a track but its first and returns
+// how many entries were removed.
+func (db *Database) DedupePlaylist(playlistID int) (int, error) {
+	removed := 0
+	err := db.rearrangePlaylist(playlistID, func(entries []playlistEntry) ([]playlistEntry, error) {
+		seen := map[int]bool{}
+		kept := entries[:0]
+		for _, e := range entries {
+			if !seen[e.track.ID] {
+				seen[e.track.ID] = true
+				kept = append(kept, e)
+			}
+		}
+		removed = len(entries) - len(kept)
+		return kept, nil
+	})
+	return removed, err
+}
+
+// CreateFilePlaylist inserts a playlist mirroring the playlist file at
+// filePath and returns its ID.
+func (db *Database) CreateFilePlaylist(name, owner, visibility, filePath string) (int, error) {
+	result, err := db.conn.Exec(`
+		INSERT INTO playlists (name, description, owner, visibility, file_path)
+		VALUES (?, '', ?, ?, ?)`, name, nullString(owner), visibility, filePath)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// GetPlaylistByFile returns the playlist mirroring the playlist file at
+// filePath, or ErrPlaylistNotFound.
+func (db *Database) GetPlaylistByFile(filePath string) (*models.Playlist, error) {
+	playlists, err := db.queryPlaylists("p.file_path = ?", filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(playlists) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPlaylistNotFound, filePath)
+	}
+	return &playlists[0], nil
+}
+
+// GetFilePlaylists returns every playlist mirroring a playlist file.
+func (db *Database) GetFilePlaylists() ([]models.Playlist, error) {
+	return db.queryPlaylists("p.file_path IS NOT NULL")
+}
+
+// SetPlaylistTracks makes a playlist's entries the given tracks in order and
+// reports whether that changed it. Each track keeps an existing entry of
+// that track, in order, where there is one; other tracks get new entries.
+func (db *Database) SetPlaylistTracks(playlistID int, trackIDs []int) (bool, error) {
+	changed := false
+	err := db.rearrangePlaylist(playlistID, func(entries []playlistEntry) ([]playlistEntry, error) {
+		byTrack := make(map[int][]playlistEntry)
+		for _, e := range entries {
+			byTrack[e.track.ID] = append(byTrack[e.track.ID], e)
+		}
+		changed = len(entries) != len(trackIDs)
+		result := make([]playlistEntry, len(trackIDs))
+		for i, id := range trackIDs {
+			if reusable := byTrack[id]; len(reusable) > 0 {
+				result[i], byTrack[id] = reusable[0], reusable[1:]
+			} else {
+				result[i].track = models.Track{ID: id}
+			}
+			changed = changed || i >= len(entries) || entries[i].id != result[i].id
+		}
+		return result, nil
+	})
+	return changed, err
+}
@@ -0,0 +1,12 @@
+package database
+
+// RecordPlay counts a play of a track and makes now its last play.
+func (db *Database) RecordPlay(trackID int) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO track_plays (track_id, play_count, last_played)
+		VALUES (?, 1, CURRENT_TIMESTAMP)
+		ON CONFLICT(track_id) DO UPDATE SET
+			play_count=play_count + 1,
+			last_played=excluded.last_played`, trackID)
+	return err
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"staccato/pkg/models"
+)
+
+// GetFileTracks returns every track with the tags read from its file, without
+// overrides applied, ordered by path. Library export and import identify
+// tracks by these values.
+func (db *Database) GetFileTracks() ([]models.Track, error) {
+	return db.queryTracks(`
+		SELECT ` + trackColumns("") + `
+		FROM (SELECT t.*, ` + mainArtistSQL + ` AS main_artist FROM tracks t)
+		ORDER BY file_path`)
+}
+
+// GetPlaylistsForExport returns every playlist, oldest first, with the IDs of
+// its tracks in playlist order, who added them and its collaborators.
+// Playlists have their own visibility, not that of their folder.
+func (db *Database) GetPlaylistsForExport() ([]models.PlaylistExport, error) {
+	rows, err := db.conn.Query(`
+		SELECT p.id, p.name, COALESCE(p.description, ''), COALESCE(p.cover_path, ''),
+			COALESCE(p.owner, ''), COALESCE(p.visibility, 'public'), p.created_at, COALESCE(p.smart_rules, ''),
+			COALESCE(p.folder_id, 0), p.position, pt.track_id, COALESCE(pt.added_by, '')
+		FROM playlists p
+		LEFT JOIN playlist_tracks pt ON pt.playlist_id = p.id
+		ORDER BY p.created_at, p.id, pt.position, pt.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []models.PlaylistExport{}
+	index := map[int]int{}
+	lastID := 0
+	for rows.Next() {
+		var id int
+		var p models.PlaylistExport
+		var trackID sql.NullInt64
+		var rules, addedBy string
+		if err := rows.Scan(&id, &p.Name, &p.Description, &p.CoverPath, &p.Owner, &p.Visibility, &p.CreatedAt, &rules,
+			&p.Folder, &p.Position, &trackID, &addedBy); err != nil {
+			return nil, err
+		}
+		if id != lastID {
+			if rules != "" {
+				p.Rules = &models.SmartRules{}
+				if err := json.Unmarshal([]byte(rules), p.Rules); err != nil {
+					return nil, err
+				}
+			}
+			p.Tracks = []int{}
+			index[id] = len(playlists)
+			playlists = append(playlists, p)
+			lastID = id
+		}
+		if trackID.Valid {
+			last := &playlists[len(playlists)-1]
+			last.Tracks = append(last.Tracks, int(trackID.Int64))
+			last.AddedBy = append(last.AddedBy, addedBy)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Entries nobody is recorded to have added need no addedBy
+	for i := range playlists {
+		if strings.Join(playlists[i].AddedBy, "") == "" {
+			playlists[i].AddedBy = nil
+		}
+	}
+
+	rows, err = db.conn.Query(`
+		SELECT playlist_id, username, role, COALESCE(added_by, ''), added_at
+		FROM playlist_collaborators
+		ORDER BY playlist_id, username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var c models.PlaylistCollaborator
+		if err := rows.Scan(&id, &c.Username, &c.Role, &c.AddedBy, &c.AddedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			playlists[i].Collaborators = append(playlists[i].Collaborators, c)
+		}
+	}
+	return playlists, rows.Err()
+}
+
+// GetPlaylistFoldersForExport returns every playlist folder, parents before
+// the folders in them.
+func (db *Database) GetPlaylistFoldersForExport() ([]models.PlaylistFolderExport, error) {
+	rows, err := db.conn.Query(`
+		WITH RECURSIVE tree(id, depth) AS (
+			SELECT id, 0 FROM playlist_folders WHERE parent_id IS NULL
+			UNION ALL
+			SELECT f.id, tree.depth + 1 FROM playlist_folders f JOIN tree ON f.parent_id = tree.id
+		)
+		SELECT f.id, f.name, COALESCE(f.parent_id, 0), COALESCE(f.owner, ''),
+			COALESCE(f.visibility, ?), f.position
+		FROM playlist_folders f
+		JOIN tree ON tree.id = f.id
+		ORDER BY tree.depth, f.position, f.id`, models.FolderVisibilityInherit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []models.PlaylistFolderExport{}
+	for rows.Next() {
+		var f models.PlaylistFolderExport
+		if err := rows.Scan(&f.ID, &f.Name, &f.Parent, &f.Owner, &f.Visibility, &f.Position); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+// InsertPlaylist creates a playlist from an export, keeping its creation
+// time and owner, and returns its ID. Playlists exported without a
+// visibility were visible to everyone and stay public. Tracks, folder and
+// collaborators are added separately; smart playlists keep their rules.
+func (db *Database) InsertPlaylist(p models.PlaylistExport) (int, error) {
+	if p.Visibility == "" {
+		p.Visibility = models.VisibilityPublic
+	}
+	var rules sql.NullString
+	if p.Rules != nil {
+		data, err := json.Marshal(p.Rules)
+		if err != nil {
+			return 0, err
+		}
+		rules = sql.NullString{String: string(data), Valid: true}
+	}
+	var id int
+	err := db.conn.QueryRow(`
+		INSERT INTO playlists (name, description, cover_path, owner, visibility, created_at, smart_rules)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`, p.Name, p.Description, nullString(p.CoverPath), nullString(p.Owner), p.Visibility, p.CreatedAt, rules).Scan(&id)
+	return id, err
+}
+
+// InsertPlaylistCollaborator gives a collaborator from an export their role
+// on a playlist, keeping when and by whom they were added. An existing
+// collaborator only gets the role.
+func (db *Database) InsertPlaylistCollaborator(playlistID int, c models.PlaylistCollaborator) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO playlist_collaborators (playlist_id, username, role, added_by, added_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (playlist_id, username) DO UPDATE SET role = excluded.role`,
+		playlistID, c.Username, c.Role, nullString(c.AddedBy), c.AddedAt)
+	return err
+}
+
+// GetDownloadJobsForExport returns the download history, oldest first. Path
+// holds the job's output_path; callers make it relative to the library root.
+func (db *Database) GetDownloadJobsForExport() ([]models.DownloadExport, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, COALESCE(url, ''), COALESCE(title, ''), COALESCE(artist, ''), COALESCE(status, ''),
+			COALESCE(error, ''), COALESCE(output_path, ''), created_at, completed_at
+		FROM download_jobs
+		ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.DownloadExport{}
+	for rows.Next() {
+		var j models.DownloadExport
+		var createdAt, completedAt sql.NullTime
+		if err := rows.Scan(&j.ID, &j.URL, &j.Title, &j.Artist, &j.Status, &j.Error, &j.Path, &createdAt, &completedAt); err != nil {
+			return nil, err
+		}
+		j.CreatedAt = createdAt.Time
+		if completedAt.Valid {
+			j.CompletedAt = &completedAt.Time
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
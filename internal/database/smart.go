@@ -0,0 +1,398 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+)
+
+// ErrInvalidSmartRules is returned (wrapped, naming the problem) for smart
+// playlist rules that use an unknown field, operator or sort, a value of
+// the wrong type, or nest too deeply.
+var ErrInvalidSmartRules = errors.New("invalid smart playlist rules")
+
+const (
+	// MaxSmartRuleDepth is how deeply rule groups may nest.
+	MaxSmartRuleDepth = 4
+	// MaxSmartRules bounds the conditions of a smart playlist.
+	MaxSmartRules = 100
+	// MaxSmartLimit bounds the limit of a smart playlist.
+	MaxSmartLimit = 10000
+)
+
+// Smart playlist rule operators.
+const (
+	SmartIs           = "is"
+	SmartIsNot        = "isNot"
+	SmartContains     = "contains"
+	SmartNotContains  = "notContains"
+	SmartStartsWith   = "startsWith"
+	SmartEndsWith     = "endsWith"
+	SmartGreater      = "gt"
+	SmartGreaterEqual = "gte"
+	SmartLess         = "lt"
+	SmartLessEqual    = "lte"
+	SmartBefore       = "before"
+	SmartAfter        = "after"
+	SmartInTheLast    = "inTheLast"
+	SmartNotInTheLast = "notInTheLast"
+)
+
+// smartKind is the type of a smart playlist field, which decides the
+// operators and values it takes.
+type smartKind int
+
+const (
+	smartText smartKind = iota
+	smartNumber
+	smartDate
+	smartBool
+)
+
+// smartField is a track field rules can test: a track_view expression and
+// its kind.
+type smartField struct {
+	expr string
+	kind smartKind
+}
+
+// smartFields are the fields smart playlist rules can test, by JSON name.
+var smartFields = map[string]smartField{
+	"title":       {"title", smartText},
+	"artist":      {"artist", smartText},
+	"album":       {"album", smartText},
+	"albumArtist": {"COALESCE(album_artist, '')", smartText},
+	"composer":    {"COALESCE(composer, '')", smartText},
+	"genre":       {"COALESCE(genre, '')", smartText},
+	"format":      {formatExpr, smartText},
+	"year":        {"year", smartNumber},
+	"duration":    {"duration", smartNumber},
+	"fileSize":    {"file_size", smartNumber},
+	"trackNumber": {"track_number", smartNumber},
+	"disc":        {"disc", smartNumber},
+	"playCount":   {"play_count", smartNumber},
+	"added":       {"created_at", smartDate},
+	"lastPlayed":  {"last_played", smartDate},
+	"compilation": {"compilation", smartBool},
+	"hasAlbumArt": {"has_album_art", smartBool},
+}
+
+// smartOperators are the operators each kind of field takes.
+var smartOperators = map[smartKind][]string{
+	smartText:   {SmartIs, SmartIsNot, SmartContains, SmartNotContains, SmartStartsWith, SmartEndsWith},
+	smartNumber: {SmartIs, SmartIsNot, SmartGreater, SmartGreaterEqual, SmartLess, SmartLessEqual},
+	smartDate:   {SmartBefore, SmartAfter, SmartInTheLast, SmartNotInTheLast},
+	smartBool:   {SmartIs, SmartIsNot},
+}
+
+// smartSort is a sort field: the SQL expressions it orders by or, for
+// names, the order the tracks are put in afterwards so it matches other
+// track listings (which SQL cannot do: articles, sort tags, collation).
+type smartSort struct {
+	exprs []string
+	order metadata.TrackOrder
+	names bool
+}
+
+// smartSorts are the fields smart playlists can be sorted by.
+var smartSorts = map[string]smartSort{
+	"title":      {nil, metadata.ByTitle, true},
+	"artist":     {nil, metadata.ByArtist, true},
+	"album":      {nil, metadata.ByAlbum, true},
+	"year":       {[]string{"year"}, 0, false},
+	"duration":   {[]string{"duration"}, 0, false},
+	"fileSize":   {[]string{"file_size"}, 0, false},
+	"added":      {[]string{"created_at"}, 0, false},
+	"playCount":  {[]string{"play_count"}, 0, false},
+	"lastPlayed": {[]string{"last_played"}, 0, false},
+	"random":     {[]string{"RANDOM()"}, 0, false},
+}
+
+// smartQuery is compiled smart playlist rules.
+type smartQuery struct {
+	where string
+	args  []interface{}
+	order string
+	sort  smartSort
+}
+
+// ValidateSmartRules checks that rules can be evaluated. The error wraps
+// ErrInvalidSmartRules and names the first problem found.
+func ValidateSmartRules(rules models.SmartRules) error {
+	_, err := compileSmartRules(rules)
+	return err
+}
+
+// compileSmartRules turns rules into a WHERE condition on track_view and,
+// unless they sort by names, an ORDER BY/LIMIT clause.
+func compileSmartRules(rules models.SmartRules) (*smartQuery, error) {
+	c := &smartCompiler{}
+	where, err := c.group(rules.Match, rules.Rules, 1)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSmartRules, err)
+	}
+
+	sortName := rules.Sort
+	if sortName == "" {
+		sortName = "artist"
+	}
+	sort, ok := smartSorts[sortName]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidSmartRules, rules.Sort)
+	}
+	if rules.Limit < 0 || rules.Limit > MaxSmartLimit {
+		return nil, fmt.Errorf("%w: limit must be between 0 and %d", ErrInvalidSmartRules, MaxSmartLimit)
+	}
+
+	var order string
+	if !sort.names {
+		direction := ""
+		if rules.Descending {
+			direction = " DESC"
+		}
+		order = " ORDER BY " + strings.Join(sort.exprs, direction+", ") + direction
+		if rules.Limit > 0 {
+			order += fmt.Sprintf(" LIMIT %d", rules.Limit)
+		}
+	}
+	return &smartQuery{where: where, args: c.args, order: order, sort: sort}, nil
+}
+
+// smartCompiler accumulates the arguments of the condition being compiled.
+type smartCompiler struct {
+	args  []interface{}
+	count int
+}
+
+// group compiles rules combined per match. An empty group matches every track.
+func (c *smartCompiler) group(match string, rules []models.SmartRule, depth int) (string, error) {
+	if depth > MaxSmartRuleDepth {
+		return "", fmt.Errorf("rule groups nest deeper than %d levels", MaxSmartRuleDepth)
+	}
+	joiner := " AND "
+	switch match {
+	case "", models.MatchAll:
+	case models.MatchAny:
+		joiner = " OR "
+	default:
+		return "", fmt.Errorf("match must be %s or %s", models.MatchAll, models.MatchAny)
+	}
+	if len(rules) == 0 {
+		return "1 = 1", nil
+	}
+
+	conds := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		var cond string
+		var err error
+		if rule.IsGroup() {
+			cond, err = c.group(rule.Match, rule.Rules, depth+1)
+		} else {
+			cond, err = c.condition(rule)
+		}
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, cond)
+	}
+	return "(" + strings.Join(conds, joiner) + ")", nil
+}
+
+// condition compiles a single rule.
+func (c *smartCompiler) condition(rule models.SmartRule) (string, error) {
+	if c.count++; c.count > MaxSmartRules {
+		return "", fmt.Errorf("more than %d rules", MaxSmartRules)
+	}
+	field, ok := smartFields[rule.Field]
+	if !ok {
+		return "", fmt.Errorf("unknown field %q", rule.Field)
+	}
+	if !slices.Contains(smartOperators[field.kind], rule.Operator) {
+		return "", fmt.Errorf("operator %q does not apply to %s", rule.Operator, rule.Field)
+	}
+	invalid := func(want string) error {
+		return fmt.Errorf("%s %s needs %s", rule.Field, rule.Operator, want)
+	}
+
+	switch field.kind {
+	case smartText:
+		var value string
+		if json.Unmarshal(rule.Value, &value) != nil {
+			return "", invalid("a string")
+		}
+		if rule.Field == "format" {
+			value = strings.ToLower(strings.TrimPrefix(value, "."))
+		}
+		like := func(pattern string) string {
+			c.args = append(c.args, pattern)
+			return field.expr + " LIKE ? ESCAPE '\\'"
+		}
+		escaped := escapeLike(value)
+		switch rule.Operator {
+		case SmartIs:
+			c.args = append(c.args, value)
+			return field.expr + " = ? COLLATE NOCASE", nil
+		case SmartIsNot:
+			c.args = append(c.args, value)
+			return field.expr + " <> ? COLLATE NOCASE", nil
+		case SmartContains:
+			return like("%" + escaped + "%"), nil
+		case SmartNotContains:
+			return "NOT " + like("%"+escaped+"%"), nil
+		case SmartStartsWith:
+			return like(escaped + "%"), nil
+		case SmartEndsWith:
+			return like("%" + escaped), nil
+		}
+
+	case smartNumber:
+		var value float64
+		if json.Unmarshal(rule.Value, &value) != nil {
+			return "", invalid("a number")
+		}
+		if op, ok := comparisonOps[rule.Operator]; ok {
+			c.args = append(c.args, value)
+			return field.expr + " " + op + " ?", nil
+		}
+
+	case smartDate:
+		switch rule.Operator {
+		case SmartInTheLast, SmartNotInTheLast:
+			var days int
+			if json.Unmarshal(rule.Value, &days) != nil || days <= 0 {
+				return "", invalid("a positive number of days")
+			}
+			c.args = append(c.args, fmt.Sprintf("-%d days", days))
+			if rule.Operator == SmartInTheLast {
+				return field.expr + " >= datetime('now', ?)", nil
+			}
+			// Tracks never played were not played in the last days either
+			return "(" + field.expr + " IS NULL OR " + field.expr + " < datetime('now', ?))", nil
+		case SmartBefore, SmartAfter:
+			var value string
+			if json.Unmarshal(rule.Value, &value) != nil {
+				return "", invalid("a date (YYYY-MM-DD)")
+			}
+			day, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return "", invalid("a date (YYYY-MM-DD)")
+			}
+			if rule.Operator == SmartBefore {
+				c.args = append(c.args, day.Format("2006-01-02 15:04:05"))
+				return field.expr + " < ?", nil
+			}
+			c.args = append(c.args, day.AddDate(0, 0, 1).Format("2006-01-02 15:04:05"))
+			return field.expr + " >= ?", nil
+		}
+
+	case smartBool:
+		var value bool
+		if json.Unmarshal(rule.Value, &value) != nil {
+			return "", invalid("true or false")
+		}
+		switch rule.Operator {
+		case SmartIs, SmartIsNot:
+			if rule.Operator == SmartIsNot {
+				value = !value
+			}
+			c.args = append(c.args, value)
+			return "COALESCE(" + field.expr + ", 0) = ?", nil
+		}
+	}
+	return "", fmt.Errorf("operator %q does not apply to %s", rule.Operator, rule.Field)
+}
+
+// comparisonOps are the SQL operators of the numeric comparisons.
+var comparisonOps = map[string]string{
+	SmartIs:           "=",
+	SmartIsNot:        "<>",
+	SmartGreater:      ">",
+	SmartGreaterEqual: ">=",
+	SmartLess:         "<",
+	SmartLessEqual:    "<=",
+}
+
+// escapeLike escapes LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// CreateSmartPlaylist inserts a smart playlist owned by owner and returns
+// its ID. Rules must have been validated.
+func (db *Database) CreateSmartPlaylist(name, description, owner, visibility string, rules models.SmartRules) (int, error) {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.conn.Exec(`
+		INSERT INTO playlists (name, description, owner, visibility, smart_rules)
+		VALUES (?, ?, ?, ?, ?)`, name, description, nullString(owner), visibility, string(data))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// UpdateSmartRules replaces the rules of a smart playlist. Rules must have
+// been validated.
+func (db *Database) UpdateSmartRules(playlistID int, rules models.SmartRules) error {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	_, err = db.conn.Exec("UPDATE playlists SET smart_rules = ? WHERE id = ? AND smart_rules IS NOT NULL", string(data), playlistID)
+	return err
+}
+
+// GetSmartPlaylistTracks evaluates smart playlist rules over the scope's
+// tracks.
+func (db *Database) GetSmartPlaylistTracks(rules models.SmartRules, scope StatsScope) ([]models.Track, error) {
+	q, err := compileSmartRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	cond, args := scope.where()
+	tracks, err := db.queryTracks(`
+		SELECT `+trackColumns("")+`
+		FROM track_view
+		WHERE `+cond+` AND `+q.where+q.order, append(args, q.args...)...)
+	if err != nil {
+		return nil, err
+	}
+	if q.sort.names {
+		db.sorter.SortTracks(tracks, q.sort.order)
+		if rules.Descending {
+			for i, j := 0, len(tracks)-1; i < j; i, j = i+1, j-1 {
+				tracks[i], tracks[j] = tracks[j], tracks[i]
+			}
+		}
+		if rules.Limit > 0 && len(tracks) > rules.Limit {
+			tracks = tracks[:rules.Limit]
+		}
+	}
+	return tracks, nil
+}
+
+// CountSmartPlaylistTracks returns how many of the scope's tracks a smart
+// playlist holds.
+func (db *Database) CountSmartPlaylistTracks(rules models.SmartRules, scope StatsScope) (int, error) {
+	q, err := compileSmartRules(rules)
+	if err != nil {
+		return 0, err
+	}
+	cond, args := scope.where()
+	query := "SELECT COUNT(*) FROM track_view WHERE " + cond + " AND " + q.where
+	if rules.Limit > 0 {
+		query = fmt.Sprintf("SELECT MIN(COUNT(*), %d) FROM track_view WHERE %s AND %s", rules.Limit, cond, q.where)
+	}
+	var count int
+	err = db.conn.QueryRow(query, append(args, q.args...)...).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"staccato/pkg/models"
+)
+
+// formatExpr is the lower-cased extension (without dot) of a track's audio
+// file in SQL, or empty if its name has none. Cue sheet tracks use the file
+// they are cut from.
+var formatExpr = extensionSQL(baseNameSQL("COALESCE(source_path, file_path)"))
+
+// afterLastSQL returns the part of the SQL string expr after the last sep,
+// or all of it if it has none: RTRIM strips the characters after the last
+// sep, leaving the prefix to skip.
+func afterLastSQL(expr, sep string) string {
+	return "SUBSTR(" + expr + ", LENGTH(RTRIM(" + expr + ", REPLACE(" + expr + ", '" + sep + "', ''))) + 1)"
+}
+
+// baseNameSQL returns the last element of the slash-separated path expr.
+func baseNameSQL(path string) string {
+	return afterLastSQL(path, "/")
+}
+
+// extensionSQL returns the lower-cased extension of the file name expr,
+// or empty if it has none.
+func extensionSQL(name string) string {
+	return "CASE WHEN INSTR(" + name + ", '.') > 0 THEN LOWER(" + afterLastSQL(name, ".") + ") ELSE '' END"
+}
+
+// StatsScope selects which tracks aggregate queries cover: every track, a
+// single user's tracks (Owner) or, by default, the main library.
+type StatsScope struct {
+	All   bool
+	Owner string
+}
+
+// where returns the SQL condition and arguments for the scope.
+func (s StatsScope) where() (string, []interface{}) {
+	switch {
+	case s.All:
+		return "1 = 1", nil
+	case s.Owner != "":
+		return "owner = ?", []interface{}{s.Owner}
+	default:
+		return "(owner IS NULL OR owner = '')", nil
+	}
+}
+
+// CountTracks returns the number of tracks in the scope using COUNT(*).
+func (db *Database) CountTracks(scope StatsScope) (int, error) {
+	cond, args := scope.where()
+	var count int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM tracks WHERE "+cond, args...).Scan(&count)
+	return count, err
+}
+
+// GetLibraryStats computes library statistics for the scope with SQL
+// aggregates.
+func (db *Database) GetLibraryStats(scope StatsScope) (*models.LibraryStats, error) {
+	cond, args := scope.where()
+	stats := &models.LibraryStats{}
+
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*),
+			COUNT(DISTINCT artist),
+			COALESCE(SUM(duration), 0),
+			COALESCE(SUM(file_size), 0),
+			COALESCE(SUM(CASE WHEN has_album_art THEN 0 ELSE 1 END), 0),
+			COALESCE(SUM(CASE WHEN artist = 'Unknown Artist' OR album = 'Unknown Album' THEN 1 ELSE 0 END), 0)
+		FROM track_view WHERE `+cond, args...).Scan(
+		&stats.TrackCount, &stats.ArtistCount, &stats.TotalDuration, &stats.TotalSize,
+		&stats.MissingArt, &stats.MissingTags)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM (
+			SELECT 1 FROM track_view WHERE `+cond+` GROUP BY album_group, album, year
+		)`, args...).Scan(&stats.AlbumCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Formats, err = db.statBuckets(formatExpr, cond, args, "count DESC, key"); err != nil {
+		return nil, err
+	}
+	if stats.Codecs, err = db.statBuckets("COALESCE(codec, '')", cond, args, "count DESC, key"); err != nil {
+		return nil, err
+	}
+	if stats.Owners, err = db.statBuckets("COALESCE(owner, '')", cond, args, "count DESC, key"); err != nil {
+		return nil, err
+	}
+	if stats.AddedPerMonth, err = db.statBuckets("COALESCE(strftime('%Y-%m', created_at), '')", cond, args, "key"); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// statBuckets groups tracks in the scope by keyExpr, returning count, total
+// duration and total size per group.
+func (db *Database) statBuckets(keyExpr, cond string, args []interface{}, orderBy string) ([]models.StatBucket, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+keyExpr+` AS key, COUNT(*) AS count, COALESCE(SUM(duration), 0), COALESCE(SUM(file_size), 0)
+		FROM track_view
+		WHERE `+cond+`
+		GROUP BY key
+		ORDER BY `+orderBy, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []models.StatBucket{}
+	for rows.Next() {
+		var b models.StatBucket
+		if err := rows.Scan(&b.Key, &b.Count, &b.Duration, &b.Size); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
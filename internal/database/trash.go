@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"staccato/pkg/models"
+)
+
+// trashColumns is the SELECT list matching scanTrashItem.
+const trashColumns = `id, original_path, trash_path, COALESCE(owner, ''), COALESCE(title, ''), COALESCE(artist, ''),
+	COALESCE(album, ''), COALESCE(file_size, 0), COALESCE(deleted_by, ''), deleted_at`
+
+// AddTrashItem records a file moved to the trash and returns its ID.
+func (db *Database) AddTrashItem(item models.TrashItem) (int, error) {
+	var id int
+	err := db.conn.QueryRow(`
+		INSERT INTO trash (original_path, trash_path, owner, title, artist, album, file_size, deleted_by, deleted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		item.OriginalPath, item.TrashPath, nullString(item.Owner), item.Title, item.Artist, item.Album,
+		item.FileSize, nullString(item.DeletedBy), item.DeletedAt).Scan(&id)
+	return id, err
+}
+
+// GetTrashItem returns a trash entry by ID, or nil if there is none.
+func (db *Database) GetTrashItem(id int) (*models.TrashItem, error) {
+	item, err := scanTrashItem(db.conn.QueryRow(`SELECT `+trashColumns+` FROM trash WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// ListTrash returns the trash entries of files in the scope, newest first.
+func (db *Database) ListTrash(scope StatsScope) ([]models.TrashItem, error) {
+	cond, args := scope.where()
+	return db.queryTrash(`SELECT `+trashColumns+` FROM trash WHERE `+cond+` ORDER BY deleted_at DESC, id DESC`, args...)
+}
+
+// GetTrashDeletedBefore returns the trash entries deleted before cutoff.
+func (db *Database) GetTrashDeletedBefore(cutoff time.Time) ([]models.TrashItem, error) {
+	return db.queryTrash(`SELECT `+trashColumns+` FROM trash WHERE deleted_at < ? ORDER BY deleted_at`, cutoff)
+}
+
+// DeleteTrashItem removes a trash entry (after its file was restored or
+// purged).
+func (db *Database) DeleteTrashItem(id int) error {
+	_, err := db.conn.Exec("DELETE FROM trash WHERE id = ?", id)
+	return err
+}
+
+func (db *Database) queryTrash(query string, args ...interface{}) ([]models.TrashItem, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.TrashItem{}
+	for rows.Next() {
+		item, err := scanTrashItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func scanTrashItem(row rowScanner) (models.TrashItem, error) {
+	var item models.TrashItem
+	err := row.Scan(&item.ID, &item.OriginalPath, &item.TrashPath, &item.Owner, &item.Title, &item.Artist,
+		&item.Album, &item.FileSize, &item.DeletedBy, &item.DeletedAt)
+	return item, err
+}
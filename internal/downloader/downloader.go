@@ -2,10 +2,12 @@ package downloader
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
@@ -15,7 +17,7 @@ import (
 
 	"staccato/internal/config"
 	"staccato/internal/database"
-	"staccato/internal/metadata"
+	"staccato/internal/library"
 
 	"github.com/google/uuid"
 )
@@ -38,6 +40,7 @@ type DownloadJob struct {
 	URL         string         `json:"url"`
 	Title       string         `json:"title"`
 	Artist      string         `json:"artist"`
+	Owner       string         `json:"owner,omitempty"`
 	Status      DownloadStatus `json:"status"`
 	Progress    int            `json:"progress"`
 	Error       string         `json:"error,omitempty"`
@@ -57,7 +60,7 @@ type Downloader struct {
 	ytDlpPath string
 	sem       chan struct{} // concurrency semaphore
 	db        *database.Database
-	extractor *metadata.Extractor
+	ingestor  *library.Ingestor
 }
 
 // NewDownloader constructs a Downloader, validating presence of yt-dlp and
@@ -82,11 +85,11 @@ func NewDownloader(cfg *config.Config) (*Downloader, error) {
 	return d, nil
 }
 
-// AttachIngest wires automatic metadata extraction & DB insertion for
-// successfully downloaded files. Can be called after construction.
-func (d *Downloader) AttachIngest(db *database.Database, extractor *metadata.Extractor) {
+// AttachIngest wires job persistence and library ingestion of successfully
+// downloaded files. Can be called after construction.
+func (d *Downloader) AttachIngest(db *database.Database, ingestor *library.Ingestor) {
 	d.db = db
-	d.extractor = extractor
+	d.ingestor = ingestor
 }
 
 // checkYtDlp attempts to discover an executable yt-dlp binary from a small
@@ -106,13 +109,15 @@ func (d *Downloader) checkYtDlp() error {
 }
 
 // DownloadFromURL schedules a new download job. It returns immediately with
-// the created job whose status will transition asynchronously.
-func (d *Downloader) DownloadFromURL(url, customTitle, customArtist string) (*DownloadJob, error) {
+// the created job whose status will transition asynchronously. With user
+// folders enabled the file is saved to the owner's folder and owned by them.
+func (d *Downloader) DownloadFromURL(url, customTitle, customArtist, owner string) (*DownloadJob, error) {
 	job := &DownloadJob{
 		ID:        uuid.New().String(),
 		URL:       url,
 		Title:     customTitle,
 		Artist:    customArtist,
+		Owner:     owner,
 		Status:    StatusPending,
 		Progress:  0,
 		CreatedAt: time.Now(),
@@ -159,7 +164,19 @@ func (d *Downloader) processDownload(job *DownloadJob) {
 	safeTitle := d.sanitizeFilename(job.Title)
 	safeArtist := d.sanitizeFilename(job.Artist)
 	filename := fmt.Sprintf("%s - %s.%%(ext)s", safeArtist, safeTitle)
-	outputPath := filepath.Join(d.config.Music.LibraryPath, filename)
+	outputDir := d.config.Music.LibraryPath
+	userFolder := ""
+	if d.ingestor != nil {
+		userFolder = d.ingestor.UserFolder(job.Owner)
+	}
+	if userFolder != "" {
+		if err := os.MkdirAll(userFolder, 0755); err != nil {
+			d.updateJobStatus(job.ID, StatusFailed, 0, fmt.Sprintf("Failed to create user folder: %v", err))
+			return
+		}
+		outputDir = userFolder
+	}
+	outputPath := filepath.Join(outputDir, filename)
 
 	// Build command with progress-friendly output
 	cmd := exec.Command(d.ytDlpPath,
@@ -275,10 +292,15 @@ func (d *Downloader) processDownload(job *DownloadJob) {
 	job.CompletedAt = &now
 
 	// Ingest into library
-	if d.db != nil && d.extractor != nil {
-		track, err := d.extractor.ExtractFromFile(actualPath, 0)
-		if err == nil {
-			_, _ = d.db.InsertTrack(track)
+	if d.ingestor != nil {
+		var err error
+		if userFolder != "" {
+			_, err = d.ingestor.IngestFileAs(context.Background(), actualPath, job.Owner)
+		} else {
+			_, err = d.ingestor.IngestFile(context.Background(), actualPath)
+		}
+		if err != nil {
+			d.updateJobStatus(job.ID, StatusCompleted, 100, fmt.Sprintf("Downloaded, but could not add to library: %v", err))
 		}
 	}
 }
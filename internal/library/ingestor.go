@@ -0,0 +1,183 @@
+// Package library adds audio files and cue sheets to the database. It is the
+// single ingest path shared by the startup scan, the file watcher, uploads,
+// downloads and tag edits, so ownership and cue sheet handling are the same
+// whichever way a file arrives.
+package library
+
+import (
+	"context"
+	"os"
+
+	"staccato/internal/database"
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Ownership maps library files to the users owning them. It is satisfied by
+// *auth.UserFolderManager; both methods return "" when user folders are off.
+type Ownership interface {
+	GetOwnerFromPath(filePath string) string
+	GetUserMusicPath(username string) string
+}
+
+// Ingestor extracts metadata from files and writes it to the database. It
+// is safe for concurrent use.
+type Ingestor struct {
+	db        *database.Database
+	extractor *metadata.Extractor
+	ownership Ownership
+	logger    *logrus.Logger
+}
+
+// NewIngestor creates an Ingestor. ownership may be nil when the server has
+// no per-user libraries.
+func NewIngestor(db *database.Database, extractor *metadata.Extractor, ownership Ownership, logger *logrus.Logger) *Ingestor {
+	return &Ingestor{
+		db:        db,
+		extractor: extractor,
+		ownership: ownership,
+		logger:    logger,
+	}
+}
+
+// OwnerOf returns the user owning path, or "" for the shared library.
+func (in *Ingestor) OwnerOf(path string) string {
+	if in.ownership == nil {
+		return ""
+	}
+	return in.ownership.GetOwnerFromPath(path)
+}
+
+// UserFolder returns the music folder of a user, or "" when user folders
+// are disabled.
+func (in *Ingestor) UserFolder(username string) string {
+	if in.ownership == nil || username == "" {
+		return ""
+	}
+	return in.ownership.GetUserMusicPath(username)
+}
+
+// IsAudioFile reports whether path has a supported audio extension.
+func (in *Ingestor) IsAudioFile(path string) bool {
+	return in.extractor.IsAudioFile(path)
+}
+
+// IngestFile adds or refreshes one audio file, taking the owner from its
+// path. A file split by a cue sheet is added as that sheet's tracks instead,
+// in which case the returned track is nil.
+func (in *Ingestor) IngestFile(ctx context.Context, path string) (*models.Track, error) {
+	return in.IngestFileAs(ctx, path, in.OwnerOf(path))
+}
+
+// IngestFileAs is IngestFile with an explicit owner, for files whose owner
+// is known from the request that created them (uploads, downloads).
+func (in *Ingestor) IngestFileAs(ctx context.Context, path, owner string) (*models.Track, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cuePath := in.extractor.CueSheetCovering(path); cuePath != "" {
+		_, err := in.IngestCueSheet(ctx, cuePath)
+		return nil, err
+	}
+
+	track, err := in.extractor.ExtractFromFile(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	track.Owner = owner
+	if track.ID, err = in.db.InsertTrack(track); err != nil {
+		return nil, err
+	}
+
+	in.logger.WithFields(logrus.Fields{
+		"artist": track.Artist,
+		"title":  track.Title,
+		"album":  track.Album,
+		"owner":  track.Owner,
+		"id":     track.ID,
+	}).Debug("Added track")
+	return &track, nil
+}
+
+// RefreshTrack re-reads a track's file after it changed on disk (e.g. a tag
+// edit), keeping its current owner.
+func (in *Ingestor) RefreshTrack(ctx context.Context, track *models.Track) (*models.Track, error) {
+	return in.IngestFileAs(ctx, track.FilePath, track.Owner)
+}
+
+// RemoveFile deletes the track of a removed audio file, along with any cue
+// sheet tracks cut from it.
+func (in *Ingestor) RemoveFile(path string) error {
+	if err := in.db.RemoveTrackByPath(path); err != nil {
+		return err
+	}
+	_, err := in.db.RemoveTracksBySource(path)
+	return err
+}
+
+// IngestCueSheet adds or refreshes the virtual tracks of a cue sheet, drops
+// tracks the sheet no longer lists and removes the standalone rows of the
+// audio files it covers, which are returned.
+func (in *Ingestor) IngestCueSheet(ctx context.Context, cuePath string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tracks, sources, err := in.extractor.ExtractCueTracks(cuePath)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := in.OwnerOf(cuePath)
+	keep := make([]string, 0, len(tracks))
+	for i := range tracks {
+		tracks[i].Owner = owner
+		keep = append(keep, tracks[i].FilePath)
+	}
+	if _, err := in.db.UpsertTracks(tracks); err != nil {
+		return nil, err
+	}
+	if _, err := in.db.PruneCueTracks(cuePath, keep); err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		if err := in.db.RemoveTrackByPath(source); err != nil {
+			return nil, err
+		}
+	}
+
+	in.logger.WithFields(logrus.Fields{
+		"cue_path": cuePath,
+		"tracks":   len(tracks),
+		"owner":    owner,
+	}).Debug("Added cue sheet tracks")
+	return sources, nil
+}
+
+// RemoveCueSheet removes a deleted cue sheet's tracks and re-adds the audio
+// files it covered as standalone tracks.
+func (in *Ingestor) RemoveCueSheet(ctx context.Context, cuePath string) error {
+	sources, err := in.db.GetCueSheetSources(cuePath)
+	if err != nil {
+		return err
+	}
+	removed, err := in.db.PruneCueTracks(cuePath, nil)
+	if err != nil {
+		return err
+	}
+	in.logger.WithFields(logrus.Fields{
+		"cue_path": cuePath,
+		"tracks":   removed,
+	}).Info("Removed cue sheet tracks")
+
+	for _, source := range sources {
+		if _, err := os.Stat(source); err != nil {
+			continue
+		}
+		if _, err := in.IngestFile(ctx, source); err != nil {
+			in.logger.WithError(err).WithField("file_path", source).Error("Error re-adding audio file")
+		}
+	}
+	return nil
+}
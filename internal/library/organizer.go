@@ -0,0 +1,182 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Move is one file rename planned by the Organizer. From and To are relative
+// to the owner's library root.
+type Move struct {
+	TrackID  int    `json:"trackId"`
+	Owner    string `json:"owner,omitempty"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Conflict string `json:"conflict,omitempty"` // why the move will be skipped
+	Error    string `json:"error,omitempty"`    // why an applied move failed
+
+	fromPath, toPath string
+}
+
+// OrganizeResult summarises an applied plan.
+type OrganizeResult struct {
+	Moved       int    `json:"moved"`
+	Skipped     int    `json:"skipped"` // conflicting moves
+	Failed      int    `json:"failed"`
+	RemovedDirs int    `json:"removedDirs"`
+	Moves       []Move `json:"moves"`
+}
+
+// Organizer renames library files to the path a NamingTemplate gives for
+// their tags. Cue sheet tracks are never moved, since their sheet refers to
+// the audio file by name.
+type Organizer struct {
+	ingestor *Ingestor
+	roots    *Roots
+	template *metadata.NamingTemplate
+}
+
+// NewOrganizer creates an Organizer that names files with template.
+func NewOrganizer(ingestor *Ingestor, roots *Roots, template *metadata.NamingTemplate) *Organizer {
+	return &Organizer{ingestor: ingestor, roots: roots, template: template}
+}
+
+// Plan returns the moves needed to organize tracks, in input order. Tracks
+// already in place are left out. Moves whose target exists on disk or is
+// claimed by an earlier move carry a Conflict and will not be applied.
+func (o *Organizer) Plan(tracks []models.Track) []Move {
+	moves := []Move{}
+	claimed := map[string]bool{}
+	for _, track := range tracks {
+		if track.IsVirtual() {
+			continue
+		}
+		rel := o.template.Render(track) + strings.ToLower(filepath.Ext(track.FilePath))
+		target := filepath.Join(o.roots.Of(track.Owner), filepath.FromSlash(rel))
+		if target == track.FilePath {
+			continue
+		}
+		move := Move{
+			TrackID:  track.ID,
+			Owner:    track.Owner,
+			From:     o.roots.Relative(track.FilePath, track.Owner),
+			To:       rel,
+			fromPath: track.FilePath,
+			toPath:   target,
+		}
+		switch {
+		case claimed[target]:
+			move.Conflict = "another track has the same target"
+		case exists(target):
+			move.Conflict = "target file exists"
+		}
+		claimed[target] = true
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// Apply carries out a plan. Each track row is repointed before its file
+// moves, so the file watcher never sees the track's file go missing; IDs,
+// overrides and playlist membership are kept. Directories emptied by the
+// moves are removed. Cancelling ctx stops before the next move.
+func (o *Organizer) Apply(ctx context.Context, moves []Move) (OrganizeResult, error) {
+	result := OrganizeResult{Moves: moves}
+	vacated := map[string]string{} // directory -> its library root
+	for i := range moves {
+		move := &moves[i]
+		if move.Conflict != "" {
+			result.Skipped++
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := o.move(move); err != nil {
+			move.Error = err.Error()
+			result.Failed++
+			o.ingestor.logger.WithError(err).WithField("file_path", move.fromPath).Error("Failed to organize file")
+			continue
+		}
+		result.Moved++
+		vacated[filepath.Dir(move.fromPath)] = o.roots.Of(move.Owner)
+	}
+
+	for dir, root := range vacated {
+		result.RemovedDirs += removeEmptyDirs(dir, root)
+	}
+	o.ingestor.logger.WithFields(logrus.Fields{
+		"moved":        result.Moved,
+		"skipped":      result.Skipped,
+		"failed":       result.Failed,
+		"removed_dirs": result.RemovedDirs,
+	}).Info("Library organized")
+	return result, nil
+}
+
+// move renames one file and its track row, undoing the row change if the
+// file cannot be moved.
+func (o *Organizer) move(m *Move) error {
+	if err := os.MkdirAll(filepath.Dir(m.toPath), 0755); err != nil {
+		return err
+	}
+	if err := o.ingestor.db.UpdateTrackPath(m.TrackID, m.toPath); err != nil {
+		return err
+	}
+	if err := moveFile(m.fromPath, m.toPath); err != nil {
+		if undoErr := o.ingestor.db.UpdateTrackPath(m.TrackID, m.fromPath); undoErr != nil {
+			return fmt.Errorf("%w (and restoring the track path failed: %v)", err, undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// moveFile renames src to dst without ever replacing an existing dst. A hard
+// link claims dst atomically; filesystems without hard links fall back to
+// a checked rename.
+func moveFile(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("target file exists")
+	}
+	if exists(dst) {
+		return fmt.Errorf("target file exists")
+	}
+	return os.Rename(src, dst)
+}
+
+// removeEmptyDirs removes dir and then its parents while they are empty,
+// stopping at root, and returns how many were removed.
+func removeEmptyDirs(dir, root string) int {
+	removed := 0
+	for {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return removed
+		}
+		// Remove fails on a non-empty directory
+		if os.Remove(dir) != nil {
+			return removed
+		}
+		removed++
+		dir = filepath.Dir(dir)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
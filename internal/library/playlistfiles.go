@@ -0,0 +1,219 @@
+package library
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"staccato/internal/database"
+	"staccato/internal/playlistfile"
+	"staccato/pkg/models"
+)
+
+// defaultImportName names an imported playlist whose file gives no name.
+const defaultImportName = "Imported playlist"
+
+// streamPathPattern matches the path of a track's stream URL, as written by
+// exports with stream URLs.
+var streamPathPattern = regexp.MustCompile(`/stream/(\d+)$`)
+
+// PlaylistFiles exports playlists as playlist files (M3U8, PLS, XSPF) and
+// imports such files as playlists, resolving their entries to tracks.
+type PlaylistFiles struct {
+	db    *database.Database
+	roots *Roots
+}
+
+// NewPlaylistFiles creates a PlaylistFiles for the libraries under roots.
+func NewPlaylistFiles(db *database.Database, roots *Roots) *PlaylistFiles {
+	return &PlaylistFiles{db: db, roots: roots}
+}
+
+// Export writes a playlist's tracks as a playlist file. With a streamBase
+// (scheme and host, e.g. "http://host:8080") locations are stream URLs,
+// otherwise paths relative to each track's library root. The path of a cue
+// sheet track is that of the audio file holding it.
+func (pf *PlaylistFiles) Export(w io.Writer, format playlistfile.Format, name string, tracks []models.Track, streamBase string) error {
+	doc := playlistfile.Playlist{Title: name, Entries: make([]playlistfile.Entry, 0, len(tracks))}
+	for _, track := range tracks {
+		location := pf.roots.Relative(track.AudioPath(), track.Owner)
+		if streamBase != "" {
+			location = fmt.Sprintf("%s/stream/%d", strings.TrimRight(streamBase, "/"), track.ID)
+		}
+		doc.Entries = append(doc.Entries, playlistfile.Entry{
+			Location: location,
+			Artist:   track.Artist,
+			Title:    track.Title,
+			Duration: track.Duration,
+		})
+	}
+	return playlistfile.Write(w, format, doc)
+}
+
+// Import parses a playlist file and creates a playlist like p of the
+// scope's tracks its entries resolve to, reporting the entries that
+// resolved to none. An entry resolves by its location first: a stream URL
+// of this server, or a path whose trailing components are a track's path
+// relative to its library root (so absolute paths from another machine
+// work). Otherwise it resolves by artist and title, from the file's hints
+// or the file name. The playlist is named p.Name, else the file's title,
+// else fileName without its extension.
+func (pf *PlaylistFiles) Import(r io.Reader, format playlistfile.Format, p models.Playlist, fileName string, scope database.StatsScope) (*models.PlaylistImportReport, error) {
+	doc, err := playlistfile.Parse(r, format)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := pf.db.GetTracksInScope(scope)
+	if err != nil {
+		return nil, err
+	}
+	matcher := newTrackMatcher(tracks, pf.roots)
+
+	report := &models.PlaylistImportReport{Entries: len(doc.Entries), Unmatched: []models.UnmatchedEntry{}}
+	var ops []models.PlaylistOp
+	for _, entry := range doc.Entries {
+		id, ok := matcher.byLocation(entry.Location)
+		if ok {
+			report.MatchedByPath++
+		} else if id, ok = matcher.byTags(entry); ok {
+			report.MatchedByTags++
+		} else {
+			report.Unmatched = append(report.Unmatched, models.UnmatchedEntry{
+				Line:     entry.Line,
+				Location: entry.Location,
+				Artist:   entry.Artist,
+				Title:    entry.Title,
+			})
+			continue
+		}
+		ops = append(ops, models.PlaylistOp{Op: models.PlaylistOpInsert, TrackID: id})
+	}
+
+	name := firstNonEmpty(p.Name, doc.Title, strings.TrimSuffix(path.Base(strings.ReplaceAll(fileName, `\`, "/")), path.Ext(fileName)))
+	if name == "" || name == "." || name == "/" {
+		name = defaultImportName
+	}
+	id, err := pf.db.CreatePlaylist(name, p.Description, p.Owner, p.Visibility)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) > 0 {
+		if err := pf.db.EditPlaylist(id, ops, p.Owner); err != nil {
+			pf.db.DeletePlaylist(id)
+			return nil, err
+		}
+	}
+	playlist, err := pf.db.GetPlaylist(id)
+	if err != nil {
+		return nil, err
+	}
+	report.Playlist = *playlist
+	return report, nil
+}
+
+// firstNonEmpty returns the first of values that is not blank.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// trackMatcher resolves playlist file entries to tracks.
+type trackMatcher struct {
+	ids    map[int]bool
+	paths  map[string]int            // lower-case path relative to the library root
+	tags   map[string][]models.Track // lower-case artist + "\x00" + title
+	titles map[string][]models.Track // lower-case title
+}
+
+func newTrackMatcher(tracks []models.Track, roots *Roots) *trackMatcher {
+	m := &trackMatcher{
+		ids:    make(map[int]bool, len(tracks)),
+		paths:  make(map[string]int, len(tracks)),
+		tags:   make(map[string][]models.Track, len(tracks)),
+		titles: make(map[string][]models.Track, len(tracks)),
+	}
+	for _, track := range tracks {
+		m.ids[track.ID] = true
+		if !track.IsVirtual() {
+			m.paths[strings.ToLower(roots.Relative(track.FilePath, track.Owner))] = track.ID
+		}
+		title := strings.ToLower(strings.TrimSpace(track.Title))
+		key := strings.ToLower(strings.TrimSpace(track.Artist)) + "\x00" + title
+		m.tags[key] = append(m.tags[key], track)
+		m.titles[title] = append(m.titles[title], track)
+	}
+	return m
+}
+
+// byLocation resolves a stream URL or a path ending in a track's relative path.
+func (m *trackMatcher) byLocation(location string) (int, bool) {
+	location = strings.TrimSpace(location)
+	if u, err := url.Parse(location); err == nil {
+		switch u.Scheme {
+		case "http", "https":
+			if match := streamPathPattern.FindStringSubmatch(u.Path); match != nil {
+				id, _ := strconv.Atoi(match[1])
+				return id, m.ids[id]
+			}
+			return 0, false
+		case "file":
+			location = u.Path
+		}
+	}
+
+	// Windows players write backslashes and drive letters
+	location = strings.ReplaceAll(location, `\`, "/")
+	if len(location) >= 2 && location[1] == ':' {
+		location = location[2:]
+	}
+	var parts []string
+	for _, part := range strings.Split(strings.ToLower(location), "/") {
+		if part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	for i := range parts {
+		if id, ok := m.paths[strings.Join(parts[i:], "/")]; ok {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// byTags resolves an entry by artist and title. Without an artist, the
+// title must name a single track. A duration in the entry rules out tracks
+// of a different length.
+func (m *trackMatcher) byTags(entry playlistfile.Entry) (int, bool) {
+	artist, title := entry.Artist, entry.Title
+	if title == "" {
+		base := path.Base(strings.ReplaceAll(entry.Location, `\`, "/"))
+		if before, after, ok := strings.Cut(strings.TrimSuffix(base, path.Ext(base)), " - "); ok {
+			artist, title = before, after
+		}
+	}
+	title = strings.ToLower(strings.TrimSpace(title))
+	if title == "" {
+		return 0, false
+	}
+
+	var candidates []models.Track
+	if artist = strings.ToLower(strings.TrimSpace(artist)); artist != "" {
+		candidates = m.tags[artist+"\x00"+title]
+	} else if len(m.titles[title]) == 1 {
+		candidates = m.titles[title]
+	}
+	for _, track := range candidates {
+		if entry.Duration <= 0 || abs(track.Duration-entry.Duration) <= tagMatchTolerance {
+			return track.ID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,243 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"staccato/internal/database"
+	"staccato/internal/playlistfile"
+	"staccato/pkg/models"
+)
+
+// IsPlaylistFile reports whether path names an M3U/M3U8, PLS or XSPF file.
+func IsPlaylistFile(path string) bool {
+	_, ok := playlistfile.FormatOf(path)
+	return ok
+}
+
+// SyncFile mirrors the playlist file at filePath, which lives in a library,
+// as a file-backed playlist owned by the library's owner: created if new,
+// otherwise renamed and refilled to match the file. Entry locations
+// relative to the file resolve against its directory; entries matching no
+// track of the library are left out. Playlists of the main library are
+// public, those of user folders private. The bool reports whether the
+// playlist was created or changed.
+func (pf *PlaylistFiles) SyncFile(filePath string) (*models.Playlist, bool, error) {
+	doc, matches, err := pf.readFile(filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	var ids []int
+	for _, id := range matches {
+		if id != 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	owner := pf.roots.OwnerOf(filePath)
+	name := firstNonEmpty(doc.Title, strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)))
+	playlist, err := pf.db.GetPlaylistByFile(filePath)
+	updated := false
+	switch {
+	case errors.Is(err, database.ErrPlaylistNotFound):
+		visibility := models.VisibilityPublic
+		if owner != "" {
+			visibility = models.VisibilityPrivate
+		}
+		id, err := pf.db.CreateFilePlaylist(name, owner, visibility, filePath)
+		if err != nil {
+			return nil, false, err
+		}
+		if playlist, err = pf.db.GetPlaylist(id); err != nil {
+			return nil, false, err
+		}
+		updated = true
+	case err != nil:
+		return nil, false, err
+	case playlist.Name != name:
+		if err := pf.db.UpdatePlaylist(playlist.ID, name, playlist.Description, playlist.CoverPath, ""); err != nil {
+			return nil, false, err
+		}
+		updated = true
+	}
+
+	changed, err := pf.db.SetPlaylistTracks(playlist.ID, ids)
+	if err != nil {
+		return nil, false, err
+	}
+	if playlist, err = pf.db.GetPlaylist(playlist.ID); err != nil {
+		return nil, false, err
+	}
+	return playlist, updated || changed, nil
+}
+
+// readFile parses the playlist file at filePath and matches its entries
+// against the tracks of the library it lives in. matches[i] is the ID of
+// the track the i-th entry matched, or 0 if it matched none.
+func (pf *PlaylistFiles) readFile(filePath string) (doc *playlistfile.Playlist, matches []int, err error) {
+	format, ok := playlistfile.FormatOf(filePath)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", playlistfile.ErrUnknownFormat, filePath)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err = playlistfile.Parse(file, format)
+	file.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tracks, err := pf.db.GetTracksInScope(database.StatsScope{Owner: pf.roots.OwnerOf(filePath)})
+	if err != nil {
+		return nil, nil, err
+	}
+	matcher := newTrackMatcher(tracks, pf.roots)
+	dir := filepath.Dir(filePath)
+	matches = make([]int, len(doc.Entries))
+	for i, entry := range doc.Entries {
+		entry.Location = resolveLocation(dir, entry.Location)
+		id, ok := matcher.byLocation(entry.Location)
+		if !ok {
+			id, ok = matcher.byTags(entry)
+		}
+		if ok {
+			matches[i] = id
+		}
+	}
+	return doc, matches, nil
+}
+
+// resolveLocation makes a location relative to a playlist file's directory
+// absolute. URLs and absolute paths are returned unchanged.
+func resolveLocation(dir, location string) string {
+	location = strings.TrimSpace(location)
+	if u, err := url.Parse(location); err == nil && u.Scheme != "" && len(u.Scheme) > 1 {
+		return location
+	}
+	slashed := strings.ReplaceAll(location, `\`, "/")
+	if strings.HasPrefix(slashed, "/") || (len(slashed) >= 2 && slashed[1] == ':') {
+		return location
+	}
+	return filepath.Join(dir, filepath.FromSlash(slashed))
+}
+
+// RemoveFile deletes the playlist mirroring the playlist file at filePath,
+// if there is one.
+func (pf *PlaylistFiles) RemoveFile(filePath string) error {
+	playlist, err := pf.db.GetPlaylistByFile(filePath)
+	if errors.Is(err, database.ErrPlaylistNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return pf.db.DeletePlaylist(playlist.ID)
+}
+
+// Prune deletes the file-backed playlists under root whose file no longer
+// exists and returns how many it deleted.
+func (pf *PlaylistFiles) Prune(root string) (int, error) {
+	playlists, err := pf.db.GetFilePlaylists()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, playlist := range playlists {
+		if rel, err := filepath.Rel(root, playlist.FilePath); err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if _, err := os.Stat(playlist.FilePath); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := pf.db.DeletePlaylist(playlist.ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// WriteBack rewrites the file of a file-backed playlist from its entries,
+// in the file's format, with locations relative to the file's directory.
+// Entries of the old file that matched no track are kept, each after as
+// many playlist entries as there were matched ones before it (or at the
+// end). The file is replaced atomically.
+func (pf *PlaylistFiles) WriteBack(playlistID int) error {
+	playlist, err := pf.db.GetPlaylist(playlistID)
+	if err != nil {
+		return err
+	}
+	if !playlist.FileBacked {
+		return fmt.Errorf("playlist %d is not file-backed", playlistID)
+	}
+	format, ok := playlistfile.FormatOf(playlist.FilePath)
+	if !ok {
+		return fmt.Errorf("%w: %s", playlistfile.ErrUnknownFormat, playlist.FilePath)
+	}
+	tracks, err := pf.db.GetPlaylistTracks(playlistID)
+	if err != nil {
+		return err
+	}
+
+	// Unmatched entries, by how many matched ones came before them
+	unmatched := map[int][]playlistfile.Entry{}
+	if old, matches, err := pf.readFile(playlist.FilePath); err == nil {
+		before := 0
+		for i, entry := range old.Entries {
+			if matches[i] != 0 {
+				before++
+				continue
+			}
+			at := min(before, len(tracks))
+			unmatched[at] = append(unmatched[at], playlistfile.Entry{
+				Location: entry.Location,
+				Artist:   entry.Artist,
+				Title:    entry.Title,
+				Duration: entry.Duration,
+			})
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	dir := filepath.Dir(playlist.FilePath)
+	doc := playlistfile.Playlist{Title: playlist.Name, Entries: make([]playlistfile.Entry, 0, len(tracks))}
+	doc.Entries = append(doc.Entries, unmatched[0]...)
+	for i, track := range tracks {
+		location := track.AudioPath()
+		if rel, err := filepath.Rel(dir, location); err == nil {
+			location = rel
+		}
+		doc.Entries = append(doc.Entries, playlistfile.Entry{
+			Location: filepath.ToSlash(location),
+			Artist:   track.Artist,
+			Title:    track.Title,
+			Duration: track.Duration,
+		})
+		doc.Entries = append(doc.Entries, unmatched[i+1]...)
+	}
+
+	// Hidden .tmp files are ignored by the watcher, so only the rename is seen
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(playlist.FilePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if info, err := os.Stat(playlist.FilePath); err == nil {
+		tmp.Chmod(info.Mode().Perm())
+	}
+	if err := playlistfile.Write(tmp, format, doc); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), playlist.FilePath)
+}
@@ -0,0 +1,404 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"staccato/internal/database"
+	"staccato/pkg/models"
+)
+
+// ErrUnsupportedExport is returned (wrapped) when an import document has a
+// version this server cannot read.
+var ErrUnsupportedExport = errors.New("unsupported library export")
+
+const (
+	// hashChunkSize is how much of each end of a file ContentHash reads.
+	hashChunkSize = 64 << 10
+	// tagMatchTolerance is how far apart in seconds two durations may be for
+	// tracks with the same tags to count as the same recording.
+	tagMatchTolerance = 2
+)
+
+// Portable exports and imports the database-only parts of a library
+// (playlists and their folders, overrides, download history) as a
+// models.LibraryExport, whose tracks are identified independently of where
+// the library is mounted.
+type Portable struct {
+	db    *database.Database
+	roots *Roots
+}
+
+// NewPortable creates a Portable for the libraries under roots.
+func NewPortable(db *database.Database, roots *Roots) *Portable {
+	return &Portable{db: db, roots: roots}
+}
+
+// ContentHash identifies a file's contents cheaply: the hex SHA-256 of its
+// size and its first and last 64 KiB. It survives renames and moves but not
+// tag edits.
+func ContentHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, st.Size())
+	if _, err := io.CopyN(h, f, hashChunkSize); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if st.Size() > hashChunkSize {
+		tail := st.Size() - hashChunkSize
+		if tail < hashChunkSize {
+			tail = hashChunkSize
+		}
+		if _, err := io.Copy(h, io.NewSectionReader(f, tail, st.Size()-tail)); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Export collects the whole library into a LibraryExport. Hashing reads the
+// ends of every audio file, so it takes a while on large libraries; files
+// that cannot be read are exported without a hash.
+func (p *Portable) Export() (*models.LibraryExport, error) {
+	tracks, err := p.db.GetFileTracks()
+	if err != nil {
+		return nil, err
+	}
+	doc := &models.LibraryExport{
+		Version:    models.LibraryExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Tracks:     make([]models.TrackRef, 0, len(tracks)),
+	}
+	for _, track := range tracks {
+		ref := models.TrackRef{
+			ID:          track.ID,
+			Path:        p.roots.Relative(track.FilePath, track.Owner),
+			Owner:       track.Owner,
+			Title:       track.Title,
+			Artist:      track.Artist,
+			Album:       track.Album,
+			TrackNumber: track.TrackNumber,
+			Duration:    track.Duration,
+			FileSize:    track.FileSize,
+		}
+		if !track.IsVirtual() {
+			ref.Hash, _ = ContentHash(track.FilePath)
+		}
+		doc.Tracks = append(doc.Tracks, ref)
+	}
+
+	if doc.Folders, err = p.db.GetPlaylistFoldersForExport(); err != nil {
+		return nil, err
+	}
+	if doc.Playlists, err = p.db.GetPlaylistsForExport(); err != nil {
+		return nil, err
+	}
+	if doc.Overrides, err = p.db.GetOverridesForExport(database.StatsScope{All: true}); err != nil {
+		return nil, err
+	}
+	for i := range doc.Overrides {
+		doc.Overrides[i].Path = p.roots.Relative(doc.Overrides[i].Path, doc.Overrides[i].Owner)
+	}
+	if doc.Downloads, err = p.db.GetDownloadJobsForExport(); err != nil {
+		return nil, err
+	}
+	for i := range doc.Downloads {
+		if doc.Downloads[i].Path != "" {
+			owner := p.roots.OwnerOf(doc.Downloads[i].Path)
+			doc.Downloads[i].Owner = owner
+			doc.Downloads[i].Path = p.roots.Relative(doc.Downloads[i].Path, owner)
+		}
+	}
+	return doc, nil
+}
+
+// Import links the document's tracks to tracks of this library and
+// recreates its playlist folders, playlists, overrides and download history
+// on them. Folders and playlists whose owner already has one of that name
+// (in the same folder) are merged into, and overrides and downloads are
+// replaced, so importing the same document twice changes nothing. Data on
+// tracks that could not be linked is skipped and listed in the report.
+func (p *Portable) Import(doc *models.LibraryExport) (*models.LibraryImportReport, error) {
+	if doc.Version < 1 || doc.Version > models.LibraryExportVersion {
+		return nil, fmt.Errorf("%w: version %d (this server reads up to %d)", ErrUnsupportedExport, doc.Version, models.LibraryExportVersion)
+	}
+	report := &models.LibraryImportReport{Tracks: len(doc.Tracks), Unmatched: []models.UnmatchedTrack{}}
+	links, err := p.linkTracks(doc.Tracks, report)
+	if err != nil {
+		return nil, err
+	}
+	unmatched := map[int]*models.UnmatchedTrack{}
+	for _, ref := range doc.Tracks {
+		if _, ok := links[ref.ID]; !ok {
+			unmatched[ref.ID] = &models.UnmatchedTrack{TrackRef: ref}
+		}
+	}
+
+	folderIDs, err := p.importFolders(doc.Folders, report)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := p.db.GetAllPlaylists()
+	if err != nil {
+		return nil, err
+	}
+	// Playlists merge with the same owner's playlist of the same name
+	playlistIDs := make(map[string]int, len(existing))
+	for _, playlist := range existing {
+		playlistIDs[playlist.Owner+"\x00"+playlist.Name] = playlist.ID
+	}
+	// Created playlists are filed in their folder once all exist, in order
+	type filing struct{ playlist, folder, position int }
+	var filings []filing
+	for _, playlist := range doc.Playlists {
+		key := playlist.Owner + "\x00" + playlist.Name
+		id, ok := playlistIDs[key]
+		// A merged playlist only gains the entries it does not have yet,
+		// counting a track listed twice as two entries
+		present := map[int]int{}
+		if ok {
+			report.PlaylistsMerged++
+			tracks, err := p.db.GetPlaylistTracks(id)
+			if err != nil {
+				return nil, err
+			}
+			for _, track := range tracks {
+				present[track.ID]++
+			}
+		} else {
+			if id, err = p.db.InsertPlaylist(playlist); err != nil {
+				return nil, err
+			}
+			playlistIDs[key] = id
+			report.PlaylistsCreated++
+			if folderID, ok := folderIDs[playlist.Folder]; ok {
+				filings = append(filings, filing{id, folderID, playlist.Position})
+			}
+		}
+		for i, refID := range playlist.Tracks {
+			trackID, ok := links[refID]
+			if !ok {
+				if u := unmatched[refID]; u != nil {
+					u.Playlists = append(u.Playlists, playlist.Name)
+				}
+				continue
+			}
+			if present[trackID] > 0 {
+				present[trackID]--
+				continue
+			}
+			addedBy := ""
+			if i < len(playlist.AddedBy) {
+				addedBy = playlist.AddedBy[i]
+			}
+			if err := p.db.AddTrackToPlaylist(id, trackID, addedBy); err != nil {
+				return nil, err
+			}
+			report.PlaylistEntries++
+		}
+		for _, collaborator := range playlist.Collaborators {
+			if !models.IsValidPlaylistRole(collaborator.Role) || collaborator.Username == playlist.Owner {
+				continue
+			}
+			if err := p.db.InsertPlaylistCollaborator(id, collaborator); err != nil {
+				return nil, err
+			}
+			report.Collaborators++
+		}
+	}
+	sort.SliceStable(filings, func(i, j int) bool { return filings[i].position < filings[j].position })
+	for _, f := range filings {
+		if err := p.db.MovePlaylist(f.playlist, f.folder, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, override := range doc.Overrides {
+		trackID, ok := links[override.TrackID]
+		if !ok {
+			if u := unmatched[override.TrackID]; u != nil {
+				u.Override = true
+			}
+			continue
+		}
+		o := override.TrackOverride
+		o.TrackID = trackID
+		if err := p.db.SetTrackOverride(o, override.AlbumArt); err != nil {
+			return nil, err
+		}
+		report.Overrides++
+	}
+
+	for _, job := range doc.Downloads {
+		path := ""
+		if job.Path != "" {
+			path = p.roots.Absolute(job.Path, job.Owner)
+		}
+		progress := 0
+		if job.Status == "completed" {
+			progress = 100
+		}
+		createdAt := job.CreatedAt
+		if err := p.db.UpsertDownloadJob(job.ID, job.URL, job.Title, job.Artist, job.Status, progress, job.Error, path, "", 0, &createdAt, job.CompletedAt); err != nil {
+			return nil, err
+		}
+		report.Downloads++
+	}
+
+	for _, ref := range doc.Tracks {
+		if u := unmatched[ref.ID]; u != nil {
+			report.Unmatched = append(report.Unmatched, *u)
+		}
+	}
+	return report, nil
+}
+
+// importFolders recreates folders, parents first, and maps each folder's
+// ID in the document to a local folder. A folder merges with the folder of
+// the same owner and name in the same parent. Folders whose parent is not
+// in the document are put at the top level.
+func (p *Portable) importFolders(folders []models.PlaylistFolderExport, report *models.LibraryImportReport) (map[int]int, error) {
+	existing, err := p.db.GetAllPlaylistFolders()
+	if err != nil {
+		return nil, err
+	}
+	key := func(owner string, parent int, name string) string {
+		return fmt.Sprintf("%s\x00%d\x00%s", owner, parent, name)
+	}
+	folderIDs := make(map[string]int, len(existing))
+	for _, folder := range existing {
+		folderIDs[key(folder.Owner, folder.ParentID, folder.Name)] = folder.ID
+	}
+
+	ids := make(map[int]int, len(folders))
+	for _, folder := range folders {
+		parent := ids[folder.Parent]
+		k := key(folder.Owner, parent, folder.Name)
+		if id, ok := folderIDs[k]; ok {
+			ids[folder.ID] = id
+			report.FoldersMerged++
+			continue
+		}
+		visibility := folder.Visibility
+		if parent == 0 && visibility == models.FolderVisibilityInherit {
+			visibility = models.VisibilityPrivate
+		}
+		id, err := p.db.CreatePlaylistFolder(folder.Name, folder.Owner, visibility, parent)
+		if err != nil {
+			return nil, err
+		}
+		ids[folder.ID] = id
+		folderIDs[k] = id
+		report.FoldersCreated++
+	}
+	return ids, nil
+}
+
+// linkTracks maps each ref's ID to a local track ID. A ref links by its
+// relative path (same owner first, then any owner if the path is unique),
+// then by content hash among local files of the same size, then by tags
+// and duration. Ambiguous candidates are not linked.
+func (p *Portable) linkTracks(refs []models.TrackRef, report *models.LibraryImportReport) (map[int]int, error) {
+	local, err := p.db.GetFileTracks()
+	if err != nil {
+		return nil, err
+	}
+	byOwnerPath := make(map[string]int, len(local))
+	byPath := make(map[string][]int, len(local))
+	bySize := map[int64][]models.Track{}
+	byTags := map[string][]models.Track{}
+	for _, track := range local {
+		rel := p.roots.Relative(track.FilePath, track.Owner)
+		byOwnerPath[track.Owner+"\x00"+rel] = track.ID
+		byPath[rel] = append(byPath[rel], track.ID)
+		if !track.IsVirtual() {
+			bySize[track.FileSize] = append(bySize[track.FileSize], track)
+		}
+		if key := tagKey(track.Title, track.Artist, track.Album); key != "" {
+			byTags[key] = append(byTags[key], track)
+		}
+	}
+
+	hashes := map[int]string{}
+	localHash := func(track models.Track) string {
+		if h, ok := hashes[track.ID]; ok {
+			return h
+		}
+		h, _ := ContentHash(track.FilePath)
+		hashes[track.ID] = h
+		return h
+	}
+
+	links := make(map[int]int, len(refs))
+	for _, ref := range refs {
+		if id, ok := byOwnerPath[ref.Owner+"\x00"+ref.Path]; ok {
+			links[ref.ID] = id
+			report.MatchedByPath++
+			continue
+		}
+		if ids := byPath[ref.Path]; len(ids) == 1 {
+			links[ref.ID] = ids[0]
+			report.MatchedByPath++
+			continue
+		}
+
+		if ref.Hash != "" {
+			var found []int
+			for _, track := range bySize[ref.FileSize] {
+				if localHash(track) == ref.Hash {
+					found = append(found, track.ID)
+				}
+			}
+			if len(found) == 1 {
+				links[ref.ID] = found[0]
+				report.MatchedByHash++
+				continue
+			}
+		}
+
+		var found []int
+		for _, track := range byTags[tagKey(ref.Title, ref.Artist, ref.Album)] {
+			if abs(track.Duration-ref.Duration) <= tagMatchTolerance {
+				found = append(found, track.ID)
+			}
+		}
+		if len(found) == 1 {
+			links[ref.ID] = found[0]
+			report.MatchedByTags++
+		}
+	}
+	return links, nil
+}
+
+// tagKey normalises tags for matching. Tracks without a title have no key.
+func tagKey(title, artist, album string) string {
+	if strings.TrimSpace(title) == "" {
+		return ""
+	}
+	norm := func(s string) string { return strings.ToLower(strings.TrimSpace(s)) }
+	return norm(title) + "\x00" + norm(artist) + "\x00" + norm(album)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+package library
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Roots locates the library root a file lives under: its owner's music
+// folder when user folders are enabled, otherwise the main library.
+type Roots struct {
+	ownership Ownership
+	library   string
+}
+
+// NewRoots creates Roots for the main library at library. ownership may be
+// nil when the server has no per-user libraries.
+func NewRoots(ownership Ownership, library string) *Roots {
+	return &Roots{ownership: ownership, library: library}
+}
+
+// Of returns the library root of owner's files.
+func (r *Roots) Of(owner string) string {
+	if owner != "" && r.ownership != nil {
+		if userPath := r.ownership.GetUserMusicPath(owner); userPath != "" {
+			return userPath
+		}
+	}
+	return r.library
+}
+
+// OwnerOf returns the user owning path, or "" for the main library.
+func (r *Roots) OwnerOf(path string) string {
+	if r.ownership == nil {
+		return ""
+	}
+	return r.ownership.GetOwnerFromPath(path)
+}
+
+// Relative returns filePath relative to the root it lives under, using
+// forward slashes. Paths outside the root are returned unchanged.
+func (r *Roots) Relative(filePath, owner string) string {
+	rel, err := filepath.Rel(r.Of(owner), filePath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(filePath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Absolute is the inverse of Relative.
+func (r *Roots) Absolute(relPath, owner string) string {
+	path := filepath.FromSlash(relPath)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(r.Of(owner), path)
+}
@@ -0,0 +1,232 @@
+package library
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"staccato/internal/database"
+	"staccato/internal/metadata"
+)
+
+// Progress is a snapshot of a running scan.
+type Progress struct {
+	// Found counts audio files discovered so far; it is final once Walked.
+	Found     int    `json:"found"`
+	Processed int    `json:"processed"`
+	Failed    int    `json:"failed"`
+	CueSheets int    `json:"cueSheets"`
+	Playlists int    `json:"playlists"`
+	Walked    bool   `json:"walked"`
+	Path      string `json:"path,omitempty"` // set when the update is for a processed file
+}
+
+// ScanResult summarises a finished (or cancelled) scan.
+type ScanResult struct {
+	Files     int           `json:"files"`
+	Tracks    int           `json:"tracks"` // audio file tracks written
+	Failed    int           `json:"failed"`
+	CueSheets int           `json:"cueSheets"`
+	Playlists int           `json:"playlists"` // playlist files mirrored
+	Elapsed   time.Duration `json:"elapsed"`
+}
+
+// Scanner walks a directory tree and ingests every audio file in it. Files
+// are read by ioWorkers goroutines, parsed by one goroutine per CPU and
+// written by a single goroutine in batched transactions.
+type Scanner struct {
+	ingestor  *Ingestor
+	ioWorkers int
+
+	// OnProgress, if set, is called after each file, from one goroutine at a
+	// time. It must not block for long.
+	OnProgress func(Progress)
+
+	// Playlists, if set, mirrors the playlist files found once the tracks
+	// are written, and drops file-backed playlists whose file is gone.
+	Playlists *PlaylistFiles
+}
+
+// NewScanner creates a Scanner. ioWorkers below 1 uses one per CPU.
+func NewScanner(ingestor *Ingestor, ioWorkers int) *Scanner {
+	if ioWorkers < 1 {
+		ioWorkers = runtime.NumCPU()
+	}
+	return &Scanner{ingestor: ingestor, ioWorkers: ioWorkers}
+}
+
+// Scan ingests root, skipping trash directories. Cue sheets are read first
+// so the audio files they split into tracks are not added whole. Cancelling ctx stops the walk; files
+// already parsed are still written. The error is the walk's or ctx's.
+func (s *Scanner) Scan(ctx context.Context, root string) (ScanResult, error) {
+	start := time.Now()
+	var (
+		mu       sync.Mutex
+		progress Progress
+	)
+	report := func(update func(p *Progress)) {
+		mu.Lock()
+		defer mu.Unlock()
+		update(&progress)
+		if s.OnProgress != nil {
+			s.OnProgress(progress)
+		}
+	}
+
+	covered, err := s.scanCueSheets(ctx, root, report)
+	if err != nil {
+		return ScanResult{Elapsed: time.Since(start)}, err
+	}
+
+	paths := make(chan string, 100)
+	// Buffering sources to the I/O worker count bounds how many files sit
+	// open waiting for a parser.
+	sources := make(chan *metadata.Source, s.ioWorkers)
+	failed := func(path string) {
+		report(func(p *Progress) { p.Processed++; p.Failed++; p.Path = path })
+	}
+
+	// I/O stage: open files and read their head and tail into memory
+	var readers sync.WaitGroup
+	for i := 0; i < s.ioWorkers; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for path := range paths {
+				if ctx.Err() != nil {
+					continue
+				}
+				src, err := metadata.OpenSource(path)
+				if err != nil {
+					s.ingestor.logger.WithError(err).WithField("file_path", path).Error("Error reading audio file")
+					failed(path)
+					continue
+				}
+				sources <- src
+			}
+		}()
+	}
+
+	// Parse stage: extract metadata from the cached data and hand it to the
+	// single database writer
+	ingest := s.ingestor.db.NewBulkIngest(database.DefaultIngestBatchSize)
+	var parsers sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		parsers.Add(1)
+		go func() {
+			defer parsers.Done()
+			for src := range sources {
+				path := src.Path()
+				if ctx.Err() != nil {
+					src.Close()
+					continue
+				}
+				track, err := s.ingestor.extractor.ExtractFromSource(src, 0)
+				src.Close()
+				if err != nil {
+					s.ingestor.logger.WithError(err).WithField("file_path", path).Error("Error extracting metadata")
+					failed(path)
+					continue
+				}
+				track.Owner = s.ingestor.OwnerOf(path)
+				ingest.Add(track)
+				report(func(p *Progress) { p.Processed++; p.Path = path })
+			}
+		}()
+	}
+
+	var playlistPaths []string
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == TrashDir {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && s.ingestor.IsAudioFile(path) && !covered[path] {
+			report(func(p *Progress) { p.Found++; p.Path = "" })
+			paths <- path
+		}
+		if !d.IsDir() && s.Playlists != nil && IsPlaylistFile(path) {
+			playlistPaths = append(playlistPaths, path)
+		}
+		return nil
+	})
+	report(func(p *Progress) { p.Walked = true; p.Path = "" })
+
+	// Drain the stages in order
+	close(paths)
+	readers.Wait()
+	close(sources)
+	parsers.Wait()
+	written, err := ingest.Close()
+	if err != nil {
+		s.ingestor.logger.WithError(err).Error("Error inserting tracks into database")
+	}
+	if s.Playlists != nil && walkErr == nil {
+		s.syncPlaylists(ctx, root, playlistPaths, report)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return ScanResult{
+		Files:     progress.Found,
+		Tracks:    written,
+		Failed:    progress.Failed,
+		CueSheets: progress.CueSheets,
+		Playlists: progress.Playlists,
+		Elapsed:   time.Since(start),
+	}, walkErr
+}
+
+// scanCueSheets ingests every cue sheet under root and returns the set of
+// audio files they cover, which the scan must not add on their own.
+func (s *Scanner) scanCueSheets(ctx context.Context, root string, report func(func(*Progress))) (map[string]bool, error) {
+	covered := map[string]bool{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && d.Name() == TrashDir {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() || !metadata.IsCueSheet(path) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sources, err := s.ingestor.IngestCueSheet(ctx, path)
+		if err != nil {
+			s.ingestor.logger.WithError(err).WithField("cue_path", path).Error("Error reading cue sheet")
+			return nil
+		}
+		for _, source := range sources {
+			covered[source] = true
+		}
+		report(func(p *Progress) { p.CueSheets++; p.Path = path })
+		return nil
+	})
+	return covered, err
+}
+
+// syncPlaylists mirrors the playlist files found by a scan, then drops
+// file-backed playlists under root whose file is gone.
+func (s *Scanner) syncPlaylists(ctx context.Context, root string, paths []string, report func(func(*Progress))) {
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, _, err := s.Playlists.SyncFile(path); err != nil {
+			s.ingestor.logger.WithError(err).WithField("playlist_path", path).Error("Error reading playlist file")
+			continue
+		}
+		report(func(p *Progress) { p.Playlists++; p.Path = path })
+	}
+	if _, err := s.Playlists.Prune(root); err != nil {
+		s.ingestor.logger.WithError(err).Error("Error removing playlists of deleted playlist files")
+	}
+}
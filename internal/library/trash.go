@@ -0,0 +1,175 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"staccato/internal/database"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TrashDir is the directory, at the top of each library root, that deleted
+// files are moved to. Scans and the file watcher skip it.
+const TrashDir = ".trash"
+
+var (
+	// ErrVirtualTrack is returned when deleting a cue sheet track, whose
+	// audio is shared with the rest of the sheet.
+	ErrVirtualTrack = errors.New("cue sheet tracks cannot be deleted individually")
+	// ErrRestoreConflict is returned when a file already exists where a
+	// trashed file would be restored.
+	ErrRestoreConflict = errors.New("a file already exists at the original path")
+)
+
+// InTrash reports whether path lies inside a trash directory.
+func InTrash(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == TrashDir {
+			return true
+		}
+	}
+	return false
+}
+
+// Trash moves deleted tracks' files into the .trash directory of their
+// library root, from where they can be restored or purged.
+type Trash struct {
+	ingestor *Ingestor
+	roots    *Roots
+}
+
+// NewTrash creates a Trash that removes and re-adds tracks through ingestor.
+func NewTrash(ingestor *Ingestor, roots *Roots) *Trash {
+	return &Trash{ingestor: ingestor, roots: roots}
+}
+
+// Delete moves a track's file to the trash, removes its row and returns the
+// new trash entry. deletedBy is recorded for the trash listing.
+func (t *Trash) Delete(track *models.Track, deletedBy string) (*models.TrashItem, error) {
+	if track.IsVirtual() {
+		return nil, ErrVirtualTrack
+	}
+	dir := filepath.Join(t.roots.Of(track.Owner), TrashDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create trash directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	item := models.TrashItem{
+		Owner:        track.Owner,
+		Title:        track.Title,
+		Artist:       track.Artist,
+		Album:        track.Album,
+		FileSize:     track.FileSize,
+		DeletedBy:    deletedBy,
+		DeletedAt:    now,
+		OriginalPath: track.FilePath,
+		// The timestamp keeps files of the same name apart
+		TrashPath: filepath.Join(dir, fmt.Sprintf("%d-%s", now.UnixNano(), filepath.Base(track.FilePath))),
+	}
+	if err := os.Rename(item.OriginalPath, item.TrashPath); err != nil {
+		return nil, fmt.Errorf("failed to move file to trash: %w", err)
+	}
+	id, err := t.ingestor.db.AddTrashItem(item)
+	if err != nil {
+		os.Rename(item.TrashPath, item.OriginalPath)
+		return nil, err
+	}
+	item.ID = id
+	item.Path = t.roots.Relative(item.OriginalPath, item.Owner)
+	if err := t.ingestor.RemoveFile(item.OriginalPath); err != nil {
+		// Undo the move so the track doesn't point into the trash
+		os.Rename(item.TrashPath, item.OriginalPath)
+		t.ingestor.db.DeleteTrashItem(id)
+		return nil, err
+	}
+
+	t.ingestor.logger.WithFields(logrus.Fields{
+		"file_path": item.OriginalPath,
+		"trash_id":  item.ID,
+		"user":      deletedBy,
+	}).Info("Moved track to trash")
+	return &item, nil
+}
+
+// List returns the trash entries in the scope, newest first.
+func (t *Trash) List(scope database.StatsScope) ([]models.TrashItem, error) {
+	items, err := t.ingestor.db.ListTrash(scope)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Path = t.roots.Relative(items[i].OriginalPath, items[i].Owner)
+	}
+	return items, nil
+}
+
+// Get returns a trash entry by ID, or nil if there is none.
+func (t *Trash) Get(id int) (*models.TrashItem, error) {
+	item, err := t.ingestor.db.GetTrashItem(id)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	item.Path = t.roots.Relative(item.OriginalPath, item.Owner)
+	return item, nil
+}
+
+// Restore moves a trashed file back to its original path and re-adds it to
+// the library as a new track, owned as before. Playlist membership and
+// overrides are not restored.
+func (t *Trash) Restore(ctx context.Context, item *models.TrashItem) (*models.Track, error) {
+	if _, err := os.Stat(item.OriginalPath); err == nil {
+		return nil, ErrRestoreConflict
+	}
+	if err := os.MkdirAll(filepath.Dir(item.OriginalPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(item.TrashPath, item.OriginalPath); err != nil {
+		return nil, fmt.Errorf("failed to restore file from trash: %w", err)
+	}
+	if err := t.ingestor.db.DeleteTrashItem(item.ID); err != nil {
+		return nil, err
+	}
+	track, err := t.ingestor.IngestFileAs(ctx, item.OriginalPath, item.Owner)
+	if err != nil {
+		return nil, err
+	}
+	t.ingestor.logger.WithFields(logrus.Fields{
+		"file_path": item.OriginalPath,
+		"trash_id":  item.ID,
+	}).Info("Restored track from trash")
+	return track, nil
+}
+
+// Purge permanently deletes a trashed file. A file already gone from the
+// trash directory only has its entry removed.
+func (t *Trash) Purge(item *models.TrashItem) error {
+	if err := os.Remove(item.TrashPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return t.ingestor.db.DeleteTrashItem(item.ID)
+}
+
+// PurgeOlderThan purges every entry deleted more than age ago and returns how
+// many were purged.
+func (t *Trash) PurgeOlderThan(age time.Duration) (int, error) {
+	items, err := t.ingestor.db.GetTrashDeletedBefore(time.Now().UTC().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for i := range items {
+		if err := t.Purge(&items[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
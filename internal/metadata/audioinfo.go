@@ -0,0 +1,517 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mewkiz/flac"
+)
+
+// Codec names reported in AudioInfo.
+const (
+	CodecPCM    = "pcm"
+	CodecFLAC   = "flac"
+	CodecALAC   = "alac"
+	CodecAAC    = "aac"
+	CodecVorbis = "vorbis"
+	CodecOpus   = "opus"
+)
+
+// AudioInfo describes the encoding of an audio stream.
+type AudioInfo struct {
+	Codec         string
+	SampleRate    int
+	Channels      int
+	BitsPerSample int // 0 for lossy codecs
+	Duration      time.Duration
+}
+
+// Lossless reports whether the codec is lossless.
+func (i AudioInfo) Lossless() bool {
+	switch i.Codec {
+	case CodecPCM, CodecFLAC, CodecALAC:
+		return true
+	default:
+		return false
+	}
+}
+
+// Seconds returns the duration rounded to whole seconds.
+func (i AudioInfo) Seconds() int {
+	return int(i.Duration.Seconds() + 0.5)
+}
+
+// ReadAudioInfo parses the headers of MP3, FLAC, WAV, Ogg (Vorbis, Opus or
+// FLAC), AIFF/AIFC, ADTS AAC and MP4 (AAC or ALAC) files.
+func ReadAudioInfo(path string) (AudioInfo, error) {
+	src, err := OpenSource(path)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	defer src.Close()
+	return readAudioInfo(src)
+}
+
+// readAudioInfo dispatches on the source's extension.
+func readAudioInfo(src *Source) (AudioInfo, error) {
+	switch ext := src.Ext(); ext {
+	case ".mp3":
+		return readMP3Info(src, src.Size())
+	case ".flac":
+		return readFLACInfo(src)
+	case ".wav":
+		return readWAVInfo(src)
+	case ".ogg", ".oga", ".opus":
+		return readOggInfo(src, src.Size())
+	case ".aiff", ".aif":
+		return readAIFFInfo(src)
+	case ".aac":
+		return readADTSInfo(src)
+	case ".m4a":
+		return readMP4Info(src, src.Size())
+	default:
+		return AudioInfo{}, fmt.Errorf("unsupported format: %s", ext)
+	}
+}
+
+// FLAC and WAV
+
+// readFLACInfo reads the STREAMINFO block.
+func readFLACInfo(f *Source) (AudioInfo, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return AudioInfo{}, err
+	}
+	stream, err := flac.New(f)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	si := stream.Info
+	if si.NSamples == 0 || si.SampleRate == 0 {
+		return AudioInfo{}, fmt.Errorf("flac stream missing sample info")
+	}
+	return AudioInfo{
+		Codec:         CodecFLAC,
+		SampleRate:    int(si.SampleRate),
+		Channels:      int(si.NChannels),
+		BitsPerSample: int(si.BitsPerSample),
+		Duration:      samplesDuration(int64(si.NSamples), int(si.SampleRate)),
+	}, nil
+}
+
+// readWAVInfo takes the duration from the size of the data chunk, clamped
+// to the file for streams written with a placeholder size.
+func readWAVInfo(f *Source) (AudioInfo, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return AudioInfo{}, err
+	}
+	format, offset, size, err := readWAVLayout(f)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	if rest := f.Size() - offset; size > rest {
+		size = rest
+	}
+	frameSize := int64(format.bitsPerSample+7) / 8 * int64(format.channels)
+	return AudioInfo{
+		Codec:         CodecPCM,
+		SampleRate:    int(format.sampleRate),
+		Channels:      format.channels,
+		BitsPerSample: format.bitsPerSample,
+		Duration:      samplesDuration(size/frameSize, int(format.sampleRate)),
+	}, nil
+}
+
+// Ogg
+
+const (
+	oggPageHeaderSize = 27
+	oggMaxPageSize    = oggPageHeaderSize + 255 + 255*255
+)
+
+// readOggInfo reads the identification header from the first page and the
+// duration from the granule position of the stream's last page.
+func readOggInfo(f *Source, size int64) (AudioInfo, error) {
+	var info AudioInfo
+	header := make([]byte, oggPageHeaderSize+255)
+	n, err := f.ReadAt(header, 0)
+	if n < oggPageHeaderSize || string(header[0:4]) != "OggS" {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("not an ogg stream")
+		}
+		return info, err
+	}
+	serial := binary.LittleEndian.Uint32(header[14:18])
+	segments := int(header[26])
+	if n < oggPageHeaderSize+segments {
+		return info, fmt.Errorf("truncated ogg page")
+	}
+	packetSize := 0
+	for _, lace := range header[oggPageHeaderSize : oggPageHeaderSize+segments] {
+		packetSize += int(lace)
+		if lace < 255 {
+			break
+		}
+	}
+	packet := make([]byte, packetSize)
+	if _, err := f.ReadAt(packet, int64(oggPageHeaderSize+segments)); err != nil {
+		return info, fmt.Errorf("reading ogg identification header: %w", err)
+	}
+
+	var preSkip int64
+	granuleRate := int64(0)
+	switch {
+	case len(packet) >= 16 && string(packet[0:7]) == "\x01vorbis":
+		info.Codec = CodecVorbis
+		info.Channels = int(packet[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		granuleRate = int64(info.SampleRate)
+	case len(packet) >= 19 && string(packet[0:8]) == "OpusHead":
+		// Opus granule positions always count 48 kHz samples.
+		info.Codec = CodecOpus
+		info.Channels = int(packet[9])
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		if info.SampleRate == 0 {
+			info.SampleRate = 48000
+		}
+		granuleRate = 48000
+	case len(packet) >= 51 && string(packet[0:5]) == "\x7fFLAC" && string(packet[9:13]) == "fLaC":
+		info.Codec = CodecFLAC
+		si := packet[17:]
+		info.SampleRate = int(si[10])<<12 | int(si[11])<<4 | int(si[12])>>4
+		info.Channels = int(si[12]>>1&0x07) + 1
+		info.BitsPerSample = int(si[12]&0x01)<<4 | int(si[13]>>4) + 1
+		granuleRate = int64(info.SampleRate)
+	default:
+		return info, fmt.Errorf("unsupported ogg codec")
+	}
+	if granuleRate == 0 {
+		return info, fmt.Errorf("invalid ogg sample rate")
+	}
+
+	granule, err := lastOggGranule(f, size, serial)
+	if err != nil {
+		return info, err
+	}
+	if samples := granule - preSkip; samples > 0 {
+		info.Duration = time.Duration(samples) * time.Second / time.Duration(granuleRate)
+	}
+	return info, nil
+}
+
+// lastOggGranule finds the last page of the given logical stream that ends a
+// packet and returns its granule position. Pages are at most 64 KiB, so the
+// last one starts within the file's final oggMaxPageSize bytes.
+func lastOggGranule(f *Source, size int64, serial uint32) (int64, error) {
+	start := size - oggMaxPageSize
+	if start < 0 {
+		start = 0
+	}
+	tail := make([]byte, size-start)
+	if _, err := f.ReadAt(tail, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	for end := len(tail); end > 0; {
+		i := bytes.LastIndex(tail[:end], []byte("OggS"))
+		if i < 0 {
+			break
+		}
+		end = i
+		if len(tail)-i < oggPageHeaderSize || tail[i+4] != 0 {
+			continue
+		}
+		granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+		if binary.LittleEndian.Uint32(tail[i+14:i+18]) == serial && granule >= 0 {
+			return granule, nil
+		}
+	}
+	return 0, fmt.Errorf("ogg stream has no final granule position")
+}
+
+// AIFF
+
+// findAIFFChunk returns the offset and size of the first chunk with the given
+// ID in an AIFF or AIFC file, and whether the file is AIFC.
+func findAIFFChunk(r io.ReadSeeker, id string) (int64, int64, bool, error) {
+	header := make([]byte, 12)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, false, err
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, false, err
+	}
+	form := string(header[8:12])
+	if string(header[0:4]) != "FORM" || (form != "AIFF" && form != "AIFC") {
+		return 0, 0, false, fmt.Errorf("not an aiff file")
+	}
+	aifc := form == "AIFC"
+
+	offset := int64(12)
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return 0, 0, aifc, fmt.Errorf("aiff chunk %q not found", id)
+		}
+		size := int64(binary.BigEndian.Uint32(chunk[4:8]))
+		offset += 8
+		if string(chunk[0:4]) == id {
+			return offset, size, aifc, nil
+		}
+		skip := size + size%2
+		if _, err := r.Seek(skip, io.SeekCurrent); err != nil {
+			return 0, 0, aifc, err
+		}
+		offset += skip
+	}
+}
+
+// readAIFFInfo parses the COMM chunk.
+func readAIFFInfo(f *Source) (AudioInfo, error) {
+	var info AudioInfo
+	offset, size, aifc, err := findAIFFChunk(f, "COMM")
+	if err != nil {
+		return info, err
+	}
+	if size < 18 {
+		return info, fmt.Errorf("invalid aiff COMM chunk")
+	}
+	comm := make([]byte, size)
+	if _, err := f.ReadAt(comm, offset); err != nil {
+		return info, err
+	}
+
+	info.Codec = CodecPCM
+	info.Channels = int(binary.BigEndian.Uint16(comm[0:2]))
+	frames := int64(binary.BigEndian.Uint32(comm[2:6]))
+	info.BitsPerSample = int(binary.BigEndian.Uint16(comm[6:8]))
+	rate := extendedFloat(comm[8:18])
+	info.SampleRate = int(rate + 0.5)
+	if aifc && size >= 22 {
+		switch compression := string(comm[18:22]); compression {
+		case "NONE", "sowt", "twos", "raw ", "in24", "in32", "fl32", "fl64":
+		default:
+			info.Codec = strings.ToLower(strings.TrimSpace(compression))
+			info.BitsPerSample = 0
+		}
+	}
+	if rate <= 0 {
+		return info, fmt.Errorf("invalid aiff sample rate")
+	}
+	info.Duration = time.Duration(float64(frames) / rate * float64(time.Second))
+	return info, nil
+}
+
+// extendedFloat decodes an 80-bit IEEE 754 extended precision number, which
+// AIFF uses for the sample rate.
+func extendedFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	value := math.Ldexp(float64(mantissa), exponent-16383-63)
+	if b[0]&0x80 != 0 {
+		value = -value
+	}
+	return value
+}
+
+// ADTS AAC
+
+var adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// readADTSInfo walks the ADTS frame headers of a raw AAC stream, counting
+// 1024 samples per raw data block. A leading ID3v2 tag is skipped.
+func readADTSInfo(f *Source) (AudioInfo, error) {
+	info := AudioInfo{Codec: CodecAAC}
+	r := bufio.NewReader(io.NewSectionReader(f, 0, f.Size()))
+	if err := skipID3v2(r); err != nil {
+		return info, err
+	}
+
+	var samples int64
+	frames := 0
+	for {
+		h, err := r.Peek(7)
+		if err != nil || h[0] != 0xFF || h[1]&0xF6 != 0xF0 {
+			// End of stream, or trailing data such as an ID3v1 tag
+			break
+		}
+		rateIndex := int(h[2] >> 2 & 0x0F)
+		if rateIndex >= len(adtsSampleRates) {
+			return info, fmt.Errorf("invalid adts sample rate index %d", rateIndex)
+		}
+		frameLen := int(h[3]&0x03)<<11 | int(h[4])<<3 | int(h[5])>>5
+		if frameLen < 7 {
+			return info, fmt.Errorf("invalid adts frame length %d", frameLen)
+		}
+		if frames == 0 {
+			info.SampleRate = adtsSampleRates[rateIndex]
+			info.Channels = int(h[2]&0x01)<<2 | int(h[3])>>6
+		}
+		samples += int64(h[6]&0x03+1) * 1024
+		frames++
+		if _, err := r.Discard(frameLen); err != nil {
+			break
+		}
+	}
+	if frames == 0 {
+		return info, fmt.Errorf("no adts frames found")
+	}
+	info.Duration = time.Duration(samples) * time.Second / time.Duration(info.SampleRate)
+	return info, nil
+}
+
+// skipID3v2 discards an ID3v2 tag at the reader's position, if present.
+func skipID3v2(r *bufio.Reader) error {
+	h, err := r.Peek(10)
+	if err != nil || string(h[0:3]) != "ID3" {
+		return nil
+	}
+	size := int(syncsafe(h[6:10])) + 10
+	if h[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	_, err = r.Discard(size)
+	return err
+}
+
+// MP4
+
+// readMP4Info reads the duration from mvhd and the codec from the sound
+// track's sample description, telling ALAC from AAC.
+func readMP4Info(f *Source, size int64) (AudioInfo, error) {
+	var info AudioInfo
+	atoms, err := readTopLevelAtoms(f, size)
+	if err != nil {
+		return info, err
+	}
+	var moov []byte
+	for _, a := range atoms {
+		if a.kind == "moov" {
+			moov = make([]byte, a.size-a.headerSize)
+			if _, err := f.ReadAt(moov, a.offset+a.headerSize); err != nil {
+				return info, err
+			}
+			break
+		}
+	}
+	if moov == nil {
+		return info, fmt.Errorf("moov atom not found")
+	}
+
+	children, err := parseAtoms(moov)
+	if err != nil {
+		return info, err
+	}
+	foundDuration := false
+	for _, child := range children {
+		switch child.kind {
+		case "mvhd":
+			if info.Duration, err = mp4HeaderDuration(child.payload); err != nil {
+				return info, err
+			}
+			foundDuration = true
+		case "trak":
+			if info.Codec == "" {
+				readMP4SoundTrack(child.payload, &info)
+			}
+		}
+	}
+	if !foundDuration {
+		return info, fmt.Errorf("mvhd atom not found")
+	}
+	if info.Codec == "" {
+		return info, fmt.Errorf("no audio track found")
+	}
+	return info, nil
+}
+
+// mp4HeaderDuration decodes the duration of an mvhd payload (version 0 or 1).
+func mp4HeaderDuration(p []byte) (time.Duration, error) {
+	var timescale, units uint64
+	switch {
+	case len(p) >= 20 && p[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(p[12:16]))
+		units = uint64(binary.BigEndian.Uint32(p[16:20]))
+	case len(p) >= 32 && p[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(p[20:24]))
+		units = binary.BigEndian.Uint64(p[24:32])
+	default:
+		return 0, fmt.Errorf("invalid mvhd atom")
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("invalid timescale")
+	}
+	return time.Duration(float64(units) / float64(timescale) * float64(time.Second)), nil
+}
+
+// readMP4SoundTrack fills info from a trak atom if its handler is "soun".
+func readMP4SoundTrack(trak []byte, info *AudioInfo) {
+	mdia := findMP4Atom(trak, "mdia")
+	hdlr := findMP4Atom(mdia, "hdlr")
+	if len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+		return
+	}
+	stsd := findMP4Atom(findMP4Atom(findMP4Atom(mdia, "minf"), "stbl"), "stsd")
+	if len(stsd) < 8 {
+		return
+	}
+	entries, err := parseAtoms(stsd[8:])
+	if err != nil || len(entries) == 0 {
+		return
+	}
+
+	// Audio sample entry: reserved(6) data_ref(2) reserved(8) channels(2)
+	// sample_size(2) reserved(4) sample_rate(16.16)
+	entry := entries[0]
+	switch entry.kind {
+	case "alac":
+		info.Codec = CodecALAC
+	case "mp4a":
+		info.Codec = CodecAAC
+	case "fLaC":
+		info.Codec = CodecFLAC
+	case "Opus":
+		info.Codec = CodecOpus
+	default:
+		info.Codec = strings.ToLower(strings.TrimSpace(entry.kind))
+	}
+	if p := entry.payload; len(p) >= 28 {
+		info.Channels = int(binary.BigEndian.Uint16(p[16:18]))
+		info.SampleRate = int(binary.BigEndian.Uint32(p[24:28]) >> 16)
+		if info.Lossless() {
+			info.BitsPerSample = int(binary.BigEndian.Uint16(p[18:20]))
+		}
+	}
+}
+
+// findMP4Atom returns the payload of the first child atom of the given kind.
+func findMP4Atom(data []byte, kind string) []byte {
+	atoms, err := parseAtoms(data)
+	if err != nil {
+		return nil
+	}
+	for _, a := range atoms {
+		if a.kind == kind {
+			return a.payload
+		}
+	}
+	return nil
+}
+
+// aiffTagReader returns the embedded ID3v2 tag of an AIFF file ("ID3 "
+// chunk) so it can be read like an MP3's.
+func aiffTagReader(f *Source) (io.ReadSeeker, error) {
+	offset, size, _, err := findAIFFChunk(f, "ID3 ")
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(f, offset, size), nil
+}
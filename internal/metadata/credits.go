@@ -0,0 +1,145 @@
+package metadata
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"staccato/pkg/models"
+)
+
+// DefaultArtistSeparators split a tag holding several artists. "&" and ","
+// are left out because they are part of too many band names.
+var DefaultArtistSeparators = []string{";", " / "}
+
+// DefaultFeaturingPatterns introduce featured artists in an artist tag or a
+// title, e.g. "Artist feat. Guest" or "Song (ft. Guest)".
+var DefaultFeaturingPatterns = []string{"featuring", "feat.", "feat", "ft."}
+
+// featuredSeparators also split a featured artist list ("feat. B & C"),
+// where they rarely belong to a name.
+var featuredSeparators = []string{", ", " & "}
+
+// remixPattern finds "(Name Remix)" or "[Name Remix]" in a title.
+var remixPattern = regexp.MustCompile(`(?i)[(\[]([^()\[\]]+?)\s+remix[)\]]`)
+
+// remixDescriptions are words used in place of a remixer's name.
+var remixDescriptions = map[string]bool{
+	"original": true, "extended": true, "radio": true, "club": true,
+	"official": true, "dub": true, "instrumental": true, "album": true,
+}
+
+// CreditParser splits artist tags into individual credits. It is safe for
+// concurrent use.
+type CreditParser struct {
+	separators         []string
+	featuredSeparators []string
+	featuring          *regexp.Regexp
+}
+
+// NewCreditParser creates a parser splitting artists on separators and
+// treating patterns as the words that introduce featured artists. Nil
+// slices use the defaults; empty entries are ignored.
+func NewCreditParser(separators, patterns []string) *CreditParser {
+	if separators == nil {
+		separators = DefaultArtistSeparators
+	}
+	if patterns == nil {
+		patterns = DefaultFeaturingPatterns
+	}
+
+	p := &CreditParser{separators: []string{"\x00"}} // ID3v2.4 multi-value frames
+	for _, sep := range separators {
+		if sep != "" {
+			p.separators = append(p.separators, sep)
+		}
+	}
+
+	p.featuredSeparators = append(append([]string(nil), p.separators...), featuredSeparators...)
+
+	// Longest first, so "feat." wins over "feat"
+	sorted := append([]string(nil), patterns...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	var alts []string
+	for _, pattern := range sorted {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			alts = append(alts, regexp.QuoteMeta(pattern))
+		}
+	}
+	if len(alts) > 0 {
+		p.featuring = regexp.MustCompile(`(?i)(?:^|\s|[(\[])(?:` + strings.Join(alts, "|") + `)\s+`)
+	}
+	return p
+}
+
+// Parse returns the credits of a track: the main and featured artists from
+// its artist tag, featured artists and a remixer named in its title, and
+// the artists of its composer and remixer tags. A name is credited once per
+// role, in tag order; an artist credited as main is not also featured.
+func (p *CreditParser) Parse(track models.Track) []models.ArtistCredit {
+	var credits []models.ArtistCredit
+	seen := map[string]bool{}
+	add := func(role string, names []string) {
+		for _, name := range names {
+			key := strings.ToLower(name)
+			if seen[role+"\x00"+key] || (role == models.RoleFeatured && seen[models.RoleMain+"\x00"+key]) {
+				continue
+			}
+			seen[role+"\x00"+key] = true
+			credits = append(credits, models.ArtistCredit{Name: name, Role: role})
+		}
+	}
+
+	main, featured := p.splitFeaturing(track.Artist)
+	add(models.RoleMain, p.split(main, p.separators))
+	add(models.RoleFeatured, featured)
+	_, featured = p.splitFeaturing(track.Title)
+	add(models.RoleFeatured, featured)
+
+	add(models.RoleRemixer, p.split(track.Remixer, p.separators))
+	for _, m := range remixPattern.FindAllStringSubmatch(track.Title, -1) {
+		if name := strings.TrimSpace(m[1]); !remixDescriptions[strings.ToLower(name)] {
+			add(models.RoleRemixer, p.split(name, p.separators))
+		}
+	}
+	add(models.RoleComposer, p.split(track.Composer, p.separators))
+	return credits
+}
+
+// splitFeaturing cuts s at the first featuring pattern, returning what comes
+// before it and the featured artists after it. A featured list ends at the
+// bracket closing it.
+func (p *CreditParser) splitFeaturing(s string) (string, []string) {
+	if p.featuring == nil {
+		return s, nil
+	}
+	loc := p.featuring.FindStringIndex(s)
+	if loc == nil {
+		return s, nil
+	}
+	before := strings.TrimRight(s[:loc[0]], " ([")
+	after := s[loc[1]:]
+	if end := strings.IndexAny(after, ")]"); end >= 0 {
+		after = after[:end]
+	}
+	return before, p.split(after, p.featuredSeparators)
+}
+
+// split breaks s on any of separators, dropping empty names.
+func (p *CreditParser) split(s string, separators []string) []string {
+	parts := []string{s}
+	for _, sep := range separators {
+		var next []string
+		for _, part := range parts {
+			next = append(next, strings.Split(part, sep)...)
+		}
+		parts = next
+	}
+	names := parts[:0]
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			names = append(names, part)
+		}
+	}
+	return names
+}
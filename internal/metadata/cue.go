@@ -0,0 +1,328 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"staccato/pkg/models"
+)
+
+// CueFramesPerSecond is the resolution of CUE sheet timestamps (CD frames).
+const CueFramesPerSecond = 75
+
+// CueSheet is a parsed .cue file describing tracks within one or more audio
+// files (typically a single-file album rip).
+type CueSheet struct {
+	Title     string
+	Performer string
+	Tracks    []CueTrack
+}
+
+// CueTrack is one TRACK entry. Start is the INDEX 01 position in CD frames
+// within File; End is the start of the next track in the same file, or 0 when
+// the track runs to the end of the file.
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	File      string
+	Start     int
+	End       int
+}
+
+// IsCueSheet reports whether the path is a .cue file.
+func IsCueSheet(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".cue")
+}
+
+// ParseCueSheet parses CUE sheet data. Non-UTF-8 input is treated as
+// Latin-1, which is what most rippers write.
+func ParseCueSheet(data []byte) (*CueSheet, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if !utf8.Valid(data) {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		data = []byte(string(runes))
+	}
+
+	sheet := &CueSheet{}
+	var file string
+	var current *CueTrack
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		command, args := splitCueLine(scanner.Text())
+		switch command {
+		case "FILE":
+			if len(args) == 0 {
+				return nil, fmt.Errorf("cue line %d: FILE without name", line)
+			}
+			file = args[0]
+		case "TRACK":
+			if file == "" {
+				return nil, fmt.Errorf("cue line %d: TRACK before FILE", line)
+			}
+			if len(args) == 0 {
+				return nil, fmt.Errorf("cue line %d: TRACK without number", line)
+			}
+			number, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("cue line %d: invalid track number %q", line, args[0])
+			}
+			sheet.Tracks = append(sheet.Tracks, CueTrack{Number: number, File: file, Start: -1})
+			current = &sheet.Tracks[len(sheet.Tracks)-1]
+		case "TITLE", "PERFORMER":
+			if len(args) == 0 {
+				continue
+			}
+			switch {
+			case current == nil && command == "TITLE":
+				sheet.Title = args[0]
+			case current == nil:
+				sheet.Performer = args[0]
+			case command == "TITLE":
+				current.Title = args[0]
+			default:
+				current.Performer = args[0]
+			}
+		case "INDEX":
+			if current == nil || len(args) < 2 {
+				continue
+			}
+			if args[0] != "01" && args[0] != "1" {
+				continue
+			}
+			frames, err := parseCueTime(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("cue line %d: %w", line, err)
+			}
+			if current.File != file {
+				// INDEX 01 after a new FILE: the track starts in that file
+				current.File = file
+			}
+			current.Start = frames
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	tracks := sheet.Tracks[:0]
+	for _, t := range sheet.Tracks {
+		if t.Start >= 0 {
+			tracks = append(tracks, t)
+		}
+	}
+	sheet.Tracks = tracks
+	if len(sheet.Tracks) == 0 {
+		return nil, fmt.Errorf("cue sheet has no tracks")
+	}
+	for i := range sheet.Tracks {
+		if i+1 < len(sheet.Tracks) && sheet.Tracks[i+1].File == sheet.Tracks[i].File {
+			sheet.Tracks[i].End = sheet.Tracks[i+1].Start
+			if sheet.Tracks[i].End <= sheet.Tracks[i].Start {
+				return nil, fmt.Errorf("cue track %d has a non-increasing index", sheet.Tracks[i+1].Number)
+			}
+		}
+	}
+	return sheet, nil
+}
+
+// splitCueLine splits a CUE line into its command and arguments, honouring
+// double-quoted arguments.
+func splitCueLine(line string) (string, []string) {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				fields = append(fields, line[1:])
+				break
+			}
+			fields = append(fields, line[1:end+1])
+			line = strings.TrimSpace(line[end+2:])
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			fields = append(fields, line)
+			break
+		}
+		fields = append(fields, line[:end])
+		line = strings.TrimSpace(line[end:])
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(fields[0]), fields[1:]
+}
+
+// parseCueTime converts mm:ss:ff into CD frames.
+func parseCueTime(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid cue time %q", value)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid cue time %q", value)
+		}
+		n[i] = v
+	}
+	if n[1] >= 60 || n[2] >= CueFramesPerSecond {
+		return 0, fmt.Errorf("invalid cue time %q", value)
+	}
+	return (n[0]*60+n[1])*CueFramesPerSecond + n[2], nil
+}
+
+// CueTrackPath returns the file_path used for a virtual track: the cue
+// sheet's path plus the track number.
+func CueTrackPath(cuePath string, number int) string {
+	return fmt.Sprintf("%s#%02d", cuePath, number)
+}
+
+// ExtractCueTracks parses a cue sheet and returns one virtual track per
+// entry. Each referenced audio file is read for duration, album art and
+// fallback tags; its path becomes the track's SourcePath. The audio files the
+// sheet covers are returned so callers can drop them as standalone tracks.
+func (e *Extractor) ExtractCueTracks(cuePath string) ([]models.Track, []string, error) {
+	data, err := os.ReadFile(cuePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	sheet, err := ParseCueSheet(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", cuePath, err)
+	}
+
+	sources := map[string]models.Track{}
+	var sourceOrder []string
+	var tracks []models.Track
+	for _, ct := range sheet.Tracks {
+		audioPath := e.resolveCueFile(cuePath, ct.File)
+		if audioPath == "" {
+			return nil, nil, fmt.Errorf("%s: audio file %q not found", cuePath, ct.File)
+		}
+		source, ok := sources[audioPath]
+		if !ok {
+			if source, err = e.ExtractFromFile(audioPath, 0); err != nil {
+				return nil, nil, err
+			}
+			sources[audioPath] = source
+			sourceOrder = append(sourceOrder, audioPath)
+		}
+
+		end := ct.End
+		if end == 0 {
+			end = source.Duration * CueFramesPerSecond
+		}
+		duration := 0
+		if end > ct.Start {
+			duration = (end - ct.Start + CueFramesPerSecond/2) / CueFramesPerSecond
+		}
+		size := source.FileSize
+		if source.Duration > 0 {
+			size = source.FileSize * int64(duration) / int64(source.Duration)
+		}
+
+		track := models.Track{
+			Title:       firstNonEmpty(ct.Title, fmt.Sprintf("Track %02d", ct.Number)),
+			Artist:      firstNonEmpty(ct.Performer, sheet.Performer, source.Artist),
+			Album:       firstNonEmpty(sheet.Title, source.Album),
+			AlbumArtist: firstNonEmpty(sheet.Performer, source.AlbumArtist),
+			Compilation: source.Compilation,
+			Composer:    source.Composer,
+			Genre:       source.Genre,
+			TrackNumber: ct.Number,
+			Disc:        source.Disc,
+			Year:        source.Year,
+			Duration:    duration,
+			FilePath:    CueTrackPath(cuePath, ct.Number),
+			FileSize:    size,
+			Codec:       source.Codec,
+			HasAlbumArt: source.HasAlbumArt,
+			AlbumArtID:  source.AlbumArtID,
+			SourcePath:  audioPath,
+			CueStart:    ct.Start,
+			CueEnd:      ct.End,
+		}
+		// Sort tags of the audio file only apply where the sheet kept its values
+		if track.Artist == source.Artist {
+			track.ArtistSort = source.ArtistSort
+		}
+		if track.AlbumArtist == source.AlbumArtist {
+			track.AlbumArtistSort = source.AlbumArtistSort
+		}
+		if track.Album == source.Album {
+			track.AlbumSort = source.AlbumSort
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, sourceOrder, nil
+}
+
+// resolveCueFile finds the audio file a FILE entry refers to, relative to the
+// cue sheet. Rippers often rename the audio after writing the sheet, so a
+// supported file with the same base name (or the cue's own base name) is
+// accepted when the exact name is missing.
+func (e *Extractor) resolveCueFile(cuePath, name string) string {
+	dir := filepath.Dir(cuePath)
+	exact := filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(name, `\`, "/")))
+	if info, err := os.Stat(exact); err == nil && !info.IsDir() && e.IsAudioFile(exact) {
+		return exact
+	}
+
+	bases := []string{
+		strings.TrimSuffix(filepath.Base(exact), filepath.Ext(exact)),
+		strings.TrimSuffix(filepath.Base(cuePath), filepath.Ext(cuePath)),
+	}
+	for _, base := range bases {
+		for _, ext := range e.supportedFormats {
+			candidate := filepath.Join(dir, base+ext)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// CueSheetCovering returns the path of a cue sheet in the audio file's
+// directory that references it, or "" if none does.
+func (e *Extractor) CueSheetCovering(audioPath string) string {
+	dir := filepath.Dir(audioPath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !IsCueSheet(entry.Name()) {
+			continue
+		}
+		cuePath := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(cuePath)
+		if err != nil {
+			continue
+		}
+		sheet, err := ParseCueSheet(data)
+		if err != nil {
+			continue
+		}
+		for _, t := range sheet.Tracks {
+			if e.resolveCueFile(cuePath, t.File) == audioPath {
+				return cuePath
+			}
+		}
+	}
+	return ""
+}
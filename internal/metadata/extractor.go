@@ -2,11 +2,8 @@ package metadata
 
 import (
 	"crypto/md5"
-	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -15,10 +12,7 @@ import (
 	"staccato/pkg/models"
 
 	"github.com/dhowden/tag"
-	"github.com/go-audio/wav"
-	"github.com/mewkiz/flac"
 	"github.com/sirupsen/logrus"
-	"github.com/tcolgate/mp3"
 )
 
 // Extractor encapsulates logic for reading audio file metadata, duration and
@@ -28,6 +22,8 @@ type Extractor struct {
 	logger           *logrus.Logger
 	albumArtCache    map[string][]byte // Cache for album art
 	albumArtMux      sync.RWMutex      // Mutex for album art cache
+	pathTemplates    []*PathTemplate   // Infer missing tags from file paths
+	relativePath     func(string) string
 }
 
 // NewExtractor constructs an Extractor for the given list of supported formats.
@@ -43,13 +39,21 @@ func NewExtractor(supportedFormats []string) *Extractor {
 	}
 }
 
+// SetPathTemplates configures templates used to fill in title, artist, album
+// and track number when tags are missing. Templates match the path relative
+// returns for a file, which should be relative to its library root so that
+// the root's own directories are never taken for fields; nil matches the
+// whole path. Call before extraction starts.
+func (e *Extractor) SetPathTemplates(templates []*PathTemplate, relative func(filePath string) string) {
+	e.pathTemplates = templates
+	e.relativePath = relative
+}
+
 // ExtractFromFile gathers metadata and duration for a single audio file,
 // producing a models.Track. If tag extraction fails it falls back to the
 // filename and default placeholders. 'id' allows caller to supply existing ID.
 func (e *Extractor) ExtractFromFile(filePath string, id int) (models.Track, error) {
-	startTime := time.Now()
-
-	file, err := os.Open(filePath)
+	src, err := OpenSource(filePath)
 	if err != nil {
 		e.logger.WithFields(logrus.Fields{
 			"filePath": filePath,
@@ -57,35 +61,46 @@ func (e *Extractor) ExtractFromFile(filePath string, id int) (models.Track, erro
 		}).Error("Failed to open audio file")
 		return models.Track{}, err
 	}
-	defer file.Close()
+	defer src.Close()
+	return e.ExtractFromSource(src, id)
+}
 
-	// Get file size
-	stat, err := file.Stat()
-	if err != nil {
-		e.logger.WithFields(logrus.Fields{
-			"filePath": filePath,
-			"error":    err.Error(),
-		}).Error("Failed to get file stats")
-		return models.Track{}, err
-	}
+// ExtractFromSource is ExtractFromFile for an already opened source. The
+// tag parser, the duration parser and art hashing all read through the
+// source's cache, so the file is read from disk once.
+func (e *Extractor) ExtractFromSource(src *Source, id int) (models.Track, error) {
+	startTime := time.Now()
+	filePath := src.Path()
 
-	// Calculate duration
-	duration, err := e.calculateDuration(filePath)
+	info, err := readAudioInfo(src)
+	duration, codec := info.Seconds(), info.Codec
 	if err != nil {
 		e.logger.WithFields(logrus.Fields{
 			"filePath": filePath,
 			"error":    err.Error(),
 		}).Warn("Failed to calculate duration, setting to 0")
-		duration = 0
+		duration, codec = 0, ""
 	}
 
-	// Extract metadata using the tag library
-	metadata, err := tag.ReadFrom(file)
-	if err != nil {
-		// If metadata extraction fails, use filename
-		filename := filepath.Base(filePath)
-		name := strings.TrimSuffix(filename, filepath.Ext(filename))
+	// Values inferred from the path fill in whatever the tags lack
+	templatePath := filePath
+	if e.relativePath != nil {
+		templatePath = e.relativePath(filePath)
+	}
+	inferred, _ := InferFromPath(e.pathTemplates, templatePath)
 
+	// Extract metadata using the tag library; AIFF keeps its ID3 tag in a chunk
+	var tagSource io.ReadSeeker = src
+	if ext := src.Ext(); ext == ".aiff" || ext == ".aif" {
+		if tagSource, err = aiffTagReader(src); err != nil {
+			tagSource = src
+		}
+	} else if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return models.Track{}, err
+	}
+	metadata, err := tag.ReadFrom(tagSource)
+	if err != nil {
+		// If metadata extraction fails, use path templates and the filename
 		e.logger.WithFields(logrus.Fields{
 			"filePath": filePath,
 			"error":    err.Error(),
@@ -93,33 +108,41 @@ func (e *Extractor) ExtractFromFile(filePath string, id int) (models.Track, erro
 
 		return models.Track{
 			ID:          id,
-			Title:       name,
-			Artist:      "Unknown Artist",
-			Album:       "Unknown Album",
-			TrackNumber: 0,
+			Title:       firstNonEmpty(inferred.Title, strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))),
+			Artist:      firstNonEmpty(inferred.Artist, "Unknown Artist"),
+			Album:       firstNonEmpty(inferred.Album, "Unknown Album"),
+			TrackNumber: inferred.TrackNumber,
+			Disc:        inferred.Disc,
+			Year:        inferred.Year,
 			Duration:    duration,
 			FilePath:    filePath,
-			FileSize:    stat.Size(),
+			FileSize:    src.Size(),
+			Codec:       codec,
 		}, nil
 	}
 
-	title := metadata.Title()
-	if title == "" {
-		title = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
-	}
-
-	artist := metadata.Artist()
-	if artist == "" {
-		artist = "Unknown Artist"
-	}
-
-	album := metadata.Album()
-	if album == "" {
-		album = "Unknown Album"
-	}
+	title := firstNonEmpty(metadata.Title(), inferred.Title, strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)))
+	artist := firstNonEmpty(metadata.Artist(), inferred.Artist, "Unknown Artist")
+	album := firstNonEmpty(metadata.Album(), inferred.Album, "Unknown Album")
+	albumArtist := strings.TrimSpace(metadata.AlbumArtist())
+	compilation := isCompilation(metadata)
+	composer := strings.TrimSpace(metadata.Composer())
+	remixer := rawText(metadata, remixerKeys)
+	genre := strings.TrimSpace(metadata.Genre())
 
 	// Extract track number
 	trackNum, _ := metadata.Track()
+	if trackNum == 0 {
+		trackNum = inferred.TrackNumber
+	}
+	disc, _ := metadata.Disc()
+	if disc == 0 {
+		disc = inferred.Disc
+	}
+	year := metadata.Year()
+	if year == 0 {
+		year = inferred.Year
+	}
 
 	// Extract album art
 	albumArtID, hasAlbumArt := e.extractAlbumArt(metadata)
@@ -140,202 +163,86 @@ func (e *Extractor) ExtractFromFile(filePath string, id int) (models.Track, erro
 		Title:       title,
 		Artist:      artist,
 		Album:       album,
+		AlbumArtist: albumArtist,
+		Compilation: compilation,
+		Composer:    composer,
+		Remixer:     remixer,
+		Genre:       genre,
 		TrackNumber: trackNum,
+		Disc:        disc,
+		Year:        year,
 		Duration:    duration,
 		FilePath:    filePath,
-		FileSize:    stat.Size(),
+		FileSize:    src.Size(),
+		Codec:       codec,
 		HasAlbumArt: hasAlbumArt,
 		AlbumArtID:  albumArtID,
+
+		ArtistSort:      rawText(metadata, artistSortKeys),
+		AlbumArtistSort: rawText(metadata, albumArtistSortKeys),
+		AlbumSort:       rawText(metadata, albumSortKeys),
+		TitleSort:       rawText(metadata, titleSortKeys),
 	}, nil
 }
 
-// calculateDuration dispatches per-format duration parsing.
-func (e *Extractor) calculateDuration(filePath string) (int, error) {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	switch ext {
-	case ".mp3":
-		return e.durationMP3(filePath)
-	case ".flac":
-		return e.durationFLAC(filePath)
-	case ".wav":
-		return e.durationWAV(filePath)
-	case ".m4a":
-		return e.durationM4A(filePath)
-	default:
-		return 0, fmt.Errorf("unsupported format: %s", ext)
+// firstNonEmpty returns the first non-empty value.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
 	}
+	return ""
 }
 
-// durationMP3 attempts frame-by-frame decoding for accuracy; if no frames can
-// be decoded it falls back to file-size / assumed bitrate estimation.
-func (e *Extractor) durationMP3(path string) (int, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-	dec := mp3.NewDecoder(f)
-	var total time.Duration
-	var skipped int
-	frames := 0
-	for {
-		var fr mp3.Frame
-		if err := dec.Decode(&fr, &skipped); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+// compilationKeys are the raw tag keys holding the compilation flag: ID3v2.2
+// and ID3v2.3/4 frames, Vorbis comments (lowercased by the tag library) and
+// the MP4 atom.
+var compilationKeys = []string{"TCP", "TCMP", "compilation", "cpil"}
+
+// isCompilation reports whether the tags mark the file as part of a
+// compilation. Taggers write the flag as "1" or as an MP4 integer.
+func isCompilation(metadata tag.Metadata) bool {
+	raw := metadata.Raw()
+	for _, key := range compilationKeys {
+		switch v := raw[key].(type) {
+		case string:
+			if s := strings.TrimSpace(strings.Trim(v, "\x00")); s != "" && s != "0" {
+				return true
 			}
-			if frames == 0 { // could not decode any frame
-				return e.estimateFromFileSize(path, 192000) // assume 192 kbps = 192000 bps
+		case int:
+			if v != 0 {
+				return true
 			}
-			break // partial decode; use what we have
 		}
-		total += fr.Duration()
-		frames++
-	}
-	return int(total.Seconds()), nil
-}
-
-// durationFLAC uses flac STREAMINFO block sample count & rate.
-func (e *Extractor) durationFLAC(path string) (int, error) {
-	stream, err := flac.ParseFile(path)
-	if err != nil {
-		return 0, err
 	}
-	si := stream.Info
-	if si.NSamples > 0 && si.SampleRate > 0 {
-		secs := float64(si.NSamples) / float64(si.SampleRate)
-		return int(secs + 0.5), nil
-	}
-	return 0, fmt.Errorf("flac stream missing sample info")
+	return false
 }
 
-// durationWAV approximates duration from header info and file length.
-func (e *Extractor) durationWAV(path string) (int, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-	dec := wav.NewDecoder(f)
-	if !dec.IsValidFile() {
-		return 0, fmt.Errorf("invalid wav file")
-	}
-	if dec.SampleRate == 0 || dec.BitDepth == 0 || dec.NumChans == 0 {
-		return 0, fmt.Errorf("invalid wav header")
-	}
-	// Approximate using file size; full sample count may require decoding all samples.
-	st, err := f.Stat()
-	if err != nil {
-		return 0, err
-	}
-	headerSize := int64(44)
-	pcmBytes := st.Size() - headerSize
-	if pcmBytes < 0 {
-		pcmBytes = 0
-	}
-	bytesPerSampleFrame := int64(dec.BitDepth/8) * int64(dec.NumChans)
-	if bytesPerSampleFrame <= 0 {
-		return 0, fmt.Errorf("invalid sample frame size")
-	}
-	sampleFrames := pcmBytes / bytesPerSampleFrame
-	secs := float64(sampleFrames) / float64(dec.SampleRate)
-	return int(secs + 0.5), nil
-}
+// remixerKeys are the raw tag keys naming a remixer: the ID3v2.2 and
+// ID3v2.3/4 "modified by" frames, and the Vorbis comment.
+var remixerKeys = []string{"TP4", "TPE4", "remixer"}
+
+// Raw tag keys of the sort tags: ID3v2.2 and ID3v2.3/4 frames, then Vorbis
+// comments. The tag library does not keep MP4 sort atoms.
+var (
+	artistSortKeys      = []string{"TSP", "TSOP", "artistsort"}
+	albumArtistSortKeys = []string{"TS2", "TSO2", "albumartistsort"}
+	albumSortKeys       = []string{"TSA", "TSOA", "albumsort"}
+	titleSortKeys       = []string{"TST", "TSOT", "titlesort"}
+)
 
-// durationM4A scans MP4 atoms minimally to read mvhd timescale/duration.
-func (e *Extractor) durationM4A(path string) (int, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-	for {
-		head := make([]byte, 8)
-		if _, err := io.ReadFull(f, head); err != nil {
-			return 0, err
-		}
-		size := binary.BigEndian.Uint32(head[0:4])
-		atom := string(head[4:8])
-		if size < 8 {
-			return 0, fmt.Errorf("invalid atom size")
-		}
-		if atom == "moov" {
-			// scan inside moov for mvhd
-			limit := int64(size) - 8
-			// position inside moov (not used)
-			for read := int64(0); read < limit; {
-				subHead := make([]byte, 8)
-				if _, err := io.ReadFull(f, subHead); err != nil {
-					return 0, err
-				}
-				subSize := binary.BigEndian.Uint32(subHead[0:4])
-				subAtom := string(subHead[4:8])
-				if subAtom == "mvhd" {
-					version := make([]byte, 1)
-					if _, err := io.ReadFull(f, version); err != nil {
-						return 0, err
-					}
-					var skip int64
-					if version[0] == 1 { // 64-bit
-						skip = 3 + 8 + 8 // flags + creation + mod times (64-bit)
-					} else {
-						skip = 3 + 4 + 4 // flags + times (32-bit)
-					}
-					if _, err := f.Seek(skip, io.SeekCurrent); err != nil {
-						return 0, err
-					}
-					tsBuf := make([]byte, 4)
-					if _, err := io.ReadFull(f, tsBuf); err != nil {
-						return 0, err
-					}
-					timescale := binary.BigEndian.Uint32(tsBuf)
-					durBuf := make([]byte, 4)
-					if _, err := io.ReadFull(f, durBuf); err != nil {
-						return 0, err
-					}
-					durUnits := binary.BigEndian.Uint32(durBuf)
-					if timescale == 0 {
-						return 0, fmt.Errorf("invalid timescale")
-					}
-					secs := float64(durUnits) / float64(timescale)
-					return int(secs + 0.5), nil
-				}
-				// skip remainder of sub atom
-				if subSize < 8 {
-					return 0, fmt.Errorf("invalid sub-atom size")
-				}
-				if _, err := f.Seek(int64(subSize)-8, io.SeekCurrent); err != nil {
-					return 0, err
-				}
-				read += int64(subSize)
+// rawText returns the first non-empty text value stored under one of keys.
+func rawText(metadata tag.Metadata, keys []string) string {
+	raw := metadata.Raw()
+	for _, key := range keys {
+		if v, ok := raw[key].(string); ok {
+			if v = strings.TrimSpace(strings.Trim(v, "\x00")); v != "" {
+				return v
 			}
-			break
 		}
-		// skip rest of atom
-		if _, err := f.Seek(int64(size)-8, io.SeekCurrent); err != nil {
-			return 0, err
-		}
-	}
-	return 0, fmt.Errorf("mvhd atom not found")
-}
-
-// estimateFromFileSize performs a last-resort duration guess using an assumed
-// constant bitrate (bits per second).
-func (e *Extractor) estimateFromFileSize(path string, bitrate int) (int, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-	st, err := f.Stat()
-	if err != nil {
-		return 0, err
-	}
-	if bitrate <= 0 {
-		return 0, fmt.Errorf("invalid bitrate")
 	}
-	dur := (st.Size() * 8) / int64(bitrate)
-	return int(dur), nil
+	return ""
 }
 
 // extractAlbumArt returns a content-hash ID (hex md5) for embedded artwork if
@@ -413,6 +320,12 @@ func (e *Extractor) GetContentType(filePath string) string {
 		return "audio/wav"
 	case ".m4a":
 		return "audio/mp4"
+	case ".ogg", ".oga", ".opus":
+		return "audio/ogg"
+	case ".aiff", ".aif":
+		return "audio/aiff"
+	case ".aac":
+		return "audio/aac"
 	default:
 		return "application/octet-stream"
 	}
@@ -0,0 +1,365 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	gomp3 "github.com/hajimehoshi/go-mp3"
+	"github.com/mewkiz/flac"
+)
+
+// Fingerprinting parameters. Audio is downmixed to mono, resampled to
+// fingerprintSampleRate and split into overlapping frames; each frame yields a
+// 32-bit sub-fingerprint derived from energy differences between 33
+// logarithmically spaced bands (Haitsma/Kalker style).
+const (
+	fingerprintSampleRate = 11025
+	fingerprintFrameSize  = 4096
+	fingerprintHopSize    = 1378 // ~8 sub-fingerprints per second
+	fingerprintBands      = 33
+	fingerprintMinFreq    = 300.0
+	fingerprintMaxFreq    = 3000.0
+	fingerprintMaxSeconds = 180 // only the first minutes are needed to identify a recording
+
+	// FingerprintFramesPerSecond is the approximate number of sub-fingerprints
+	// produced per second of audio.
+	FingerprintFramesPerSecond = float64(fingerprintSampleRate) / float64(fingerprintHopSize)
+)
+
+// ErrFingerprintUnsupported is returned when no pure-Go decoder is available
+// for a file's format.
+var ErrFingerprintUnsupported = errors.New("fingerprinting not supported for this format")
+
+// ComputeFingerprint decodes the start of an audio file to PCM and returns its
+// acoustic fingerprint as a sequence of 32-bit sub-fingerprints. FLAC, WAV and
+// MP3 are supported.
+func ComputeFingerprint(filePath string) ([]uint32, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []float64
+	var rate int
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".flac":
+		samples, rate, err = decodeFLACMono(f)
+	case ".wav":
+		samples, rate, err = decodeWAVMono(f)
+	case ".mp3":
+		samples, rate, err = decodeMP3Mono(f)
+	default:
+		return nil, ErrFingerprintUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate")
+	}
+
+	pcm := resampleMono(samples, rate, fingerprintSampleRate)
+	if len(pcm) < fingerprintFrameSize*2 {
+		return nil, fmt.Errorf("audio too short to fingerprint")
+	}
+	return fingerprintPCM(pcm), nil
+}
+
+// decodeFLACMono decodes FLAC frames into normalized mono samples.
+func decodeFLACMono(r io.Reader) ([]float64, int, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer stream.Close()
+
+	rate := int(stream.Info.SampleRate)
+	limit := rate * fingerprintMaxSeconds
+	scale := math.Ldexp(1, int(stream.Info.BitsPerSample)-1)
+	out := make([]float64, 0, limit)
+	for len(out) < limit {
+		fr, err := stream.ParseNext()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if len(out) == 0 {
+				return nil, 0, err
+			}
+			break
+		}
+		nch := len(fr.Subframes)
+		for i := 0; i < int(fr.BlockSize); i++ {
+			var sum float64
+			for ch := 0; ch < nch; ch++ {
+				sum += float64(fr.Subframes[ch].Samples[i])
+			}
+			out = append(out, sum/float64(nch)/scale)
+		}
+	}
+	return out, rate, nil
+}
+
+// decodeWAVMono decodes WAV PCM into normalized mono samples.
+func decodeWAVMono(r io.ReadSeeker) ([]float64, int, error) {
+	dec := wav.NewDecoder(r)
+	if !dec.IsValidFile() {
+		return nil, 0, fmt.Errorf("invalid wav file")
+	}
+	rate := int(dec.SampleRate)
+	nch := int(dec.NumChans)
+	if rate == 0 || nch == 0 || dec.BitDepth == 0 {
+		return nil, 0, fmt.Errorf("invalid wav header")
+	}
+	scale := math.Ldexp(1, int(dec.BitDepth)-1)
+	limit := rate * fingerprintMaxSeconds
+	buf := &audio.IntBuffer{
+		Format: &audio.Format{NumChannels: nch, SampleRate: rate},
+		Data:   make([]int, 4096*nch),
+	}
+	out := make([]float64, 0, limit)
+	for len(out) < limit {
+		n, err := dec.PCMBuffer(buf)
+		if n == 0 || err != nil {
+			break
+		}
+		for i := 0; i+nch <= n; i += nch {
+			var sum float64
+			for ch := 0; ch < nch; ch++ {
+				sum += float64(buf.Data[i+ch])
+			}
+			out = append(out, sum/float64(nch)/scale)
+		}
+	}
+	if len(out) == 0 {
+		return nil, 0, fmt.Errorf("no pcm data in wav file")
+	}
+	return out, rate, nil
+}
+
+// decodeMP3Mono decodes MP3 audio (always 16-bit stereo from go-mp3) into
+// normalized mono samples.
+func decodeMP3Mono(r io.Reader) ([]float64, int, error) {
+	dec, err := gomp3.NewDecoder(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	rate := dec.SampleRate()
+	limit := rate * fingerprintMaxSeconds
+	out := make([]float64, 0, limit)
+	buf := make([]byte, 16384)
+	var carry []byte
+	for len(out) < limit {
+		n, err := dec.Read(buf)
+		data := append(carry, buf[:n]...)
+		i := 0
+		for ; i+4 <= len(data); i += 4 {
+			left := int16(binary.LittleEndian.Uint16(data[i:]))
+			right := int16(binary.LittleEndian.Uint16(data[i+2:]))
+			out = append(out, (float64(left)+float64(right))/65536.0)
+		}
+		carry = append(carry[:0], data[i:]...)
+		if err != nil {
+			if errors.Is(err, io.EOF) || len(out) > 0 {
+				break
+			}
+			return nil, 0, err
+		}
+	}
+	return out, rate, nil
+}
+
+// resampleMono converts samples to the target rate using a box low-pass filter
+// followed by linear interpolation; adequate for fingerprinting purposes.
+func resampleMono(in []float64, from, to int) []float64 {
+	if from == to {
+		return in
+	}
+	ratio := float64(from) / float64(to)
+	src := in
+	if ratio > 1 {
+		// Average over a window of roughly one output sample to limit aliasing.
+		win := int(math.Ceil(ratio))
+		src = make([]float64, len(in))
+		var acc float64
+		for i, v := range in {
+			acc += v
+			if i >= win {
+				acc -= in[i-win]
+			}
+			n := win
+			if i+1 < win {
+				n = i + 1
+			}
+			src[i] = acc / float64(n)
+		}
+	}
+	outLen := int(float64(len(src)) / ratio)
+	out := make([]float64, outLen)
+	for i := range out {
+		pos := float64(i) * ratio
+		j := int(pos)
+		frac := pos - float64(j)
+		if j+1 < len(src) {
+			out[i] = src[j]*(1-frac) + src[j+1]*frac
+		} else {
+			out[i] = src[len(src)-1]
+		}
+	}
+	return out
+}
+
+// fingerprintPCM computes sub-fingerprints from mono PCM at
+// fingerprintSampleRate.
+func fingerprintPCM(pcm []float64) []uint32 {
+	window := make([]float64, fingerprintFrameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fingerprintFrameSize-1))
+	}
+
+	// Precompute FFT bin edges for logarithmically spaced bands.
+	edges := make([]int, fingerprintBands+1)
+	binHz := float64(fingerprintSampleRate) / float64(fingerprintFrameSize)
+	for b := 0; b <= fingerprintBands; b++ {
+		freq := fingerprintMinFreq * math.Pow(fingerprintMaxFreq/fingerprintMinFreq, float64(b)/fingerprintBands)
+		edges[b] = int(freq / binHz)
+	}
+
+	buf := make([]complex128, fingerprintFrameSize)
+	prev := make([]float64, fingerprintBands)
+	cur := make([]float64, fingerprintBands)
+	var out []uint32
+	for start, first := 0, true; start+fingerprintFrameSize <= len(pcm); start += fingerprintHopSize {
+		for i := 0; i < fingerprintFrameSize; i++ {
+			buf[i] = complex(pcm[start+i]*window[i], 0)
+		}
+		fft(buf)
+		for b := 0; b < fingerprintBands; b++ {
+			var e float64
+			for k := edges[b]; k < edges[b+1] || k == edges[b]; k++ {
+				m := cmplx.Abs(buf[k])
+				e += m * m
+			}
+			cur[b] = e
+		}
+		if !first {
+			var word uint32
+			for b := 0; b < fingerprintBands-1; b++ {
+				d := (cur[b] - cur[b+1]) - (prev[b] - prev[b+1])
+				if d > 0 {
+					word |= 1 << uint(b)
+				}
+			}
+			out = append(out, word)
+		}
+		first = false
+		prev, cur = cur, prev
+	}
+	return out
+}
+
+// fft performs an in-place iterative radix-2 Cooley-Tukey transform. len(a)
+// must be a power of two.
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := a[start+k]
+				v := a[start+k+size/2] * w
+				a[start+k] = u + v
+				a[start+k+size/2] = u - v
+				w *= step
+			}
+		}
+	}
+}
+
+// CompareFingerprints returns a similarity score in [0,1] between two
+// fingerprints: one minus the lowest bit error rate found while sliding one
+// against the other by up to maxShift sub-fingerprints. Unrelated audio
+// scores around 0.5; the same recording typically scores above 0.8.
+func CompareFingerprints(a, b []uint32, maxShift int) float64 {
+	const minOverlap = 64
+	best := 0.0
+	for shift := -maxShift; shift <= maxShift; shift++ {
+		ai, bi := 0, 0
+		if shift > 0 {
+			ai = shift
+		} else {
+			bi = -shift
+		}
+		n := len(a) - ai
+		if len(b)-bi < n {
+			n = len(b) - bi
+		}
+		if n < minOverlap {
+			continue
+		}
+		errBits := 0
+		for i := 0; i < n; i++ {
+			errBits += bits.OnesCount32(a[ai+i] ^ b[bi+i])
+		}
+		score := 1 - float64(errBits)/float64(n*(fingerprintBands-1))
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// EncodeFingerprint serializes a fingerprint for storage.
+func EncodeFingerprint(fp []uint32) []byte {
+	out := make([]byte, len(fp)*4)
+	for i, v := range fp {
+		binary.LittleEndian.PutUint32(out[i*4:], v)
+	}
+	return out
+}
+
+// DecodeFingerprint reverses EncodeFingerprint.
+func DecodeFingerprint(data []byte) []uint32 {
+	out := make([]uint32, len(data)/4)
+	for i := range out {
+		out[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	return out
+}
+
+// IsLosslessFormat reports whether a file holds a lossless codec. Containers
+// that may hold either kind (.m4a with ALAC or AAC, Ogg with FLAC or Vorbis)
+// are inspected.
+func IsLosslessFormat(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".flac", ".wav", ".aiff", ".aif":
+		return true
+	case ".m4a", ".ogg", ".oga":
+		info, err := ReadAudioInfo(filePath)
+		return err == nil && info.Lossless()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,227 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	flacBlockStreamInfo    = 0
+	flacBlockPadding       = 1
+	flacBlockVorbisComment = 4
+	flacBlockPicture       = 6
+
+	flacPadding      = 1024
+	flacMaxBlockSize = 1<<24 - 1
+)
+
+// flacBlock is a raw FLAC metadata block (without its 4-byte header).
+type flacBlock struct {
+	kind byte
+	data []byte
+}
+
+// rewriteFLACTags rebuilds the metadata blocks of a FLAC stream with an
+// updated VORBIS_COMMENT (and front cover PICTURE) block, then copies the
+// audio frames unchanged.
+func rewriteFLACTags(src *os.File, size int64, dst io.Writer, update TagUpdate) error {
+	offset := int64(0)
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return fmt.Errorf("reading FLAC header: %w", err)
+	}
+	// Some taggers prepend an ID3v2 tag; it is dropped on rewrite.
+	if string(header[:3]) == "ID3" {
+		offset = 10 + int64(syncsafe(header[6:10]))
+	}
+
+	r := io.NewSectionReader(src, offset, size-offset)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "fLaC" {
+		return fmt.Errorf("not a FLAC stream")
+	}
+
+	var blocks []flacBlock
+	for {
+		bh := make([]byte, 4)
+		if _, err := io.ReadFull(r, bh); err != nil {
+			return fmt.Errorf("reading FLAC metadata: %w", err)
+		}
+		last := bh[0]&0x80 != 0
+		length := int(bh[1])<<16 | int(bh[2])<<8 | int(bh[3])
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("reading FLAC metadata: %w", err)
+		}
+		blocks = append(blocks, flacBlock{kind: bh[0] & 0x7F, data: data})
+		if last {
+			break
+		}
+	}
+	if len(blocks) == 0 || blocks[0].kind != flacBlockStreamInfo {
+		return fmt.Errorf("FLAC stream is missing STREAMINFO")
+	}
+	audioStart, _ := r.Seek(0, io.SeekCurrent)
+
+	var comments *flacBlock
+	kept := make([]flacBlock, 0, len(blocks)+2)
+	for i := range blocks {
+		b := blocks[i]
+		switch {
+		case b.kind == flacBlockPadding:
+			continue
+		case b.kind == flacBlockVorbisComment:
+			if comments == nil {
+				comments = &b
+			}
+			continue
+		case b.kind == flacBlockPicture && update.Picture != nil && flacPictureType(b.data) == 3:
+			continue
+		}
+		kept = append(kept, b)
+	}
+
+	vendor, fields := parseVorbisComment(comments)
+	fields = applyVorbisUpdate(fields, update)
+	// STREAMINFO must stay first; comments follow it.
+	kept = append(kept[:1], append([]flacBlock{{kind: flacBlockVorbisComment, data: buildVorbisComment(vendor, fields)}}, kept[1:]...)...)
+	if update.Picture != nil {
+		kept = append(kept, flacBlock{kind: flacBlockPicture, data: buildFLACPicture(update.Picture)})
+	}
+	kept = append(kept, flacBlock{kind: flacBlockPadding, data: make([]byte, flacPadding)})
+
+	var out bytes.Buffer
+	out.WriteString("fLaC")
+	for i, b := range kept {
+		if len(b.data) > flacMaxBlockSize {
+			return fmt.Errorf("FLAC metadata block too large")
+		}
+		kind := b.kind
+		if i == len(kept)-1 {
+			kind |= 0x80
+		}
+		out.Write([]byte{kind, byte(len(b.data) >> 16), byte(len(b.data) >> 8), byte(len(b.data))})
+		out.Write(b.data)
+	}
+	if _, err := dst.Write(out.Bytes()); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(dst, io.NewSectionReader(src, offset+audioStart, size-offset-audioStart))
+	return err
+}
+
+// parseVorbisComment returns the vendor string and "KEY=value" fields of a
+// VORBIS_COMMENT block (little-endian lengths).
+func parseVorbisComment(b *flacBlock) (string, []string) {
+	vendor := "staccato"
+	if b == nil {
+		return vendor, nil
+	}
+	data := b.data
+	readString := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return "", false
+		}
+		s := string(data[4 : 4+n])
+		data = data[4+n:]
+		return s, true
+	}
+
+	v, ok := readString()
+	if !ok || len(data) < 4 {
+		return vendor, nil
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	var fields []string
+	for i := uint32(0); i < count; i++ {
+		field, ok := readString()
+		if !ok {
+			break
+		}
+		fields = append(fields, field)
+	}
+	return v, fields
+}
+
+// applyVorbisUpdate replaces the fields affected by the update. Field names
+// are matched case-insensitively.
+func applyVorbisUpdate(fields []string, update TagUpdate) []string {
+	set := func(key string, value *string) {
+		if value == nil {
+			return
+		}
+		out := fields[:0]
+		for _, f := range fields {
+			name, _, _ := strings.Cut(f, "=")
+			if !strings.EqualFold(name, key) {
+				out = append(out, f)
+			}
+		}
+		fields = out
+		if *value != "" {
+			fields = append(fields, key+"="+*value)
+		}
+	}
+	set("TITLE", update.Title)
+	set("ARTIST", update.Artist)
+	set("ALBUM", update.Album)
+	if update.TrackNumber != nil {
+		value := ""
+		if *update.TrackNumber > 0 {
+			value = strconv.Itoa(*update.TrackNumber)
+		}
+		set("TRACKNUMBER", &value)
+	}
+	return fields
+}
+
+// buildVorbisComment serialises a VORBIS_COMMENT block body.
+func buildVorbisComment(vendor string, fields []string) []byte {
+	var buf bytes.Buffer
+	writeString := func(s string) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	writeString(vendor)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(fields)))
+	for _, f := range fields {
+		writeString(f)
+	}
+	return buf.Bytes()
+}
+
+// buildFLACPicture serialises a front cover PICTURE block body.
+func buildFLACPicture(picture []byte) []byte {
+	mime := pictureMimeType(picture)
+	width, height, depth := pictureDimensions(picture)
+
+	var buf bytes.Buffer
+	for _, v := range []uint32{3, uint32(len(mime))} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	buf.WriteString(mime)
+	for _, v := range []uint32{0, uint32(width), uint32(height), uint32(depth), 0, uint32(len(picture))} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	buf.Write(picture)
+	return buf.Bytes()
+}
+
+// flacPictureType returns the picture type of a PICTURE block body.
+func flacPictureType(data []byte) uint32 {
+	if len(data) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data)
+}
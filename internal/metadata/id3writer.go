@@ -0,0 +1,275 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const id3Padding = 1024
+
+// id3Frame is a raw ID3v2.3/2.4 frame kept verbatim unless replaced.
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// id3v22FrameMap converts the text frames of legacy v2.2 tags.
+var id3v22FrameMap = map[string]string{
+	"TT2": "TIT2", "TP1": "TPE1", "TP2": "TPE2", "TAL": "TALB", "TRK": "TRCK",
+	"TPA": "TPOS", "TYE": "TYER", "TCO": "TCON", "TCM": "TCOM",
+}
+
+// rewriteID3v2 writes a new ID3v2 tag (keeping unrelated frames of any
+// existing tag) followed by the original audio data.
+func rewriteID3v2(src *os.File, size int64, dst io.Writer, update TagUpdate) error {
+	version := byte(4)
+	var frames []id3Frame
+	audioStart := int64(0)
+
+	header := make([]byte, 10)
+	if n, _ := io.ReadFull(src, header); n == 10 && string(header[:3]) == "ID3" {
+		major := header[3]
+		flags := header[5]
+		tagSize := int64(syncsafe(header[6:10]))
+		audioStart = 10 + tagSize
+		if flags&0x10 != 0 { // footer present
+			audioStart += 10
+		}
+		if audioStart > size {
+			return fmt.Errorf("corrupt ID3v2 tag size")
+		}
+
+		body := make([]byte, tagSize)
+		if _, err := io.ReadFull(src, body); err != nil {
+			return fmt.Errorf("reading ID3v2 tag: %w", err)
+		}
+		if flags&0x80 != 0 && major < 4 { // whole-tag unsynchronisation
+			body = bytes.ReplaceAll(body, []byte{0xFF, 0x00}, []byte{0xFF})
+		}
+		if flags&0x40 != 0 && len(body) >= 4 { // extended header
+			var extSize int
+			if major == 4 {
+				extSize = int(syncsafe(body[:4]))
+			} else {
+				extSize = int(binary.BigEndian.Uint32(body[:4])) + 4
+			}
+			if extSize > len(body) {
+				return fmt.Errorf("corrupt ID3v2 extended header")
+			}
+			body = body[extSize:]
+		}
+
+		switch major {
+		case 2:
+			frames = parseID3v22Frames(body)
+		case 3, 4:
+			version = major
+			frames = parseID3Frames(body, major)
+		default:
+			return fmt.Errorf("unsupported ID3v2 version 2.%d", major)
+		}
+	}
+
+	frames = applyID3Update(frames, update, version)
+
+	var tag bytes.Buffer
+	for _, f := range frames {
+		fh := make([]byte, 10)
+		copy(fh, f.id)
+		if version == 4 {
+			putSyncsafe(fh[4:8], uint32(len(f.data)))
+		} else {
+			binary.BigEndian.PutUint32(fh[4:8], uint32(len(f.data)))
+		}
+		copy(fh[8:], f.flags[:])
+		tag.Write(fh)
+		tag.Write(f.data)
+	}
+	tag.Write(make([]byte, id3Padding))
+
+	head := []byte{'I', 'D', '3', version, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(head[6:10], uint32(tag.Len()))
+	if _, err := dst.Write(head); err != nil {
+		return err
+	}
+	if _, err := dst.Write(tag.Bytes()); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(dst, io.NewSectionReader(src, audioStart, size-audioStart))
+	return err
+}
+
+// parseID3Frames splits a v2.3/v2.4 tag body into frames, stopping at padding.
+func parseID3Frames(body []byte, major byte) []id3Frame {
+	var frames []id3Frame
+	for len(body) >= 10 && body[0] != 0 {
+		var frameSize int
+		if major == 4 {
+			frameSize = int(syncsafe(body[4:8]))
+		} else {
+			frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+		}
+		if frameSize < 0 || 10+frameSize > len(body) {
+			break
+		}
+		f := id3Frame{id: string(body[:4]), data: append([]byte(nil), body[10:10+frameSize]...)}
+		copy(f.flags[:], body[8:10])
+		frames = append(frames, f)
+		body = body[10+frameSize:]
+	}
+	return frames
+}
+
+// parseID3v22Frames converts the text frames of a v2.2 tag; other frames
+// (including v2.2 pictures) are dropped.
+func parseID3v22Frames(body []byte) []id3Frame {
+	var frames []id3Frame
+	for len(body) >= 6 && body[0] != 0 {
+		frameSize := int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		if 6+frameSize > len(body) {
+			break
+		}
+		if id, ok := id3v22FrameMap[string(body[:3])]; ok {
+			frames = append(frames, id3Frame{id: id, data: append([]byte(nil), body[6:6+frameSize]...)})
+		}
+		body = body[6+frameSize:]
+	}
+	return frames
+}
+
+// applyID3Update replaces (or removes) the frames affected by the update.
+func applyID3Update(frames []id3Frame, update TagUpdate, version byte) []id3Frame {
+	setText := func(id string, value *string) {
+		if value == nil {
+			return
+		}
+		frames = removeID3Frames(frames, id)
+		if *value != "" {
+			frames = append(frames, id3Frame{id: id, data: encodeID3Text(*value, version)})
+		}
+	}
+	setText("TIT2", update.Title)
+	setText("TPE1", update.Artist)
+	setText("TALB", update.Album)
+
+	if update.TrackNumber != nil {
+		total := ""
+		for _, f := range frames {
+			if f.id == "TRCK" {
+				if _, rest, ok := strings.Cut(decodeID3Text(f.data), "/"); ok {
+					total = "/" + rest
+				}
+			}
+		}
+		value := ""
+		if *update.TrackNumber > 0 {
+			value = strconv.Itoa(*update.TrackNumber) + total
+		}
+		setText("TRCK", &value)
+	}
+
+	if update.Picture != nil {
+		frames = removeID3Frames(frames, "APIC")
+		var data bytes.Buffer
+		data.WriteByte(0) // ISO-8859-1 description
+		data.WriteString(pictureMimeType(update.Picture))
+		data.WriteByte(0)
+		data.WriteByte(3) // front cover
+		data.WriteByte(0) // empty description
+		data.Write(update.Picture)
+		frames = append(frames, id3Frame{id: "APIC", data: data.Bytes()})
+	}
+	return frames
+}
+
+// removeID3Frames drops all frames with the given ID.
+func removeID3Frames(frames []id3Frame, id string) []id3Frame {
+	out := frames[:0]
+	for _, f := range frames {
+		if f.id != id {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// encodeID3Text encodes a text frame payload: UTF-8 for v2.4, Latin-1 or
+// UTF-16 with BOM for v2.3.
+func encodeID3Text(value string, version byte) []byte {
+	if version == 4 {
+		return append([]byte{3}, value...)
+	}
+	latin1 := true
+	for _, r := range value {
+		if r > 0xFF {
+			latin1 = false
+			break
+		}
+	}
+	if latin1 {
+		out := []byte{0}
+		for _, r := range value {
+			out = append(out, byte(r))
+		}
+		return out
+	}
+	out := []byte{1, 0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(value)) {
+		out = append(out, byte(u), byte(u>>8))
+	}
+	return out
+}
+
+// decodeID3Text decodes a text frame payload in any of the four encodings.
+func decodeID3Text(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	enc, payload := data[0], data[1:]
+	switch enc {
+	case 1, 2:
+		littleEndian := enc == 1
+		if len(payload) >= 2 && enc == 1 {
+			littleEndian = payload[0] == 0xFF && payload[1] == 0xFE
+			payload = payload[2:]
+		}
+		units := make([]uint16, 0, len(payload)/2)
+		for i := 0; i+1 < len(payload); i += 2 {
+			if littleEndian {
+				units = append(units, uint16(payload[i])|uint16(payload[i+1])<<8)
+			} else {
+				units = append(units, uint16(payload[i])<<8|uint16(payload[i+1]))
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case 3:
+		return strings.TrimRight(string(payload), "\x00")
+	default:
+		runes := make([]rune, len(payload))
+		for i, b := range payload {
+			runes[i] = rune(b)
+		}
+		return strings.TrimRight(string(runes), "\x00")
+	}
+}
+
+// syncsafe decodes a 4-byte syncsafe integer.
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// putSyncsafe encodes v as a 4-byte syncsafe integer.
+func putSyncsafe(b []byte, v uint32) {
+	b[0] = byte(v>>21) & 0x7F
+	b[1] = byte(v>>14) & 0x7F
+	b[2] = byte(v>>7) & 0x7F
+	b[3] = byte(v) & 0x7F
+}
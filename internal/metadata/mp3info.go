@@ -0,0 +1,395 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CodecMP3 is reported for MPEG audio layer III (and I/II) files.
+const CodecMP3 = "mp3"
+
+var (
+	mp3Bitrates = [2][3][15]int{
+		{ // MPEG-1: layer I, II, III
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{ // MPEG-2 and 2.5
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	mp3SampleRates = map[int][3]int{
+		mpeg1:  {44100, 48000, 32000},
+		mpeg2:  {22050, 24000, 16000},
+		mpeg25: {11025, 12000, 8000},
+	}
+)
+
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+
+	mp3HeaderSize = 4
+	// Headerless files whose sampled frames share one bitrate are treated as
+	// CBR; this many consecutive frames are compared at each sample point.
+	mp3CBRSampleFrames = 8
+)
+
+// mp3Header is a decoded MPEG audio frame header.
+type mp3Header struct {
+	version    int // mpeg1, mpeg2 or mpeg25
+	layer      int // 1, 2 or 3
+	bitrate    int // bits per second
+	sampleRate int
+	padding    bool
+	mono       bool
+}
+
+// parseMP3Header decodes a 4-byte frame header, reporting false for anything
+// that is not a valid (non free-format) header.
+func parseMP3Header(b []byte) (mp3Header, bool) {
+	var h mp3Header
+	if len(b) < mp3HeaderSize || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return h, false
+	}
+	h.version = int(b[1] >> 3 & 0x03)
+	layerBits := int(b[1] >> 1 & 0x03)
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int(b[2] >> 2 & 0x03)
+	if h.version == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return h, false
+	}
+	h.layer = 4 - layerBits
+	table := 0
+	if h.version != mpeg1 {
+		table = 1
+	}
+	h.bitrate = mp3Bitrates[table][h.layer-1][bitrateIndex] * 1000
+	h.sampleRate = mp3SampleRates[h.version][rateIndex]
+	h.padding = b[2]&0x02 != 0
+	h.mono = b[3]>>6 == 3
+	return h, true
+}
+
+// samplesPerFrame returns the number of PCM samples one frame decodes to.
+func (h mp3Header) samplesPerFrame() int {
+	switch {
+	case h.layer == 1:
+		return 384
+	case h.layer == 3 && h.version != mpeg1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// frameSize returns the frame length in bytes including the header.
+func (h mp3Header) frameSize() int {
+	pad := 0
+	if h.padding {
+		pad = 1
+	}
+	if h.layer == 1 {
+		return (12*h.bitrate/h.sampleRate + pad) * 4
+	}
+	return h.samplesPerFrame()/8*h.bitrate/h.sampleRate + pad
+}
+
+// sideInfoSize is the length of the layer III side information that precedes
+// a Xing header in the first frame.
+func (h mp3Header) sideInfoSize() int {
+	switch {
+	case h.version == mpeg1 && h.mono:
+		return 17
+	case h.version == mpeg1:
+		return 32
+	case h.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// readMP3Info reads the duration of an MP3 file. A Xing/Info or VBRI header in
+// the first frame gives the frame count directly (with LAME encoder delay and
+// padding removed); headerless files are treated as CBR when sampled frames
+// share one bitrate and scanned frame by frame otherwise. ID3v2 and APE tags
+// at the start and ID3v1/APE tags at the end are excluded from the audio.
+func readMP3Info(f *Source, size int64) (AudioInfo, error) {
+	info := AudioInfo{Codec: CodecMP3}
+	start, err := mp3AudioStart(f)
+	if err != nil {
+		return info, err
+	}
+	end := mp3AudioEnd(f, size)
+
+	first, offset, err := findMP3Frame(f, start, end)
+	if err != nil {
+		return info, err
+	}
+	info.SampleRate = first.sampleRate
+	info.Channels = 2
+	if first.mono {
+		info.Channels = 1
+	}
+	spf := int64(first.samplesPerFrame())
+
+	frame := make([]byte, first.frameSize())
+	n, _ := f.ReadAt(frame, offset)
+	frame = frame[:n]
+	if samples, ok := xingSamples(first, frame); ok {
+		info.Duration = samplesDuration(samples, first.sampleRate)
+		return info, nil
+	}
+	if frames, ok := vbriFrames(frame); ok {
+		info.Duration = samplesDuration(frames*spf, first.sampleRate)
+		return info, nil
+	}
+
+	if isMP3CBR(f, first, offset, end) {
+		audioBytes := end - offset
+		info.Duration = time.Duration(float64(audioBytes*8) / float64(first.bitrate) * float64(time.Second))
+		return info, nil
+	}
+	samples, err := scanMP3Frames(f, offset, end)
+	if err != nil {
+		return info, err
+	}
+	info.Duration = samplesDuration(samples, first.sampleRate)
+	return info, nil
+}
+
+func samplesDuration(samples int64, rate int) time.Duration {
+	if samples < 0 {
+		samples = 0
+	}
+	return time.Duration(samples) * time.Second / time.Duration(rate)
+}
+
+// mp3AudioStart returns the offset after any leading ID3v2 and APE tags.
+func mp3AudioStart(f *Source) (int64, error) {
+	var offset int64
+	header := make([]byte, 32)
+	for {
+		n, err := f.ReadAt(header, offset)
+		if n < 10 {
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			return offset, nil
+		}
+		switch {
+		case string(header[0:3]) == "ID3":
+			size := int64(syncsafe(header[6:10])) + 10
+			if header[5]&0x10 != 0 {
+				size += 10 // footer
+			}
+			offset += size
+		case n == 32 && string(header[0:8]) == "APETAGEX":
+			// The size excludes the 32-byte header
+			offset += 32 + int64(binary.LittleEndian.Uint32(header[12:16]))
+		default:
+			return offset, nil
+		}
+	}
+}
+
+// mp3AudioEnd returns the offset where trailing ID3v1, Lyrics3 or APE tags
+// begin (the file size if there are none).
+func mp3AudioEnd(f *Source, size int64) int64 {
+	end := size
+	buf := make([]byte, 32)
+	for end > 0 {
+		switch {
+		case end >= 128 && readAtEquals(f, end-128, "TAG"):
+			end -= 128
+		case end >= 32 && readAtEquals(f, end-32, "APETAGEX"):
+			if _, err := f.ReadAt(buf, end-32); err != nil {
+				return end
+			}
+			tagSize := int64(binary.LittleEndian.Uint32(buf[12:16])) // includes the footer
+			if tagSize < 32 {
+				return end // corrupt: smaller than the footer alone
+			}
+			if binary.LittleEndian.Uint32(buf[20:24])&(1<<31) != 0 {
+				tagSize += 32 // header present
+			}
+			if tagSize > end {
+				return end
+			}
+			end -= tagSize
+		case end >= 15 && readAtEquals(f, end-9, "LYRICS200"):
+			if _, err := f.ReadAt(buf[:6], end-15); err != nil {
+				return end
+			}
+			var lyricsSize int64
+			if _, err := fmt.Sscanf(string(buf[:6]), "%06d", &lyricsSize); err != nil || lyricsSize+15 > end {
+				return end
+			}
+			end -= lyricsSize + 15
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+func readAtEquals(f *Source, offset int64, want string) bool {
+	buf := make([]byte, len(want))
+	_, err := f.ReadAt(buf, offset)
+	return err == nil && string(buf) == want
+}
+
+// findMP3Frame returns the first frame header at or after start that is
+// followed by another valid header (or the end of the audio), skipping junk.
+func findMP3Frame(f *Source, start, end int64) (mp3Header, int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, start, end-start))
+	offset := start
+	for {
+		b, err := r.Peek(mp3HeaderSize)
+		if err != nil {
+			return mp3Header{}, 0, fmt.Errorf("no mp3 frames found")
+		}
+		if h, ok := parseMP3Header(b); ok {
+			next := offset + int64(h.frameSize())
+			if next >= end {
+				return h, offset, nil
+			}
+			nb := make([]byte, mp3HeaderSize)
+			if _, err := f.ReadAt(nb, next); err == nil {
+				if nh, ok := parseMP3Header(nb); ok && nh.version == h.version && nh.layer == h.layer && nh.sampleRate == h.sampleRate {
+					return h, offset, nil
+				}
+			}
+		}
+		r.Discard(1)
+		offset++
+	}
+}
+
+// xingSamples reads a Xing or Info header from the first frame and returns
+// the stream's sample count, less any LAME encoder delay and padding.
+func xingSamples(h mp3Header, frame []byte) (int64, bool) {
+	pos := mp3HeaderSize + h.sideInfoSize()
+	if len(frame) < pos+12 {
+		return 0, false
+	}
+	tag := string(frame[pos : pos+4])
+	if tag != "Xing" && tag != "Info" {
+		return 0, false
+	}
+	flags := binary.BigEndian.Uint32(frame[pos+4 : pos+8])
+	if flags&0x01 == 0 {
+		return 0, false // no frame count
+	}
+	frames := int64(binary.BigEndian.Uint32(frame[pos+8 : pos+12]))
+	samples := frames * int64(h.samplesPerFrame())
+
+	lame := pos + 12
+	if flags&0x02 != 0 {
+		lame += 4 // byte count
+	}
+	if flags&0x04 != 0 {
+		lame += 100 // TOC
+	}
+	if flags&0x08 != 0 {
+		lame += 4 // quality
+	}
+	if len(frame) >= lame+24 {
+		encoder := string(frame[lame : lame+4])
+		if encoder == "LAME" || encoder == "Lavf" || encoder == "Lavc" {
+			d := frame[lame+21 : lame+24]
+			delay := int64(d[0])<<4 | int64(d[1]>>4)
+			padding := int64(d[1]&0x0F)<<8 | int64(d[2])
+			if delay+padding < samples {
+				samples -= delay + padding
+			}
+		}
+	}
+	return samples, true
+}
+
+// vbriFrames reads the frame count of a Fraunhofer VBRI header, which sits 32
+// bytes after the first frame's header.
+func vbriFrames(frame []byte) (int64, bool) {
+	pos := mp3HeaderSize + 32
+	if len(frame) < pos+18 || string(frame[pos:pos+4]) != "VBRI" {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint32(frame[pos+14 : pos+18])), true
+}
+
+// isMP3CBR compares the bitrate of a run of frames at the start and in the
+// middle of the audio with the first frame's.
+func isMP3CBR(f *Source, first mp3Header, start, end int64) bool {
+	for _, offset := range []int64{start, start + (end-start)/2} {
+		h, at, err := findMP3Frame(f, offset, end)
+		if err != nil {
+			continue
+		}
+		for i := 0; i < mp3CBRSampleFrames; i++ {
+			if h.bitrate != first.bitrate {
+				return false
+			}
+			at += int64(h.frameSize())
+			b := make([]byte, mp3HeaderSize)
+			if at >= end {
+				break
+			}
+			if _, err := f.ReadAt(b, at); err != nil {
+				break
+			}
+			var ok bool
+			if h, ok = parseMP3Header(b); !ok {
+				break
+			}
+		}
+	}
+	return true
+}
+
+// scanMP3Frames walks every frame header between start and end and returns
+// the total sample count, resynchronising after corrupt data.
+func scanMP3Frames(f *Source, start, end int64) (int64, error) {
+	r := bufio.NewReaderSize(io.NewSectionReader(f, start, end-start), 64<<10)
+	var samples int64
+	frames := 0
+	for {
+		b, err := r.Peek(mp3HeaderSize)
+		if err != nil {
+			break
+		}
+		h, ok := parseMP3Header(b)
+		if !ok {
+			// Resync: skip to the next possible sync byte
+			if _, err := r.Discard(1); err != nil {
+				break
+			}
+			if buf, _ := r.Peek(r.Buffered()); len(buf) > 0 {
+				if i := bytes.IndexByte(buf, 0xFF); i > 0 {
+					r.Discard(i)
+				} else if i < 0 {
+					r.Discard(len(buf))
+				}
+			}
+			continue
+		}
+		samples += int64(h.samplesPerFrame())
+		frames++
+		if _, err := r.Discard(h.frameSize()); err != nil {
+			break
+		}
+	}
+	if frames == 0 {
+		return 0, fmt.Errorf("no mp3 frames found")
+	}
+	return samples, nil
+}
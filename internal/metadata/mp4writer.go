@@ -0,0 +1,362 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// mp4Atom is an atom header plus its payload location. For top-level atoms
+// only the offsets are known; nested atoms reference slices of their parent.
+type mp4Atom struct {
+	kind       string
+	offset     int64 // start of header
+	headerSize int64
+	size       int64 // including header
+	payload    []byte
+}
+
+// iTunes data atom type indicators.
+const (
+	mp4DataImplicit = 0
+	mp4DataUTF8     = 1
+	mp4DataJPEG     = 13
+	mp4DataPNG      = 14
+)
+
+// rewriteMP4Tags rebuilds the moov/udta/meta/ilst atoms of an MP4 file with
+// updated iTunes items. When moov grows or shrinks ahead of media data the
+// stco/co64 chunk offsets are shifted accordingly.
+func rewriteMP4Tags(src *os.File, size int64, dst io.Writer, update TagUpdate) error {
+	top, err := readTopLevelAtoms(src, size)
+	if err != nil {
+		return err
+	}
+
+	moovIdx := -1
+	for i, a := range top {
+		if a.kind == "moov" {
+			moovIdx = i
+			break
+		}
+	}
+	if moovIdx < 0 {
+		return fmt.Errorf("MP4 file has no moov atom")
+	}
+	moov := top[moovIdx]
+	if moov.size > 64<<20 {
+		return fmt.Errorf("MP4 moov atom too large")
+	}
+	moovPayload := make([]byte, moov.size-moov.headerSize)
+	if _, err := src.ReadAt(moovPayload, moov.offset+moov.headerSize); err != nil {
+		return fmt.Errorf("reading moov atom: %w", err)
+	}
+
+	children, err := parseAtoms(moovPayload)
+	if err != nil {
+		return fmt.Errorf("parsing moov atom: %w", err)
+	}
+	var udta []byte
+	var rest bytes.Buffer
+	for _, c := range children {
+		if c.kind == "udta" && udta == nil {
+			udta = c.payload
+			continue
+		}
+		rest.Write(encodeAtom(c.kind, c.payload))
+	}
+	newUdta, err := rebuildUdta(udta, update)
+	if err != nil {
+		return err
+	}
+	rest.Write(encodeAtom("udta", newUdta))
+	newMoov := encodeAtom("moov", rest.Bytes())
+
+	delta := int64(len(newMoov)) - moov.size
+	moovEnd := moov.offset + moov.size
+	if err := shiftChunkOffsets(newMoov[8:], moovEnd, delta); err != nil {
+		return err
+	}
+
+	for i, a := range top {
+		if i == moovIdx {
+			if _, err := dst.Write(newMoov); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := io.Copy(dst, io.NewSectionReader(src, a.offset, a.size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTopLevelAtoms lists the top-level atoms of the file without reading
+// their payloads.
+func readTopLevelAtoms(src io.ReaderAt, size int64) ([]mp4Atom, error) {
+	var atoms []mp4Atom
+	header := make([]byte, 16)
+	for offset := int64(0); offset < size; {
+		if size-offset < 8 {
+			break
+		}
+		if _, err := src.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("reading MP4 atom header: %w", err)
+		}
+		a := mp4Atom{kind: string(header[4:8]), offset: offset, headerSize: 8}
+		a.size = int64(binary.BigEndian.Uint32(header[:4]))
+		switch a.size {
+		case 0:
+			a.size = size - offset
+		case 1:
+			if _, err := src.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("reading MP4 atom header: %w", err)
+			}
+			a.size = int64(binary.BigEndian.Uint64(header[8:16]))
+			a.headerSize = 16
+		}
+		if a.size < a.headerSize || offset+a.size > size {
+			return nil, fmt.Errorf("corrupt MP4 atom %q", a.kind)
+		}
+		atoms = append(atoms, a)
+		offset += a.size
+	}
+	return atoms, nil
+}
+
+// parseAtoms splits an in-memory container payload into child atoms.
+func parseAtoms(data []byte) ([]mp4Atom, error) {
+	var atoms []mp4Atom
+	for len(data) >= 8 {
+		size := int64(binary.BigEndian.Uint32(data[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = int64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated atom header")
+			}
+			size = int64(binary.BigEndian.Uint64(data[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || size > int64(len(data)) {
+			return nil, fmt.Errorf("corrupt atom %q", string(data[4:8]))
+		}
+		atoms = append(atoms, mp4Atom{kind: string(data[4:8]), headerSize: headerSize, size: size, payload: data[headerSize:size]})
+		data = data[size:]
+	}
+	return atoms, nil
+}
+
+// encodeAtom serialises an atom with a 32-bit (or, if needed, 64-bit) size.
+func encodeAtom(kind string, payload []byte) []byte {
+	size := uint64(len(payload)) + 8
+	if size > math.MaxUint32 {
+		out := make([]byte, 16, size+8)
+		binary.BigEndian.PutUint32(out, 1)
+		copy(out[4:], kind)
+		binary.BigEndian.PutUint64(out[8:], size+8)
+		return append(out, payload...)
+	}
+	out := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(out, uint32(size))
+	copy(out[4:], kind)
+	return append(out, payload...)
+}
+
+// rebuildUdta returns a udta payload whose meta/ilst carries the update,
+// preserving other udta children, meta children and ilst items.
+func rebuildUdta(udta []byte, update TagUpdate) ([]byte, error) {
+	children, err := parseAtoms(udta)
+	if err != nil {
+		return nil, fmt.Errorf("parsing udta atom: %w", err)
+	}
+
+	var out bytes.Buffer
+	var meta []byte
+	for _, c := range children {
+		if c.kind == "meta" && meta == nil {
+			meta = c.payload
+			continue
+		}
+		out.Write(encodeAtom(c.kind, c.payload))
+	}
+
+	// meta is a full box: 4 bytes of version/flags precede its children.
+	var metaChildren []mp4Atom
+	if len(meta) >= 4 {
+		if metaChildren, err = parseAtoms(meta[4:]); err != nil {
+			return nil, fmt.Errorf("parsing meta atom: %w", err)
+		}
+	}
+
+	hasHdlr := false
+	var ilst []byte
+	var kept []mp4Atom
+	for _, c := range metaChildren {
+		switch c.kind {
+		case "hdlr":
+			hasHdlr = true
+		case "ilst":
+			if ilst == nil {
+				ilst = c.payload
+			}
+			continue
+		case "free":
+			continue
+		}
+		kept = append(kept, c)
+	}
+
+	var newMeta bytes.Buffer
+	newMeta.Write([]byte{0, 0, 0, 0})
+	if !hasHdlr {
+		hdlr := []byte{0, 0, 0, 0, 0, 0, 0, 0, 'm', 'd', 'i', 'r', 'a', 'p', 'p', 'l', 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		newMeta.Write(encodeAtom("hdlr", hdlr))
+	}
+	for _, c := range kept {
+		newMeta.Write(encodeAtom(c.kind, c.payload))
+	}
+
+	newIlst, err := rebuildIlst(ilst, update)
+	if err != nil {
+		return nil, err
+	}
+	newMeta.Write(encodeAtom("ilst", newIlst))
+	out.Write(encodeAtom("meta", newMeta.Bytes()))
+	return out.Bytes(), nil
+}
+
+// rebuildIlst replaces the items affected by the update in an ilst payload.
+func rebuildIlst(ilst []byte, update TagUpdate) ([]byte, error) {
+	items, err := parseAtoms(ilst)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ilst atom: %w", err)
+	}
+
+	replaced := map[string][]byte{}
+	setText := func(kind string, value *string) {
+		if value == nil {
+			return
+		}
+		replaced[kind] = nil
+		if *value != "" {
+			replaced[kind] = mp4DataAtom(mp4DataUTF8, []byte(*value))
+		}
+	}
+	setText("\xa9nam", update.Title)
+	setText("\xa9ART", update.Artist)
+	setText("\xa9alb", update.Album)
+
+	if update.TrackNumber != nil {
+		replaced["trkn"] = nil
+		if *update.TrackNumber > 0 {
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint16(value[2:], uint16(*update.TrackNumber))
+			for _, item := range items {
+				if item.kind == "trkn" {
+					if total, ok := mp4TrackTotal(item.payload); ok {
+						binary.BigEndian.PutUint16(value[4:], total)
+					}
+				}
+			}
+			replaced["trkn"] = mp4DataAtom(mp4DataImplicit, value)
+		}
+	}
+	if update.Picture != nil {
+		kind := uint32(mp4DataJPEG)
+		if pictureMimeType(update.Picture) == "image/png" {
+			kind = mp4DataPNG
+		}
+		replaced["covr"] = mp4DataAtom(kind, update.Picture)
+	}
+
+	var out bytes.Buffer
+	for _, item := range items {
+		if _, ok := replaced[item.kind]; ok {
+			continue
+		}
+		out.Write(encodeAtom(item.kind, item.payload))
+	}
+	for _, kind := range []string{"\xa9nam", "\xa9ART", "\xa9alb", "trkn", "covr"} {
+		if data, ok := replaced[kind]; ok && data != nil {
+			out.Write(encodeAtom(kind, data))
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// mp4DataAtom builds the "data" child of an ilst item.
+func mp4DataAtom(kind uint32, value []byte) []byte {
+	payload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(payload, kind)
+	return encodeAtom("data", append(payload, value...))
+}
+
+// mp4TrackTotal extracts the total track count from an existing trkn item.
+func mp4TrackTotal(item []byte) (uint16, bool) {
+	children, err := parseAtoms(item)
+	if err != nil {
+		return 0, false
+	}
+	for _, c := range children {
+		if c.kind == "data" && len(c.payload) >= 8+6 {
+			return binary.BigEndian.Uint16(c.payload[8+4:]), true
+		}
+	}
+	return 0, false
+}
+
+// shiftChunkOffsets adds delta to every stco/co64 chunk offset at or beyond
+// moovEnd, walking trak/mdia/minf/stbl containers in place.
+func shiftChunkOffsets(container []byte, moovEnd, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	children, err := parseAtoms(container)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		switch c.kind {
+		case "trak", "mdia", "minf", "stbl":
+			if err := shiftChunkOffsets(c.payload, moovEnd, delta); err != nil {
+				return err
+			}
+		case "stco":
+			if len(c.payload) < 8 {
+				continue
+			}
+			count := int(binary.BigEndian.Uint32(c.payload[4:8]))
+			for i := 0; i < count && 8+i*4+4 <= len(c.payload); i++ {
+				p := c.payload[8+i*4:]
+				off := int64(binary.BigEndian.Uint32(p))
+				if off >= moovEnd {
+					off += delta
+					if off < 0 || off > math.MaxUint32 {
+						return fmt.Errorf("chunk offset out of range after tag rewrite")
+					}
+					binary.BigEndian.PutUint32(p, uint32(off))
+				}
+			}
+		case "co64":
+			if len(c.payload) < 8 {
+				continue
+			}
+			count := int(binary.BigEndian.Uint32(c.payload[4:8]))
+			for i := 0; i < count && 8+i*8+8 <= len(c.payload); i++ {
+				p := c.payload[8+i*8:]
+				off := int64(binary.BigEndian.Uint64(p))
+				if off >= moovEnd {
+					binary.BigEndian.PutUint64(p, uint64(off+delta))
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"staccato/pkg/models"
+)
+
+// namingFields are the placeholders a NamingTemplate may use.
+var namingFields = map[string]bool{
+	"albumartist": true,
+	"artist":      true,
+	"album":       true,
+	"title":       true,
+	"track":       true,
+	"disc":        true,
+	"year":        true,
+}
+
+// namingPlaceholder matches {name} and {name:0N} (zero-padded to N digits).
+var namingPlaceholder = regexp.MustCompile(`\{([a-z]+)(?::0(\d))?\}`)
+
+// unsafePathChars are replaced in tag values so a value cannot add path
+// segments or produce names some filesystems reject.
+var unsafePathChars = strings.NewReplacer(
+	"/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_",
+)
+
+// NamingTemplate builds a library-relative path from a track's tags, e.g.
+// "{albumartist}/{year} - {album}/{disc}{track:02} {title}". It is the
+// inverse of PathTemplate: that one reads tags from paths, this one writes
+// paths from tags.
+type NamingTemplate struct {
+	source string
+}
+
+// ParseNamingTemplate checks a template. Placeholders are {albumartist},
+// {artist}, {album}, {title}, {track}, {disc} and {year}; numeric ones take
+// a zero-padding width such as {track:02}.
+func ParseNamingTemplate(template string) (*NamingTemplate, error) {
+	template = strings.Trim(strings.TrimSpace(template), "/")
+	if template == "" {
+		return nil, fmt.Errorf("naming template is empty")
+	}
+	if strings.Contains(template, "\\") {
+		return nil, fmt.Errorf("naming template %q must separate directories with /", template)
+	}
+	hasTitle := false
+	for _, m := range namingPlaceholder.FindAllStringSubmatch(template, -1) {
+		name := m[1]
+		if !namingFields[name] {
+			return nil, fmt.Errorf("unknown placeholder {%s} in naming template %q", name, template)
+		}
+		if m[2] != "" && name != "track" && name != "disc" && name != "year" {
+			return nil, fmt.Errorf("placeholder {%s} in naming template %q cannot be padded", name, template)
+		}
+		hasTitle = hasTitle || name == "title"
+	}
+	if strings.Count(template, "{") != len(namingPlaceholder.FindAllString(template, -1)) {
+		return nil, fmt.Errorf("malformed placeholder in naming template %q", template)
+	}
+	// Without the title every track of an album would get the same name
+	if !hasTitle {
+		return nil, fmt.Errorf("naming template %q must include {title}", template)
+	}
+	for _, segment := range strings.Split(template, "/") {
+		if strings.TrimSpace(segment) == "" || segment == "." || segment == ".." {
+			return nil, fmt.Errorf("naming template %q has an empty or relative directory", template)
+		}
+	}
+	return &NamingTemplate{source: template}, nil
+}
+
+// String returns the template as written.
+func (t *NamingTemplate) String() string {
+	return t.source
+}
+
+// Render returns the track's library-relative path with forward slashes and
+// without an extension. Missing values render empty; separators left
+// dangling at the start or end of a segment are trimmed, and a segment left
+// empty becomes "Unknown". {albumartist} is the track's album group artist,
+// so compilations land under "Various Artists".
+func (t *NamingTemplate) Render(track models.Track) string {
+	number := func(n int, width string) string {
+		if n <= 0 {
+			return ""
+		}
+		if width == "" {
+			return strconv.Itoa(n)
+		}
+		w, _ := strconv.Atoi(width)
+		return fmt.Sprintf("%0*d", w, n)
+	}
+
+	segments := strings.Split(t.source, "/")
+	for i, segment := range segments {
+		segment = namingPlaceholder.ReplaceAllStringFunc(segment, func(ph string) string {
+			m := namingPlaceholder.FindStringSubmatch(ph)
+			switch m[1] {
+			case "albumartist":
+				return unsafePathChars.Replace(track.AlbumGroupArtist())
+			case "artist":
+				return unsafePathChars.Replace(track.Artist)
+			case "album":
+				return unsafePathChars.Replace(track.Album)
+			case "title":
+				return unsafePathChars.Replace(track.Title)
+			case "track":
+				return number(track.TrackNumber, m[2])
+			case "disc":
+				return number(track.Disc, m[2])
+			case "year":
+				return number(track.Year, m[2])
+			}
+			return ""
+		})
+		segment = strings.Join(strings.Fields(segment), " ")
+		// Dots are trimmed too: names cannot be hidden, and Windows drops
+		// trailing dots
+		segment = strings.Trim(segment, " -_.")
+		if segment == "" {
+			segment = "Unknown"
+		}
+		segments[i] = segment
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,132 @@
+package metadata
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pathTemplateFields maps template placeholders to the pattern they match.
+var pathTemplateFields = map[string]string{
+	"artist": `[^/]+?`,
+	"album":  `[^/]+?`,
+	"title":  `[^/]+?`,
+	"track":  `\d{1,4}`,
+	"disc":   `\d{1,3}`,
+	"year":   `\d{4}`,
+	"any":    `[^/]*?`, // matched and discarded
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// PathTemplate infers metadata from a file's path, e.g.
+// "{artist}/{album}/{track} - {title}". Templates match the trailing path
+// segments (without extension), so they work regardless of library depth.
+type PathTemplate struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// PathInference holds the values a template extracted from a path. Zero
+// values mean the template does not provide the field.
+type PathInference struct {
+	Artist      string `json:"artist,omitempty"`
+	Album       string `json:"album,omitempty"`
+	Title       string `json:"title,omitempty"`
+	TrackNumber int    `json:"trackNumber,omitempty"`
+	Disc        int    `json:"disc,omitempty"`
+	Year        int    `json:"year,omitempty"`
+}
+
+// ParsePathTemplate compiles a template. Placeholders other than {artist},
+// {album}, {title}, {track}, {disc}, {year} and {any} are rejected, as are
+// repeated placeholders (except {any}).
+func ParsePathTemplate(template string) (*PathTemplate, error) {
+	template = strings.Trim(filepath.ToSlash(strings.TrimSpace(template)), "/")
+	if template == "" {
+		return nil, fmt.Errorf("path template is empty")
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString(`(?:^|/)`)
+	seen := map[string]bool{}
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringSubmatchIndex(template, -1) {
+		name := template[loc[2]:loc[3]]
+		expr, ok := pathTemplateFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s} in path template %q", name, template)
+		}
+		pattern.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		if name == "any" {
+			pattern.WriteString(expr)
+		} else {
+			if seen[name] {
+				return nil, fmt.Errorf("placeholder {%s} used twice in path template %q", name, template)
+			}
+			seen[name] = true
+			pattern.WriteString("(?P<" + name + ">" + expr + ")")
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[last:]))
+	pattern.WriteString(`$`)
+
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("path template %q has no placeholders", template)
+	}
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template %q: %w", template, err)
+	}
+	return &PathTemplate{source: template, re: re}, nil
+}
+
+// String returns the template as written.
+func (t *PathTemplate) String() string {
+	return t.source
+}
+
+// Infer matches the template against filePath and returns the extracted
+// values; ok is false if the path does not fit the template.
+func (t *PathTemplate) Infer(filePath string) (PathInference, bool) {
+	p := filepath.ToSlash(filePath)
+	p = strings.TrimSuffix(p, filepath.Ext(p))
+
+	match := t.re.FindStringSubmatch(p)
+	if match == nil {
+		return PathInference{}, false
+	}
+
+	var inf PathInference
+	for i, name := range t.re.SubexpNames() {
+		value := strings.TrimSpace(match[i])
+		switch name {
+		case "artist":
+			inf.Artist = value
+		case "album":
+			inf.Album = value
+		case "title":
+			inf.Title = value
+		case "track":
+			inf.TrackNumber, _ = strconv.Atoi(value)
+		case "disc":
+			inf.Disc, _ = strconv.Atoi(value)
+		case "year":
+			inf.Year, _ = strconv.Atoi(value)
+		}
+	}
+	return inf, true
+}
+
+// InferFromPath tries each template in order and returns the first match.
+func InferFromPath(templates []*PathTemplate, filePath string) (PathInference, bool) {
+	for _, t := range templates {
+		if inf, ok := t.Infer(filePath); ok {
+			return inf, true
+		}
+	}
+	return PathInference{}, false
+}
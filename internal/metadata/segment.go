@@ -0,0 +1,459 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mewkiz/flac"
+	"github.com/tcolgate/mp3"
+)
+
+// ErrSegmentUnsupported is returned by OpenSegment for formats it cannot cut
+// natively; callers may fall back to transcoding.
+var ErrSegmentUnsupported = errors.New("segment streaming is not supported for this format")
+
+// Segment is a seekable stream of part of an audio file, suitable for
+// http.ServeContent.
+type Segment struct {
+	io.ReadSeeker
+	ContentType string
+	closer      io.Closer
+}
+
+// Close releases the underlying file.
+func (s *Segment) Close() error {
+	return s.closer.Close()
+}
+
+// OpenSegment opens the audio between start and end, given in CD frames as in
+// a cue sheet (end 0 means the end of the file). WAV and FLAC sources are
+// served as sample-accurate PCM WAV; MP3 sources are cut at the nearest frame
+// boundaries and stay MP3.
+func OpenSegment(path string, start, end int) (*Segment, error) {
+	if start < 0 || (end != 0 && end <= start) {
+		return nil, fmt.Errorf("invalid segment %d-%d", start, end)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var seg *Segment
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav":
+		seg, err = openWAVSegment(f, start, end)
+	case ".flac":
+		seg, err = openFLACSegment(f, start, end)
+	case ".mp3":
+		seg, err = openMP3Segment(f, start, end)
+	default:
+		err = ErrSegmentUnsupported
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	seg.closer = f
+	return seg, nil
+}
+
+// cueSample converts a CD frame position to a sample index at rate.
+func cueSample(frames int, rate int64) int64 {
+	return int64(frames) * rate / CueFramesPerSecond
+}
+
+// pcmFormat describes interleaved little-endian PCM.
+type pcmFormat struct {
+	formatTag     uint16 // 1 = integer PCM, 3 = IEEE float
+	channels      int
+	sampleRate    int64
+	bitsPerSample int
+}
+
+func (p pcmFormat) blockAlign() int64 {
+	return int64(p.channels * ((p.bitsPerSample + 7) / 8))
+}
+
+// wavHeader returns a canonical 44-byte WAV header for dataSize bytes of PCM.
+func wavHeader(p pcmFormat, dataSize int64) []byte {
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], uint32(36+dataSize))
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], p.formatTag)
+	binary.LittleEndian.PutUint16(h[22:], uint16(p.channels))
+	binary.LittleEndian.PutUint32(h[24:], uint32(p.sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(p.sampleRate*p.blockAlign()))
+	binary.LittleEndian.PutUint16(h[32:], uint16(p.blockAlign()))
+	binary.LittleEndian.PutUint16(h[34:], uint16(p.bitsPerSample))
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], uint32(dataSize))
+	return h
+}
+
+// segmentBounds converts cue positions to a sample range within total
+// samples, clamping to the stream length.
+func segmentBounds(start, end int, rate, total int64) (int64, int64) {
+	first := cueSample(start, rate)
+	last := total
+	if end > 0 {
+		last = cueSample(end, rate)
+	}
+	if last > total {
+		last = total
+	}
+	if first > last {
+		first = last
+	}
+	return first, last
+}
+
+// openWAVSegment serves a byte slice of the data chunk behind a new header.
+func openWAVSegment(f *os.File, start, end int) (*Segment, error) {
+	format, dataOffset, dataSize, err := readWAVLayout(f)
+	if err != nil {
+		return nil, err
+	}
+	first, last := segmentBounds(start, end, format.sampleRate, dataSize/format.blockAlign())
+	size := (last - first) * format.blockAlign()
+	return &Segment{
+		ReadSeeker: newConcatReader(
+			bytes.NewReader(wavHeader(format, size)),
+			io.NewSectionReader(f, dataOffset+first*format.blockAlign(), size),
+		),
+		ContentType: "audio/wav",
+	}, nil
+}
+
+// readWAVLayout walks the RIFF chunks and returns the sample format and the
+// position and size of the data chunk.
+func readWAVLayout(r io.ReadSeeker) (pcmFormat, int64, int64, error) {
+	var format pcmFormat
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return format, 0, 0, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return format, 0, 0, fmt.Errorf("invalid wav file")
+	}
+
+	offset := int64(12)
+	haveFormat := false
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return format, 0, 0, fmt.Errorf("wav data chunk not found: %w", err)
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		offset += 8
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return format, 0, 0, fmt.Errorf("invalid wav fmt chunk")
+			}
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return format, 0, 0, err
+			}
+			format.formatTag = binary.LittleEndian.Uint16(body[0:2])
+			format.channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			format.sampleRate = int64(binary.LittleEndian.Uint32(body[4:8]))
+			format.bitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			if format.formatTag == 0xFFFE && size >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE: the sub-format GUID starts with the tag.
+				format.formatTag = binary.LittleEndian.Uint16(body[24:26])
+			}
+			if format.formatTag != 1 && format.formatTag != 3 {
+				return format, 0, 0, ErrSegmentUnsupported
+			}
+			if format.channels == 0 || format.sampleRate == 0 || format.bitsPerSample == 0 {
+				return format, 0, 0, fmt.Errorf("invalid wav header")
+			}
+			haveFormat = true
+			offset += size
+		case "data":
+			if !haveFormat {
+				return format, 0, 0, fmt.Errorf("wav data chunk before fmt chunk")
+			}
+			return format, offset, size, nil
+		default:
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				return format, 0, 0, err
+			}
+			offset += size
+		}
+		if size%2 == 1 {
+			if _, err := r.Seek(1, io.SeekCurrent); err != nil {
+				return format, 0, 0, err
+			}
+			offset++
+		}
+	}
+}
+
+// openFLACSegment decodes the FLAC stream on demand into PCM WAV.
+func openFLACSegment(f *os.File, start, end int) (*Segment, error) {
+	stream, err := flac.NewSeek(f)
+	if err != nil {
+		return nil, err
+	}
+	info := stream.Info
+	bits := int(info.BitsPerSample)
+	if info.NSamples == 0 || info.SampleRate == 0 || (bits != 8 && bits != 16 && bits != 24 && bits != 32) {
+		return nil, ErrSegmentUnsupported
+	}
+
+	format := pcmFormat{
+		formatTag:     1,
+		channels:      int(info.NChannels),
+		sampleRate:    int64(info.SampleRate),
+		bitsPerSample: bits,
+	}
+	first, last := segmentBounds(start, end, format.sampleRate, int64(info.NSamples))
+	pcm := &flacPCMReader{
+		stream: stream,
+		format: format,
+		first:  first,
+		size:   (last - first) * format.blockAlign(),
+	}
+	return &Segment{
+		ReadSeeker:  newConcatReader(bytes.NewReader(wavHeader(format, pcm.size)), pcm),
+		ContentType: "audio/wav",
+	}, nil
+}
+
+// flacPCMReader exposes a sample range of a FLAC stream as seekable PCM
+// bytes, decoding one frame at a time.
+type flacPCMReader struct {
+	stream *flac.Stream
+	format pcmFormat
+	first  int64 // absolute index of the segment's first sample
+	size   int64 // segment length in bytes
+	pos    int64
+
+	buf      []byte // PCM of the last decoded frame
+	bufStart int64  // segment byte offset of buf[0]; negative if the frame begins earlier
+	valid    bool   // stream is positioned right after buf
+}
+
+func (p *flacPCMReader) Read(b []byte) (int, error) {
+	if p.pos >= p.size {
+		return 0, io.EOF
+	}
+	maxFrame := int64(p.stream.Info.BlockSizeMax) * p.format.blockAlign()
+	for p.pos < p.bufStart || p.pos >= p.bufStart+int64(len(p.buf)) {
+		// Decode forward when the position is within a frame of the
+		// stream; otherwise seek.
+		if !p.valid || p.pos < p.bufStart || p.pos >= p.bufStart+int64(len(p.buf))+maxFrame {
+			if err := p.seek(p.first + p.pos/p.format.blockAlign()); err != nil {
+				p.valid = false
+				return 0, err
+			}
+		}
+		if err := p.decodeFrame(); err != nil {
+			p.valid = false
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+
+	avail := p.buf[p.pos-p.bufStart:]
+	if remaining := p.size - p.pos; int64(len(avail)) > remaining {
+		avail = avail[:remaining]
+	}
+	n := copy(b, avail)
+	p.pos += int64(n)
+	return n, nil
+}
+
+// seek positions the stream at the frame containing sample, or the frame
+// before it. flac.Stream.Seek cannot find samples in a shorter final frame
+// of a fixed-block-size stream, so those fall back one block.
+func (p *flacPCMReader) seek(sample int64) error {
+	_, err := p.stream.Seek(uint64(sample))
+	if err != nil && sample >= int64(p.stream.Info.BlockSizeMax) {
+		_, err = p.stream.Seek(uint64(sample - int64(p.stream.Info.BlockSizeMax)))
+	}
+	return err
+}
+
+// decodeFrame decodes the next frame into buf as interleaved PCM.
+func (p *flacPCMReader) decodeFrame() error {
+	frame, err := p.stream.ParseNext()
+	if err != nil {
+		return err
+	}
+	width := (p.format.bitsPerSample + 7) / 8
+	n := int(frame.BlockSize)
+	p.buf = p.buf[:0]
+	for i := 0; i < n; i++ {
+		for _, sub := range frame.Subframes {
+			s := sub.Samples[i]
+			switch width {
+			case 1:
+				p.buf = append(p.buf, byte(s+128)) // 8-bit WAV is unsigned
+			case 2:
+				p.buf = append(p.buf, byte(s), byte(s>>8))
+			case 3:
+				p.buf = append(p.buf, byte(s), byte(s>>8), byte(s>>16))
+			default:
+				p.buf = append(p.buf, byte(s), byte(s>>8), byte(s>>16), byte(s>>24))
+			}
+		}
+	}
+	// frame.SampleNumber multiplies by the frame's own block size, which is
+	// wrong for the shorter final frame of a fixed-block-size stream.
+	start := int64(frame.Num)
+	if frame.HasFixedBlockSize {
+		start *= int64(p.stream.Info.BlockSizeMax)
+	}
+	p.bufStart = (start - p.first) * p.format.blockAlign()
+	p.valid = true
+	return nil
+}
+
+func (p *flacPCMReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seekPosition(p.pos, p.size, offset, whence)
+	if err != nil {
+		return p.pos, err
+	}
+	p.pos = pos
+	return pos, nil
+}
+
+// openMP3Segment finds the byte range of the frames covering the segment.
+// Each frame is assigned to the side of a boundary its midpoint falls on.
+func openMP3Segment(f *os.File, start, end int) (*Segment, error) {
+	counter := &countingReader{r: bufio.NewReader(f)}
+	dec := mp3.NewDecoder(counter)
+	startTime := time.Duration(start) * time.Second / CueFramesPerSecond
+	endTime := time.Duration(end) * time.Second / CueFramesPerSecond
+
+	var elapsed time.Duration
+	var skipped int
+	first, last := int64(-1), int64(-1)
+	for {
+		var frame mp3.Frame
+		if err := dec.Decode(&frame, &skipped); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, err
+		}
+		frameStart := counter.n - int64(frame.Size())
+		mid := elapsed + frame.Duration()/2
+		if end > 0 && mid >= endTime {
+			break
+		}
+		if first < 0 && mid >= startTime {
+			first = frameStart
+		}
+		if first >= 0 {
+			last = counter.n
+		}
+		elapsed += frame.Duration()
+	}
+	if first < 0 {
+		return nil, fmt.Errorf("segment starts beyond the end of the file")
+	}
+	return &Segment{
+		ReadSeeker:  io.NewSectionReader(f, first, last-first),
+		ContentType: "audio/mpeg",
+	}, nil
+}
+
+// countingReader counts bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// concatReader joins fixed-size seekable parts into one seekable stream.
+type concatReader struct {
+	parts []io.ReadSeeker
+	sizes []int64
+	size  int64
+	pos   int64
+}
+
+// newConcatReader sizes each part by seeking to its end.
+func newConcatReader(parts ...io.ReadSeeker) *concatReader {
+	c := &concatReader{parts: parts, sizes: make([]int64, len(parts))}
+	for i, part := range parts {
+		size, _ := part.Seek(0, io.SeekEnd)
+		c.sizes[i] = size
+		c.size += size
+	}
+	return c
+}
+
+func (c *concatReader) Read(b []byte) (int, error) {
+	base := int64(0)
+	for i, part := range c.parts {
+		if c.pos < base+c.sizes[i] {
+			if _, err := part.Seek(c.pos-base, io.SeekStart); err != nil {
+				return 0, err
+			}
+			if remaining := base + c.sizes[i] - c.pos; int64(len(b)) > remaining {
+				b = b[:remaining]
+			}
+			n, err := part.Read(b)
+			c.pos += int64(n)
+			if err == io.EOF {
+				err = nil
+				if n == 0 {
+					err = io.ErrUnexpectedEOF
+				}
+			}
+			return n, err
+		}
+		base += c.sizes[i]
+	}
+	return 0, io.EOF
+}
+
+func (c *concatReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seekPosition(c.pos, c.size, offset, whence)
+	if err != nil {
+		return c.pos, err
+	}
+	c.pos = pos
+	return pos, nil
+}
+
+// seekPosition resolves an io.Seeker offset against the current position and
+// total size.
+func seekPosition(current, size, offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += current
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative seek position")
+	}
+	return offset, nil
+}
@@ -0,0 +1,155 @@
+package metadata
+
+import (
+	"sort"
+	"strings"
+
+	"staccato/pkg/models"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// DefaultSortArticles are the leading articles ignored when a name has no
+// sort tag, so "The Beatles" sorts under B.
+var DefaultSortArticles = []string{"The", "A", "An", "Die", "Der", "Das", "Le", "La", "Les", "L'"}
+
+// TrackOrder selects how Sorter.SortTracks orders a track list.
+type TrackOrder int
+
+const (
+	// ByArtist orders by artist, album, track number and title.
+	ByArtist TrackOrder = iota
+	// ByAlbum orders by album, keeping each album (album group artist,
+	// album and year) together in disc and track order.
+	ByAlbum
+	// ByTitle orders by title, then artist.
+	ByTitle
+)
+
+// Sorter orders names the way a listener expects: by their sort tags when
+// present, otherwise without leading articles, compared with Unicode
+// collation (case-insensitive, accents after the base letter, numbers by
+// value). It is safe for concurrent use.
+type Sorter struct {
+	articles []string
+}
+
+// NewSorter creates a Sorter ignoring the given leading articles. Nil uses
+// DefaultSortArticles; articles match case-insensitively and, unless they
+// end in an apostrophe, must be followed by a space.
+func NewSorter(articles []string) *Sorter {
+	if articles == nil {
+		articles = DefaultSortArticles
+	}
+	s := &Sorter{}
+	for _, article := range articles {
+		if article = strings.TrimSpace(article); article != "" {
+			s.articles = append(s.articles, article)
+		}
+	}
+	return s
+}
+
+// SortName returns the name to sort name by: sortTag if set, otherwise name
+// without a leading article.
+func (s *Sorter) SortName(name, sortTag string) string {
+	if sortTag = strings.TrimSpace(sortTag); sortTag != "" {
+		return sortTag
+	}
+	name = strings.TrimSpace(name)
+	for _, article := range s.articles {
+		if len(name) <= len(article) || !strings.EqualFold(name[:len(article)], article) {
+			continue
+		}
+		rest := name[len(article):]
+		if rest[0] == ' ' || strings.HasSuffix(article, "'") {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return name
+}
+
+// newCollator returns a collator for sort keys. Collators are not safe for
+// concurrent use, so each sort makes its own.
+func newCollator() *collate.Collator {
+	return collate.New(language.Und, collate.IgnoreCase, collate.Numeric)
+}
+
+// SortStrings sorts names in place by their sort names.
+func (s *Sorter) SortStrings(names []string) {
+	c := newCollator()
+	var buf collate.Buffer
+	keys := make(map[string]string, len(names))
+	for _, name := range names {
+		keys[name] = string(c.KeyFromString(&buf, s.SortName(name, "")))
+		buf.Reset()
+	}
+	sort.SliceStable(names, func(i, j int) bool { return keys[names[i]] < keys[names[j]] })
+}
+
+// SortTracks sorts tracks in place. Ties keep their relative order.
+func (s *Sorter) SortTracks(tracks []models.Track, order TrackOrder) {
+	sorted := make([]models.Track, len(tracks))
+	for i, j := range s.SortIndexes(tracks, order) {
+		sorted[i] = tracks[j]
+	}
+	copy(tracks, sorted)
+}
+
+// SortIndexes returns the indexes of tracks in sorted order, leaving tracks
+// as they are. Ties keep their relative order.
+func (s *Sorter) SortIndexes(tracks []models.Track, order TrackOrder) []int {
+	c := newCollator()
+	var buf collate.Buffer
+	key := func(name, sortTag string) string {
+		k := string(c.KeyFromString(&buf, s.SortName(name, sortTag)))
+		buf.Reset()
+		return k
+	}
+
+	type sortKey struct {
+		first, second, title string
+		year, disc, number   int
+	}
+	keys := make([]sortKey, len(tracks))
+	for i, t := range tracks {
+		k := sortKey{title: key(t.Title, t.TitleSort), number: t.TrackNumber}
+		switch order {
+		case ByAlbum:
+			k.first = key(t.Album, t.AlbumSort)
+			k.second = key(t.AlbumGroupArtist(), t.AlbumGroupArtistSort())
+			k.year, k.disc = t.Year, t.Disc
+		case ByTitle:
+			k.first = k.title
+			k.second = key(t.Artist, t.ArtistSort)
+		default:
+			k.first = key(t.Artist, t.ArtistSort)
+			k.second = key(t.Album, t.AlbumSort)
+		}
+		keys[i] = k
+	}
+
+	idx := make([]int, len(tracks))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		x, y := keys[idx[a]], keys[idx[b]]
+		switch {
+		case x.first != y.first:
+			return x.first < y.first
+		case x.second != y.second:
+			return x.second < y.second
+		case x.year != y.year:
+			return x.year < y.year
+		case x.disc != y.disc:
+			return x.disc < y.disc
+		case x.number != y.number:
+			return x.number < y.number
+		default:
+			return x.title < y.title
+		}
+	})
+	return idx
+}
@@ -0,0 +1,164 @@
+package metadata
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sourceBlockSize = 64 << 10
+	// sourceHeadBlocks are read up front: tags, stream headers and most
+	// embedded artwork live at the start of the file.
+	sourceHeadBlocks = 4
+	// sourceMaxBlocks bounds the cache; streaming reads past it (frame scans,
+	// large artwork) keep only the block being read.
+	sourceMaxBlocks = 64
+)
+
+// Source is an open audio file read through a block cache, so the tag
+// parser, the duration parser and art hashing share a single pass over the
+// file instead of each opening and re-reading it. It implements io.Reader,
+// io.Seeker and io.ReaderAt and is not safe for concurrent use.
+type Source struct {
+	path     string
+	file     *os.File
+	size     int64
+	pos      int64
+	blocks   map[int64][]byte
+	last     int64 // index of the most recent uncached block in lastData
+	lastData []byte
+}
+
+// OpenSource opens path and reads the head and tail of the file, which is
+// where every supported format keeps its tags and stream headers. The
+// remaining parsing then mostly runs from memory, so callers can separate
+// I/O-bound opening from CPU-bound extraction.
+func OpenSource(path string) (*Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &Source{
+		path:   path,
+		file:   f,
+		size:   st.Size(),
+		blocks: make(map[int64][]byte),
+		last:   -1,
+	}
+	for i := int64(0); i < sourceHeadBlocks && i*sourceBlockSize < s.size; i++ {
+		if _, err := s.block(i); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if s.size > 0 {
+		if _, err := s.block((s.size - 1) / sourceBlockSize); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Path returns the file path the source was opened from.
+func (s *Source) Path() string { return s.path }
+
+// Size returns the file size in bytes.
+func (s *Source) Size() int64 { return s.size }
+
+// Ext returns the lower-cased file extension.
+func (s *Source) Ext() string { return strings.ToLower(filepath.Ext(s.path)) }
+
+// Close closes the underlying file and drops the cache.
+func (s *Source) Close() error {
+	s.blocks = nil
+	s.lastData = nil
+	return s.file.Close()
+}
+
+// block returns block i, reading it from disk only if it is not cached.
+func (s *Source) block(i int64) ([]byte, error) {
+	if b, ok := s.blocks[i]; ok {
+		return b, nil
+	}
+	if i == s.last {
+		return s.lastData, nil
+	}
+	off := i * sourceBlockSize
+	n := int64(sourceBlockSize)
+	if off+n > s.size {
+		n = s.size - off
+	}
+	b := make([]byte, n)
+	if _, err := s.file.ReadAt(b, off); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(s.blocks) < sourceMaxBlocks {
+		s.blocks[i] = b
+	} else {
+		s.last, s.lastData = i, b
+	}
+	return b, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (s *Source) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("metadata: negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		at := off + int64(n)
+		if at >= s.size {
+			return n, io.EOF
+		}
+		b, err := s.block(at / sourceBlockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], b[at%sourceBlockSize:])
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (s *Source) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if rest := s.size - s.pos; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := s.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (s *Source) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("metadata: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("metadata: negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,164 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register decoders for picture dimensions
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrTagWriteUnsupported is returned when tags cannot be written for a format.
+var ErrTagWriteUnsupported = errors.New("writing tags is not supported for this format")
+
+const (
+	maxTagFieldLength = 1024
+	maxPictureSize    = 10 << 20
+)
+
+// TagUpdate describes changes to write into an audio file's tags. Nil fields
+// are left untouched; a non-nil empty string removes the field.
+type TagUpdate struct {
+	Title       *string
+	Artist      *string
+	Album       *string
+	TrackNumber *int
+	Picture     []byte // replacement front cover (JPEG or PNG); nil keeps existing art
+}
+
+// IsEmpty reports whether the update changes nothing.
+func (u TagUpdate) IsEmpty() bool {
+	return u.Title == nil && u.Artist == nil && u.Album == nil && u.TrackNumber == nil && u.Picture == nil
+}
+
+// Validate checks field lengths, characters and the picture format.
+func (u TagUpdate) Validate() error {
+	fields := map[string]*string{"title": u.Title, "artist": u.Artist, "album": u.Album}
+	for name, value := range fields {
+		if value == nil {
+			continue
+		}
+		if len(*value) > maxTagFieldLength {
+			return fmt.Errorf("%s too long (max %d characters)", name, maxTagFieldLength)
+		}
+		if strings.ContainsAny(*value, "\x00\r\n") {
+			return fmt.Errorf("%s contains invalid characters", name)
+		}
+	}
+	if u.TrackNumber != nil && (*u.TrackNumber < 0 || *u.TrackNumber > 9999) {
+		return fmt.Errorf("track number must be between 0 and 9999")
+	}
+	if u.Picture != nil {
+		if len(u.Picture) > maxPictureSize {
+			return fmt.Errorf("album art too large (max %d bytes)", maxPictureSize)
+		}
+		if mime := pictureMimeType(u.Picture); mime != "image/jpeg" && mime != "image/png" {
+			return fmt.Errorf("album art must be a JPEG or PNG image")
+		}
+	}
+	return nil
+}
+
+// CanWriteTags reports whether WriteTags supports the file's format.
+func CanWriteTags(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp3", ".flac", ".m4a":
+		return true
+	default:
+		return false
+	}
+}
+
+// WriteTags applies the update to the file's native tag format (ID3v2 for
+// MP3, Vorbis comments for FLAC, iTunes atoms for M4A). The file is rewritten
+// to a temporary sibling and atomically renamed over the original.
+func WriteTags(filePath string, update TagUpdate) error {
+	if err := update.Validate(); err != nil {
+		return err
+	}
+	if update.IsEmpty() {
+		return nil
+	}
+
+	var rewrite func(src *os.File, size int64, dst io.Writer, update TagUpdate) error
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp3":
+		rewrite = rewriteID3v2
+	case ".flac":
+		rewrite = rewriteFLACTags
+	case ".m4a":
+		rewrite = rewriteMP4Tags
+	default:
+		return ErrTagWriteUnsupported
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tagtmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmpPath)
+	}
+
+	if err := rewrite(src, stat.Size(), tmp, update); err != nil {
+		cleanup()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, stat.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	src.Close()
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// pictureMimeType sniffs the MIME type of image data.
+func pictureMimeType(data []byte) string {
+	switch {
+	case len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF:
+		return "image/jpeg"
+	case len(data) >= 8 && bytes.Equal(data[:8], []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// pictureDimensions returns width, height and bit depth of an image if they
+// can be decoded, otherwise zeros.
+func pictureDimensions(data []byte) (int, int, int) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0
+	}
+	return cfg.Width, cfg.Height, 24
+}
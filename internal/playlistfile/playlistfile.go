@@ -0,0 +1,399 @@
+// Package playlistfile reads and writes playlist files used by other
+// players: M3U/M3U8, PLS and XSPF.
+package playlistfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is a playlist file format.
+type Format string
+
+const (
+	// M3U8 is an extended M3U playlist in UTF-8. Plain .m3u files are read
+	// the same way.
+	M3U8 Format = "m3u8"
+	// PLS is a Winamp/Shoutcast PLS playlist.
+	PLS Format = "pls"
+	// XSPF is an XML Shareable Playlist Format playlist.
+	XSPF Format = "xspf"
+)
+
+var (
+	// ErrUnknownFormat is returned for formats other than M3U8, PLS and XSPF.
+	ErrUnknownFormat = errors.New("unknown playlist format")
+	// ErrInvalidFile is returned (wrapped) for files that cannot be parsed.
+	ErrInvalidFile = errors.New("invalid playlist file")
+)
+
+// xspfNamespace is the XML namespace of XSPF version 1.
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+// ParseFormat returns the format named s ("m3u", "m3u8", "pls" or "xspf",
+// in any letter case).
+func ParseFormat(s string) (Format, bool) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "m3u", "m3u8":
+		return M3U8, true
+	case "pls":
+		return PLS, true
+	case "xspf":
+		return XSPF, true
+	}
+	return "", false
+}
+
+// FormatOf returns the format of a file name by its extension.
+func FormatOf(name string) (Format, bool) {
+	return ParseFormat(path.Ext(strings.ReplaceAll(name, `\`, "/")))
+}
+
+// Sniff guesses the format of playlist file contents: XML is XSPF, a
+// "[playlist]" section is PLS, anything else M3U8.
+func Sniff(data []byte) Format {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return XSPF
+	case len(trimmed) >= 10 && strings.EqualFold(string(trimmed[:10]), "[playlist]"):
+		return PLS
+	default:
+		return M3U8
+	}
+}
+
+// Extension returns the file extension for the format, with the dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case PLS:
+		return "audio/x-scpls"
+	case XSPF:
+		return "application/xspf+xml"
+	default:
+		return "audio/x-mpegurl; charset=utf-8"
+	}
+}
+
+// Entry is one item of a playlist file. Location is a path or URL as
+// written in the file; Artist, Title and Duration (in seconds) are the
+// optional display hints that come with it. Line is the line of the file
+// the entry starts on.
+type Entry struct {
+	Location string
+	Artist   string
+	Title    string
+	Duration int
+	Line     int
+}
+
+// Playlist is the contents of a playlist file.
+type Playlist struct {
+	Title   string
+	Entries []Entry
+}
+
+// Write writes p to w in format f.
+func Write(w io.Writer, f Format, p Playlist) error {
+	switch f {
+	case M3U8:
+		return writeM3U(w, p)
+	case PLS:
+		return writePLS(w, p)
+	case XSPF:
+		return writeXSPF(w, p)
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+}
+
+// Parse reads a playlist file in format f.
+func Parse(r io.Reader, f Format) (*Playlist, error) {
+	switch f {
+	case M3U8:
+		return parseM3U(r)
+	case PLS:
+		return parsePLS(r)
+	case XSPF:
+		return parseXSPF(r)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+}
+
+// displayName joins artist and title the way M3U and PLS titles carry them.
+func displayName(e Entry) string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
+
+// splitDisplayName is the inverse of displayName.
+func splitDisplayName(s string) (artist, title string) {
+	if before, after, ok := strings.Cut(s, " - "); ok {
+		return strings.TrimSpace(before), strings.TrimSpace(after)
+	}
+	return "", strings.TrimSpace(s)
+}
+
+// durationOrUnknown returns seconds, or -1 (unknown in M3U and PLS) for 0.
+func durationOrUnknown(seconds int) int {
+	if seconds <= 0 {
+		return -1
+	}
+	return seconds
+}
+
+func writeM3U(w io.Writer, p Playlist) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U\n")
+	if p.Title != "" {
+		fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(p.Title))
+	}
+	for _, e := range p.Entries {
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n%s\n", durationOrUnknown(e.Duration), oneLine(displayName(e)), oneLine(e.Location))
+	}
+	return bw.Flush()
+}
+
+func parseM3U(r io.Reader) (*Playlist, error) {
+	p := &Playlist{}
+	var info *Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "#EXTINF:"):
+			duration, name, _ := strings.Cut(strings.TrimPrefix(text, "#EXTINF:"), ",")
+			info = &Entry{}
+			// Attributes such as tvg-id="..." may follow the duration
+			if fields := strings.Fields(duration); len(fields) > 0 {
+				info.Duration, _ = strconv.Atoi(fields[0])
+			}
+			info.Artist, info.Title = splitDisplayName(name)
+		case strings.HasPrefix(text, "#PLAYLIST:"):
+			p.Title = strings.TrimSpace(strings.TrimPrefix(text, "#PLAYLIST:"))
+		case strings.HasPrefix(text, "#"):
+		default:
+			e := Entry{Location: text, Line: line}
+			if info != nil {
+				e.Artist, e.Title, e.Duration = info.Artist, info.Title, info.Duration
+				info = nil
+			}
+			if e.Duration < 0 {
+				e.Duration = 0
+			}
+			p.Entries = append(p.Entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	return p, nil
+}
+
+func writePLS(w io.Writer, p Playlist) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[playlist]\n")
+	if p.Title != "" {
+		fmt.Fprintf(bw, "X-GNOME-Title=%s\n", oneLine(p.Title))
+	}
+	for i, e := range p.Entries {
+		n := i + 1
+		fmt.Fprintf(bw, "File%d=%s\nTitle%d=%s\nLength%d=%d\n",
+			n, oneLine(e.Location), n, oneLine(displayName(e)), n, durationOrUnknown(e.Duration))
+	}
+	fmt.Fprintf(bw, "NumberOfEntries=%d\nVersion=2\n", len(p.Entries))
+	return bw.Flush()
+}
+
+func parsePLS(r io.Reader) (*Playlist, error) {
+	p := &Playlist{}
+	entries := map[int]*Entry{}
+	entry := func(n int) *Entry {
+		if entries[n] == nil {
+			entries[n] = &Entry{}
+		}
+		return entries[n]
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		key, value, ok := strings.Cut(strings.TrimPrefix(scanner.Text(), "\ufeff"), "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if key == "x-gnome-title" {
+			p.Title = value
+			continue
+		}
+		for _, prefix := range []string{"file", "title", "length"} {
+			n, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+			if !strings.HasPrefix(key, prefix) || err != nil {
+				continue
+			}
+			e := entry(n)
+			switch prefix {
+			case "file":
+				e.Location, e.Line = value, line
+			case "title":
+				e.Artist, e.Title = splitDisplayName(value)
+			case "length":
+				if d, err := strconv.Atoi(value); err == nil && d > 0 {
+					e.Duration = d
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	numbers := make([]int, 0, len(entries))
+	for n, e := range entries {
+		if e.Location != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		p.Entries = append(p.Entries, *entries[n])
+	}
+	return p, nil
+}
+
+// xspfTrack is a <track> of an XSPF playlist.
+type xspfTrack struct {
+	Location string `xml:"location,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Title    string `xml:"title,omitempty"`
+	Duration int    `xml:"duration,omitempty"` // in milliseconds
+}
+
+func writeXSPF(w io.Writer, p Playlist) error {
+	doc := struct {
+		XMLName xml.Name    `xml:"playlist"`
+		Version string      `xml:"version,attr"`
+		XMLNS   string      `xml:"xmlns,attr"`
+		Title   string      `xml:"title,omitempty"`
+		Tracks  []xspfTrack `xml:"trackList>track"`
+	}{Version: "1", XMLNS: xspfNamespace, Title: p.Title}
+	for _, e := range p.Entries {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location: locationURI(e.Location),
+			Creator:  e.Artist,
+			Title:    e.Title,
+			Duration: e.Duration * 1000,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func parseXSPF(r io.Reader) (*Playlist, error) {
+	p := &Playlist{}
+	dec := xml.NewDecoder(r)
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case t.Name.Local == "title" && depth == 2:
+				if err := dec.DecodeElement(&p.Title, &t); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+				}
+				depth--
+			case t.Name.Local == "track":
+				line, _ := dec.InputPos()
+				var track xspfTrack
+				if err := dec.DecodeElement(&track, &t); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+				}
+				depth--
+				if track.Location == "" {
+					continue
+				}
+				p.Entries = append(p.Entries, Entry{
+					Location: locationPath(strings.TrimSpace(track.Location)),
+					Artist:   strings.TrimSpace(track.Creator),
+					Title:    strings.TrimSpace(track.Title),
+					Duration: track.Duration / 1000,
+					Line:     line,
+				})
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// locationURI turns a path into the URI reference XSPF locations hold.
+// URLs are kept as they are.
+func locationURI(location string) string {
+	if u, err := url.Parse(location); err == nil && u.Scheme != "" && len(u.Scheme) > 1 {
+		return location
+	}
+	if strings.HasPrefix(location, "/") {
+		return (&url.URL{Scheme: "file", Path: location}).String()
+	}
+	return (&url.URL{Path: location}).String()
+}
+
+// locationPath is the inverse of locationURI: file URIs and relative URI
+// references become paths, other URLs are kept.
+func locationPath(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	switch u.Scheme {
+	case "file":
+		return u.Path
+	case "":
+		return u.Path
+	default:
+		return location
+	}
+}
+
+// oneLine replaces line breaks, which would end an M3U or PLS entry early.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+}
@@ -20,8 +20,12 @@ func (ms *MusicServer) handleAlbumArt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get album art from metadata extractor cache
+	// Get album art from metadata extractor cache, falling back to art
+	// supplied through a metadata override
 	artData, exists := ms.extractor.GetAlbumArt(artID)
+	if !exists {
+		artData, exists = ms.db.GetOverrideArt(artID)
+	}
 	if !exists {
 		http.Error(w, "Album art not found", http.StatusNotFound)
 		return
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+
+	"staccato/pkg/models"
+)
+
+// creditRoles are the values accepted for the role filter.
+var creditRoles = map[string]bool{
+	models.RoleMain:     true,
+	models.RoleFeatured: true,
+	models.RoleRemixer:  true,
+	models.RoleComposer: true,
+}
+
+// handleGetArtists lists the artists credited on the caller's tracks, one
+// entry per artist even when tags name several together.
+func (ms *MusicServer) handleGetArtists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	artists, err := ms.db.GetArtists(ms.libraryScope(r))
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving artists", err)
+		return
+	}
+	ms.respondJSON(w, artists)
+}
+
+// handleGetArtistTracks responds with the caller's tracks crediting the
+// artist named by the artist query parameter, optionally in one role.
+func (ms *MusicServer) handleGetArtistTracks(w http.ResponseWriter, r *http.Request) {
+	name := sanitizeInput(r.URL.Query().Get("artist"))
+	if validationErr := ms.validateSearchQuery(name); validationErr != nil {
+		validationErr.Field = "artist"
+		ms.respondWithValidationError(w, r, []ValidationError{*validationErr})
+		return
+	}
+	role := r.URL.Query().Get("role")
+	if role != "" && !creditRoles[role] {
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "role",
+			Message: "Role must be main, featured, remixer or composer",
+			Code:    "INVALID_ROLE",
+		}})
+		return
+	}
+
+	tracks, err := ms.db.GetTracksByArtist(name, role, ms.libraryScope(r))
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving tracks", err)
+		return
+	}
+	ms.respondJSON(w, tracks)
+}
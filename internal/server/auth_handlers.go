@@ -53,6 +53,25 @@ func (ms *MusicServer) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// currentUsername returns the authenticated username from the request context
+// (empty when auth is disabled or the request is anonymous).
+func currentUsername(r *http.Request) string {
+	if user, ok := r.Context().Value(UserContextKey).(string); ok {
+		return user
+	}
+	return ""
+}
+
+// requireAdmin writes a 403 response and returns false unless the caller is an
+// administrator (always true when auth is disabled).
+func (ms *MusicServer) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if ms.authService.IsAdmin(currentUsername(r)) {
+		return true
+	}
+	ms.respondWithError(w, r, http.StatusForbidden, "Administrator access required", nil)
+	return false
+}
+
 // isPublicPath checks if a path should be accessible without authentication
 func isPublicPath(path string) bool {
 	publicPaths := []string{
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"staccato/internal/database"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startBackupScheduler writes a backup every database.backup_interval_hours,
+// rotating old ones out. It runs until shutdown.
+func (ms *MusicServer) startBackupScheduler() {
+	interval := time.Duration(ms.config.Database.BackupIntervalHours) * time.Hour
+	go func() {
+		ms.logger.WithFields(logrus.Fields{
+			"interval":   interval,
+			"backup_dir": ms.config.Database.BackupDir,
+			"retention":  ms.config.Database.BackupRetention,
+		}).Info("Scheduled backups enabled")
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ms.shutdownCh:
+				return
+			case <-ticker.C:
+				info, err := ms.db.BackupToDir(ms.config.Database.BackupDir, ms.config.Database.BackupRetention)
+				if err != nil {
+					ms.logger.WithError(err).Error("Scheduled backup failed")
+					continue
+				}
+				ms.logger.WithField("backup", info.Name).Info("Scheduled backup written")
+			}
+		}
+	}()
+}
+
+// handleBackups lists backups (GET) or writes a new one (POST). Admin only.
+func (ms *MusicServer) handleBackups(w http.ResponseWriter, r *http.Request) {
+	if !ms.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		backups, err := database.ListBackups(ms.config.Database.BackupDir)
+		if err != nil {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to list backups", err)
+			return
+		}
+		ms.respondJSON(w, backups)
+	case http.MethodPost:
+		info, err := ms.db.BackupToDir(ms.config.Database.BackupDir, ms.config.Database.BackupRetention)
+		if err != nil {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to write backup", err)
+			return
+		}
+		ms.logger.WithFields(logrus.Fields{
+			"backup": info.Name,
+			"user":   currentUsername(r),
+		}).Info("Backup written")
+		ms.respondJSON(w, info)
+	default:
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// handleBackup serves /api/admin/backups/{name}: GET downloads the file,
+// DELETE removes it and POST .../restore restores it. Admin only.
+func (ms *MusicServer) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if !ms.requireAdmin(w, r) {
+		return
+	}
+	rest := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/api/admin/backups/")
+	name, action, _ := strings.Cut(rest, "/")
+	if !database.IsBackupName(name) || (action != "" && action != "restore") {
+		ms.respondWithError(w, r, http.StatusNotFound, "Backup not found", nil)
+		return
+	}
+	path := filepath.Join(ms.config.Database.BackupDir, name)
+	if _, err := os.Stat(path); err != nil {
+		ms.respondWithError(w, r, http.StatusNotFound, "Backup not found", err)
+		return
+	}
+
+	switch {
+	case action == "restore" && r.Method == http.MethodPost:
+		ms.restoreBackup(w, r, path)
+	case action == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		http.ServeFile(w, r, path)
+	case action == "" && r.Method == http.MethodDelete:
+		if err := os.Remove(path); err != nil {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to delete backup", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// restoreBackup validates a backup, saves the current database as a new
+// backup so the restore can be undone, then restores over the live database.
+func (ms *MusicServer) restoreBackup(w http.ResponseWriter, r *http.Request, path string) {
+	if _, err := database.ValidateBackup(path); err != nil {
+		if errors.Is(err, database.ErrInvalidBackup) {
+			ms.respondWithError(w, r, http.StatusUnprocessableEntity, err.Error(), err)
+		} else {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to read backup", err)
+		}
+		return
+	}
+
+	// Retention 0 here: the safety copy must not rotate out the backup
+	// being restored.
+	previous, err := ms.db.BackupToDir(ms.config.Database.BackupDir, 0)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to back up the current database", err)
+		return
+	}
+	if err := ms.db.Restore(path); err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to restore backup", err)
+		return
+	}
+
+	ms.logger.WithFields(logrus.Fields{
+		"backup":   filepath.Base(path),
+		"previous": previous.Name,
+		"user":     currentUsername(r),
+	}).Warn("Database restored from backup")
+	ms.respondJSON(w, map[string]interface{}{
+		"restored": filepath.Base(path),
+		"previous": previous,
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// roleOn returns the role of user on a playlist, given whether they are an
+// administrator and the role they were given as a collaborator ("" if
+// none). See playlistRole.
+func roleOn(user string, admin bool, playlist *models.Playlist, collaboratorRole string) string {
+	switch {
+	case admin || (playlist.Owner != "" && playlist.Owner == user):
+		return models.PlaylistRoleOwner
+	case collaboratorRole != "":
+		return collaboratorRole
+	case playlist.VisibleTo(user):
+		return models.PlaylistRoleViewer
+	}
+	return ""
+}
+
+// setPlaylistRoles sets the caller's role on each of playlists.
+func (ms *MusicServer) setPlaylistRoles(r *http.Request, playlists []models.Playlist) error {
+	user := currentUsername(r)
+	admin := ms.authService.IsAdmin(user)
+	roles, err := ms.db.GetPlaylistRoles(user)
+	if err != nil {
+		return err
+	}
+	for i := range playlists {
+		playlists[i].Role = roleOn(user, admin, &playlists[i], roles[playlists[i].ID])
+	}
+	return nil
+}
+
+// handlePlaylistCollaborators lists a playlist's collaborators (GET
+// /api/playlists/{id}/collaborators), gives a user a role on it (PUT
+// .../collaborators/{username} json role) or takes it away (DELETE
+// .../collaborators/{username}), returning the resulting collaborators.
+// Anyone who can view the playlist may list them; only its owners may
+// change them, except that collaborators may remove themselves.
+func (ms *MusicServer) handlePlaylistCollaborators(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(r.URL.Path, "/")
+	username := ""
+	if len(pathParts) >= 6 {
+		username = pathParts[5]
+	}
+	switch {
+	case r.Method == http.MethodGet && username == "":
+	case (r.Method == http.MethodPut || r.Method == http.MethodDelete) && username != "":
+	default:
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleViewer)
+	if playlist == nil {
+		return
+	}
+	user := currentUsername(r)
+	leaving := r.Method == http.MethodDelete && username == user
+	if r.Method != http.MethodGet && !leaving && !models.PlaylistRoleAllows(playlist.Role, models.PlaylistRoleOwner) {
+		ms.respondWithError(w, r, http.StatusForbidden, "Only administrators or the playlist owner can share it", nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+			return
+		}
+		if !models.IsValidPlaylistRole(req.Role) {
+			ms.respondWithValidationError(w, r, []ValidationError{{
+				Field:   "role",
+				Message: "Role must be viewer, editor or owner",
+				Code:    "INVALID_ROLE",
+			}})
+			return
+		}
+		if username == playlist.Owner {
+			ms.respondWithValidationError(w, r, []ValidationError{{
+				Field:   "username",
+				Message: "The playlist's creator always owns it",
+				Code:    "PLAYLIST_OWNER",
+			}})
+			return
+		}
+		if !ms.authService.UserExists(username) {
+			ms.respondWithError(w, r, http.StatusNotFound, "User not found", nil)
+			return
+		}
+		if err := ms.db.SetPlaylistCollaborator(playlist.ID, username, req.Role, user); err != nil {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Error updating collaborators", err)
+			return
+		}
+		ms.logger.WithFields(logrus.Fields{
+			"playlist_id":  playlist.ID,
+			"collaborator": username,
+			"role":         req.Role,
+			"user":         user,
+		}).Info("Playlist collaborator set")
+	case http.MethodDelete:
+		removed, err := ms.db.RemovePlaylistCollaborator(playlist.ID, username)
+		if err != nil {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Error updating collaborators", err)
+			return
+		}
+		if !removed {
+			ms.respondWithError(w, r, http.StatusNotFound, "Collaborator not found", nil)
+			return
+		}
+		ms.logger.WithFields(logrus.Fields{
+			"playlist_id":  playlist.ID,
+			"collaborator": username,
+			"user":         user,
+		}).Info("Playlist collaborator removed")
+	}
+
+	collaborators, err := ms.db.GetPlaylistCollaborators(playlist.ID)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving collaborators", err)
+		return
+	}
+	if collaborators == nil {
+		collaborators = []models.PlaylistCollaborator{}
+	}
+	ms.respondJSON(w, collaborators)
+}
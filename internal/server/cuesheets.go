@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// streamCueTrack serves a cue sheet track cut from its source file. Formats
+// metadata.OpenSegment cannot cut are transcoded to FLAC with ffmpeg when it
+// is installed; those responses do not support Range requests.
+func (ms *MusicServer) streamCueTrack(w http.ResponseWriter, r *http.Request, track *models.Track) {
+	source := track.AudioPath()
+	if validationErr := ms.validateFilePath(source); validationErr != nil {
+		ms.respondWithValidationError(w, r, []ValidationError{*validationErr})
+		return
+	}
+
+	segment, err := metadata.OpenSegment(source, track.CueStart, track.CueEnd)
+	if errors.Is(err, metadata.ErrSegmentUnsupported) {
+		ms.transcodeCueTrack(w, r, track)
+		return
+	}
+	if err != nil {
+		ms.logger.WithError(err).WithField("file_path", source).Error("Error opening audio segment")
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error opening audio file", err)
+		return
+	}
+	defer segment.Close()
+
+	ms.logger.WithFields(logrus.Fields{
+		"track_id": track.ID,
+		"artist":   track.Artist,
+		"title":    track.Title,
+	}).Info("Streaming cue track")
+
+	w.Header().Set("Content-Type", segment.ContentType)
+	http.ServeContent(w, r, "", time.Time{}, segment)
+}
+
+// transcodeCueTrack streams a cue track through ffmpeg as FLAC.
+func (ms *MusicServer) transcodeCueTrack(w http.ResponseWriter, r *http.Request, track *models.Track) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusUnsupportedMediaType, "Streaming cue tracks from this format requires ffmpeg", err)
+		return
+	}
+
+	args := []string{"-v", "error", "-ss", cueTimestamp(track.CueStart), "-i", track.AudioPath()}
+	if track.CueEnd > 0 {
+		args = append(args, "-t", cueTimestamp(track.CueEnd-track.CueStart))
+	}
+	args = append(args, "-map", "0:a:0", "-f", "flac", "pipe:1")
+
+	w.Header().Set("Content-Type", "audio/flac")
+	w.Header().Set("Accept-Ranges", "none")
+	cmd := exec.CommandContext(r.Context(), ffmpeg, args...)
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil && r.Context().Err() == nil {
+		ms.logger.WithError(err).WithField("track_id", track.ID).Error("Error transcoding cue track")
+	}
+}
+
+// cueTimestamp formats CD frames as seconds for ffmpeg.
+func cueTimestamp(frames int) string {
+	return fmt.Sprintf("%.3f", float64(frames)/metadata.CueFramesPerSecond)
+}
@@ -54,7 +54,7 @@ func (ms *MusicServer) handleDownloadMusic(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Start download
-	job, err := ms.downloader.DownloadFromURL(req.URL, req.Title, req.Artist)
+	job, err := ms.downloader.DownloadFromURL(req.URL, req.Title, req.Artist, currentUsername(r))
 	if err != nil {
 		response := map[string]interface{}{
 			"error": fmt.Sprintf("Failed to start download: %v", err),
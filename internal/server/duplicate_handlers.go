@@ -0,0 +1,199 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"staccato/internal/database"
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+)
+
+const (
+	defaultDuplicateThreshold = 0.8
+	duplicateMaxShiftSeconds  = 15
+	duplicateDurationSlack    = 20 // seconds of length difference tolerated between copies
+)
+
+// DuplicateCandidate describes one copy within a duplicate group.
+type DuplicateCandidate struct {
+	Track       models.Track `json:"track"`
+	Path        string       `json:"path"`
+	Format      string       `json:"format"`
+	Lossless    bool         `json:"lossless"`
+	BitrateKbps int          `json:"bitrateKbps"`
+	Similarity  float64      `json:"similarity"` // best score against another group member
+	Keep        bool         `json:"keep"`
+}
+
+// DuplicateGroup is a set of tracks that are likely the same recording.
+type DuplicateGroup struct {
+	Tracks      []DuplicateCandidate `json:"tracks"`
+	Similarity  float64              `json:"similarity"` // lowest linking score within the group
+	KeepTrackID int                  `json:"keepTrackId"`
+}
+
+// handleGetDuplicates groups acoustically similar tracks (admin only).
+// Optional ?threshold= sets the minimum similarity (0.5-1, default 0.8).
+func (ms *MusicServer) handleGetDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	if !ms.requireAdmin(w, r) {
+		return
+	}
+
+	threshold := defaultDuplicateThreshold
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed < 0.5 || parsed > 1 {
+			ms.respondWithValidationError(w, r, []ValidationError{{
+				Field:   "threshold",
+				Message: "Threshold must be a number between 0.5 and 1",
+				Code:    "INVALID_THRESHOLD",
+			}})
+			return
+		}
+		threshold = parsed
+	}
+
+	fingerprints, err := ms.db.GetAllFingerprints()
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error loading fingerprints", err)
+		return
+	}
+	pending, err := ms.db.CountPendingFingerprints()
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error counting pending fingerprints", err)
+		return
+	}
+
+	ms.respondJSON(w, map[string]interface{}{
+		"groups":        ms.findDuplicateGroups(fingerprints, threshold),
+		"threshold":     threshold,
+		"fingerprinted": len(fingerprints),
+		"pending":       pending,
+		"enabled":       ms.config.Music.Fingerprinting,
+	})
+}
+
+// findDuplicateGroups compares fingerprints of tracks with similar durations
+// and joins matching pairs into groups (union-find). Input must be ordered by
+// duration.
+func (ms *MusicServer) findDuplicateGroups(fps []database.TrackFingerprint, threshold float64) []DuplicateGroup {
+	decoded := make([][]uint32, len(fps))
+	for i := range fps {
+		decoded[i] = metadata.DecodeFingerprint(fps[i].Fingerprint)
+	}
+
+	parent := make([]int, len(fps))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	maxShift := int(math.Round(duplicateMaxShiftSeconds * metadata.FingerprintFramesPerSecond))
+	best := make([]float64, len(fps))
+	linkMin := make(map[int]float64)
+	for i := range fps {
+		for j := i + 1; j < len(fps); j++ {
+			if fps[j].Track.Duration-fps[i].Track.Duration > duplicateDurationSlack {
+				break
+			}
+			score := metadata.CompareFingerprints(decoded[i], decoded[j], maxShift)
+			if score < threshold {
+				continue
+			}
+			if score > best[i] {
+				best[i] = score
+			}
+			if score > best[j] {
+				best[j] = score
+			}
+			ri, rj := find(i), find(j)
+			minScore := score
+			for _, root := range []int{ri, rj} {
+				if v, ok := linkMin[root]; ok && v < minScore {
+					minScore = v
+				}
+			}
+			if ri != rj {
+				parent[rj] = ri
+				delete(linkMin, rj)
+			}
+			linkMin[ri] = minScore
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range fps {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	var groups []DuplicateGroup
+	for root, idxs := range members {
+		if len(idxs) < 2 {
+			continue
+		}
+		group := DuplicateGroup{Similarity: linkMin[root]}
+		keepIdx := -1
+		keepScore := -1
+		for _, i := range idxs {
+			cand := ms.duplicateCandidate(fps[i].Track, best[i])
+			score := qualityScore(cand)
+			if score > keepScore || (score == keepScore && cand.Track.FileSize > group.Tracks[keepIdx].Track.FileSize) {
+				keepScore = score
+				keepIdx = len(group.Tracks)
+			}
+			group.Tracks = append(group.Tracks, cand)
+		}
+		group.Tracks[keepIdx].Keep = true
+		group.KeepTrackID = group.Tracks[keepIdx].Track.ID
+		sort.SliceStable(group.Tracks, func(a, b int) bool {
+			return qualityScore(group.Tracks[a]) > qualityScore(group.Tracks[b])
+		})
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(a, b int) bool {
+		return groups[a].Similarity > groups[b].Similarity
+	})
+	return groups
+}
+
+// duplicateCandidate derives format and bitrate details for a track.
+func (ms *MusicServer) duplicateCandidate(track models.Track, similarity float64) DuplicateCandidate {
+	bitrate := 0
+	if track.Duration > 0 {
+		bitrate = int(track.FileSize * 8 / int64(track.Duration) / 1000)
+	}
+	return DuplicateCandidate{
+		Track:       track,
+		Path:        filepath.ToSlash(track.FilePath),
+		Format:      strings.TrimPrefix(strings.ToLower(filepath.Ext(track.FilePath)), "."),
+		Lossless:    metadata.IsLosslessFormat(track.FilePath),
+		BitrateKbps: bitrate,
+		Similarity:  similarity,
+	}
+}
+
+// qualityScore ranks copies: lossless first, then by average bitrate.
+func qualityScore(c DuplicateCandidate) int {
+	score := c.BitrateKbps
+	if c.Lossless {
+		score += 1000000
+	}
+	return score
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	fingerprintBatchSize    = 50
+	fingerprintIdleInterval = 5 * time.Minute
+)
+
+// startFingerprinter launches the background job that computes acoustic
+// fingerprints for tracks lacking one. It runs until shutdown.
+func (ms *MusicServer) startFingerprinter() {
+	workers := ms.config.Music.FingerprintWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	go func() {
+		ms.logger.WithField("workers", workers).Info("Fingerprint job started")
+		for {
+			processed := ms.fingerprintBatch(workers)
+			if processed > 0 {
+				continue
+			}
+			select {
+			case <-ms.shutdownCh:
+				return
+			case <-time.After(fingerprintIdleInterval):
+			}
+		}
+	}()
+}
+
+// fingerprintBatch fingerprints the next batch of pending tracks and returns
+// how many fingerprints (or recorded failures) were saved. Tracks whose
+// result could not be saved stay pending, so a batch saving none makes the
+// job wait instead of retrying them at once.
+func (ms *MusicServer) fingerprintBatch(workers int) int {
+	tracks, err := ms.db.GetTracksNeedingFingerprint(fingerprintBatchSize)
+	if err != nil {
+		ms.logger.WithError(err).Error("Failed to load tracks for fingerprinting")
+		return 0
+	}
+	if len(tracks) == 0 {
+		return 0
+	}
+
+	jobs := make(chan models.Track)
+	var saved int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for track := range jobs {
+				if ms.fingerprintTrack(track) {
+					atomic.AddInt64(&saved, 1)
+				}
+			}
+		}()
+	}
+
+	for _, track := range tracks {
+		select {
+		case <-ms.shutdownCh:
+			close(jobs)
+			wg.Wait()
+			return 0
+		case jobs <- track:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return int(saved)
+}
+
+// fingerprintTrack computes and stores the fingerprint of a single track,
+// recording failures so they are not retried until the file changes. It
+// reports whether the result was saved.
+func (ms *MusicServer) fingerprintTrack(track models.Track) bool {
+	fp, err := metadata.ComputeFingerprint(track.FilePath)
+	if err != nil {
+		ms.logger.WithError(err).WithField("file_path", track.FilePath).Debug("Could not fingerprint track")
+		return ms.db.SaveFingerprint(track.ID, track.FileSize, nil, err.Error()) == nil
+	}
+
+	if err := ms.db.SaveFingerprint(track.ID, track.FileSize, metadata.EncodeFingerprint(fp), ""); err != nil {
+		return false
+	}
+	ms.logger.WithFields(logrus.Fields{
+		"track_id": track.ID,
+		"frames":   len(fp),
+	}).Debug("Fingerprinted track")
+	return true
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"staccato/internal/database"
+	"staccato/pkg/models"
+)
+
+// canModifyFolder reports whether the caller may change a playlist folder
+// or file things in it: administrators may change any folder, other users
+// only their own.
+func (ms *MusicServer) canModifyFolder(r *http.Request, folder *models.PlaylistFolder) bool {
+	user := currentUsername(r)
+	return ms.authService.IsAdmin(user) || folder.Owner == user
+}
+
+// loadFolder returns playlist folder id if the caller may view it or, with
+// modify, change it. Folders the caller cannot see are reported as not
+// found. On failure the error response is written and nil returned.
+func (ms *MusicServer) loadFolder(w http.ResponseWriter, r *http.Request, id int, modify bool) *models.PlaylistFolder {
+	folder, err := ms.db.GetPlaylistFolder(id)
+	if errors.Is(err, database.ErrFolderNotFound) {
+		ms.respondWithError(w, r, http.StatusNotFound, "Folder not found", err)
+		return nil
+	}
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving folder", err)
+		return nil
+	}
+
+	canModify := ms.canModifyFolder(r, folder)
+	if !canModify && !folder.VisibleTo(currentUsername(r)) {
+		ms.respondWithError(w, r, http.StatusNotFound, "Folder not found", nil)
+		return nil
+	}
+	if modify && !canModify {
+		ms.respondWithError(w, r, http.StatusForbidden, "Only administrators or the folder owner can change it", nil)
+		return nil
+	}
+	return folder
+}
+
+// respondFolderError reports a failed folder change: not found for a
+// missing folder, a validation error for an invalid change, a server error
+// otherwise.
+func (ms *MusicServer) respondFolderError(w http.ResponseWriter, r *http.Request, field string, err error) {
+	switch {
+	case errors.Is(err, database.ErrFolderNotFound):
+		ms.respondWithError(w, r, http.StatusNotFound, "Folder not found", err)
+	case errors.Is(err, database.ErrInvalidFolderChange):
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   field,
+			Message: err.Error(),
+			Code:    "INVALID_FOLDER_CHANGE",
+		}})
+	default:
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error updating folder", err)
+	}
+}
+
+// invalidFolderVisibility rejects a folder visibility other than private,
+// shared, public or inherit.
+var invalidFolderVisibility = ValidationError{
+	Field:   "visibility",
+	Message: "Visibility must be private, shared, public or inherit",
+	Code:    "INVALID_VISIBILITY",
+}
+
+// isValidFolderVisibility reports whether v is a playlist visibility or
+// FolderVisibilityInherit.
+func isValidFolderVisibility(v string) bool {
+	return models.IsValidVisibility(v) || v == models.FolderVisibilityInherit
+}
+
+// handlePlaylistFolders lists the caller's folders and public ones (GET;
+// ?scope=all for every user's, administrators only) or creates a folder
+// (POST json name/parentId/visibility). A folder created in another is
+// its owner's; otherwise it is the caller's.
+func (ms *MusicServer) handlePlaylistFolders(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if folders := ms.listedFolders(w, r); folders != nil {
+			ms.respondJSON(w, folders)
+		}
+		return
+	case http.MethodPost:
+	default:
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	var req struct {
+		Name       string `json:"name"`
+		ParentID   int    `json:"parentId"`
+		Visibility string `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if req.Name == "" {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Folder name is required", nil)
+		return
+	}
+	if req.Visibility == "" {
+		req.Visibility = defaultVisibility(r)
+		if req.ParentID != 0 {
+			req.Visibility = models.FolderVisibilityInherit
+		}
+	}
+	if !isValidFolderVisibility(req.Visibility) {
+		ms.respondWithValidationError(w, r, []ValidationError{invalidFolderVisibility})
+		return
+	}
+
+	owner := currentUsername(r)
+	if req.ParentID != 0 {
+		parent := ms.loadFolder(w, r, req.ParentID, true)
+		if parent == nil {
+			return
+		}
+		owner = parent.Owner
+	}
+	id, err := ms.db.CreatePlaylistFolder(req.Name, owner, req.Visibility, req.ParentID)
+	if err != nil {
+		ms.respondFolderError(w, r, "parentId", err)
+		return
+	}
+
+	ms.respondJSON(w, map[string]interface{}{
+		"id":      id,
+		"message": "Folder created successfully",
+	})
+}
+
+// listedFolders returns the folders listed for the caller: their own and
+// public ones, or every folder for an administrator passing ?scope=all. On
+// failure the error response is written and nil returned.
+func (ms *MusicServer) listedFolders(w http.ResponseWriter, r *http.Request) []models.PlaylistFolder {
+	var folders []models.PlaylistFolder
+	var err error
+	if r.URL.Query().Get("scope") == "all" {
+		if !ms.requireAdmin(w, r) {
+			return nil
+		}
+		folders, err = ms.db.GetAllPlaylistFolders()
+	} else {
+		folders, err = ms.db.GetPlaylistFoldersVisibleTo(currentUsername(r))
+	}
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving folders", err)
+		return nil
+	}
+	if folders == nil {
+		folders = []models.PlaylistFolder{}
+	}
+	return folders
+}
+
+// handlePlaylistFolder renames a folder or sets its visibility (PUT json
+// name/visibility, either may be empty to keep it), deletes it (DELETE),
+// moving what it holds into its parent, or moves it (POST
+// /api/playlists/folders/{id}/move json parentId/position).
+func (ms *MusicServer) handlePlaylistFolder(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(r.URL.Path, "/")
+	if len(pathParts) < 5 {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid folder ID", nil)
+		return
+	}
+	id, err := strconv.Atoi(pathParts[4])
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid folder ID", err)
+		return
+	}
+	move := len(pathParts) >= 6 && pathParts[5] == "move"
+	switch {
+	case move && r.Method == http.MethodPost:
+	case !move && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+	default:
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	folder := ms.loadFolder(w, r, id, true)
+	if folder == nil {
+		return
+	}
+
+	switch {
+	case move:
+		var req struct {
+			ParentID int  `json:"parentId"`
+			Position *int `json:"position"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+			return
+		}
+		if req.ParentID != 0 && ms.loadFolder(w, r, req.ParentID, true) == nil {
+			return
+		}
+		if err := ms.db.MovePlaylistFolder(folder.ID, req.ParentID, req.Position); err != nil {
+			ms.respondFolderError(w, r, "parentId", err)
+			return
+		}
+		ms.respondJSON(w, map[string]string{"message": "Folder moved"})
+	case r.Method == http.MethodPut:
+		var req struct {
+			Name       string `json:"name"`
+			Visibility string `json:"visibility"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+			return
+		}
+		if req.Visibility != "" && !isValidFolderVisibility(req.Visibility) {
+			ms.respondWithValidationError(w, r, []ValidationError{invalidFolderVisibility})
+			return
+		}
+		if err := ms.db.UpdatePlaylistFolder(folder.ID, req.Name, req.Visibility); err != nil {
+			ms.respondFolderError(w, r, "visibility", err)
+			return
+		}
+		ms.respondJSON(w, map[string]string{"message": "Folder updated successfully"})
+	default:
+		if err := ms.db.DeletePlaylistFolder(folder.ID); err != nil {
+			ms.respondFolderError(w, r, "", err)
+			return
+		}
+		ms.respondJSON(w, map[string]string{"message": "Folder deleted"})
+	}
+}
+
+// handleMovePlaylist files a playlist in a folder, or takes it out of any
+// with folderId 0 (POST /api/playlists/{id}/move json folderId/position).
+// The caller must own the playlist and be able to change the folder, which
+// must have the playlist's owner.
+func (ms *MusicServer) handleMovePlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	// Filing a file-backed playlist leaves its file alone, so the
+	// read-only check of owner-level changes does not apply
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleViewer)
+	if playlist == nil {
+		return
+	}
+	if !models.PlaylistRoleAllows(playlist.Role, models.PlaylistRoleOwner) {
+		ms.respondWithError(w, r, http.StatusForbidden, "Only administrators or the playlist owner can change it", nil)
+		return
+	}
+
+	var req struct {
+		FolderID int  `json:"folderId"`
+		Position *int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if req.FolderID != 0 && ms.loadFolder(w, r, req.FolderID, true) == nil {
+		return
+	}
+	if err := ms.db.MovePlaylist(playlist.ID, req.FolderID, req.Position); err != nil {
+		ms.respondFolderError(w, r, "folderId", err)
+		return
+	}
+	ms.respondJSON(w, map[string]string{"message": "Playlist moved"})
+}
+
+// sortPlaylistsByPosition orders playlists by their position in their
+// folder, keeping the order of those at the same position.
+func sortPlaylistsByPosition(playlists []models.Playlist) {
+	sort.SliceStable(playlists, func(i, j int) bool { return playlists[i].Position < playlists[j].Position })
+}
+
+// playlistTree nests playlists and folders into a tree. Folders and
+// playlists whose folder is not among folders are put at the top level.
+func playlistTree(folders []models.PlaylistFolder, playlists []models.Playlist) models.PlaylistFolderNode {
+	listed := make(map[int]bool, len(folders))
+	for _, f := range folders {
+		listed[f.ID] = true
+	}
+	childFolders := map[int][]models.PlaylistFolder{}
+	for _, f := range folders {
+		parent := f.ParentID
+		if !listed[parent] {
+			parent = 0
+		}
+		childFolders[parent] = append(childFolders[parent], f)
+	}
+	sortPlaylistsByPosition(playlists)
+	childPlaylists := map[int][]models.Playlist{}
+	for _, p := range playlists {
+		folder := p.FolderID
+		if !listed[folder] {
+			folder = 0
+		}
+		childPlaylists[folder] = append(childPlaylists[folder], p)
+	}
+
+	var build func(folder *models.PlaylistFolder) models.PlaylistFolderNode
+	build = func(folder *models.PlaylistFolder) models.PlaylistFolderNode {
+		id := 0
+		if folder != nil {
+			id = folder.ID
+		}
+		node := models.PlaylistFolderNode{
+			Folder:    folder,
+			Folders:   []models.PlaylistFolderNode{},
+			Playlists: childPlaylists[id],
+		}
+		if node.Playlists == nil {
+			node.Playlists = []models.Playlist{}
+		}
+		for i := range childFolders[id] {
+			node.Folders = append(node.Folders, build(&childFolders[id][i]))
+		}
+		return node
+	}
+	return build(nil)
+}
+
+// respondFolderContents writes what is directly in the folder named by the
+// folder query parameter, or at the top level of the caller's playlist
+// tree for folder 0.
+func (ms *MusicServer) respondFolderContents(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("folder"))
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid folder ID", err)
+		return
+	}
+
+	contents := models.PlaylistFolderContents{Folders: []models.PlaylistFolder{}}
+	if id == 0 {
+		playlists := ms.listedPlaylists(w, r)
+		if playlists == nil {
+			return
+		}
+		folders := ms.listedFolders(w, r)
+		if folders == nil {
+			return
+		}
+		root := playlistTree(folders, playlists)
+		for _, node := range root.Folders {
+			contents.Folders = append(contents.Folders, *node.Folder)
+		}
+		contents.Playlists = root.Playlists
+		ms.respondJSON(w, contents)
+		return
+	}
+
+	if contents.Folder = ms.loadFolder(w, r, id, false); contents.Folder == nil {
+		return
+	}
+	children, err := ms.db.GetPlaylistFolderChildren(id)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving folders", err)
+		return
+	}
+	user := currentUsername(r)
+	admin := ms.authService.IsAdmin(user)
+	for _, child := range children {
+		if admin || child.VisibleTo(user) {
+			contents.Folders = append(contents.Folders, child)
+		}
+	}
+
+	inFolder, err := ms.db.GetPlaylistsInFolder(id)
+	if err == nil {
+		err = ms.setPlaylistRoles(r, inFolder)
+	}
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving playlists", err)
+		return
+	}
+	contents.Playlists = []models.Playlist{}
+	for _, p := range inFolder {
+		if p.Role != "" {
+			contents.Playlists = append(contents.Playlists, p)
+		}
+	}
+	ms.countSmartPlaylists(r, contents.Playlists)
+	sortPlaylistsByPosition(contents.Playlists)
+	ms.respondJSON(w, contents)
+}
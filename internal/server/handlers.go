@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 
+	"staccato/internal/database"
 	"staccato/pkg/models"
 
 	"github.com/sirupsen/logrus"
@@ -29,8 +30,14 @@ func (ms *MusicServer) handleHome(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, filepath.Join(ms.config.Server.StaticDir, "index.html"))
 }
 
-// handleGetTracks returns tracks optionally filtered (search) or sorted.
+// handleGetTracks returns tracks optionally filtered (search, artist) or
+// sorted.
 func (ms *MusicServer) handleGetTracks(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("artist") != "" {
+		ms.handleGetArtistTracks(w, r)
+		return
+	}
+
 	// Validate search query if provided
 	searchQuery := r.URL.Query().Get("search")
 	if searchQuery != "" {
@@ -90,37 +97,38 @@ func (ms *MusicServer) handleGetTracks(w http.ResponseWriter, r *http.Request) {
 
 // handleGetTrackCount responds with a JSON count of all tracks.
 func (ms *MusicServer) handleGetTrackCount(w http.ResponseWriter, r *http.Request) {
-	var tracks []models.Track
-	var err error
+	count, err := ms.db.CountTracks(ms.libraryScope(r))
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving track count", err)
+		return
+	}
 
-	// Check if auth and user folders are both enabled and get current user
+	response := map[string]int{"count": count}
+	ms.respondJSON(w, response)
+}
+
+// libraryScope determines which tracks the caller sees:
+// - If auth is enabled AND user folders are enabled AND we have a current user: the user's tracks
+// - Otherwise: only main library tracks (excludes user tracks)
+func (ms *MusicServer) libraryScope(r *http.Request) database.StatsScope {
 	authService := ms.authService
 	userFolderManager := authService.GetUserFolderManager()
-	var currentUser string
 	if authService.IsEnabled() && userFolderManager.IsEnabled() {
-		if user := r.Context().Value(UserContextKey); user != nil {
-			currentUser = user.(string)
+		if user := currentUsername(r); user != "" {
+			return database.StatsScope{Owner: user}
 		}
 	}
+	return database.StatsScope{}
+}
 
-	// Determine which data source to use:
-	// - If auth is enabled AND user folders are enabled AND we have a current user: show user's tracks
-	// - Otherwise: show only main library tracks (excludes user tracks)
-	showUserTracks := authService.IsEnabled() && userFolderManager.IsEnabled() && currentUser != ""
-
-	if showUserTracks {
-		tracks, err = ms.db.GetTracksByOwner(currentUser)
-	} else {
-		tracks, err = ms.db.GetMainLibraryTracks()
+// accessibleTrack returns a track the caller may play: with auth and user
+// folders enabled only the user's own tracks, otherwise only main library
+// tracks.
+func (ms *MusicServer) accessibleTrack(r *http.Request, trackID int) (*models.Track, error) {
+	if scope := ms.libraryScope(r); scope.Owner != "" {
+		return ms.db.GetTrackByIDForOwner(trackID, scope.Owner)
 	}
-
-	if err != nil {
-		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving track count", err)
-		return
-	}
-
-	response := map[string]int{"count": len(tracks)}
-	ms.respondJSON(w, response)
+	return ms.db.GetMainLibraryTrackByID(trackID)
 }
 
 // handleStreamTrack streams an individual track by ID with Range support.
@@ -133,32 +141,21 @@ func (ms *MusicServer) handleStreamTrack(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Check if auth and user folders are both enabled and get current user
-	authService := ms.authService
-	userFolderManager := authService.GetUserFolderManager()
-	var currentUser string
-	if authService.IsEnabled() && userFolderManager.IsEnabled() {
-		if user := r.Context().Value(UserContextKey); user != nil {
-			currentUser = user.(string)
-		}
+	track, err := ms.accessibleTrack(r, trackID)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusNotFound, "Track not found", err)
+		return
 	}
 
-	// Determine which data source to use:
-	// - If auth is enabled AND user folders are enabled AND we have a current user: show user's tracks
-	// - Otherwise: show only main library tracks (excludes user tracks)
-	showUserTracks := authService.IsEnabled() && userFolderManager.IsEnabled() && currentUser != ""
-
-	// Get track from database (with ownership check if user folders enabled)
-	var track *models.Track
-	var err error
-	if showUserTracks {
-		track, err = ms.db.GetTrackByIDForOwner(trackID, currentUser)
-	} else {
-		track, err = ms.db.GetMainLibraryTrackByID(trackID)
+	// Count a play when a client starts the track, not for every seek
+	if r.Method == http.MethodGet && isPlayStart(r.Header.Get("Range")) {
+		if err := ms.db.RecordPlay(track.ID); err != nil {
+			ms.logger.WithError(err).WithField("track_id", trackID).Warn("Error recording play")
+		}
 	}
 
-	if err != nil {
-		ms.respondWithError(w, r, http.StatusNotFound, "Track not found", err)
+	if track.IsVirtual() {
+		ms.streamCueTrack(w, r, track)
 		return
 	}
 
@@ -216,6 +213,12 @@ func (ms *MusicServer) handleStreamTrack(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// isPlayStart reports whether a stream request with the given Range header
+// starts at the beginning of the track.
+func isPlayStart(rangeHeader string) bool {
+	return rangeHeader == "" || strings.HasPrefix(strings.TrimSpace(rangeHeader), "bytes=0-")
+}
+
 // handleRangeRequest implements simple single-range byte serving for seeking.
 func (ms *MusicServer) handleRangeRequest(w http.ResponseWriter, _ *http.Request, file *os.File, fileSize int64, rangeHeader string) {
 	// Parse range header (e.g., "bytes=0-1023")
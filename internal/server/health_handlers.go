@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"time"
+
+	"staccato/internal/database"
 )
 
 // HealthStatus represents operational status for the /health endpoint.
@@ -44,11 +46,11 @@ func (ms *MusicServer) handleHealthCheck(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Get track count
-	tracks, err := ms.db.GetAllTracks()
+	count, err := ms.db.CountTracks(database.StatsScope{All: true})
 	if err != nil {
 		health.Details["track_count_error"] = err.Error()
 	} else {
-		health.Tracks = len(tracks)
+		health.Tracks = count
 	}
 
 	// Set appropriate HTTP status code
@@ -62,14 +64,14 @@ func (ms *MusicServer) handleHealthCheck(w http.ResponseWriter, r *http.Request)
 // checkDatabaseHealth performs a trivial query to validate DB access.
 func (ms *MusicServer) checkDatabaseHealth() error {
 	// Try a simple query to check database connectivity
-	_, err := ms.db.GetAllTracks()
+	_, err := ms.db.CountTracks(database.StatsScope{All: true})
 	return err
 }
 
 // checkStorageHealth validates storage accessibility (placeholder for future enhancements).
 func (ms *MusicServer) checkStorageHealth() error {
 	// Check if music library path exists and is accessible
-	_, err := ms.db.GetAllTracks()
+	_, err := ms.db.CountTracks(database.StatsScope{All: true})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"staccato/internal/library"
+	"staccato/internal/metadata"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OrganizeRequest optionally overrides the configured naming template.
+type OrganizeRequest struct {
+	Template string `json:"template"`
+}
+
+// organizerFor builds an organizer for template, defaulting to the
+// configured one. It writes a validation error and returns nil if the
+// template is missing or invalid.
+func (ms *MusicServer) organizerFor(w http.ResponseWriter, r *http.Request, template string) *library.Organizer {
+	if template == "" {
+		template = ms.config.Music.OrganizeTemplate
+	}
+	if template == "" {
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "template",
+			Message: "A naming template is required",
+			Code:    "MISSING_TEMPLATE",
+		}})
+		return nil
+	}
+	t, err := metadata.ParseNamingTemplate(template)
+	if err != nil {
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "template",
+			Message: err.Error(),
+			Code:    "INVALID_TEMPLATE",
+		}})
+		return nil
+	}
+	return library.NewOrganizer(ms.ingestor, ms.roots, t)
+}
+
+// handlePreviewOrganize lists the moves organizing the library would make,
+// without changing anything (admin only). ?template= overrides the
+// configured naming template.
+func (ms *MusicServer) handlePreviewOrganize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	if !ms.requireAdmin(w, r) {
+		return
+	}
+	organizer := ms.organizerFor(w, r, r.URL.Query().Get("template"))
+	if organizer == nil {
+		return
+	}
+
+	tracks, err := ms.db.GetAllTracks()
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error loading tracks", err)
+		return
+	}
+	moves := organizer.Plan(tracks)
+	conflicts := 0
+	for _, move := range moves {
+		if move.Conflict != "" {
+			conflicts++
+		}
+	}
+	ms.respondJSON(w, map[string]interface{}{
+		"moves":     moves,
+		"total":     len(moves),
+		"conflicts": conflicts,
+	})
+}
+
+// handleApplyOrganize moves library files to their templated paths (admin
+// only). The plan is recomputed, so it reflects the library at the time of
+// the request rather than of an earlier preview.
+func (ms *MusicServer) handleApplyOrganize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	if !ms.requireAdmin(w, r) {
+		return
+	}
+	var req OrganizeRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxTagRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	organizer := ms.organizerFor(w, r, req.Template)
+	if organizer == nil {
+		return
+	}
+
+	// Tag rewrites go through temporary files next to the original, so
+	// files must not move underneath them
+	ms.tagWriteMu.Lock()
+	defer ms.tagWriteMu.Unlock()
+
+	tracks, err := ms.db.GetAllTracks()
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error loading tracks", err)
+		return
+	}
+	result, err := organizer.Apply(context.Background(), organizer.Plan(tracks))
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to organize library", err)
+		return
+	}
+	ms.logger.WithFields(logrus.Fields{
+		"moved": result.Moved,
+		"user":  currentUsername(r),
+	}).Info("Library organize applied")
+	ms.respondJSON(w, result)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"staccato/internal/database"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// overrideTrack resolves the track addressed by /api/tracks/{id}/overrides
+// and checks that the caller may change its metadata. It writes the error
+// response and returns nil on failure.
+func (ms *MusicServer) overrideTrack(w http.ResponseWriter, r *http.Request) *models.Track {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	trackID, validationErr := ms.validateTrackID(pathParts, 4)
+	if validationErr != nil {
+		ms.respondWithValidationError(w, r, []ValidationError{*validationErr})
+		return nil
+	}
+	track, err := ms.db.GetTrackByID(trackID)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusNotFound, "Track not found", err)
+		return nil
+	}
+	if !ms.canEditTrack(r, track) {
+		ms.respondWithError(w, r, http.StatusForbidden, "Only administrators or the track owner can edit metadata", nil)
+		return nil
+	}
+	return track
+}
+
+// handleGetTrackOverride returns a track's metadata override (404 if none).
+func (ms *MusicServer) handleGetTrackOverride(w http.ResponseWriter, r *http.Request) {
+	track := ms.overrideTrack(w, r)
+	if track == nil {
+		return
+	}
+	override, err := ms.db.GetTrackOverride(track.ID)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error loading override", err)
+		return
+	}
+	if override == nil {
+		ms.respondWithError(w, r, http.StatusNotFound, "Track has no override", nil)
+		return
+	}
+	ms.respondJSON(w, override)
+}
+
+// handleSetTrackOverride replaces a track's metadata override without touching
+// the file. Omitted fields fall back to the file's tags.
+func (ms *MusicServer) handleSetTrackOverride(w http.ResponseWriter, r *http.Request) {
+	track := ms.overrideTrack(w, r)
+	if track == nil {
+		return
+	}
+
+	var changes TagChanges
+	r.Body = http.MaxBytesReader(w, r.Body, maxTagRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	// Empty strings mean "not overridden" rather than a blank value.
+	for _, field := range []**string{&changes.Title, &changes.Artist, &changes.Album} {
+		if *field != nil && strings.TrimSpace(**field) == "" {
+			*field = nil
+		}
+	}
+	update, validationErrs := changes.toTagUpdate()
+	if len(validationErrs) > 0 {
+		ms.respondWithValidationError(w, r, validationErrs)
+		return
+	}
+
+	override := models.TrackOverride{
+		TrackID:     track.ID,
+		Title:       update.Title,
+		Artist:      update.Artist,
+		Album:       update.Album,
+		TrackNumber: update.TrackNumber,
+		UpdatedBy:   currentUsername(r),
+	}
+	if err := ms.db.SetTrackOverride(override, update.Picture); err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to save override", err)
+		return
+	}
+
+	ms.logger.WithFields(logrus.Fields{
+		"track_id": track.ID,
+		"user":     override.UpdatedBy,
+	}).Info("Track metadata override saved")
+
+	updated, err := ms.db.GetTrackByID(track.ID)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error loading track", err)
+		return
+	}
+	ms.respondJSON(w, updated)
+}
+
+// handleDeleteTrackOverride removes a track's override so file tags apply.
+func (ms *MusicServer) handleDeleteTrackOverride(w http.ResponseWriter, r *http.Request) {
+	track := ms.overrideTrack(w, r)
+	if track == nil {
+		return
+	}
+	if err := ms.db.DeleteTrackOverride(track.ID); err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to delete override", err)
+		return
+	}
+	updated, err := ms.db.GetTrackByID(track.ID)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error loading track", err)
+		return
+	}
+	ms.respondJSON(w, updated)
+}
+
+// handleExportOverrides returns all overrides the caller may manage as JSON,
+// keyed by path relative to the library root so they can be re-applied after
+// a database rebuild. Administrators export every track; others their own.
+func (ms *MusicServer) handleExportOverrides(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	scope := database.StatsScope{All: true}
+	if user := currentUsername(r); !ms.authService.IsAdmin(user) {
+		scope = database.StatsScope{Owner: user}
+	}
+	overrides, err := ms.db.GetOverridesForExport(scope)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error exporting overrides", err)
+		return
+	}
+	for i := range overrides {
+		overrides[i].Path = ms.libraryRelativePath(overrides[i].Path, overrides[i].Owner)
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="staccato-overrides.json"`)
+	ms.respondJSON(w, map[string]interface{}{
+		"version":   1,
+		"overrides": overrides,
+	})
+}
+
+// libraryRelativePath returns filePath relative to the root it lives under
+// (the owner's music folder or the main library), using forward slashes.
+// Paths outside both roots are returned unchanged.
+func (ms *MusicServer) libraryRelativePath(filePath, owner string) string {
+	return ms.roots.Relative(filePath, owner)
+}
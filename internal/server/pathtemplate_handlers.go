@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+)
+
+const (
+	defaultTemplatePreviewSize = 20
+	maxTemplatePreviewSize     = 200
+)
+
+// PathTemplatePreview shows what the templates infer for one file next to
+// the values currently stored for it.
+type PathTemplatePreview struct {
+	TrackID  int                     `json:"trackId"`
+	Path     string                  `json:"path"`
+	Current  models.Track            `json:"current"`
+	Matched  bool                    `json:"matched"`
+	Template string                  `json:"template,omitempty"`
+	Inferred *metadata.PathInference `json:"inferred,omitempty"`
+}
+
+// compilePathTemplates parses path templates in order.
+func compilePathTemplates(sources []string) ([]*metadata.PathTemplate, error) {
+	templates := make([]*metadata.PathTemplate, 0, len(sources))
+	for _, source := range sources {
+		t, err := metadata.ParsePathTemplate(source)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// handlePreviewPathTemplates applies path templates to a sample of library
+// files without changing anything (admin only). Templates come from repeated
+// ?template= parameters, defaulting to the configured ones; ?limit= sets the
+// sample size (default 20, max 200). Files with missing tags are sampled first.
+func (ms *MusicServer) handlePreviewPathTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	if !ms.requireAdmin(w, r) {
+		return
+	}
+
+	sources := r.URL.Query()["template"]
+	if len(sources) == 0 {
+		sources = ms.config.Music.PathTemplates
+	}
+	if len(sources) == 0 {
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "template",
+			Message: "At least one template is required",
+			Code:    "MISSING_TEMPLATE",
+		}})
+		return
+	}
+	templates, err := compilePathTemplates(sources)
+	if err != nil {
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "template",
+			Message: err.Error(),
+			Code:    "INVALID_TEMPLATE",
+		}})
+		return
+	}
+
+	limit := defaultTemplatePreviewSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxTemplatePreviewSize {
+			ms.respondWithValidationError(w, r, []ValidationError{{
+				Field:   "limit",
+				Message: "Limit must be between 1 and 200",
+				Code:    "INVALID_LIMIT",
+			}})
+			return
+		}
+		limit = parsed
+	}
+
+	tracks, err := ms.db.SampleTracks(limit)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error sampling tracks", err)
+		return
+	}
+
+	previews := make([]PathTemplatePreview, 0, len(tracks))
+	matched := 0
+	for _, track := range tracks {
+		preview := PathTemplatePreview{
+			TrackID: track.ID,
+			Path:    ms.libraryRelativePath(track.FilePath, track.Owner),
+			Current: track,
+		}
+		for _, t := range templates {
+			if inf, ok := t.Infer(preview.Path); ok {
+				preview.Matched = true
+				preview.Template = t.String()
+				preview.Inferred = &inf
+				matched++
+				break
+			}
+		}
+		previews = append(previews, preview)
+	}
+
+	ms.respondJSON(w, map[string]interface{}{
+		"templates": sources,
+		"sampled":   len(previews),
+		"matched":   matched,
+		"files":     previews,
+	})
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,48 +11,210 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"staccato/internal/database"
+	"staccato/pkg/models"
 )
 
-// handleGetPlaylists returns all playlists (with track counts) as JSON.
-func (ms *MusicServer) handleGetPlaylists(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// playlistRole returns the caller's role on a playlist: owner for its
+// owner and administrators, the role a collaborator was given, viewer for
+// anyone else when the playlist is not private, and "" when the caller may
+// not see it.
+func (ms *MusicServer) playlistRole(r *http.Request, playlist *models.Playlist) (string, error) {
+	user := currentUsername(r)
+	role, err := ms.db.GetPlaylistRole(playlist.ID, user)
+	if err != nil {
+		return "", err
+	}
+	return roleOn(user, ms.authService.IsAdmin(user), playlist, role), nil
+}
 
-	playlists, err := ms.db.GetAllPlaylists()
+// playlistFromPath loads the playlist whose ID is the fourth segment of the
+// request path, with the caller's Role, and checks the role grants need:
+// PlaylistRoleViewer to view it, PlaylistRoleEditor to change its tracks,
+// PlaylistRoleOwner to change the playlist itself. Playlists the caller
+// cannot see are reported as not found. On failure the error response is
+// written and nil returned.
+func (ms *MusicServer) playlistFromPath(w http.ResponseWriter, r *http.Request, need string) *models.Playlist {
+	pathParts := strings.Split(r.URL.Path, "/")
+	if len(pathParts) < 4 {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid playlist ID", nil)
+		return nil
+	}
+	playlistID, err := strconv.Atoi(pathParts[3])
 	if err != nil {
-		http.Error(w, "Error retrieving playlists", http.StatusInternalServerError)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid playlist ID", err)
+		return nil
+	}
+
+	playlist, err := ms.db.GetPlaylist(playlistID)
+	if errors.Is(err, database.ErrPlaylistNotFound) {
+		ms.respondWithError(w, r, http.StatusNotFound, "Playlist not found", err)
+		return nil
+	}
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving playlist", err)
+		return nil
+	}
+	if playlist.Role, err = ms.playlistRole(r, playlist); err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving playlist", err)
+		return nil
+	}
+
+	if playlist.Role == "" {
+		ms.respondWithError(w, r, http.StatusNotFound, "Playlist not found", nil)
+		return nil
+	}
+	if !models.PlaylistRoleAllows(playlist.Role, need) {
+		message := "Only administrators or the playlist owner can change it"
+		if need == models.PlaylistRoleEditor {
+			message = "Only the playlist's owners and editors can change its tracks"
+		}
+		ms.respondWithError(w, r, http.StatusForbidden, message, nil)
+		return nil
+	}
+	if need != models.PlaylistRoleViewer && playlist.FileBacked && !ms.config.Music.PlaylistWriteBack {
+		ms.respondWithError(w, r, http.StatusForbidden, "Playlist is read-only: it mirrors a playlist file", nil)
+		return nil
+	}
+	return playlist
+}
+
+// writeBackPlaylist saves an edit of a file-backed playlist to its file.
+// A failed write is logged; the edit stands and the next change of the file
+// overwrites it.
+func (ms *MusicServer) writeBackPlaylist(playlist *models.Playlist) {
+	if !playlist.FileBacked {
+		return
+	}
+	if err := ms.playlistFiles.WriteBack(playlist.ID); err != nil {
+		ms.logger.WithError(err).WithField("playlist_id", playlist.ID).Error("Error writing playlist file")
+	}
+}
+
+// handleGetPlaylists returns the caller's own playlists, those they
+// collaborate on and public ones (with track counts and the caller's role)
+// as JSON. Administrators may pass ?scope=all to list every user's
+// playlists. ?view=tree nests them in their folders; ?folder=ID returns
+// only what is directly in a folder.
+func (ms *MusicServer) handleGetPlaylists(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("folder") != "" {
+		ms.respondFolderContents(w, r)
+		return
+	}
+	switch query.Get("view") {
+	case "", "list", "tree":
+	default:
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "view",
+			Message: "View must be list or tree",
+			Code:    "INVALID_VIEW",
+		}})
+		return
+	}
+
+	playlists := ms.listedPlaylists(w, r)
+	if playlists == nil {
 		return
 	}
+	if query.Get("view") != "tree" {
+		ms.respondJSON(w, playlists)
+		return
+	}
+	folders := ms.listedFolders(w, r)
+	if folders == nil {
+		return
+	}
+	ms.respondJSON(w, playlistTree(folders, playlists))
+}
 
-	json.NewEncoder(w).Encode(playlists)
+// listedPlaylists returns the playlists listed for the caller, or every
+// playlist for an administrator passing ?scope=all, with track counts and
+// the caller's role. On failure the error response is written and nil
+// returned.
+func (ms *MusicServer) listedPlaylists(w http.ResponseWriter, r *http.Request) []models.Playlist {
+	var playlists []models.Playlist
+	var err error
+	if r.URL.Query().Get("scope") == "all" {
+		if !ms.requireAdmin(w, r) {
+			return nil
+		}
+		playlists, err = ms.db.GetAllPlaylists()
+	} else {
+		playlists, err = ms.db.GetPlaylistsVisibleTo(currentUsername(r))
+	}
+	if err == nil {
+		err = ms.setPlaylistRoles(r, playlists)
+	}
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving playlists", err)
+		return nil
+	}
+	if playlists == nil {
+		playlists = []models.Playlist{}
+	}
+	ms.countSmartPlaylists(r, playlists)
+	return playlists
+}
+
+// defaultVisibility is the visibility of playlists and folders created
+// without one: private to their creator, or public when there is no caller
+// (auth disabled). Those have no owner, so once auth is enabled private
+// ones could only be seen by administrators.
+func defaultVisibility(r *http.Request) string {
+	if currentUsername(r) == "" {
+		return models.VisibilityPublic
+	}
+	return models.VisibilityPrivate
 }
 
-// handleCreatePlaylist creates a new playlist (POST json name/description).
+// handleCreatePlaylist creates a new playlist owned by the caller (POST json
+// name/description/visibility, and rules for a smart playlist). Playlists
+// get defaultVisibility unless asked otherwise.
 func (ms *MusicServer) handleCreatePlaylist(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-
 	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
+		Name        string             `json:"name"`
+		Description string             `json:"description"`
+		Visibility  string             `json:"visibility"`
+		Rules       *models.SmartRules `json:"rules"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
 		return
 	}
 
 	if req.Name == "" {
-		http.Error(w, "Playlist name is required", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Playlist name is required", nil)
+		return
+	}
+	if req.Visibility == "" {
+		req.Visibility = defaultVisibility(r)
+	}
+	if !models.IsValidVisibility(req.Visibility) {
+		ms.respondWithValidationError(w, r, []ValidationError{invalidVisibility})
 		return
 	}
 
-	id, err := ms.db.CreatePlaylist(req.Name, req.Description)
+	var id int
+	var err error
+	if req.Rules != nil {
+		if err := database.ValidateSmartRules(*req.Rules); err != nil {
+			ms.respondWithValidationError(w, r, []ValidationError{invalidSmartRules(err)})
+			return
+		}
+		id, err = ms.db.CreateSmartPlaylist(req.Name, req.Description, currentUsername(r), req.Visibility, *req.Rules)
+	} else {
+		id, err = ms.db.CreatePlaylist(req.Name, req.Description, currentUsername(r), req.Visibility)
+	}
 	if err != nil {
-		http.Error(w, "Error creating playlist", http.StatusInternalServerError)
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error creating playlist", err)
 		return
 	}
 
@@ -59,50 +222,162 @@ func (ms *MusicServer) handleCreatePlaylist(w http.ResponseWriter, r *http.Reque
 		"id":      id,
 		"message": "Playlist created successfully",
 	}
-	json.NewEncoder(w).Encode(response)
+	ms.respondJSON(w, response)
+}
+
+// invalidVisibility rejects a visibility other than private, shared or public.
+var invalidVisibility = ValidationError{
+	Field:   "visibility",
+	Message: "Visibility must be private, shared or public",
+	Code:    "INVALID_VISIBILITY",
 }
 
-// handleGetPlaylistTracks returns tracks contained in the specified playlist.
+// handleGetPlaylistTracks returns the entries of the specified playlist:
+// its tracks in order, each with the entry ID edits refer to and who added
+// it when. Entries of smart playlists are the tracks matching its rules and
+// have no entry ID.
 func (ms *MusicServer) handleGetPlaylistTracks(w http.ResponseWriter, r *http.Request) {
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 4 {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleViewer)
+	if playlist == nil {
+		return
+	}
+	if !playlist.Smart {
+		ms.respondPlaylistEntries(w, r, playlist.ID)
 		return
 	}
 
-	playlistID, err := strconv.Atoi(pathParts[3])
+	tracks, err := ms.playlistTracks(r, playlist)
 	if err != nil {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving playlist tracks", err)
 		return
 	}
+	entries := make([]models.PlaylistEntry, len(tracks))
+	for i, track := range tracks {
+		entries[i] = models.PlaylistEntry{Track: track}
+	}
+	ms.respondJSON(w, entries)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-
-	tracks, err := ms.db.GetPlaylistTracks(playlistID)
+// respondPlaylistEntries writes a playlist's entries as JSON.
+func (ms *MusicServer) respondPlaylistEntries(w http.ResponseWriter, r *http.Request, playlistID int) {
+	entries, err := ms.db.GetPlaylistEntries(playlistID)
 	if err != nil {
-		http.Error(w, "Error retrieving playlist tracks", http.StatusInternalServerError)
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving playlist tracks", err)
 		return
 	}
+	if entries == nil {
+		entries = []models.PlaylistEntry{}
+	}
+	ms.respondJSON(w, entries)
+}
 
-	json.NewEncoder(w).Encode(tracks)
+// respondPlaylistEditError reports a failed playlist edit: a validation
+// error when the edit was invalid, a server error otherwise.
+func (ms *MusicServer) respondPlaylistEditError(w http.ResponseWriter, r *http.Request, field string, err error) {
+	if errors.Is(err, database.ErrInvalidPlaylistEdit) {
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   field,
+			Message: err.Error(),
+			Code:    "INVALID_PLAYLIST_EDIT",
+		}})
+		return
+	}
+	ms.respondWithError(w, r, http.StatusInternalServerError, "Error updating playlist", err)
 }
 
-// handleAddTrackToPlaylist appends a track to a playlist (POST json trackId).
-func (ms *MusicServer) handleAddTrackToPlaylist(w http.ResponseWriter, r *http.Request) {
+// handleEditPlaylistTracks applies a list of move/insert/remove/replace
+// operations to a playlist in one transaction (PATCH json operations) and
+// returns the resulting entries. Inserted tracks must be ones the caller
+// could stream.
+func (ms *MusicServer) handleEditPlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleEditor)
+	if playlist == nil || ms.rejectSmartPlaylistEdit(w, r, playlist) {
+		return
+	}
+
+	var req struct {
+		Operations []models.PlaylistOp `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if len(req.Operations) == 0 {
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "operations",
+			Message: "At least one operation is required",
+			Code:    "NO_OPERATIONS",
+		}})
+		return
+	}
+
+	for _, op := range req.Operations {
+		if op.TrackID == 0 {
+			continue
+		}
+		if _, err := ms.accessibleTrack(r, op.TrackID); err != nil {
+			ms.respondWithError(w, r, http.StatusNotFound, "Track not found", err)
+			return
+		}
+	}
+
+	if err := ms.db.EditPlaylist(playlist.ID, req.Operations, currentUsername(r)); err != nil {
+		ms.respondPlaylistEditError(w, r, "operations", err)
+		return
+	}
+	ms.writeBackPlaylist(playlist)
+	ms.respondPlaylistEntries(w, r, playlist.ID)
+}
+
+// handlePlaylistAction rearranges a whole playlist (POST
+// /api/playlists/{id}/{sort|shuffle|dedupe}) and returns the resulting
+// entries. sort takes json field/descending.
+func (ms *MusicServer) handlePlaylistAction(w http.ResponseWriter, r *http.Request, action string) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 4 {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleEditor)
+	if playlist == nil || ms.rejectSmartPlaylistEdit(w, r, playlist) {
 		return
 	}
 
-	playlistID, err := strconv.Atoi(pathParts[3])
+	var err error
+	switch action {
+	case "sort":
+		var req struct {
+			Field      string `json:"field"`
+			Descending bool   `json:"descending"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+			return
+		}
+		err = ms.db.SortPlaylist(playlist.ID, req.Field, req.Descending)
+	case "shuffle":
+		err = ms.db.ShufflePlaylist(playlist.ID)
+	case "dedupe":
+		_, err = ms.db.DedupePlaylist(playlist.ID)
+	}
 	if err != nil {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+		ms.respondPlaylistEditError(w, r, "field", err)
+		return
+	}
+	ms.writeBackPlaylist(playlist)
+	ms.respondPlaylistEntries(w, r, playlist.ID)
+}
+
+// handleAddTrackToPlaylist appends a track to a playlist (POST json trackId).
+// Only tracks the caller could stream may be added.
+func (ms *MusicServer) handleAddTrackToPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleEditor)
+	if playlist == nil || ms.rejectSmartPlaylistEdit(w, r, playlist) {
 		return
 	}
 
@@ -111,115 +386,115 @@ func (ms *MusicServer) handleAddTrackToPlaylist(w http.ResponseWriter, r *http.R
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
 		return
 	}
 
-	err = ms.db.AddTrackToPlaylist(playlistID, req.TrackID)
-	if err != nil {
-		http.Error(w, "Error adding track to playlist", http.StatusInternalServerError)
+	if _, err := ms.accessibleTrack(r, req.TrackID); err != nil {
+		ms.respondWithError(w, r, http.StatusNotFound, "Track not found", err)
+		return
+	}
+
+	if err := ms.db.AddTrackToPlaylist(playlist.ID, req.TrackID, currentUsername(r)); err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error adding track to playlist", err)
 		return
 	}
+	ms.writeBackPlaylist(playlist)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Track added to playlist"})
+	ms.respondJSON(w, map[string]string{"message": "Track added to playlist"})
 }
 
 // handleRemoveTrackFromPlaylist removes track from playlist (DELETE route).
 func (ms *MusicServer) handleRemoveTrackFromPlaylist(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "DELETE" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
 	pathParts := strings.Split(r.URL.Path, "/")
 	if len(pathParts) < 6 {
-		http.Error(w, "Invalid playlist or track ID", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid playlist or track ID", nil)
 		return
 	}
 
-	playlistID, err := strconv.Atoi(pathParts[3])
+	trackID, err := strconv.Atoi(pathParts[5])
 	if err != nil {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid track ID", err)
 		return
 	}
 
-	trackID, err := strconv.Atoi(pathParts[5])
-	if err != nil {
-		http.Error(w, "Invalid track ID", http.StatusBadRequest)
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleEditor)
+	if playlist == nil || ms.rejectSmartPlaylistEdit(w, r, playlist) {
 		return
 	}
 
-	err = ms.db.RemoveTrackFromPlaylist(playlistID, trackID)
+	err = ms.db.RemoveTrackFromPlaylist(playlist.ID, trackID)
 	if err != nil {
-		http.Error(w, "Error removing track from playlist", http.StatusInternalServerError)
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error removing track from playlist", err)
 		return
 	}
+	ms.writeBackPlaylist(playlist)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Track removed from playlist"})
+	ms.respondJSON(w, map[string]string{"message": "Track removed from playlist"})
 }
 
 // handleDeletePlaylist deletes a playlist (DELETE).
 func (ms *MusicServer) handleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "DELETE" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 4 {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleOwner)
+	if playlist == nil {
 		return
 	}
-
-	playlistID, err := strconv.Atoi(pathParts[3])
-	if err != nil {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+	if playlist.FileBacked {
+		ms.respondWithError(w, r, http.StatusForbidden, "Delete the playlist file to remove a file-backed playlist", nil)
 		return
 	}
 
-	err = ms.db.DeletePlaylist(playlistID)
+	err := ms.db.DeletePlaylist(playlist.ID)
 	if err != nil {
-		http.Error(w, "Error deleting playlist", http.StatusInternalServerError)
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error deleting playlist", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Playlist deleted"})
+	ms.respondJSON(w, map[string]string{"message": "Playlist deleted"})
 }
 
-// handleUpdatePlaylist updates playlist name/description and optional cover image (multipart PUT).
+// handleUpdatePlaylist updates playlist name/description/visibility and
+// optional cover image (multipart PUT). An empty visibility keeps the current
+// one; a playlist in a folder has the folder's visibility whatever its own.
 func (ms *MusicServer) handleUpdatePlaylist(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 4 {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
-	playlistID, err := strconv.Atoi(pathParts[3])
-	if err != nil {
-		http.Error(w, "Invalid playlist ID", http.StatusBadRequest)
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleOwner)
+	if playlist == nil {
 		return
 	}
+	playlistID := playlist.ID
 
 	// Parse multipart form data
-	err = r.ParseMultipartForm(32 << 20) // 32 MB max memory
+	err := r.ParseMultipartForm(32 << 20) // 32 MB max memory
 	if err != nil {
-		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Error parsing form data", err)
 		return
 	}
 
 	name := r.FormValue("name")
 	description := r.FormValue("description")
+	visibility := r.FormValue("visibility")
 
 	if name == "" {
-		http.Error(w, "Playlist name is required", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Playlist name is required", nil)
+		return
+	}
+	if visibility != "" && !models.IsValidVisibility(visibility) {
+		ms.respondWithValidationError(w, r, []ValidationError{invalidVisibility})
 		return
 	}
 
@@ -233,7 +508,7 @@ func (ms *MusicServer) handleUpdatePlaylist(w http.ResponseWriter, r *http.Reque
 		// Create covers directory if it doesn't exist
 		coversDir := filepath.Join("static", "covers")
 		if err := os.MkdirAll(coversDir, 0755); err != nil {
-			http.Error(w, "Error creating covers directory", http.StatusInternalServerError)
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Error creating covers directory", err)
 			return
 		}
 
@@ -245,32 +520,33 @@ func (ms *MusicServer) handleUpdatePlaylist(w http.ResponseWriter, r *http.Reque
 		// Save the file
 		dst, err := os.Create(coverPath)
 		if err != nil {
-			http.Error(w, "Error saving cover image", http.StatusInternalServerError)
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Error saving cover image", err)
 			return
 		}
 		defer dst.Close()
 
 		_, err = io.Copy(dst, file)
 		if err != nil {
-			http.Error(w, "Error saving cover image", http.StatusInternalServerError)
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Error saving cover image", err)
 			return
 		}
 
 		// Convert to relative path for storage in database
 		coverPath = filepath.ToSlash(coverPath) // Convert to forward slashes for consistency
 	} else if err != http.ErrMissingFile {
-		http.Error(w, "Error processing cover image", http.StatusBadRequest)
+		ms.respondWithError(w, r, http.StatusBadRequest, "Error processing cover image", err)
 		return
 	}
 
 	// Update playlist in database
-	err = ms.db.UpdatePlaylist(playlistID, name, description, coverPath)
+	err = ms.db.UpdatePlaylist(playlistID, name, description, coverPath, visibility)
 	if err != nil {
-		http.Error(w, "Error updating playlist", http.StatusInternalServerError)
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error updating playlist", err)
 		return
 	}
+	if name != playlist.Name {
+		ms.writeBackPlaylist(playlist)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	// CORS headers now applied globally via middleware.
-	json.NewEncoder(w).Encode(map[string]string{"message": "Playlist updated successfully"})
+	ms.respondJSON(w, map[string]string{"message": "Playlist updated successfully"})
 }
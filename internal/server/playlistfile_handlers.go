@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"staccato/internal/playlistfile"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxPlaylistFileSize bounds uploaded playlist files.
+const maxPlaylistFileSize = 10 << 20
+
+// unsafeFileNameChars are replaced in download file names.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9 ._-]+`)
+
+// requestBaseURL returns the scheme and host the client reached the server
+// at, honouring a TLS-terminating proxy's X-Forwarded-Proto.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// handleExportPlaylist downloads a playlist as an M3U8, PLS or XSPF file
+// (GET ?format=m3u8|pls|xspf&locations=urls|paths).
+func (ms *MusicServer) handleExportPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	format := playlistfile.M3U8
+	if f := query.Get("format"); f != "" {
+		var ok bool
+		if format, ok = playlistfile.ParseFormat(f); !ok {
+			ms.respondWithValidationError(w, r, []ValidationError{invalidPlaylistFormat})
+			return
+		}
+	}
+	var streamBase string
+	switch query.Get("locations") {
+	case "", "urls":
+		streamBase = requestBaseURL(r)
+	case "paths":
+	default:
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "locations",
+			Message: "Locations must be urls or paths",
+			Code:    "INVALID_LOCATIONS",
+		}})
+		return
+	}
+
+	playlist := ms.playlistFromPath(w, r, models.PlaylistRoleViewer)
+	if playlist == nil {
+		return
+	}
+	tracks, err := ms.playlistTracks(r, playlist)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error retrieving playlist tracks", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := ms.playlistFiles.Export(&buf, format, playlist.Name, tracks, streamBase); err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error exporting playlist", err)
+		return
+	}
+	fileName := strings.TrimSpace(unsafeFileNameChars.ReplaceAllString(playlist.Name, "_"))
+	if fileName == "" {
+		fileName = "playlist"
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+format.Extension()+`"`)
+	w.Write(buf.Bytes())
+}
+
+// invalidPlaylistFormat rejects a playlist file format other than m3u8, pls
+// or xspf.
+var invalidPlaylistFormat = ValidationError{
+	Field:   "format",
+	Message: "Format must be m3u, m3u8, pls or xspf",
+	Code:    "INVALID_FORMAT",
+}
+
+// handleImportPlaylist creates a playlist owned by the caller from an
+// uploaded M3U/M3U8, PLS or XSPF file (multipart file, optional name,
+// visibility and format) and reports the entries that matched no track.
+// Only tracks the caller could stream are matched.
+func (ms *MusicServer) handleImportPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPlaylistFileSize)
+	if err := r.ParseMultipartForm(maxPlaylistFileSize); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Error parsing form data", err)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Playlist file is required", err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Error reading playlist file", err)
+		return
+	}
+
+	format, ok := playlistfile.FormatOf(header.Filename)
+	if f := r.FormValue("format"); f != "" {
+		if format, ok = playlistfile.ParseFormat(f); !ok {
+			ms.respondWithValidationError(w, r, []ValidationError{invalidPlaylistFormat})
+			return
+		}
+	} else if !ok {
+		format = playlistfile.Sniff(data)
+	}
+
+	visibility := r.FormValue("visibility")
+	if visibility == "" {
+		visibility = defaultVisibility(r)
+	}
+	if !models.IsValidVisibility(visibility) {
+		ms.respondWithValidationError(w, r, []ValidationError{invalidVisibility})
+		return
+	}
+
+	template := models.Playlist{
+		Name:        r.FormValue("name"),
+		Description: r.FormValue("description"),
+		Owner:       currentUsername(r),
+		Visibility:  visibility,
+	}
+	report, err := ms.playlistFiles.Import(bytes.NewReader(data), format, template, header.Filename, ms.libraryScope(r))
+	if err != nil {
+		if errors.Is(err, playlistfile.ErrInvalidFile) {
+			ms.respondWithError(w, r, http.StatusBadRequest, "Invalid playlist file", err)
+		} else {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Error importing playlist", err)
+		}
+		return
+	}
+	ms.logger.WithFields(logrus.Fields{
+		"playlist_id": report.Playlist.ID,
+		"entries":     report.Entries,
+		"unmatched":   len(report.Unmatched),
+		"user":        currentUsername(r),
+	}).Info("Playlist imported")
+	ms.respondJSON(w, report)
+}
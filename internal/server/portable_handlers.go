@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"staccato/internal/library"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxLibraryImportSize bounds import documents, which carry override album
+// art inline.
+const maxLibraryImportSize = 512 << 20
+
+// handleLibraryExport returns playlists, overrides and download history as a
+// portable JSON document for moving to another instance. Admin only.
+func (ms *MusicServer) handleLibraryExport(w http.ResponseWriter, r *http.Request) {
+	if !ms.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	doc, err := ms.portable.Export()
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error exporting library", err)
+		return
+	}
+	ms.logger.WithFields(logrus.Fields{
+		"tracks":    len(doc.Tracks),
+		"playlists": len(doc.Playlists),
+		"user":      currentUsername(r),
+	}).Info("Library exported")
+
+	w.Header().Set("Content-Disposition", `attachment; filename="staccato-library.json"`)
+	ms.respondJSON(w, doc)
+}
+
+// handleLibraryImport applies a document from handleLibraryExport to this
+// library and reports what could not be linked. Admin only.
+func (ms *MusicServer) handleLibraryImport(w http.ResponseWriter, r *http.Request) {
+	if !ms.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxLibraryImportSize)
+	var doc models.LibraryExport
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid import document", err)
+		return
+	}
+
+	report, err := ms.portable.Import(&doc)
+	if err != nil {
+		if errors.Is(err, library.ErrUnsupportedExport) {
+			ms.respondWithError(w, r, http.StatusUnprocessableEntity, err.Error(), err)
+		} else {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Error importing library", err)
+		}
+		return
+	}
+	ms.logger.WithFields(logrus.Fields{
+		"tracks":    report.Tracks,
+		"unmatched": len(report.Unmatched),
+		"playlists": report.PlaylistsCreated + report.PlaylistsMerged,
+		"user":      currentUsername(r),
+	}).Info("Library imported")
+	ms.respondJSON(w, report)
+}
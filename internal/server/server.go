@@ -5,17 +5,15 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
-	"runtime"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"staccato/internal/auth"
 	"staccato/internal/config"
 	"staccato/internal/database"
 	"staccato/internal/downloader"
+	"staccato/internal/library"
 	"staccato/internal/metadata"
 	"staccato/internal/ngrok"
 
@@ -23,6 +21,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// scanProgressInterval is how many files pass between scan progress logs.
+const scanProgressInterval = 1000
+
 // Context keys for request context values
 type contextKey string
 
@@ -34,17 +35,23 @@ const (
 // service including DB access, metadata extraction, optional downloader,
 // optional ngrok tunneling, and filesystem watching.
 type MusicServer struct {
-	db           *database.Database
-	config       *config.Config
-	watcher      *fsnotify.Watcher
-	extractor    *metadata.Extractor
-	downloader   *downloader.Downloader
-	ngrokService *ngrok.Service
-	authService  *auth.Service
-	server       *http.Server
-	handler      http.Handler // root HTTP handler (router + middleware chain)
-	shutdownCh   chan struct{}
-	logger       *logrus.Logger
+	db            *database.Database
+	config        *config.Config
+	watcher       *fsnotify.Watcher
+	extractor     *metadata.Extractor
+	ingestor      *library.Ingestor
+	roots         *library.Roots
+	portable      *library.Portable
+	playlistFiles *library.PlaylistFiles
+	trash         *library.Trash
+	downloader    *downloader.Downloader
+	ngrokService  *ngrok.Service
+	authService   *auth.Service
+	server        *http.Server
+	handler       http.Handler // root HTTP handler (router + middleware chain)
+	shutdownCh    chan struct{}
+	logger        *logrus.Logger
+	tagWriteMu    sync.Mutex // serialises tag rewrites of library files
 }
 
 // NewMusicServer constructs a MusicServer with optional components (downloader,
@@ -109,21 +116,51 @@ func NewMusicServer(cfg *config.Config, db *database.Database) (*MusicServer, er
 		logger:       logger,
 	}
 
+	// Compile path templates (already validated with the config)
+	templates, err := compilePathTemplates(cfg.Music.PathTemplates)
+	if err != nil {
+		return nil, err
+	}
+	// Templates match paths relative to the library root of each file
+	server.roots = library.NewRoots(authSvc.GetUserFolderManager(), cfg.Music.LibraryPath)
+	server.extractor.SetPathTemplates(templates, func(filePath string) string {
+		return server.roots.Relative(filePath, server.roots.OwnerOf(filePath))
+	})
+
+	// Order listings by sort names, and split artist tags into credits as
+	// configured, reparsing tracks already stored
+	db.SetSorter(metadata.NewSorter(cfg.Music.SortArticles))
+	db.SetCreditParser(metadata.NewCreditParser(cfg.Music.ArtistSeparators, cfg.Music.FeaturingPatterns))
+	if count, err := db.RebuildTrackArtists(); err != nil {
+		logger.WithError(err).Warn("Failed to rebuild artist credits")
+	} else {
+		logger.WithField("credits", count).Info("Artist credits rebuilt")
+	}
+
+	server.ingestor = library.NewIngestor(db, server.extractor, authSvc.GetUserFolderManager(), logger)
+	server.portable = library.NewPortable(db, server.roots)
+	server.playlistFiles = library.NewPlaylistFiles(db, server.roots)
+	server.trash = library.NewTrash(server.ingestor, server.roots)
+
 	// Set up user data cleanup callback
 	authSvc.SetCleanupCallback(func(username string) error {
+		if err := db.RemoveCollaborations(username); err != nil {
+			return err
+		}
 		return db.DeleteTracksByOwner(username)
 	})
 
 	// Attach ingestion capabilities if downloader available
 	if server.downloader != nil {
-		server.downloader.AttachIngest(server.db, server.extractor)
+		server.downloader.AttachIngest(server.db, server.ingestor)
 	}
 
 	return server, nil
 }
 
 // ScanMusicLibrary walks the configured music directory ingesting supported
-// audio files into the database. Concurrency is sized to runtime.NumCPU.
+// audio files into the database (see library.Scanner). Shutting down cancels
+// a scan in progress.
 func (ms *MusicServer) ScanMusicLibrary() error {
 	if !ms.config.Music.ScanOnStartup {
 		ms.logger.Info("Skipping library scan (disabled in config)")
@@ -142,63 +179,39 @@ func (ms *MusicServer) ScanMusicLibrary() error {
 		ms.logger.WithField("library_path", scanPath).Info("Scanning music library")
 	}
 
-	var wg sync.WaitGroup
-	var trackCount int64
-	jobs := make(chan string, 100)
-
-	// Start worker pool
-	numWorkers := runtime.NumCPU()
-	for i := 0; i < numWorkers; i++ {
-		go func() {
-			for path := range jobs {
-				track, err := ms.extractor.ExtractFromFile(path, 0)
-				if err != nil {
-					ms.logger.WithError(err).WithField("file_path", path).Error("Error extracting metadata")
-					wg.Done()
-					continue
-				}
-
-				// Determine ownership if user folders are enabled
-				if ms.authService.GetUserFolderManager().IsEnabled() {
-					owner := ms.authService.GetUserFolderManager().GetOwnerFromPath(path)
-					track.Owner = owner
-				}
-
-				_, err = ms.db.InsertTrack(track)
-				if err != nil {
-					ms.logger.WithError(err).Error("Error inserting track into database")
-				} else {
-					atomic.AddInt64(&trackCount, 1)
-					ms.logger.WithFields(logrus.Fields{
-						"artist": track.Artist,
-						"title":  track.Title,
-						"album":  track.Album,
-						"owner":  track.Owner,
-					}).Debug("Added track")
-				}
-				wg.Done()
-			}
-		}()
-	}
-
-	// Walk directory and enqueue jobs
-	walkErr := filepath.Walk(scanPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if ms.extractor.IsAudioFile(path) {
-			wg.Add(1)
-			jobs <- path
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-ms.shutdownCh:
+			cancel()
+		case <-ctx.Done():
 		}
-		return nil
-	})
+	}()
 
-	// Close jobs channel and wait for all workers
-	close(jobs)
-	wg.Wait()
+	scanner := library.NewScanner(ms.ingestor, ms.config.Music.ScanIOWorkers)
+	if ms.config.Music.SyncPlaylistFiles {
+		scanner.Playlists = ms.playlistFiles
+	}
+	scanner.OnProgress = func(p library.Progress) {
+		if p.Processed > 0 && p.Processed%scanProgressInterval == 0 && p.Path != "" {
+			ms.logger.WithFields(logrus.Fields{
+				"processed": p.Processed,
+				"found":     p.Found,
+				"failed":    p.Failed,
+			}).Info("Library scan progress")
+		}
+	}
+	result, err := scanner.Scan(ctx, scanPath)
 
-	ms.logger.WithField("track_count", trackCount).Info("Library scan completed")
-	return walkErr
+	ms.logger.WithFields(logrus.Fields{
+		"track_count": result.Tracks,
+		"cue_sheets":  result.CueSheets,
+		"playlists":   result.Playlists,
+		"failed":      result.Failed,
+		"elapsed":     result.Elapsed,
+	}).Info("Library scan completed")
+	return err
 }
 
 // Start begins serving HTTP requests and (optionally) establishes an ngrok
@@ -218,16 +231,24 @@ func (ms *MusicServer) Start() {
 		}
 	}
 
-	// Set up routes (build handler chain)
-	ms.handler = ms.setupRoutes()
+	// Start background fingerprinting for duplicate detection
+	if ms.config.Music.Fingerprinting {
+		ms.startFingerprinter()
+	}
+
+	// Start purging expired trash
+	if ms.config.Music.TrashRetentionDays > 0 {
+		ms.startTrashPurger()
+	}
 
-	// Get track count from database
-	tracks, err := ms.db.GetAllTracks()
-	trackCount := 0
-	if err == nil {
-		trackCount = len(tracks)
+	// Start scheduled database backups
+	if ms.config.Database.BackupIntervalHours > 0 {
+		ms.startBackupScheduler()
 	}
 
+	// Get track count from database
+	trackCount, _ := ms.db.CountTracks(database.StatsScope{All: true})
+
 	localAddress := fmt.Sprintf("http://%s", ms.config.GetAddress())
 
 	ms.logger.WithFields(logrus.Fields{
@@ -258,7 +279,7 @@ func (ms *MusicServer) Start() {
 		ReadTimeout:  time.Duration(ms.config.Server.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(ms.config.Server.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(ms.config.Server.IdleTimeout) * time.Second,
-		Handler:      ms.handler,
+		Handler:      ms.Handler(),
 	}
 
 	// Start server in a goroutine
@@ -279,6 +300,15 @@ func (ms *MusicServer) Start() {
 	}
 }
 
+// Handler returns the root HTTP handler (routes plus the middleware chain),
+// building it on first use.
+func (ms *MusicServer) Handler() http.Handler {
+	if ms.handler == nil {
+		ms.handler = ms.setupRoutes()
+	}
+	return ms.handler
+}
+
 func (ms *MusicServer) setupRoutes() http.Handler {
 	// Create a new ServeMux
 	mux := http.NewServeMux()
@@ -294,10 +324,27 @@ func (ms *MusicServer) setupRoutes() http.Handler {
 	mux.HandleFunc("/api/tracks", ms.handleGetTracks)
 	mux.HandleFunc("/api/tracks/count", ms.handleGetTrackCount)
 	mux.HandleFunc("/api/tracks/upload", ms.handleUploadTrack)
+	mux.HandleFunc("/api/tracks/bulk", ms.handleBulkUpdateTracks)
+	mux.HandleFunc("/api/tracks/", ms.handleTrack)
+	mux.HandleFunc("/api/overrides/export", ms.handleExportOverrides)
+	mux.HandleFunc("/api/trash", ms.handleTrash)
+	mux.HandleFunc("/api/trash/", ms.handleTrashItem)
+	mux.HandleFunc("/api/library/stats", ms.handleLibraryStats)
+	mux.HandleFunc("/api/artists", ms.handleGetArtists)
 	mux.HandleFunc("/stream/", ms.handleStreamTrack)
 	mux.HandleFunc("/albumart/", ms.handleAlbumArt) // Album art endpoint
 	mux.HandleFunc("/health", ms.handleHealthCheck) // Health check endpoint
 
+	// Admin routes
+	mux.HandleFunc("/api/admin/duplicates", ms.handleGetDuplicates)
+	mux.HandleFunc("/api/admin/path-templates/preview", ms.handlePreviewPathTemplates)
+	mux.HandleFunc("/api/admin/organize/preview", ms.handlePreviewOrganize)
+	mux.HandleFunc("/api/admin/organize/apply", ms.handleApplyOrganize)
+	mux.HandleFunc("/api/admin/backups", ms.handleBackups)
+	mux.HandleFunc("/api/admin/backups/", ms.handleBackup)
+	mux.HandleFunc("/api/admin/library/export", ms.handleLibraryExport)
+	mux.HandleFunc("/api/admin/library/import", ms.handleLibraryImport)
+
 	// Download routes
 	mux.HandleFunc("/api/download", ms.handleDownloadMusic)
 	mux.HandleFunc("/api/downloads", ms.handleGetDownloads)
@@ -308,6 +355,9 @@ func (ms *MusicServer) setupRoutes() http.Handler {
 	// Playlist routes
 	mux.HandleFunc("/api/playlists", ms.handleGetPlaylists)
 	mux.HandleFunc("/api/playlists/create", ms.handleCreatePlaylist)
+	mux.HandleFunc("/api/playlists/import", ms.handleImportPlaylist)
+	mux.HandleFunc("/api/playlists/folders", ms.handlePlaylistFolders)
+	mux.HandleFunc("/api/playlists/folders/", ms.handlePlaylistFolder)
 	mux.HandleFunc("/api/playlists/", func(w http.ResponseWriter, r *http.Request) {
 		pathParts := strings.Split(r.URL.Path, "/")
 		if len(pathParts) >= 5 && pathParts[4] == "tracks" {
@@ -318,7 +368,19 @@ func (ms *MusicServer) setupRoutes() http.Handler {
 				ms.handleAddTrackToPlaylist(w, r)
 			case "DELETE":
 				ms.handleRemoveTrackFromPlaylist(w, r)
+			case "PATCH":
+				ms.handleEditPlaylistTracks(w, r)
 			}
+		} else if len(pathParts) >= 5 && pathParts[4] == "collaborators" {
+			ms.handlePlaylistCollaborators(w, r)
+		} else if len(pathParts) >= 5 && pathParts[4] == "move" {
+			ms.handleMovePlaylist(w, r)
+		} else if len(pathParts) >= 5 && pathParts[4] == "rules" {
+			ms.handleSmartRules(w, r)
+		} else if len(pathParts) >= 5 && pathParts[4] == "export" {
+			ms.handleExportPlaylist(w, r)
+		} else if len(pathParts) >= 5 && (pathParts[4] == "sort" || pathParts[4] == "shuffle" || pathParts[4] == "dedupe") {
+			ms.handlePlaylistAction(w, r, pathParts[4])
 		} else {
 			switch r.Method {
 			case "DELETE":
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"staccato/internal/database"
+	"staccato/pkg/models"
+)
+
+// invalidSmartRules reports rules the database layer cannot evaluate.
+func invalidSmartRules(err error) ValidationError {
+	return ValidationError{
+		Field:   "rules",
+		Message: err.Error(),
+		Code:    "INVALID_SMART_RULES",
+	}
+}
+
+// playlistTracks returns a playlist's tracks in order. Smart playlists are
+// evaluated over the caller's library, so they only hold tracks the caller
+// could stream.
+func (ms *MusicServer) playlistTracks(r *http.Request, playlist *models.Playlist) ([]models.Track, error) {
+	if playlist.Smart {
+		return ms.db.GetSmartPlaylistTracks(*playlist.Rules, ms.libraryScope(r))
+	}
+	return ms.db.GetPlaylistTracks(playlist.ID)
+}
+
+// countSmartPlaylists sets the track counts of smart playlists, which have
+// no stored entries, to the number of the caller's tracks they match.
+func (ms *MusicServer) countSmartPlaylists(r *http.Request, playlists []models.Playlist) {
+	scope := ms.libraryScope(r)
+	for i := range playlists {
+		if !playlists[i].Smart {
+			continue
+		}
+		count, err := ms.db.CountSmartPlaylistTracks(*playlists[i].Rules, scope)
+		if err != nil {
+			ms.logger.WithError(err).WithField("playlist_id", playlists[i].ID).Warn("Failed to evaluate smart playlist")
+			continue
+		}
+		playlists[i].TrackCount = count
+	}
+}
+
+// rejectSmartPlaylistEdit refuses an edit of a smart playlist's entries,
+// which follow from its rules. It reports whether the edit was refused.
+func (ms *MusicServer) rejectSmartPlaylistEdit(w http.ResponseWriter, r *http.Request, playlist *models.Playlist) bool {
+	if !playlist.Smart {
+		return false
+	}
+	ms.respondWithError(w, r, http.StatusBadRequest, "Smart playlist tracks follow from its rules; edit the rules instead", nil)
+	return true
+}
+
+// handleSmartRules returns (GET) or replaces (PUT json rules) the rules of
+// a smart playlist. Editors may replace them.
+func (ms *MusicServer) handleSmartRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	need := models.PlaylistRoleViewer
+	if r.Method == http.MethodPut {
+		need = models.PlaylistRoleEditor
+	}
+	playlist := ms.playlistFromPath(w, r, need)
+	if playlist == nil {
+		return
+	}
+	if !playlist.Smart {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Playlist is not a smart playlist", nil)
+		return
+	}
+	if r.Method == http.MethodGet {
+		ms.respondJSON(w, playlist.Rules)
+		return
+	}
+
+	var rules models.SmartRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if err := database.ValidateSmartRules(rules); err != nil {
+		ms.respondWithValidationError(w, r, []ValidationError{invalidSmartRules(err)})
+		return
+	}
+	if err := ms.db.UpdateSmartRules(playlist.ID, rules); err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error updating playlist", err)
+		return
+	}
+	ms.respondJSON(w, rules)
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"staccato/internal/database"
+)
+
+// handleLibraryStats returns aggregate statistics for the caller's library.
+// Administrators may pass ?scope=all to include every user's tracks.
+func (ms *MusicServer) handleLibraryStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	scope := ms.libraryScope(r)
+	if r.URL.Query().Get("scope") == "all" {
+		if !ms.requireAdmin(w, r) {
+			return
+		}
+		scope = database.StatsScope{All: true}
+	}
+
+	stats, err := ms.db.GetLibraryStats(scope)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Error computing library statistics", err)
+		return
+	}
+
+	ms.respondJSON(w, stats)
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxTagRequestSize = 16 << 20 // base64 album art inflates the body
+	maxBulkTracks     = 500
+)
+
+// TagChanges is the JSON body of a tag edit. Omitted fields are left
+// untouched; albumArt is base64-encoded JPEG or PNG data.
+type TagChanges struct {
+	Title       *string `json:"title,omitempty"`
+	Artist      *string `json:"artist,omitempty"`
+	Album       *string `json:"album,omitempty"`
+	TrackNumber *int    `json:"trackNumber,omitempty"`
+	AlbumArt    *string `json:"albumArt,omitempty"`
+}
+
+// BulkTagRequest applies the same changes to several tracks (e.g. an album).
+type BulkTagRequest struct {
+	TrackIDs []int      `json:"trackIds"`
+	Changes  TagChanges `json:"changes"`
+}
+
+// BulkTagResult reports the outcome for one track of a bulk edit.
+type BulkTagResult struct {
+	TrackID int           `json:"trackId"`
+	Success bool          `json:"success"`
+	Error   string        `json:"error,omitempty"`
+	Track   *models.Track `json:"track,omitempty"`
+}
+
+// tagEditError carries the HTTP status for a failed tag edit.
+type tagEditError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *tagEditError) Error() string { return e.message }
+
+// handleTrack dispatches /api/tracks/{id} and its sub-resources by method.
+func (ms *MusicServer) handleTrack(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	switch {
+	case len(pathParts) == 5 && pathParts[4] == "overrides":
+		switch r.Method {
+		case http.MethodGet:
+			ms.handleGetTrackOverride(w, r)
+		case http.MethodPut:
+			ms.handleSetTrackOverride(w, r)
+		case http.MethodDelete:
+			ms.handleDeleteTrackOverride(w, r)
+		default:
+			ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		}
+	case len(pathParts) == 4:
+		switch r.Method {
+		case http.MethodPatch:
+			ms.handleUpdateTrackTags(w, r)
+		case http.MethodDelete:
+			ms.handleDeleteTrack(w, r)
+		default:
+			ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		}
+	default:
+		ms.respondWithError(w, r, http.StatusNotFound, "Not found", nil)
+	}
+}
+
+// handleUpdateTrackTags writes tag changes to a single track's file and
+// refreshes its database row (admin or track owner only).
+func (ms *MusicServer) handleUpdateTrackTags(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	trackID, validationErr := ms.validateTrackID(pathParts, 4)
+	if validationErr != nil {
+		ms.respondWithValidationError(w, r, []ValidationError{*validationErr})
+		return
+	}
+
+	var changes TagChanges
+	r.Body = http.MaxBytesReader(w, r.Body, maxTagRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	update, validationErrs := changes.toTagUpdate()
+	if len(validationErrs) > 0 {
+		ms.respondWithValidationError(w, r, validationErrs)
+		return
+	}
+
+	track, err := ms.updateTrackTags(r, trackID, update)
+	if err != nil {
+		var editErr *tagEditError
+		if errors.As(err, &editErr) {
+			ms.respondWithError(w, r, editErr.status, editErr.message, editErr.err)
+		} else {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to update tags", err)
+		}
+		return
+	}
+	ms.respondJSON(w, track)
+}
+
+// handleBulkUpdateTracks applies one set of tag changes to many tracks and
+// reports per-track results. Tracks the caller may not edit fail individually.
+func (ms *MusicServer) handleBulkUpdateTracks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	var req BulkTagRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxTagRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ms.respondWithError(w, r, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if len(req.TrackIDs) == 0 || len(req.TrackIDs) > maxBulkTracks {
+		ms.respondWithValidationError(w, r, []ValidationError{{
+			Field:   "trackIds",
+			Message: "Between 1 and 500 track IDs are required",
+			Code:    "INVALID_TRACK_IDS",
+		}})
+		return
+	}
+	update, validationErrs := req.Changes.toTagUpdate()
+	if len(validationErrs) > 0 {
+		ms.respondWithValidationError(w, r, validationErrs)
+		return
+	}
+
+	results := make([]BulkTagResult, 0, len(req.TrackIDs))
+	updated := 0
+	for _, id := range req.TrackIDs {
+		result := BulkTagResult{TrackID: id}
+		track, err := ms.updateTrackTags(r, id, update)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Success = true
+			result.Track = track
+			updated++
+		}
+		results = append(results, result)
+	}
+
+	ms.respondJSON(w, map[string]interface{}{
+		"updated": updated,
+		"failed":  len(results) - updated,
+		"results": results,
+	})
+}
+
+// canEditTrack reports whether the caller may change a track's metadata:
+// administrators may edit any track, other users only tracks they own.
+func (ms *MusicServer) canEditTrack(r *http.Request, track *models.Track) bool {
+	user := currentUsername(r)
+	return ms.authService.IsAdmin(user) || (track.Owner != "" && track.Owner == user)
+}
+
+// toTagUpdate converts request changes into a metadata.TagUpdate, decoding
+// album art and validating every field.
+func (c TagChanges) toTagUpdate() (metadata.TagUpdate, []ValidationError) {
+	update := metadata.TagUpdate{
+		Title:       c.Title,
+		Artist:      c.Artist,
+		Album:       c.Album,
+		TrackNumber: c.TrackNumber,
+	}
+	if c.AlbumArt != nil {
+		data, err := base64.StdEncoding.DecodeString(*c.AlbumArt)
+		if err != nil || len(data) == 0 {
+			return update, []ValidationError{{
+				Field:   "albumArt",
+				Message: "Album art must be base64-encoded image data",
+				Code:    "INVALID_ALBUM_ART",
+			}}
+		}
+		update.Picture = data
+	}
+	if update.IsEmpty() {
+		return update, []ValidationError{{
+			Field:   "changes",
+			Message: "At least one tag must be changed",
+			Code:    "NO_CHANGES",
+		}}
+	}
+	if err := update.Validate(); err != nil {
+		return update, []ValidationError{{
+			Field:   "changes",
+			Message: err.Error(),
+			Code:    "INVALID_TAGS",
+		}}
+	}
+	return update, nil
+}
+
+// updateTrackTags checks that the caller may edit the track, writes the tags
+// to its file and re-reads the file to refresh the database row and album art.
+func (ms *MusicServer) updateTrackTags(r *http.Request, trackID int, update metadata.TagUpdate) (*models.Track, error) {
+	track, err := ms.db.GetTrackByID(trackID)
+	if err != nil {
+		return nil, &tagEditError{http.StatusNotFound, "Track not found", err}
+	}
+
+	user := currentUsername(r)
+	if !ms.canEditTrack(r, track) {
+		return nil, &tagEditError{http.StatusForbidden, "Only administrators or the track owner can edit tags", nil}
+	}
+	if track.IsVirtual() {
+		return nil, &tagEditError{http.StatusUnprocessableEntity, "Tracks from a cue sheet have no tags of their own; use overrides instead", nil}
+	}
+	if validationErr := ms.validateFilePath(track.FilePath); validationErr != nil {
+		return nil, &tagEditError{http.StatusBadRequest, validationErr.Message, nil}
+	}
+	if !metadata.CanWriteTags(track.FilePath) {
+		return nil, &tagEditError{http.StatusUnprocessableEntity, "Tag editing is not supported for this file format", nil}
+	}
+
+	ms.tagWriteMu.Lock()
+	defer ms.tagWriteMu.Unlock()
+
+	if err := metadata.WriteTags(track.FilePath, update); err != nil {
+		return nil, &tagEditError{http.StatusInternalServerError, "Failed to write tags to file", err}
+	}
+
+	refreshed, err := ms.ingestor.RefreshTrack(r.Context(), track)
+	if err != nil {
+		return nil, &tagEditError{http.StatusInternalServerError, "Failed to re-read updated file", err}
+	}
+
+	ms.logger.WithFields(logrus.Fields{
+		"track_id": track.ID,
+		"user":     user,
+		"title":    refreshed.Title,
+		"artist":   refreshed.Artist,
+		"album":    refreshed.Album,
+	}).Info("Track tags updated")
+
+	// Re-read through the database so any overrides are applied.
+	return ms.db.GetTrackByID(track.ID)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"staccato/internal/database"
+	"staccato/internal/library"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// trashPurgeInterval is how often trashed files are checked against
+// music.trash_retention_days.
+const trashPurgeInterval = time.Hour
+
+// startTrashPurger purges trashed files older than the retention period now
+// and every trashPurgeInterval until shutdown.
+func (ms *MusicServer) startTrashPurger() {
+	retention := time.Duration(ms.config.Music.TrashRetentionDays) * 24 * time.Hour
+	purge := func() {
+		purged, err := ms.trash.PurgeOlderThan(retention)
+		if err != nil {
+			ms.logger.WithError(err).Error("Failed to purge trash")
+		}
+		if purged > 0 {
+			ms.logger.WithField("purged", purged).Info("Purged expired trash")
+		}
+	}
+	go func() {
+		purge()
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ms.shutdownCh:
+				return
+			case <-ticker.C:
+				purge()
+			}
+		}
+	}()
+}
+
+// handleDeleteTrack moves a track's file to the trash and removes the track
+// (admin or track owner only).
+func (ms *MusicServer) handleDeleteTrack(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	trackID, validationErr := ms.validateTrackID(pathParts, 4)
+	if validationErr != nil {
+		ms.respondWithValidationError(w, r, []ValidationError{*validationErr})
+		return
+	}
+	track, err := ms.db.GetTrackByID(trackID)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusNotFound, "Track not found", err)
+		return
+	}
+	if !ms.canEditTrack(r, track) {
+		ms.respondWithError(w, r, http.StatusForbidden, "Only administrators or the track owner can delete tracks", nil)
+		return
+	}
+
+	// Don't move a file out from under a tag rewrite
+	ms.tagWriteMu.Lock()
+	item, err := ms.trash.Delete(track, currentUsername(r))
+	ms.tagWriteMu.Unlock()
+	if err != nil {
+		if errors.Is(err, library.ErrVirtualTrack) {
+			ms.respondWithError(w, r, http.StatusConflict, err.Error(), err)
+		} else {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to delete track", err)
+		}
+		return
+	}
+	ms.respondJSON(w, item)
+}
+
+// handleTrash lists trashed files (GET) or purges all of them (DELETE).
+// Administrators see every library's trash; other users their own.
+func (ms *MusicServer) handleTrash(w http.ResponseWriter, r *http.Request) {
+	scope := database.StatsScope{All: true}
+	if user := currentUsername(r); !ms.authService.IsAdmin(user) {
+		if user == "" {
+			ms.respondWithError(w, r, http.StatusForbidden, "Only administrators or track owners can manage the trash", nil)
+			return
+		}
+		scope = database.StatsScope{Owner: user}
+	}
+	items, err := ms.trash.List(scope)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to list trash", err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ms.respondJSON(w, items)
+	case http.MethodDelete:
+		purged := 0
+		for i := range items {
+			if err := ms.trash.Purge(&items[i]); err != nil {
+				ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to purge trash", err)
+				return
+			}
+			purged++
+		}
+		ms.logger.WithFields(logrus.Fields{
+			"purged": purged,
+			"user":   currentUsername(r),
+		}).Info("Trash emptied")
+		ms.respondJSON(w, map[string]int{"purged": purged})
+	default:
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// handleTrashItem serves /api/trash/{id}: DELETE purges the file and POST
+// .../restore moves it back into the library.
+func (ms *MusicServer) handleTrashItem(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/api/trash/")
+	idStr, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 || (action != "" && action != "restore") {
+		ms.respondWithError(w, r, http.StatusNotFound, "Trash item not found", nil)
+		return
+	}
+	item, err := ms.trash.Get(id)
+	if err != nil {
+		ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to load trash item", err)
+		return
+	}
+	user := currentUsername(r)
+	if item == nil || !(ms.authService.IsAdmin(user) || (item.Owner != "" && item.Owner == user)) {
+		ms.respondWithError(w, r, http.StatusNotFound, "Trash item not found", nil)
+		return
+	}
+
+	switch {
+	case action == "restore" && r.Method == http.MethodPost:
+		ms.restoreTrashItem(w, r, item)
+	case action == "" && r.Method == http.MethodDelete:
+		if err := ms.trash.Purge(item); err != nil {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to purge trash item", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		ms.respondWithError(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// restoreTrashItem moves a trashed file back and returns its new track.
+func (ms *MusicServer) restoreTrashItem(w http.ResponseWriter, r *http.Request, item *models.TrashItem) {
+	track, err := ms.trash.Restore(context.Background(), item)
+	if err != nil {
+		if errors.Is(err, library.ErrRestoreConflict) {
+			ms.respondWithError(w, r, http.StatusConflict, err.Error(), err)
+		} else {
+			ms.respondWithError(w, r, http.StatusInternalServerError, "Failed to restore track", err)
+		}
+		return
+	}
+	ms.respondJSON(w, track)
+}
@@ -112,27 +112,18 @@ func (ms *MusicServer) handleUploadTrack(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Extract metadata and add to database
-	track, err := ms.extractor.ExtractFromFile(destPath, 0)
+	track, err := ms.ingestor.IngestFileAs(r.Context(), destPath, username)
 	if err != nil {
-		ms.logger.WithError(err).WithField("file_path", destPath).Warn("Failed to extract metadata from uploaded file")
 		// Don't fail the upload, just log the warning
-	} else {
-		// Set ownership
-		track.Owner = username
-
-		// Insert into database
-		trackID, err := ms.db.InsertTrack(track)
-		if err != nil {
-			ms.logger.WithError(err).WithField("file_path", destPath).Error("Failed to insert uploaded track into database")
-		} else {
-			ms.logger.WithFields(logrus.Fields{
-				"username": username,
-				"filename": safeFilename,
-				"track_id": trackID,
-				"artist":   track.Artist,
-				"title":    track.Title,
-			}).Info("File uploaded and added to library")
-		}
+		ms.logger.WithError(err).WithField("file_path", destPath).Warn("Failed to add uploaded file to library")
+	} else if track != nil {
+		ms.logger.WithFields(logrus.Fields{
+			"username": username,
+			"filename": safeFilename,
+			"track_id": track.ID,
+			"artist":   track.Artist,
+			"title":    track.Title,
+		}).Info("File uploaded and added to library")
 	}
 
 	// Return success response
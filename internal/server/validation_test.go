@@ -1,6 +1,7 @@
 package server
 
 import (
+	"path/filepath"
 	"testing"
 
 	"staccato/internal/auth"
@@ -10,9 +11,12 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func createTestMusicServer() *MusicServer {
+func createTestMusicServer(t *testing.T) *MusicServer {
+	t.Helper()
 	cfg := config.DefaultConfig()
 	cfg.Music.LibraryPath = "/tmp/test-music"
+	// Keep the default admin account the user store creates out of the tree
+	cfg.Auth.UsersFilePath = filepath.Join(t.TempDir(), "users.toml")
 
 	logger := logrus.New()
 	logger.SetLevel(logrus.ErrorLevel) // Reduce noise in tests
@@ -29,7 +33,7 @@ func createTestMusicServer() *MusicServer {
 }
 
 func TestValidateTrackID(t *testing.T) {
-	ms := createTestMusicServer()
+	ms := createTestMusicServer(t)
 
 	tests := []struct {
 		name      string
@@ -93,7 +97,7 @@ func TestValidateTrackID(t *testing.T) {
 }
 
 func TestValidateSearchQuery(t *testing.T) {
-	ms := createTestMusicServer()
+	ms := createTestMusicServer(t)
 
 	tests := []struct {
 		name      string
@@ -137,7 +141,7 @@ func TestValidateSearchQuery(t *testing.T) {
 }
 
 func TestValidateFilePath(t *testing.T) {
-	ms := createTestMusicServer()
+	ms := createTestMusicServer(t)
 
 	tests := []struct {
 		name      string
@@ -176,7 +180,7 @@ func TestValidateFilePath(t *testing.T) {
 }
 
 func TestValidateURL(t *testing.T) {
-	ms := createTestMusicServer()
+	ms := createTestMusicServer(t)
 
 	tests := []struct {
 		name      string
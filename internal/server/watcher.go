@@ -1,11 +1,15 @@
 package server
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"staccato/internal/library"
+	"staccato/internal/metadata"
+
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 )
@@ -44,6 +48,9 @@ func (ms *MusicServer) addDirectoryToWatcher(dir string) error {
 			return err
 		}
 		if info.IsDir() {
+			if info.Name() == library.TrashDir {
+				return filepath.SkipDir
+			}
 			return ms.watcher.Add(path)
 		}
 		return nil
@@ -73,15 +80,35 @@ func (ms *MusicServer) watchFiles() {
 
 // handleFileEvent applies filtering & delegates creation/removal actions.
 func (ms *MusicServer) handleFileEvent(event fsnotify.Event) {
-	// Ignore temporary files and hidden files
+	// Ignore temporary files, hidden files and anything in the trash
 	fileName := filepath.Base(event.Name)
-	if strings.HasPrefix(fileName, ".") || strings.HasSuffix(fileName, ".tmp") {
+	if strings.HasPrefix(fileName, ".") || strings.HasSuffix(fileName, ".tmp") || library.InTrash(event.Name) {
 		return
 	}
 
 	isAudioFile := ms.extractor.IsAudioFile(event.Name)
+	isCueSheet := metadata.IsCueSheet(event.Name)
+	isPlaylistFile := ms.config.Music.SyncPlaylistFiles && library.IsPlaylistFile(event.Name)
 
 	switch {
+	case (event.Has(fsnotify.Create) || event.Has(fsnotify.Write)) && isCueSheet:
+		go func(name string) {
+			time.Sleep(500 * time.Millisecond) // Ensure file is fully written
+			ms.handleNewCueSheet(name)
+		}(event.Name)
+
+	case (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) && isCueSheet:
+		go ms.handleRemovedCueSheet(event.Name)
+
+	case (event.Has(fsnotify.Create) || event.Has(fsnotify.Write)) && isPlaylistFile:
+		go func(name string) {
+			time.Sleep(500 * time.Millisecond) // Ensure file is fully written
+			ms.handlePlaylistFile(name)
+		}(event.Name)
+
+	case (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) && isPlaylistFile:
+		go ms.handleRemovedPlaylistFile(event.Name)
+
 	case event.Has(fsnotify.Create) && isAudioFile:
 		// Dispatch new file processing asynchronously
 		go func(name string) {
@@ -102,60 +129,84 @@ func (ms *MusicServer) handleFileEvent(event fsnotify.Event) {
 	}
 }
 
-// handleNewFile extracts metadata & inserts new track if unseen.
+// handleNewFile adds a created audio file (or the cue sheet covering it).
 func (ms *MusicServer) handleNewFile(filePath string) {
 	ms.logger.WithField("file_path", filePath).Info("New audio file detected")
 
-	// Check if file already exists in database
-	exists, err := ms.db.TrackExists(filePath)
+	track, err := ms.ingestor.IngestFile(context.Background(), filePath)
 	if err != nil {
-		ms.logger.WithError(err).WithField("file_path", filePath).Error("Error checking if track exists")
+		ms.logger.WithError(err).WithField("file_path", filePath).Error("Error adding new track")
 		return
 	}
-	if exists {
-		ms.logger.WithField("file_path", filePath).Debug("Track already exists in database")
-		return
+	if track != nil {
+		ms.logger.WithFields(logrus.Fields{
+			"artist": track.Artist,
+			"title":  track.Title,
+			"album":  track.Album,
+			"owner":  track.Owner,
+			"id":     track.ID,
+		}).Info("Added new track")
 	}
+}
 
-	// Extract metadata and add to database
-	track, err := ms.extractor.ExtractFromFile(filePath, 0)
-	if err != nil {
-		ms.logger.WithError(err).WithField("file_path", filePath).Error("Error extracting metadata")
+// handleRemovedFile removes track rows referencing deleted audio files.
+func (ms *MusicServer) handleRemovedFile(filePath string) {
+	ms.logger.WithField("file_path", filePath).Info("Audio file removed")
+
+	if err := ms.ingestor.RemoveFile(filePath); err != nil {
+		ms.logger.WithError(err).WithField("file_path", filePath).Error("Error removing track from database")
 		return
 	}
 
-	// Determine ownership if user folders are enabled
-	if ms.authService.GetUserFolderManager().IsEnabled() {
-		owner := ms.authService.GetUserFolderManager().GetOwnerFromPath(filePath)
-		track.Owner = owner
-	}
+	ms.logger.WithField("file_path", filePath).Info("Removed track from database")
+}
 
-	id, err := ms.db.InsertTrack(track)
+// handleNewCueSheet (re)ingests a created or edited cue sheet.
+func (ms *MusicServer) handleNewCueSheet(cuePath string) {
+	sources, err := ms.ingestor.IngestCueSheet(context.Background(), cuePath)
 	if err != nil {
-		ms.logger.WithError(err).Error("Error inserting new track into database")
+		ms.logger.WithError(err).WithField("cue_path", cuePath).Error("Error reading cue sheet")
 		return
 	}
-
 	ms.logger.WithFields(logrus.Fields{
-		"artist": track.Artist,
-		"title":  track.Title,
-		"album":  track.Album,
-		"owner":  track.Owner,
-		"id":     id,
-	}).Info("Added new track")
+		"cue_path": cuePath,
+		"sources":  len(sources),
+	}).Info("Added cue sheet tracks")
 }
 
-// handleRemovedFile removes track rows referencing deleted audio files.
-func (ms *MusicServer) handleRemovedFile(filePath string) {
-	ms.logger.WithField("file_path", filePath).Info("Audio file removed")
+// handleRemovedCueSheet removes a deleted cue sheet's tracks and re-adds the
+// audio files it covered as standalone tracks.
+func (ms *MusicServer) handleRemovedCueSheet(cuePath string) {
+	if err := ms.ingestor.RemoveCueSheet(context.Background(), cuePath); err != nil {
+		ms.logger.WithError(err).WithField("cue_path", cuePath).Error("Error removing cue sheet tracks")
+	}
+}
 
-	err := ms.db.RemoveTrackByPath(filePath)
+// handlePlaylistFile mirrors a created or edited playlist file.
+func (ms *MusicServer) handlePlaylistFile(filePath string) {
+	playlist, changed, err := ms.playlistFiles.SyncFile(filePath)
 	if err != nil {
-		ms.logger.WithError(err).WithField("file_path", filePath).Error("Error removing track from database")
+		ms.logger.WithError(err).WithField("playlist_path", filePath).Error("Error reading playlist file")
 		return
 	}
+	if changed {
+		ms.logger.WithFields(logrus.Fields{
+			"playlist_path": filePath,
+			"playlist_id":   playlist.ID,
+			"tracks":        playlist.TrackCount,
+		}).Info("Synced playlist file")
+	}
+}
 
-	ms.logger.WithField("file_path", filePath).Info("Removed track from database")
+// handleRemovedPlaylistFile deletes the playlist of a deleted playlist file.
+// Files replaced by a rename, as editors save them, still exist and keep it.
+func (ms *MusicServer) handleRemovedPlaylistFile(filePath string) {
+	if _, err := os.Stat(filePath); err == nil {
+		return
+	}
+	if err := ms.playlistFiles.RemoveFile(filePath); err != nil {
+		ms.logger.WithError(err).WithField("playlist_path", filePath).Error("Error removing playlist of playlist file")
+	}
 }
 
 // stopFileWatcher closes the watcher (idempotent).
@@ -0,0 +1,23 @@
+package models
+
+// Roles an artist can be credited with on a track.
+const (
+	RoleMain     = "main"
+	RoleFeatured = "featured"
+	RoleRemixer  = "remixer"
+	RoleComposer = "composer"
+)
+
+// ArtistCredit is one artist credited on a track, parsed from its tags.
+type ArtistCredit struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// Artist is an artist credited on at least one track, with the roles it
+// appears in.
+type Artist struct {
+	Name       string   `json:"name"`
+	TrackCount int      `json:"trackCount"`
+	Roles      []string `json:"roles"`
+}
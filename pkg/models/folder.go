@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// FolderVisibilityInherit makes a nested folder take its parent's
+// visibility instead of having its own.
+const FolderVisibilityInherit = "inherit"
+
+// PlaylistFolder groups playlists and other folders of one owner. Nested
+// folders may inherit their parent's visibility instead of having their
+// own; Visibility is the one in effect either way. Playlists in a folder
+// always have its visibility.
+type PlaylistFolder struct {
+	ID                 int       `json:"id"`
+	Name               string    `json:"name"`
+	ParentID           int       `json:"parentId,omitempty"`
+	Owner              string    `json:"owner,omitempty"`
+	Visibility         string    `json:"visibility"`
+	InheritsVisibility bool      `json:"inheritsVisibility"`
+	Position           int       `json:"position"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+// VisibleTo reports whether user may view the folder: its owner always
+// can, anyone else unless it is private.
+func (f *PlaylistFolder) VisibleTo(user string) bool {
+	return f.Owner == user || f.Visibility != VisibilityPrivate
+}
+
+// PlaylistFolderNode is a folder with everything in it, as returned in
+// playlist trees. The root of a tree has no Folder.
+type PlaylistFolderNode struct {
+	Folder    *PlaylistFolder      `json:"folder,omitempty"`
+	Folders   []PlaylistFolderNode `json:"folders"`
+	Playlists []Playlist           `json:"playlists"`
+}
+
+// PlaylistFolderContents is what is directly in a folder, or at the top
+// level when Folder is nil.
+type PlaylistFolderContents struct {
+	Folder    *PlaylistFolder  `json:"folder,omitempty"`
+	Folders   []PlaylistFolder `json:"folders"`
+	Playlists []Playlist       `json:"playlists"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// TrackOverride holds metadata values that take precedence over the tags read
+// from a track's file. Nil fields are not overridden.
+type TrackOverride struct {
+	TrackID     int       `json:"trackId"`
+	Title       *string   `json:"title,omitempty"`
+	Artist      *string   `json:"artist,omitempty"`
+	Album       *string   `json:"album,omitempty"`
+	TrackNumber *int      `json:"trackNumber,omitempty"`
+	AlbumArtID  string    `json:"albumArtId,omitempty"`
+	UpdatedBy   string    `json:"updatedBy,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// TrackOverrideExport is a portable override keyed by the track's path
+// relative to its library root, with album art inlined.
+type TrackOverrideExport struct {
+	Path     string `json:"path"`
+	Owner    string `json:"owner,omitempty"`
+	AlbumArt []byte `json:"albumArt,omitempty"` // base64 in JSON
+	TrackOverride
+}
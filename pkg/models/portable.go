@@ -0,0 +1,124 @@
+package models
+
+import "time"
+
+// LibraryExportVersion is the LibraryExport format written by this server.
+// Imports accept this version and older ones. Version 2 added playlist
+// folders, collaborators and who added each entry.
+const LibraryExportVersion = 2
+
+// LibraryExport is a portable copy of the library data that lives only in
+// the database: playlists and their folders, overrides and download
+// history. Everything that refers to a track does so by TrackRef.ID, and
+// the TrackRef identifies the file by library-relative path, tags and
+// content hash, so the document can be re-linked against a library rooted
+// somewhere else.
+type LibraryExport struct {
+	Version    int                    `json:"version"`
+	ExportedAt time.Time              `json:"exportedAt"`
+	Tracks     []TrackRef             `json:"tracks"`
+	Folders    []PlaylistFolderExport `json:"folders"`
+	Playlists  []PlaylistExport       `json:"playlists"`
+	Overrides  []TrackOverrideExport  `json:"overrides"` // TrackID refers to Tracks
+	Downloads  []DownloadExport       `json:"downloads"`
+}
+
+// TrackRef identifies a track of the exporting instance. Tags are the ones
+// read from the file, without overrides applied.
+type TrackRef struct {
+	ID          int    `json:"id"` // track ID on the exporting instance
+	Path        string `json:"path"`
+	Owner       string `json:"owner,omitempty"`
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	TrackNumber int    `json:"trackNumber,omitempty"`
+	Duration    int    `json:"duration"` // in seconds
+	FileSize    int64  `json:"fileSize"`
+	Hash        string `json:"hash,omitempty"` // empty for cue sheet tracks
+}
+
+// PlaylistExport is a playlist with its tracks in order. Visibility is the
+// playlist's own; in a folder, the folder's applies instead.
+type PlaylistExport struct {
+	Name          string                 `json:"name"`
+	Description   string                 `json:"description,omitempty"`
+	CoverPath     string                 `json:"coverPath,omitempty"`
+	Owner         string                 `json:"owner,omitempty"`
+	Visibility    string                 `json:"visibility,omitempty"` // empty in exports from before playlists had owners
+	CreatedAt     time.Time              `json:"createdAt"`
+	Folder        int                    `json:"folder,omitempty"` // PlaylistFolderExport ID
+	Position      int                    `json:"position,omitempty"`
+	Tracks        []int                  `json:"tracks"`            // TrackRef IDs
+	AddedBy       []string               `json:"addedBy,omitempty"` // who added each of Tracks, if recorded
+	Rules         *SmartRules            `json:"rules,omitempty"`   // set for smart playlists
+	Collaborators []PlaylistCollaborator `json:"collaborators,omitempty"`
+}
+
+// PlaylistFolderExport is a playlist folder. Visibility is the folder's own
+// or FolderVisibilityInherit.
+type PlaylistFolderExport struct {
+	ID         int    `json:"id"` // folder ID on the exporting instance
+	Name       string `json:"name"`
+	Parent     int    `json:"parent,omitempty"` // PlaylistFolderExport ID
+	Owner      string `json:"owner,omitempty"`
+	Visibility string `json:"visibility"`
+	Position   int    `json:"position"`
+}
+
+// DownloadExport is one entry of the download history. Path is relative to
+// the library root of Owner.
+type DownloadExport struct {
+	ID          string     `json:"id"`
+	URL         string     `json:"url"`
+	Title       string     `json:"title"`
+	Artist      string     `json:"artist"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Path        string     `json:"path,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// LibraryImportReport summarises an import of a LibraryExport.
+type LibraryImportReport struct {
+	Tracks           int              `json:"tracks"`
+	MatchedByPath    int              `json:"matchedByPath"`
+	MatchedByHash    int              `json:"matchedByHash"`
+	MatchedByTags    int              `json:"matchedByTags"`
+	PlaylistsCreated int              `json:"playlistsCreated"`
+	PlaylistsMerged  int              `json:"playlistsMerged"`
+	FoldersCreated   int              `json:"foldersCreated"`
+	FoldersMerged    int              `json:"foldersMerged"`
+	Collaborators    int              `json:"collaborators"`
+	PlaylistEntries  int              `json:"playlistEntries"`
+	Overrides        int              `json:"overrides"`
+	Downloads        int              `json:"downloads"`
+	Unmatched        []UnmatchedTrack `json:"unmatched"`
+}
+
+// UnmatchedTrack is a TrackRef no local track could be linked to, with the
+// data that was dropped because of it.
+type UnmatchedTrack struct {
+	TrackRef
+	Playlists []string `json:"playlists,omitempty"`
+	Override  bool     `json:"override,omitempty"`
+}
+
+// PlaylistImportReport summarises an import of a playlist file.
+type PlaylistImportReport struct {
+	Playlist      Playlist         `json:"playlist"`
+	Entries       int              `json:"entries"`
+	MatchedByPath int              `json:"matchedByPath"`
+	MatchedByTags int              `json:"matchedByTags"`
+	Unmatched     []UnmatchedEntry `json:"unmatched"`
+}
+
+// UnmatchedEntry is a playlist file entry no track could be found for.
+type UnmatchedEntry struct {
+	Line     int    `json:"line"`
+	Location string `json:"location"`
+	Artist   string `json:"artist,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "encoding/json"
+
+// Smart playlist rule combinators.
+const (
+	// MatchAll combines rules so that a track must match every one.
+	MatchAll = "all"
+	// MatchAny combines rules so that a track must match at least one.
+	MatchAny = "any"
+)
+
+// SmartRules define a smart playlist: the tracks matching Rules, combined
+// per Match (MatchAll when empty), ordered by the Sort field and cut to
+// Limit tracks (0 for no limit).
+type SmartRules struct {
+	Match      string      `json:"match,omitempty"`
+	Rules      []SmartRule `json:"rules"`
+	Sort       string      `json:"sort,omitempty"`
+	Descending bool        `json:"descending,omitempty"`
+	Limit      int         `json:"limit,omitempty"`
+}
+
+// SmartRule is a condition comparing a track field to Value with Operator
+// or, when Rules is set, a nested group of rules combined per Match.
+type SmartRule struct {
+	Field    string          `json:"field,omitempty"`
+	Operator string          `json:"operator,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Match    string          `json:"match,omitempty"`
+	Rules    []SmartRule     `json:"rules,omitempty"`
+}
+
+// IsGroup reports whether the rule is a nested group of rules.
+func (r SmartRule) IsGroup() bool {
+	return len(r.Rules) > 0
+}
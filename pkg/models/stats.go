@@ -0,0 +1,24 @@
+package models
+
+// LibraryStats summarizes the contents of a music library.
+type LibraryStats struct {
+	TrackCount    int          `json:"trackCount"`
+	AlbumCount    int          `json:"albumCount"`
+	ArtistCount   int          `json:"artistCount"`
+	TotalDuration int64        `json:"totalDuration"` // in seconds
+	TotalSize     int64        `json:"totalSize"`     // in bytes
+	Formats       []StatBucket `json:"formats"`
+	Codecs        []StatBucket `json:"codecs"`
+	Owners        []StatBucket `json:"owners"`
+	AddedPerMonth []StatBucket `json:"addedPerMonth"`
+	MissingArt    int          `json:"missingArt"`
+	MissingTags   int          `json:"missingTags"`
+}
+
+// StatBucket is one row of a grouped library statistic.
+type StatBucket struct {
+	Key      string `json:"key"`
+	Count    int    `json:"count"`
+	Duration int64  `json:"duration"` // in seconds
+	Size     int64  `json:"size"`     // in bytes
+}
@@ -2,29 +2,212 @@ package models
 
 import "time"
 
+// VariousArtists is the album artist compilations are grouped under.
+const VariousArtists = "Various Artists"
+
 // Track represents a music track in the system.
 type Track struct {
 	ID          int    `json:"id"`
 	Title       string `json:"title"`
 	Artist      string `json:"artist"`
 	Album       string `json:"album"`
+	AlbumArtist string `json:"albumArtist,omitempty"` // empty when the file has no album artist tag
+	Compilation bool   `json:"compilation,omitempty"`
+	Composer    string `json:"composer,omitempty"`
+	Remixer     string `json:"remixer,omitempty"`
+	Genre       string `json:"genre,omitempty"`
 	TrackNumber int    `json:"trackNumber"`
+	Disc        int    `json:"disc,omitempty"`
+	Year        int    `json:"year,omitempty"`
 	Duration    int    `json:"duration"` // in seconds
 	FilePath    string `json:"-"`        // don't expose file path to client
 	FileSize    int64  `json:"fileSize"`
+	Codec       string `json:"codec,omitempty"` // e.g. flac, alac, aac; empty when unknown
 	HasAlbumArt bool   `json:"hasAlbumArt"`
 	AlbumArtID  string `json:"albumArtId,omitempty"` // For caching album art
 	Owner       string `json:"-"`                    // don't expose owner to client, used for filtering
+
+	// MainArtist is the first main artist credit parsed from Artist, without
+	// featured artists ("A" for "A feat. B"). Set on tracks read from the
+	// database; empty otherwise.
+	MainArtist string `json:"-"`
+
+	// Sort tags (TSOP, ARTISTSORT, ...) as read from the file; empty when
+	// absent or when an override replaced the value they belong to.
+	ArtistSort      string `json:"artistSort,omitempty"`
+	AlbumArtistSort string `json:"albumArtistSort,omitempty"`
+	AlbumSort       string `json:"albumSort,omitempty"`
+	TitleSort       string `json:"titleSort,omitempty"`
+
+	// Virtual tracks defined by a cue sheet play a segment of SourcePath.
+	// CueStart/CueEnd are in CD frames (1/75 s); CueEnd 0 means end of file.
+	SourcePath string `json:"-"`
+	CueStart   int    `json:"-"`
+	CueEnd     int    `json:"-"`
+}
+
+// IsVirtual reports whether the track is a cue sheet segment of another file.
+func (t Track) IsVirtual() bool {
+	return t.SourcePath != ""
+}
+
+// AlbumGroupArtist returns the artist the track's album is grouped under:
+// VariousArtists for compilations, otherwise the album artist, falling back
+// to the main artist, so guest appearances don't split an album, and then
+// the track artist. Albums are keyed on this, the album and the year.
+func (t Track) AlbumGroupArtist() string {
+	switch {
+	case t.Compilation:
+		return VariousArtists
+	case t.AlbumArtist != "":
+		return t.AlbumArtist
+	case t.MainArtist != "":
+		return t.MainArtist
+	default:
+		return t.Artist
+	}
+}
+
+// AlbumGroupArtistSort returns the sort tag for AlbumGroupArtist, if any.
+// The artist sort tag only applies while the main artist is the whole tag.
+func (t Track) AlbumGroupArtistSort() string {
+	switch {
+	case t.Compilation:
+		return ""
+	case t.AlbumArtist != "":
+		return t.AlbumArtistSort
+	case t.MainArtist != "" && t.MainArtist != t.Artist:
+		return ""
+	default:
+		return t.ArtistSort
+	}
+}
+
+// AudioPath returns the file holding the track's audio.
+func (t Track) AudioPath() string {
+	if t.SourcePath != "" {
+		return t.SourcePath
+	}
+	return t.FilePath
+}
+
+// Playlist visibilities.
+const (
+	// VisibilityPrivate playlists are seen only by their owner.
+	VisibilityPrivate = "private"
+	// VisibilityShared playlists can be opened by any user given their ID,
+	// but are only listed for their owner.
+	VisibilityShared = "shared"
+	// VisibilityPublic playlists are listed for every user.
+	VisibilityPublic = "public"
+)
+
+// Playlist collaborator roles, from least to most capable.
+const (
+	// PlaylistRoleViewer may view a playlist, even a private one.
+	PlaylistRoleViewer = "viewer"
+	// PlaylistRoleEditor may also change its entries and smart rules.
+	PlaylistRoleEditor = "editor"
+	// PlaylistRoleOwner may also rename, delete and share it, like the
+	// user who created it.
+	PlaylistRoleOwner = "owner"
+)
+
+// playlistRoleRanks orders the playlist roles.
+var playlistRoleRanks = map[string]int{
+	PlaylistRoleViewer: 1,
+	PlaylistRoleEditor: 2,
+	PlaylistRoleOwner:  3,
 }
 
-// Playlist represents a user-created playlist.
+// IsValidPlaylistRole reports whether role is one of the playlist roles.
+func IsValidPlaylistRole(role string) bool {
+	return playlistRoleRanks[role] > 0
+}
+
+// PlaylistRoleAllows reports whether role grants at least what need does.
+// The empty role grants nothing.
+func PlaylistRoleAllows(role, need string) bool {
+	return role != "" && playlistRoleRanks[role] >= playlistRoleRanks[need]
+}
+
+// Playlist represents a user-created playlist. Owner is empty for
+// playlists created without auth or before playlists had owners.
+// Role is the requesting user's role on the playlist, set by the API.
+// Playlists filed in a folder have its visibility, and are ordered among
+// its playlists by Position.
+// File-backed playlists mirror a playlist file found in a library, named by
+// FilePath. Smart playlists hold the tracks matching their Rules, evaluated
+// when read, instead of stored entries.
 type Playlist struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description,omitempty"`
-	CoverPath   string    `json:"coverPath,omitempty"`
-	CreatedAt   time.Time `json:"createdAt"`
-	TrackCount  int       `json:"trackCount"`
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	CoverPath   string      `json:"coverPath,omitempty"`
+	Owner       string      `json:"owner,omitempty"`
+	Visibility  string      `json:"visibility"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	TrackCount  int         `json:"trackCount"`
+	FileBacked  bool        `json:"fileBacked"`
+	FilePath    string      `json:"-"` // don't expose file path to client
+	Smart       bool        `json:"smart"`
+	Rules       *SmartRules `json:"rules,omitempty"`
+	Role        string      `json:"role,omitempty"`
+	FolderID    int         `json:"folderId,omitempty"`
+	Position    int         `json:"position"`
+}
+
+// IsValidVisibility reports whether v is one of the playlist visibilities.
+func IsValidVisibility(v string) bool {
+	return v == VisibilityPrivate || v == VisibilityShared || v == VisibilityPublic
+}
+
+// VisibleTo reports whether user may view the playlist: its owner always
+// can, anyone else unless it is private.
+func (p *Playlist) VisibleTo(user string) bool {
+	return p.Owner == user || p.Visibility != VisibilityPrivate
+}
+
+// PlaylistEntry is one entry of a playlist: a track and the ID of its place
+// in the playlist, which tells apart entries of a track added twice, with
+// who added it and when. AddedBy is empty for entries added without auth,
+// by a playlist file or before entries recorded it.
+type PlaylistEntry struct {
+	Track
+	EntryID int        `json:"entryId"`
+	AddedBy string     `json:"addedBy,omitempty"`
+	AddedAt *time.Time `json:"addedAt,omitempty"`
+}
+
+// PlaylistCollaborator is a user given a role on a playlist by one of its
+// owners.
+type PlaylistCollaborator struct {
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	AddedBy  string    `json:"addedBy,omitempty"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+// Playlist edit operations.
+const (
+	// PlaylistOpMove moves entry EntryID to Position.
+	PlaylistOpMove = "move"
+	// PlaylistOpInsert inserts track TrackID at Position, or appends it
+	// when Position is nil.
+	PlaylistOpInsert = "insert"
+	// PlaylistOpRemove removes entry EntryID.
+	PlaylistOpRemove = "remove"
+	// PlaylistOpReplace puts track TrackID in place of entry EntryID's track.
+	PlaylistOpReplace = "replace"
+)
+
+// PlaylistOp is one operation of a playlist edit. Positions are 0-based
+// indexes into the playlist as left by the operations before it.
+type PlaylistOp struct {
+	Op       string `json:"op"`
+	EntryID  int    `json:"entryId,omitempty"`
+	TrackID  int    `json:"trackId,omitempty"`
+	Position *int   `json:"position,omitempty"`
 }
 
 // PlaylistTrack represents the relationship between playlists and tracks.
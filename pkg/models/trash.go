@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// TrashItem is a deleted track's file waiting in its library's trash
+// directory to be restored or purged.
+type TrashItem struct {
+	ID        int       `json:"id"`
+	Path      string    `json:"path"` // original path, relative to its library root
+	Owner     string    `json:"owner,omitempty"`
+	Title     string    `json:"title"`
+	Artist    string    `json:"artist"`
+	Album     string    `json:"album"`
+	FileSize  int64     `json:"fileSize"`
+	DeletedBy string    `json:"deletedBy,omitempty"`
+	DeletedAt time.Time `json:"deletedAt"`
+
+	OriginalPath string `json:"-"`
+	TrashPath    string `json:"-"`
+}
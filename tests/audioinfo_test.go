@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"staccato/internal/database"
+	"staccato/internal/metadata"
+)
+
+// oggCRC computes the Ogg page checksum (CRC-32, polynomial 0x04c11db7,
+// unreflected).
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// oggPage encodes one Ogg page holding a single packet of under 255 bytes.
+func oggPage(flags byte, granule int64, seq uint32, packet []byte) []byte {
+	page := make([]byte, 28, 28+len(packet))
+	copy(page, "OggS")
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:], 0x5EED)
+	binary.LittleEndian.PutUint32(page[18:], seq)
+	page[26] = 1
+	page[27] = byte(len(packet))
+	page = append(page, packet...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	return page
+}
+
+// buildTestOgg writes an Ogg stream whose first packet is ident, followed by
+// a comment packet, an audio page and a final page at granule.
+func buildTestOgg(t *testing.T, path string, ident, comments []byte, granule int64) {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.Write(oggPage(0x02, 0, 0, ident))
+	buf.Write(oggPage(0, 0, 1, comments))
+	buf.Write(oggPage(0, granule/2, 2, bytes.Repeat([]byte{0x55}, 200)))
+	buf.Write(oggPage(0x04, granule, 3, bytes.Repeat([]byte{0x55}, 100)))
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test Ogg: %v", err)
+	}
+}
+
+// vorbisComments builds a comment header packet with the given prefix.
+func vorbisComments(prefix string, fields ...string) []byte {
+	var p bytes.Buffer
+	p.WriteString(prefix)
+	binary.Write(&p, binary.LittleEndian, uint32(4))
+	p.WriteString("test")
+	binary.Write(&p, binary.LittleEndian, uint32(len(fields)))
+	for _, f := range fields {
+		binary.Write(&p, binary.LittleEndian, uint32(len(f)))
+		p.WriteString(f)
+	}
+	if prefix == "\x03vorbis" {
+		p.WriteByte(1) // framing bit
+	}
+	return p.Bytes()
+}
+
+// id3v23Title builds a minimal ID3v2.3 tag holding a TIT2 frame.
+func id3v23Title(title string) []byte {
+	frame := append([]byte("TIT2"), 0, 0, 0, byte(len(title)+1), 0, 0, 0)
+	frame = append(frame, title...)
+	tag := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(frame))}
+	return append(tag, frame...)
+}
+
+// buildTestAIFF writes an AIFF file with silent 16-bit PCM and, if id3 is
+// non-nil, an "ID3 " chunk.
+func buildTestAIFF(t *testing.T, path string, sampleRate float64, channels, frames int, id3 []byte) {
+	t.Helper()
+	comm := make([]byte, 18)
+	binary.BigEndian.PutUint16(comm[0:], uint16(channels))
+	binary.BigEndian.PutUint32(comm[2:], uint32(frames))
+	binary.BigEndian.PutUint16(comm[6:], 16)
+	// 80-bit extended float: normalised mantissa with explicit integer bit
+	exp := math.Ilogb(sampleRate)
+	binary.BigEndian.PutUint16(comm[8:], uint16(exp+16383))
+	binary.BigEndian.PutUint64(comm[10:], uint64(sampleRate*math.Pow(2, float64(63-exp))))
+	ssnd := make([]byte, 8+frames*channels*2)
+
+	var body bytes.Buffer
+	body.WriteString("AIFF")
+	for _, chunk := range []struct {
+		id   string
+		data []byte
+	}{{"COMM", comm}, {"SSND", ssnd}, {"ID3 ", id3}} {
+		if chunk.data == nil {
+			continue
+		}
+		body.WriteString(chunk.id)
+		binary.Write(&body, binary.BigEndian, uint32(len(chunk.data)))
+		body.Write(chunk.data)
+		if len(chunk.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	var file bytes.Buffer
+	file.WriteString("FORM")
+	binary.Write(&file, binary.BigEndian, uint32(body.Len()))
+	file.Write(body.Bytes())
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test AIFF: %v", err)
+	}
+}
+
+// buildTestADTS writes frames of raw AAC with ADTS headers (one raw data
+// block of 1024 samples each) behind an empty ID3v2 tag.
+func buildTestADTS(t *testing.T, path string, rateIndex, channels, frames int) {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 10})
+	buf.Write(make([]byte, 10))
+	const frameLen = 7 + 32
+	for i := 0; i < frames; i++ {
+		h := []byte{
+			0xFF, 0xF1,
+			byte(1<<6 | rateIndex<<2 | channels>>2),
+			byte(channels&3<<6 | frameLen>>11),
+			byte(frameLen >> 3),
+			byte(frameLen&7<<5 | 0x1F),
+			0xFC,
+		}
+		buf.Write(h)
+		buf.Write(make([]byte, frameLen-7))
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test AAC: %v", err)
+	}
+}
+
+// buildTestMP4Audio writes an M4A with one sound track using the given
+// sample entry type ("mp4a" or "alac").
+func buildTestMP4Audio(t *testing.T, path, entryType string, timescale, units uint32) {
+	t.Helper()
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], units)
+	hdlr := make([]byte, 25)
+	copy(hdlr[8:], "soun")
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[6:], 1)
+	binary.BigEndian.PutUint16(entry[16:], 2)
+	binary.BigEndian.PutUint16(entry[18:], 16)
+	binary.BigEndian.PutUint32(entry[24:], 44100<<16)
+	stsd := append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, mp4TestAtom(entryType, entry)...)
+
+	moov := mp4TestAtom("moov",
+		mp4TestAtom("mvhd", mvhd),
+		mp4TestAtom("trak", mp4TestAtom("mdia",
+			mp4TestAtom("hdlr", hdlr),
+			mp4TestAtom("minf", mp4TestAtom("stbl", mp4TestAtom("stsd", stsd))))))
+	ftyp := mp4TestAtom("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42isom"))
+	file := bytes.Join([][]byte{ftyp, moov, mp4TestAtom("mdat", testAudioPayload)}, nil)
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatalf("Failed to write test M4A: %v", err)
+	}
+}
+
+func TestAudioInfo(t *testing.T) {
+	dir := t.TempDir()
+
+	vorbisIdent := make([]byte, 30)
+	copy(vorbisIdent, "\x01vorbis")
+	vorbisIdent[11] = 2
+	binary.LittleEndian.PutUint32(vorbisIdent[12:], 44100)
+
+	opusIdent := make([]byte, 19)
+	copy(opusIdent, "OpusHead")
+	opusIdent[8] = 1
+	opusIdent[9] = 2
+	binary.LittleEndian.PutUint16(opusIdent[10:], 312)
+	binary.LittleEndian.PutUint32(opusIdent[12:], 44100)
+
+	flacIdent := make([]byte, 51)
+	copy(flacIdent, "\x7fFLAC\x01\x00\x00\x01fLaC")
+	// STREAMINFO: 48000 Hz, 2 channels, 24 bits
+	si := flacIdent[17:]
+	si[10], si[11], si[12], si[13] = 0x0B, 0xB8, 0x03, 0x70
+
+	buildTestOgg(t, filepath.Join(dir, "vorbis.ogg"), vorbisIdent, vorbisComments("\x03vorbis", "TITLE=Ogg Song", "ARTIST=Ogg Artist"), 44100*125)
+	buildTestOgg(t, filepath.Join(dir, "voice.opus"), opusIdent, vorbisComments("OpusTags", "TITLE=Opus Song"), 48000*61+312)
+	buildTestOgg(t, filepath.Join(dir, "lossless.oga"), flacIdent, vorbisComments("\x84\x00\x00\x00"), 48000*30)
+	buildTestAIFF(t, filepath.Join(dir, "pcm.aiff"), 44100, 2, 44100*42, id3v23Title("AIFF Song"))
+	buildTestADTS(t, filepath.Join(dir, "raw.aac"), 4, 2, 44100*7/1024)
+	buildTestMP4Audio(t, filepath.Join(dir, "lossy.m4a"), "mp4a", 1000, 95000)
+	buildTestMP4Audio(t, filepath.Join(dir, "lossless.m4a"), "alac", 44100, 44100*200)
+	writeTestFLAC(t, filepath.Join(dir, "mono.flac"), make([]int16, 8000*3+4000), 8000)
+	writeTestWAV(t, filepath.Join(dir, "mono.wav"), make([]int16, 8000*3+4000), 8000, 1)
+
+	testCases := []struct {
+		file     string
+		codec    string
+		rate     int
+		channels int
+		bits     int
+		duration time.Duration
+		lossless bool
+	}{
+		{"vorbis.ogg", metadata.CodecVorbis, 44100, 2, 0, 125 * time.Second, false},
+		{"voice.opus", metadata.CodecOpus, 44100, 2, 0, 61 * time.Second, false},
+		{"lossless.oga", metadata.CodecFLAC, 48000, 2, 24, 30 * time.Second, true},
+		{"pcm.aiff", metadata.CodecPCM, 44100, 2, 16, 42 * time.Second, true},
+		{"raw.aac", metadata.CodecAAC, 44100, 2, 0, time.Duration(44100*7/1024) * 1024 * time.Second / 44100, false},
+		{"lossy.m4a", metadata.CodecAAC, 44100, 2, 0, 95 * time.Second, false},
+		{"lossless.m4a", metadata.CodecALAC, 44100, 2, 16, 200 * time.Second, true},
+		{"mono.flac", metadata.CodecFLAC, 8000, 1, 16, 3500 * time.Millisecond, true},
+		{"mono.wav", metadata.CodecPCM, 8000, 1, 16, 3500 * time.Millisecond, true},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(dir, tc.file)
+		info, err := metadata.ReadAudioInfo(path)
+		if err != nil {
+			t.Errorf("ReadAudioInfo(%s) failed: %v", tc.file, err)
+			continue
+		}
+		if info.Codec != tc.codec || info.SampleRate != tc.rate || info.Channels != tc.channels || info.BitsPerSample != tc.bits {
+			t.Errorf("ReadAudioInfo(%s) = %+v", tc.file, info)
+		}
+		if info.Duration != tc.duration {
+			t.Errorf("ReadAudioInfo(%s) duration = %v, want %v", tc.file, info.Duration, tc.duration)
+		}
+		if got := metadata.IsLosslessFormat(path); got != tc.lossless {
+			t.Errorf("IsLosslessFormat(%s) = %v, want %v", tc.file, got, tc.lossless)
+		}
+	}
+
+	t.Run("Extractor", func(t *testing.T) {
+		extractor := metadata.NewExtractor([]string{".ogg", ".opus", ".oga", ".aiff", ".aac", ".m4a"})
+		track, err := extractor.ExtractFromFile(filepath.Join(dir, "vorbis.ogg"), 0)
+		if err != nil {
+			t.Fatalf("ExtractFromFile failed: %v", err)
+		}
+		if track.Duration != 125 || track.Title != "Ogg Song" || track.Artist != "Ogg Artist" {
+			t.Errorf("Unexpected Ogg track: %+v", track)
+		}
+		track, err = extractor.ExtractFromFile(filepath.Join(dir, "voice.opus"), 0)
+		if err != nil || track.Duration != 61 || track.Title != "Opus Song" {
+			t.Errorf("Unexpected Opus track: %+v (%v)", track, err)
+		}
+		track, err = extractor.ExtractFromFile(filepath.Join(dir, "pcm.aiff"), 0)
+		if err != nil || track.Duration != 42 || track.Title != "AIFF Song" {
+			t.Errorf("Unexpected AIFF track: %+v (%v)", track, err)
+		}
+	})
+
+	t.Run("StoredCodec", func(t *testing.T) {
+		db, err := database.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("Failed to create test database: %v", err)
+		}
+		defer db.Close()
+
+		extractor := metadata.NewExtractor([]string{".ogg", ".opus", ".oga", ".aiff", ".aac", ".m4a", ".flac", ".wav"})
+		for _, tc := range testCases {
+			track, err := extractor.ExtractFromFile(filepath.Join(dir, tc.file), 0)
+			if err != nil {
+				t.Errorf("ExtractFromFile(%s) failed: %v", tc.file, err)
+				continue
+			}
+			id, err := db.InsertTrack(track)
+			if err != nil {
+				t.Fatalf("Failed to insert %s: %v", tc.file, err)
+			}
+			stored, err := db.GetTrackByID(id)
+			if err != nil {
+				t.Fatalf("Failed to get %s: %v", tc.file, err)
+			}
+			if stored.Codec != tc.codec {
+				t.Errorf("%s: expected codec %q, got %q", tc.file, tc.codec, stored.Codec)
+			}
+		}
+	})
+}
@@ -0,0 +1,408 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"staccato/internal/config"
+	"staccato/internal/database"
+	"staccato/internal/server"
+	"staccato/pkg/models"
+)
+
+// authzUsers are the callers every authorization case is run as: alice owns
+// the playlist or track under test, carol and dave collaborate on it as
+// viewer and editor, bob has nothing to do with it.
+var authzUsers = []struct {
+	name string
+	as   string
+}{
+	{"NonOwner", "bob"},
+	{"Viewer", "carol"},
+	{"Editor", "dave"},
+	{"Owner", "alice"},
+	{"Admin", "admin"},
+}
+
+// authzServer is a music server with auth enabled, driven through its HTTP
+// handler by logged-in users.
+type authzServer struct {
+	t        *testing.T
+	db       *database.Database
+	handler  http.Handler
+	cfg      *config.Config
+	sessions map[string][]*http.Cookie
+}
+
+// newAuthzServer starts a server whose user store, in a temporary
+// directory, holds an admin and the users of authzUsers, and logs them in.
+func newAuthzServer(t *testing.T, userFolders bool) *authzServer {
+	t.Helper()
+	testDir := t.TempDir()
+
+	usersFile := filepath.Join(testDir, "users.toml")
+	users := "[[users]]\nusername = \"admin\"\npassword = \"admin-pass\"\nrole = \"admin\"\n"
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		users += fmt.Sprintf("\n[[users]]\nusername = %q\npassword = \"%s-pass\"\nrole = \"user\"\n", name, name)
+	}
+	if err := os.WriteFile(usersFile, []byte(users), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(testDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Port:      "8080",
+			Host:      "localhost",
+			StaticDir: "./static",
+		},
+		Music: config.MusicConfig{
+			LibraryPath:      filepath.Join(testDir, "music"),
+			SupportedFormats: []string{".mp3", ".flac", ".wav", ".m4a"},
+		},
+		Auth: config.AuthConfig{
+			Enabled:         true,
+			UsersFilePath:   usersFile,
+			SessionDuration: "1h",
+			UserFolders:     userFolders,
+			UserMusicPath:   filepath.Join(testDir, "users"),
+		},
+	}
+	ms, err := server.NewMusicServer(cfg, db)
+	if err != nil {
+		t.Fatalf("Failed to create music server: %v", err)
+	}
+
+	s := &authzServer{t: t, db: db, handler: ms.Handler(), cfg: cfg, sessions: map[string][]*http.Cookie{}}
+	for _, name := range []string{"admin", "alice", "bob", "carol", "dave"} {
+		body := fmt.Sprintf(`{"username": %q, "password": "%s-pass"}`, name, name)
+		rr := s.do("", http.MethodPost, "/api/auth/login", body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Failed to log in %s: %d %s", name, rr.Code, rr.Body.String())
+		}
+		s.sessions[name] = rr.Result().Cookies()
+	}
+	return s
+}
+
+// do sends a JSON request as user ("" for an anonymous one).
+func (s *authzServer) do(user, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	return s.send(user, req)
+}
+
+// doForm sends a multipart form request as user.
+func (s *authzServer) doForm(user, method, path string, fields map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	form.Close()
+	req := httptest.NewRequest(method, path, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return s.send(user, req)
+}
+
+func (s *authzServer) send(user string, req *http.Request) *httptest.ResponseRecorder {
+	req.Header.Set("Accept", "application/json")
+	for _, cookie := range s.sessions[user] {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// must sends a JSON request as user that has to succeed and decodes its
+// response into out (if not nil).
+func (s *authzServer) must(user, method, path, body string, out interface{}) {
+	s.t.Helper()
+	rr := s.do(user, method, path, body)
+	if rr.Code != http.StatusOK {
+		s.t.Fatalf("%s %s as %s: expected status 200, got %d: %s", method, path, user, rr.Code, rr.Body.String())
+	}
+	if out != nil {
+		if err := json.NewDecoder(rr.Body).Decode(out); err != nil {
+			s.t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+}
+
+// sharedPlaylist has alice create a playlist with the given visibility and
+// tracks, shared with carol as viewer and dave as editor.
+func (s *authzServer) sharedPlaylist(visibility string, trackIDs ...int) int {
+	s.t.Helper()
+	var created struct {
+		ID int `json:"id"`
+	}
+	body := fmt.Sprintf(`{"name": "Mixtape", "visibility": %q}`, visibility)
+	s.must("alice", http.MethodPost, "/api/playlists/create", body, &created)
+	for _, id := range trackIDs {
+		s.must("alice", http.MethodPost, fmt.Sprintf("/api/playlists/%d/tracks", created.ID), fmt.Sprintf(`{"trackId": %d}`, id), nil)
+	}
+	s.must("alice", http.MethodPut, fmt.Sprintf("/api/playlists/%d/collaborators/carol", created.ID), `{"role": "viewer"}`, nil)
+	s.must("alice", http.MethodPut, fmt.Sprintf("/api/playlists/%d/collaborators/dave", created.ID), `{"role": "editor"}`, nil)
+	return created.ID
+}
+
+// addTrack stores a FLAC file as a track of owner's library ("" for the
+// main library) and returns its ID.
+func (s *authzServer) addTrack(owner, name string) int {
+	s.t.Helper()
+	dir := s.cfg.Music.LibraryPath
+	if owner != "" {
+		dir = filepath.Join(s.cfg.Auth.UserMusicPath, owner)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		s.t.Fatalf("Failed to create library directory: %v", err)
+	}
+	path := filepath.Join(dir, name+".flac")
+	writeTestFLAC(s.t, path, make([]int16, 8000), 8000)
+	info, err := os.Stat(path)
+	if err != nil {
+		s.t.Fatalf("Failed to stat test track: %v", err)
+	}
+	id, err := s.db.InsertTrack(models.Track{
+		Title:    name,
+		Artist:   "Test Artist",
+		Album:    "Test Album",
+		FilePath: path,
+		FileSize: info.Size(),
+		Owner:    owner,
+	})
+	if err != nil {
+		s.t.Fatalf("Failed to insert test track: %v", err)
+	}
+	return id
+}
+
+func TestPlaylistAuthorization(t *testing.T) {
+	s := newAuthzServer(t, false)
+	trackID := s.addTrack("", "Song")
+
+	testCases := []struct {
+		name       string
+		visibility string
+		request    func(user string, playlistID int) *httptest.ResponseRecorder
+		want       map[string]int // by user
+	}{
+		{
+			name:       "ViewPrivate",
+			visibility: models.VisibilityPrivate,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodGet, fmt.Sprintf("/api/playlists/%d/tracks", id), "")
+			},
+			want: map[string]int{"bob": 404, "carol": 200, "dave": 200, "alice": 200, "admin": 200},
+		},
+		{
+			name:       "ViewPublic",
+			visibility: models.VisibilityPublic,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodGet, fmt.Sprintf("/api/playlists/%d/tracks", id), "")
+			},
+			want: map[string]int{"bob": 200, "carol": 200, "dave": 200, "alice": 200, "admin": 200},
+		},
+		{
+			name:       "AddTrackPrivate",
+			visibility: models.VisibilityPrivate,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodPost, fmt.Sprintf("/api/playlists/%d/tracks", id), fmt.Sprintf(`{"trackId": %d}`, trackID))
+			},
+			want: map[string]int{"bob": 404, "carol": 403, "dave": 200, "alice": 200, "admin": 200},
+		},
+		{
+			name:       "AddTrackPublic",
+			visibility: models.VisibilityPublic,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodPost, fmt.Sprintf("/api/playlists/%d/tracks", id), fmt.Sprintf(`{"trackId": %d}`, trackID))
+			},
+			want: map[string]int{"bob": 403, "carol": 403, "dave": 200, "alice": 200, "admin": 200},
+		},
+		{
+			name:       "RemoveTrack",
+			visibility: models.VisibilityPrivate,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodDelete, fmt.Sprintf("/api/playlists/%d/tracks/%d", id, trackID), "")
+			},
+			want: map[string]int{"bob": 404, "carol": 403, "dave": 200, "alice": 200, "admin": 200},
+		},
+		{
+			name:       "Rename",
+			visibility: models.VisibilityPrivate,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.doForm(user, http.MethodPut, fmt.Sprintf("/api/playlists/%d", id), map[string]string{"name": "Renamed"})
+			},
+			want: map[string]int{"bob": 404, "carol": 403, "dave": 403, "alice": 200, "admin": 200},
+		},
+		{
+			name:       "Share",
+			visibility: models.VisibilityPrivate,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodPut, fmt.Sprintf("/api/playlists/%d/collaborators/bob", id), `{"role": "viewer"}`)
+			},
+			want: map[string]int{"bob": 404, "carol": 403, "dave": 403, "alice": 200, "admin": 200},
+		},
+		{
+			name:       "RemoveViewer",
+			visibility: models.VisibilityPrivate,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodDelete, fmt.Sprintf("/api/playlists/%d/collaborators/carol", id), "")
+			},
+			// carol may leave the playlist herself
+			want: map[string]int{"bob": 404, "carol": 200, "dave": 403, "alice": 200, "admin": 200},
+		},
+		{
+			name:       "Delete",
+			visibility: models.VisibilityPrivate,
+			request: func(user string, id int) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodDelete, fmt.Sprintf("/api/playlists/%d", id), "")
+			},
+			want: map[string]int{"bob": 404, "carol": 403, "dave": 403, "alice": 200, "admin": 200},
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, u := range authzUsers {
+			t.Run(tc.name+"/"+u.name, func(t *testing.T) {
+				playlistID := s.sharedPlaylist(tc.visibility, trackID)
+				rr := tc.request(u.as, playlistID)
+				if rr.Code != tc.want[u.as] {
+					t.Errorf("Expected status %d, got %d: %s", tc.want[u.as], rr.Code, rr.Body.String())
+				}
+			})
+		}
+	}
+
+	t.Run("Roles", func(t *testing.T) {
+		playlistID := s.sharedPlaylist(models.VisibilityPrivate)
+		want := map[string]string{
+			"bob":   "",
+			"carol": models.PlaylistRoleViewer,
+			"dave":  models.PlaylistRoleEditor,
+			"alice": models.PlaylistRoleOwner,
+			"admin": models.PlaylistRoleOwner,
+		}
+		for _, u := range authzUsers {
+			// Administrators list other users' private playlists on request
+			path := "/api/playlists"
+			if u.as == "admin" {
+				path += "?scope=all"
+			}
+			var playlists []models.Playlist
+			s.must(u.as, http.MethodGet, path, "", &playlists)
+			role := ""
+			for _, playlist := range playlists {
+				if playlist.ID == playlistID {
+					role = playlist.Role
+				}
+			}
+			if role != want[u.as] {
+				t.Errorf("%s: expected role %q, got %q", u.name, want[u.as], role)
+			}
+		}
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		playlistID := s.sharedPlaylist(models.VisibilityPublic)
+		rr := s.do("", http.MethodGet, fmt.Sprintf("/api/playlists/%d/tracks", playlistID), "")
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", rr.Code)
+		}
+	})
+}
+
+func TestTrackAuthorization(t *testing.T) {
+	s := newAuthzServer(t, true)
+
+	// ownedTrack returns a new track of alice's, in a playlist she shares
+	// with carol and dave, so collaborators are checked too.
+	count := 0
+	ownedTrack := func() int {
+		count++
+		trackID := s.addTrack("alice", fmt.Sprintf("Song %d", count))
+		s.sharedPlaylist(models.VisibilityPrivate, trackID)
+		return trackID
+	}
+	// trashedTrack returns the trash entry of a new track of alice's that
+	// she deleted.
+	trashedTrack := func() int {
+		var item models.TrashItem
+		s.must("alice", http.MethodDelete, fmt.Sprintf("/api/tracks/%d", ownedTrack()), "", &item)
+		return item.ID
+	}
+
+	testCases := []struct {
+		name    string
+		request func(user string) *httptest.ResponseRecorder
+		want    map[string]int // by user
+	}{
+		{
+			name: "EditTags",
+			request: func(user string) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodPatch, fmt.Sprintf("/api/tracks/%d", ownedTrack()), `{"title": "Retitled"}`)
+			},
+			want: map[string]int{"bob": 403, "carol": 403, "dave": 403, "alice": 200, "admin": 200},
+		},
+		{
+			name: "Delete",
+			request: func(user string) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodDelete, fmt.Sprintf("/api/tracks/%d", ownedTrack()), "")
+			},
+			want: map[string]int{"bob": 403, "carol": 403, "dave": 403, "alice": 200, "admin": 200},
+		},
+		{
+			name: "PurgeTrash",
+			request: func(user string) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodDelete, fmt.Sprintf("/api/trash/%d", trashedTrack()), "")
+			},
+			want: map[string]int{"bob": 404, "carol": 404, "dave": 404, "alice": 204, "admin": 204},
+		},
+		{
+			name: "RestoreTrash",
+			request: func(user string) *httptest.ResponseRecorder {
+				return s.do(user, http.MethodPost, fmt.Sprintf("/api/trash/%d/restore", trashedTrack()), "")
+			},
+			want: map[string]int{"bob": 404, "carol": 404, "dave": 404, "alice": 200, "admin": 200},
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, u := range authzUsers {
+			t.Run(tc.name+"/"+u.name, func(t *testing.T) {
+				rr := tc.request(u.as)
+				if rr.Code != tc.want[u.as] {
+					t.Errorf("Expected status %d, got %d: %s", tc.want[u.as], rr.Code, rr.Body.String())
+				}
+			})
+		}
+	}
+
+	t.Run("ListTrash", func(t *testing.T) {
+		itemID := trashedTrack()
+		for _, u := range authzUsers {
+			var items []models.TrashItem
+			s.must(u.as, http.MethodGet, "/api/trash", "", &items)
+			listed := false
+			for _, item := range items {
+				listed = listed || item.ID == itemID
+			}
+			if want := u.as == "alice" || u.as == "admin"; listed != want {
+				t.Errorf("%s: expected listed %v, got %v", u.name, want, listed)
+			}
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"staccato/internal/database"
+	"staccato/internal/metadata"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+const testCueSheet = `REM GENRE Rock
+PERFORMER "The Band"
+TITLE "Live Album"
+FILE "album.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "Opening"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Middle"
+    PERFORMER "Guest Singer"
+    INDEX 00 00:03:50
+    INDEX 01 00:04:00
+  TRACK 03 AUDIO
+    INDEX 01 00:07:30
+`
+
+// writeTestFLAC encodes 16-bit mono samples as a FLAC stream whose only
+// metadata block is STREAMINFO.
+func writeTestFLAC(t *testing.T, path string, samples []int16, sampleRate int) {
+	t.Helper()
+	const blockSize = 4096
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create test FLAC: %v", err)
+	}
+	defer f.Close()
+
+	info := &meta.StreamInfo{
+		BlockSizeMin:  16,
+		BlockSizeMax:  blockSize,
+		SampleRate:    uint32(sampleRate),
+		NChannels:     1,
+		BitsPerSample: 16,
+		NSamples:      uint64(len(samples)),
+	}
+	enc, err := flac.NewEncoder(f, info)
+	if err != nil {
+		t.Fatalf("Failed to create FLAC encoder: %v", err)
+	}
+	for num, start := 0, 0; start < len(samples); num, start = num+1, start+blockSize {
+		end := start + blockSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		block := make([]int32, end-start)
+		for i, s := range samples[start:end] {
+			block[i] = int32(s)
+		}
+		fr := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         uint16(len(block)),
+				SampleRate:        uint32(sampleRate),
+				Channels:          frame.ChannelsMono,
+				BitsPerSample:     16,
+				Num:               uint64(num),
+			},
+			Subframes: []*frame.Subframe{{
+				SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+				Samples:   block,
+				NSamples:  len(block),
+			}},
+		}
+		if err := enc.WriteFrame(fr); err != nil {
+			t.Fatalf("Failed to write FLAC frame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Failed to finish FLAC stream: %v", err)
+	}
+}
+
+// readSegment reads a segment fully and checks it is a WAV holding want.
+func readSegment(t *testing.T, seg *metadata.Segment, want []int16) {
+	t.Helper()
+	data, err := io.ReadAll(seg)
+	if err != nil {
+		t.Fatalf("Failed to read segment: %v", err)
+	}
+	if len(data) != 44+len(want)*2 || string(data[0:4]) != "RIFF" || string(data[36:40]) != "data" {
+		t.Fatalf("Segment is %d bytes, want a WAV with %d samples", len(data), len(want))
+	}
+	for i, s := range want {
+		if got := int16(binary.LittleEndian.Uint16(data[44+i*2:])); got != s {
+			t.Fatalf("Sample %d = %d, want %d", i, got, s)
+		}
+	}
+
+	// Seeking into the middle must return the same bytes as a full read.
+	offset := int64(44 + len(want)) // odd sample boundary, mid-frame
+	if _, err := seg.Seek(offset, io.SeekStart); err != nil {
+		t.Fatalf("Failed to seek segment: %v", err)
+	}
+	tail, err := io.ReadAll(seg)
+	if err != nil {
+		t.Fatalf("Failed to read after seek: %v", err)
+	}
+	if !bytes.Equal(tail, data[offset:]) {
+		t.Error("Data after seek differs from sequential read")
+	}
+}
+
+func TestCueSheets(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		sheet, err := metadata.ParseCueSheet([]byte(testCueSheet))
+		if err != nil {
+			t.Fatalf("ParseCueSheet failed: %v", err)
+		}
+		if sheet.Title != "Live Album" || sheet.Performer != "The Band" || len(sheet.Tracks) != 3 {
+			t.Fatalf("Unexpected sheet: %+v", sheet)
+		}
+		second := sheet.Tracks[1]
+		if second.Title != "Middle" || second.Performer != "Guest Singer" || second.File != "album.wav" {
+			t.Errorf("Unexpected track 2: %+v", second)
+		}
+		if second.Start != 4*75 || second.End != 7*75+30 || sheet.Tracks[2].End != 0 {
+			t.Errorf("Unexpected positions: %+v", sheet.Tracks)
+		}
+
+		latin1 := []byte("FILE \"a.wav\" WAVE\n  TRACK 01 AUDIO\n    TITLE \"Caf\xe9\"\n    INDEX 01 00:00:00\n")
+		if sheet, err := metadata.ParseCueSheet(latin1); err != nil || sheet.Tracks[0].Title != "Café" {
+			t.Errorf("Latin-1 title not decoded: %v %+v", err, sheet)
+		}
+
+		for _, bad := range []string{
+			"",
+			"TRACK 01 AUDIO\n INDEX 01 00:00:00\n",
+			"FILE a.wav WAVE\n TRACK 01 AUDIO\n INDEX 01 00:61:00\n",
+			"FILE a.wav WAVE\n TRACK 01 AUDIO\n INDEX 01 00:05:00\n TRACK 02 AUDIO\n INDEX 01 00:04:00\n",
+		} {
+			if _, err := metadata.ParseCueSheet([]byte(bad)); err == nil {
+				t.Errorf("Expected error for %q", bad)
+			}
+		}
+	})
+
+	const rate = 8000
+	samples := synthMelody(7, 10, rate, 1, 0)
+	// Track 2 runs from 4.0s to 7.4s.
+	track2 := samples[4*rate : 74*rate/10]
+
+	t.Run("ExtractWAV", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestWAV(t, filepath.Join(dir, "album.wav"), samples, rate, 1)
+		cuePath := filepath.Join(dir, "album.cue")
+		if err := os.WriteFile(cuePath, []byte(testCueSheet), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		extractor := metadata.NewExtractor([]string{".wav", ".flac"})
+		tracks, sources, err := extractor.ExtractCueTracks(cuePath)
+		if err != nil {
+			t.Fatalf("ExtractCueTracks failed: %v", err)
+		}
+		if len(tracks) != 3 || len(sources) != 1 || sources[0] != filepath.Join(dir, "album.wav") {
+			t.Fatalf("Unexpected result: %d tracks, sources %v", len(tracks), sources)
+		}
+		if tracks[1].FilePath != cuePath+"#02" || tracks[1].Artist != "Guest Singer" || tracks[1].Album != "Live Album" || tracks[1].Duration != 3 {
+			t.Errorf("Unexpected track 2: %+v", tracks[1])
+		}
+		if tracks[2].Title != "Track 03" || tracks[2].Artist != "The Band" || !tracks[2].IsVirtual() {
+			t.Errorf("Unexpected track 3: %+v", tracks[2])
+		}
+		if got := extractor.CueSheetCovering(sources[0]); got != cuePath {
+			t.Errorf("CueSheetCovering = %q, want %q", got, cuePath)
+		}
+
+		seg, err := metadata.OpenSegment(tracks[1].AudioPath(), tracks[1].CueStart, tracks[1].CueEnd)
+		if err != nil {
+			t.Fatalf("OpenSegment failed: %v", err)
+		}
+		defer seg.Close()
+		if seg.ContentType != "audio/wav" {
+			t.Errorf("Content type = %q", seg.ContentType)
+		}
+		readSegment(t, seg, track2)
+	})
+
+	t.Run("SegmentFLAC", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "album.flac")
+		writeTestFLAC(t, path, samples, rate)
+
+		seg, err := metadata.OpenSegment(path, 4*75, 7*75+30)
+		if err != nil {
+			t.Fatalf("OpenSegment failed: %v", err)
+		}
+		defer seg.Close()
+		readSegment(t, seg, track2)
+
+		last, err := metadata.OpenSegment(path, 7*75+30, 0)
+		if err != nil {
+			t.Fatalf("OpenSegment failed: %v", err)
+		}
+		defer last.Close()
+		readSegment(t, last, samples[74*rate/10:])
+	})
+
+	t.Run("Database", func(t *testing.T) {
+		dir := t.TempDir()
+		db, err := database.NewDatabase(filepath.Join(dir, "cue.db"))
+		if err != nil {
+			t.Fatalf("Failed to create test database: %v", err)
+		}
+		defer db.Close()
+
+		audio := filepath.Join(dir, "album.wav")
+		writeTestWAV(t, audio, samples, rate, 1)
+		cuePath := filepath.Join(dir, "album.cue")
+		if err := os.WriteFile(cuePath, []byte(testCueSheet), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tracks, _, err := metadata.NewExtractor([]string{".wav"}).ExtractCueTracks(cuePath)
+		if err != nil {
+			t.Fatalf("ExtractCueTracks failed: %v", err)
+		}
+		for _, track := range tracks {
+			if _, err := db.InsertTrack(track); err != nil {
+				t.Fatalf("Failed to insert track: %v", err)
+			}
+		}
+
+		all, err := db.GetAllTracks()
+		if err != nil || len(all) != 3 {
+			t.Fatalf("Expected 3 tracks, got %d (%v)", len(all), err)
+		}
+		for _, track := range all {
+			if track.TrackNumber == 2 && (track.SourcePath != audio || track.CueStart != 300 || track.CueEnd != 555) {
+				t.Errorf("Cue fields not stored: %+v", track)
+			}
+		}
+		if sources, err := db.GetCueSheetSources(cuePath); err != nil || len(sources) != 1 || sources[0] != audio {
+			t.Errorf("GetCueSheetSources = %v, %v", sources, err)
+		}
+
+		removed, err := db.PruneCueTracks(cuePath, []string{tracks[0].FilePath, tracks[1].FilePath})
+		if err != nil || removed != 1 {
+			t.Errorf("PruneCueTracks removed %d (%v), want 1", removed, err)
+		}
+		removed, err = db.RemoveTracksBySource(audio)
+		if err != nil || removed != 2 {
+			t.Errorf("RemoveTracksBySource removed %d (%v), want 2", removed, err)
+		}
+	})
+}
@@ -1,10 +1,18 @@
 package tests
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"staccato/internal/database"
+	"staccato/internal/metadata"
 	"staccato/pkg/models"
 )
 
@@ -256,7 +264,7 @@ func TestDatabasePlaylists(t *testing.T) {
 	}
 
 	t.Run("CreatePlaylist", func(t *testing.T) {
-		playlistID, err := db.CreatePlaylist("Test Playlist", "A test playlist")
+		playlistID, err := db.CreatePlaylist("Test Playlist", "A test playlist", "", models.VisibilityPrivate)
 		if err != nil {
 			t.Fatalf("Failed to create playlist: %v", err)
 		}
@@ -279,18 +287,18 @@ func TestDatabasePlaylists(t *testing.T) {
 
 	t.Run("PlaylistOperations", func(t *testing.T) {
 		// Create a playlist for testing operations
-		playlistID, err := db.CreatePlaylist("Operations Test", "Testing playlist operations")
+		playlistID, err := db.CreatePlaylist("Operations Test", "Testing playlist operations", "", models.VisibilityPrivate)
 		if err != nil {
 			t.Fatalf("Failed to create test playlist: %v", err)
 		}
 
 		// Add tracks to playlist
-		err = db.AddTrackToPlaylist(playlistID, trackID1)
+		err = db.AddTrackToPlaylist(playlistID, trackID1, "")
 		if err != nil {
 			t.Fatalf("Failed to add track 1 to playlist: %v", err)
 		}
 
-		err = db.AddTrackToPlaylist(playlistID, trackID2)
+		err = db.AddTrackToPlaylist(playlistID, trackID2, "")
 		if err != nil {
 			t.Fatalf("Failed to add track 2 to playlist: %v", err)
 		}
@@ -322,7 +330,7 @@ func TestDatabasePlaylists(t *testing.T) {
 		}
 
 		// Update playlist
-		err = db.UpdatePlaylist(playlistID, "Updated Name", "Updated description", "/path/to/cover.jpg")
+		err = db.UpdatePlaylist(playlistID, "Updated Name", "Updated description", "/path/to/cover.jpg", models.VisibilityPublic)
 		if err != nil {
 			t.Fatalf("Failed to update playlist: %v", err)
 		}
@@ -342,5 +350,1143 @@ func TestDatabasePlaylists(t *testing.T) {
 		if len(tracks) != 0 {
 			t.Errorf("Expected 0 tracks from deleted playlist, got %d", len(tracks))
 		}
+
+		if _, err := db.GetPlaylist(playlistID); !errors.Is(err, database.ErrPlaylistNotFound) {
+			t.Errorf("GetPlaylist of deleted playlist = %v, want ErrPlaylistNotFound", err)
+		}
+	})
+
+	t.Run("Visibility", func(t *testing.T) {
+		ids := map[string]int{}
+		for _, p := range []struct{ name, owner, visibility string }{
+			{"alice private", "alice", models.VisibilityPrivate},
+			{"alice shared", "alice", models.VisibilityShared},
+			{"alice public", "alice", models.VisibilityPublic},
+			{"bob private", "bob", models.VisibilityPrivate},
+		} {
+			id, err := db.CreatePlaylist(p.name, "", p.owner, p.visibility)
+			if err != nil {
+				t.Fatalf("Failed to create playlist %q: %v", p.name, err)
+			}
+			ids[p.name] = id
+		}
+
+		listed, err := db.GetPlaylistsVisibleTo("bob")
+		if err != nil {
+			t.Fatalf("Failed to list playlists: %v", err)
+		}
+		names := map[string]bool{}
+		for _, p := range listed {
+			names[p.Name] = true
+		}
+		if !names["bob private"] || !names["alice public"] || names["alice private"] || names["alice shared"] {
+			t.Errorf("Playlists listed for bob = %v, want his own and public ones", names)
+		}
+
+		for name, wantVisible := range map[string]bool{
+			"alice private": false,
+			"alice shared":  true,
+			"alice public":  true,
+		} {
+			p, err := db.GetPlaylist(ids[name])
+			if err != nil {
+				t.Fatalf("Failed to get playlist %q: %v", name, err)
+			}
+			if p.Owner != "alice" {
+				t.Errorf("Playlist %q owner = %q, want alice", name, p.Owner)
+			}
+			if p.VisibleTo("bob") != wantVisible {
+				t.Errorf("Playlist %q visible to bob = %v, want %v", name, !wantVisible, wantVisible)
+			}
+			if !p.VisibleTo("alice") {
+				t.Errorf("Playlist %q not visible to its owner", name)
+			}
+		}
+	})
+
+	t.Run("Collaborators", func(t *testing.T) {
+		id, err := db.CreatePlaylist("office", "", "alice", models.VisibilityPrivate)
+		if err != nil {
+			t.Fatalf("Failed to create playlist: %v", err)
+		}
+		if err := db.SetPlaylistCollaborator(id, "carol", models.PlaylistRoleViewer, "alice"); err != nil {
+			t.Fatalf("Failed to add collaborator: %v", err)
+		}
+		if err := db.SetPlaylistCollaborator(id, "carol", models.PlaylistRoleEditor, "dave"); err != nil {
+			t.Fatalf("Failed to change collaborator role: %v", err)
+		}
+		if err := db.SetPlaylistCollaborator(id, "erin", models.PlaylistRoleOwner, "alice"); err != nil {
+			t.Fatalf("Failed to add collaborator: %v", err)
+		}
+
+		collaborators, err := db.GetPlaylistCollaborators(id)
+		if err != nil {
+			t.Fatalf("Failed to list collaborators: %v", err)
+		}
+		if len(collaborators) != 2 || collaborators[0].Username != "carol" ||
+			collaborators[0].Role != models.PlaylistRoleEditor || collaborators[0].AddedBy != "alice" {
+			t.Errorf("Collaborators = %+v, want carol as an editor added by alice, then erin", collaborators)
+		}
+		if role, _ := db.GetPlaylistRole(id, "carol"); role != models.PlaylistRoleEditor {
+			t.Errorf("carol's role = %q, want editor", role)
+		}
+		if role, _ := db.GetPlaylistRole(id, "bob"); role != "" {
+			t.Errorf("bob's role = %q, want none", role)
+		}
+
+		listed, err := db.GetPlaylistsVisibleTo("carol")
+		if err != nil {
+			t.Fatalf("Failed to list playlists: %v", err)
+		}
+		found := false
+		for _, p := range listed {
+			found = found || p.ID == id
+		}
+		if !found {
+			t.Error("Private playlist not listed for its collaborator")
+		}
+
+		if removed, err := db.RemovePlaylistCollaborator(id, "carol"); err != nil || !removed {
+			t.Errorf("RemovePlaylistCollaborator = %v, %v, want removed", removed, err)
+		}
+		if removed, _ := db.RemovePlaylistCollaborator(id, "carol"); removed {
+			t.Error("Removing a collaborator twice reported a removal")
+		}
+		if err := db.RemoveCollaborations("erin"); err != nil {
+			t.Fatalf("Failed to remove collaborations: %v", err)
+		}
+		if collaborators, _ := db.GetPlaylistCollaborators(id); len(collaborators) != 0 {
+			t.Errorf("Collaborators after removals = %+v, want none", collaborators)
+		}
+
+		if !models.PlaylistRoleAllows(models.PlaylistRoleOwner, models.PlaylistRoleEditor) ||
+			models.PlaylistRoleAllows(models.PlaylistRoleViewer, models.PlaylistRoleEditor) ||
+			models.PlaylistRoleAllows("", models.PlaylistRoleViewer) {
+			t.Error("PlaylistRoleAllows does not order viewer < editor < owner")
+		}
+	})
+}
+
+// entryTitles returns the titles of a playlist's entries, space-separated.
+func entryTitles(t *testing.T, db *database.Database, playlistID int) string {
+	t.Helper()
+	entries, err := db.GetPlaylistEntries(playlistID)
+	if err != nil {
+		t.Fatalf("Failed to get playlist entries: %v", err)
+	}
+	titles := make([]string, len(entries))
+	for i, e := range entries {
+		titles[i] = e.Title
+	}
+	return strings.Join(titles, " ")
+}
+
+func TestPlaylistEditing(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "edits.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	ids := map[string]int{}
+	for i, track := range []models.Track{
+		{Title: "C", Artist: "The Zombies", Year: 1968, Duration: 200},
+		{Title: "A", Artist: "Abba", Year: 1976, Duration: 300},
+		{Title: "B", Artist: "Muse", Year: 2003, Duration: 100},
+	} {
+		track.FilePath = fmt.Sprintf("/lib/%d.mp3", i)
+		id, err := db.InsertTrack(track)
+		if err != nil {
+			t.Fatalf("Failed to insert track: %v", err)
+		}
+		ids[track.Title] = id
+	}
+
+	playlistID, err := db.CreatePlaylist("Edits", "", "", models.VisibilityPrivate)
+	if err != nil {
+		t.Fatalf("Failed to create playlist: %v", err)
+	}
+	for _, title := range []string{"A", "B", "A", "C"} {
+		if err := db.AddTrackToPlaylist(playlistID, ids[title], ""); err != nil {
+			t.Fatalf("Failed to add track: %v", err)
+		}
+	}
+	if got := entryTitles(t, db, playlistID); got != "A B A C" {
+		t.Fatalf("Entries = %q, want duplicates kept in order", got)
+	}
+
+	entries, _ := db.GetPlaylistEntries(playlistID)
+	pos := func(i int) *int { return &i }
+
+	t.Run("Operations", func(t *testing.T) {
+		err := db.EditPlaylist(playlistID, []models.PlaylistOp{
+			{Op: models.PlaylistOpMove, EntryID: entries[3].EntryID, Position: pos(0)},
+			{Op: models.PlaylistOpRemove, EntryID: entries[2].EntryID},
+			{Op: models.PlaylistOpInsert, TrackID: ids["B"], Position: pos(1)},
+			{Op: models.PlaylistOpReplace, EntryID: entries[1].EntryID, TrackID: ids["C"]},
+			{Op: models.PlaylistOpInsert, TrackID: ids["A"]},
+		}, "carol")
+		if err != nil {
+			t.Fatalf("Failed to edit playlist: %v", err)
+		}
+		if got := entryTitles(t, db, playlistID); got != "C B A C A" {
+			t.Errorf("Entries after edit = %q, want %q", got, "C B A C A")
+		}
+		edited, _ := db.GetPlaylistEntries(playlistID)
+		for i, e := range edited {
+			want := ""
+			if i == 1 || i == 4 {
+				want = "carol"
+			}
+			if e.AddedBy != want || e.AddedAt == nil {
+				t.Errorf("Entry %d added by %q at %v, want %q with a time", i, e.AddedBy, e.AddedAt, want)
+			}
+		}
+	})
+
+	t.Run("InvalidEditChangesNothing", func(t *testing.T) {
+		before := entryTitles(t, db, playlistID)
+		for _, op := range []models.PlaylistOp{
+			{Op: models.PlaylistOpRemove, EntryID: entries[2].EntryID}, // removed above
+			{Op: models.PlaylistOpMove, EntryID: entries[0].EntryID, Position: pos(10)},
+			{Op: models.PlaylistOpInsert},
+			{Op: "rotate", EntryID: entries[0].EntryID},
+		} {
+			err := db.EditPlaylist(playlistID, []models.PlaylistOp{
+				{Op: models.PlaylistOpInsert, TrackID: ids["B"], Position: pos(0)},
+				op,
+			}, "carol")
+			if !errors.Is(err, database.ErrInvalidPlaylistEdit) {
+				t.Errorf("EditPlaylist with %+v = %v, want ErrInvalidPlaylistEdit", op, err)
+			}
+		}
+		if got := entryTitles(t, db, playlistID); got != before {
+			t.Errorf("Entries after failed edits = %q, want %q", got, before)
+		}
+	})
+
+	t.Run("SortShuffleDedupe", func(t *testing.T) {
+		for _, tc := range []struct {
+			field      string
+			descending bool
+			want       string
+		}{
+			{database.PlaylistSortTitle, false, "A A B C C"},
+			{database.PlaylistSortArtist, false, "A A B C C"}, // Abba, Muse, The Zombies
+			{database.PlaylistSortDuration, true, "A A C C B"},
+			{database.PlaylistSortYear, false, "C C A A B"},
+		} {
+			if err := db.SortPlaylist(playlistID, tc.field, tc.descending); err != nil {
+				t.Fatalf("Failed to sort by %s: %v", tc.field, err)
+			}
+			if got := entryTitles(t, db, playlistID); got != tc.want {
+				t.Errorf("Sorted by %s = %q, want %q", tc.field, got, tc.want)
+			}
+		}
+		if err := db.SortPlaylist(playlistID, "bpm", false); !errors.Is(err, database.ErrInvalidPlaylistEdit) {
+			t.Errorf("SortPlaylist by unknown field = %v, want ErrInvalidPlaylistEdit", err)
+		}
+
+		if err := db.ShufflePlaylist(playlistID); err != nil {
+			t.Fatalf("Failed to shuffle: %v", err)
+		}
+		if got := strings.Fields(entryTitles(t, db, playlistID)); len(got) != 5 {
+			t.Errorf("Shuffled playlist has %d entries, want 5", len(got))
+		}
+
+		removed, err := db.DedupePlaylist(playlistID)
+		if err != nil || removed != 2 {
+			t.Fatalf("DedupePlaylist = %d, %v; want 2 removed", removed, err)
+		}
+		if err := db.SortPlaylist(playlistID, database.PlaylistSortTitle, false); err != nil {
+			t.Fatalf("Failed to sort: %v", err)
+		}
+		if got := entryTitles(t, db, playlistID); got != "A B C" {
+			t.Errorf("Deduped playlist = %q, want %q", got, "A B C")
+		}
+	})
+
+	t.Run("SetTracks", func(t *testing.T) {
+		// Entries stay with their track, so who added them does not move
+		if err := db.EditPlaylist(playlistID, []models.PlaylistOp{{Op: models.PlaylistOpInsert, TrackID: ids["B"], Position: pos(0)}}, "carol"); err != nil {
+			t.Fatal(err)
+		}
+		before, _ := db.GetPlaylistEntries(playlistID) // B A B C
+		changed, err := db.SetPlaylistTracks(playlistID, []int{ids["A"], ids["C"], ids["B"], ids["A"]})
+		if err != nil || !changed {
+			t.Fatalf("SetPlaylistTracks = %v, %v", changed, err)
+		}
+		after, _ := db.GetPlaylistEntries(playlistID)
+		for i, want := range []int{before[1].EntryID, before[3].EntryID, before[0].EntryID} {
+			if after[i].EntryID != want {
+				t.Errorf("Entry %d has ID %d, want %d", i, after[i].EntryID, want)
+			}
+		}
+		if after[2].AddedBy != "carol" {
+			t.Errorf("B was added by %q, want carol", after[2].AddedBy)
+		}
+		for _, e := range before {
+			if after[3].EntryID == e.EntryID {
+				t.Errorf("The second A reused entry %d of %s", e.EntryID, e.Track.Title)
+			}
+		}
+		if changed, err := db.SetPlaylistTracks(playlistID, []int{ids["A"], ids["C"], ids["B"], ids["A"]}); err != nil || changed {
+			t.Errorf("Setting the same tracks = %v, %v, want unchanged", changed, err)
+		}
+	})
+}
+
+func TestPlaylistEntryMigration(t *testing.T) {
+	// Start from the current schema with the old playlist_tracks table
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+	db, err := database.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	for _, title := range []string{"First", "Second"} {
+		if _, err := db.InsertTrack(models.Track{Title: title, FilePath: "/lib/" + title + ".mp3"}); err != nil {
+			t.Fatalf("Failed to insert track: %v", err)
+		}
+	}
+	db.Close()
+
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for _, stmt := range []string{
+		`DROP TABLE playlist_tracks`,
+		`CREATE TABLE playlist_tracks (playlist_id INTEGER, track_id INTEGER, position INTEGER, added_at DATETIME DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (playlist_id, track_id))`,
+		`INSERT INTO playlists (name, description, visibility) VALUES ('Old', '', 'public')`,
+		`INSERT INTO playlist_tracks (playlist_id, track_id, position) VALUES (1, 2, 1), (1, 1, 2), (1, 99, 3)`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create old schema: %v", err)
+		}
+	}
+	conn.Close()
+
+	db, err = database.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	defer db.Close()
+
+	if got := entryTitles(t, db, 1); got != "Second First" {
+		t.Errorf("Migrated entries = %q, want %q", got, "Second First")
+	}
+	if err := db.AddTrackToPlaylist(1, 2, ""); err != nil {
+		t.Fatalf("Failed to add a duplicate after migration: %v", err)
+	}
+	if got := entryTitles(t, db, 1); got != "Second First Second" {
+		t.Errorf("Entries = %q, want %q", got, "Second First Second")
+	}
+}
+
+func TestLibraryStats(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "stats.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	tracks := []models.Track{
+		{Title: "One", Artist: "Artist A", Album: "Album 1", Duration: 100, FilePath: "/lib/a/1.flac", FileSize: 1000, Codec: "flac", HasAlbumArt: true},
+		{Title: "Two", Artist: "Artist A", Album: "Album 1", Duration: 200, FilePath: "/lib/a/2.FLAC", FileSize: 2000, Codec: "flac", HasAlbumArt: true},
+		{Title: "Three", Artist: "Artist B", Album: "Album 2", Duration: 300, FilePath: "/lib/b/3.mp3", FileSize: 3000, Codec: "mp3"},
+		{Title: "raw", Artist: "Unknown Artist", Album: "Unknown Album", Duration: 50, FilePath: "/lib/raw.v1/4.mp3", FileSize: 500, Codec: "mp3"},
+		{Title: "Mine", Artist: "Artist C", Album: "Album 3", Duration: 60, FilePath: "/users/bob/5.m4a", FileSize: 600, Codec: "alac", Owner: "bob"},
+		{Title: "Bare", Artist: "Artist C", Album: "Album 3", Duration: 10, FilePath: "/users/bob/v2.0/6", FileSize: 100, Owner: "bob", HasAlbumArt: true},
+	}
+	for _, track := range tracks {
+		if _, err := db.InsertTrack(track); err != nil {
+			t.Fatalf("Failed to insert track: %v", err)
+		}
+	}
+
+	count, err := db.CountTracks(database.StatsScope{})
+	if err != nil || count != 4 {
+		t.Errorf("Expected 4 main library tracks, got %d (%v)", count, err)
+	}
+	count, err = db.CountTracks(database.StatsScope{Owner: "bob"})
+	if err != nil || count != 2 {
+		t.Errorf("Expected 2 tracks for bob, got %d (%v)", count, err)
+	}
+
+	stats, err := db.GetLibraryStats(database.StatsScope{All: true})
+	if err != nil {
+		t.Fatalf("Failed to get library stats: %v", err)
+	}
+	if stats.TrackCount != 6 || stats.AlbumCount != 4 || stats.ArtistCount != 4 {
+		t.Errorf("Unexpected counts: %+v", stats)
+	}
+	if stats.TotalDuration != 720 || stats.TotalSize != 7200 {
+		t.Errorf("Unexpected totals: duration=%d size=%d", stats.TotalDuration, stats.TotalSize)
+	}
+	if stats.MissingArt != 3 || stats.MissingTags != 1 {
+		t.Errorf("Expected 3 missing art and 1 missing tags, got %d and %d", stats.MissingArt, stats.MissingTags)
+	}
+
+	formats := map[string]int{}
+	for _, b := range stats.Formats {
+		formats[b.Key] = b.Count
+	}
+	// Dots in directory names are not extensions
+	if formats["flac"] != 2 || formats["mp3"] != 2 || formats["m4a"] != 1 || formats[""] != 1 || len(formats) != 4 {
+		t.Errorf("Unexpected format breakdown: %v", stats.Formats)
+	}
+	codecs := map[string]int{}
+	for _, b := range stats.Codecs {
+		codecs[b.Key] = b.Count
+	}
+	// The codec comes from the stored column, not the extension
+	if codecs["flac"] != 2 || codecs["mp3"] != 2 || codecs["alac"] != 1 || codecs[""] != 1 || len(codecs) != 4 {
+		t.Errorf("Unexpected codec breakdown: %v", stats.Codecs)
+	}
+	if len(stats.Owners) != 2 || len(stats.AddedPerMonth) != 1 {
+		t.Errorf("Unexpected owner/month breakdown: %v %v", stats.Owners, stats.AddedPerMonth)
+	}
+}
+
+func TestAlbumGrouping(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "albums.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	tracks := []models.Track{
+		{Title: "B2", Artist: "Artist B feat. X", AlbumArtist: "Artist B", Album: "Same Name", TrackNumber: 2, FilePath: "/lib/b/2.mp3"},
+		{Title: "C1", Artist: "Singer 1", AlbumArtist: "Label", Compilation: true, Album: "Same Name", TrackNumber: 1, FilePath: "/lib/c/1.mp3"},
+		{Title: "B1", Artist: "Artist B", AlbumArtist: "Artist B", Album: "Same Name", TrackNumber: 1, FilePath: "/lib/b/1.mp3"},
+		{Title: "C2", Artist: "Singer 2", Compilation: true, Album: "Same Name", TrackNumber: 2, FilePath: "/lib/c/2.mp3"},
+		{Title: "A1", Artist: "Artist A", Album: "Same Name", TrackNumber: 1, FilePath: "/lib/a/1.mp3"},
+		{Title: "Z1", Artist: "Artist A", Album: "Another", TrackNumber: 1, FilePath: "/lib/a/z.mp3"},
+		{Title: "B3", Artist: "Artist B", AlbumArtist: "Artist B", Album: "Same Name", TrackNumber: 1, Disc: 2, FilePath: "/lib/b/3.mp3"},
+		// No album artist tag: grouped under the main artist, without the guest
+		{Title: "D1", Artist: "Artist D feat. Guest", Album: "Solo", TrackNumber: 1, FilePath: "/lib/d/1.mp3"},
+		{Title: "D2", Artist: "Artist D", Album: "Solo", TrackNumber: 2, FilePath: "/lib/d/2.mp3"},
+	}
+	for _, track := range tracks {
+		if _, err := db.InsertTrack(track); err != nil {
+			t.Fatalf("Failed to insert track: %v", err)
+		}
+	}
+
+	sorted, err := db.GetTracksSortedByAlbum()
+	if err != nil {
+		t.Fatalf("Failed to get tracks sorted by album: %v", err)
+	}
+	var titles []string
+	for _, track := range sorted {
+		titles = append(titles, track.Title)
+	}
+	if got, want := strings.Join(titles, " "), "Z1 A1 B1 B2 B3 C1 C2 D1 D2"; got != want {
+		t.Errorf("Album order = %q, want %q", got, want)
+	}
+	if sorted[5].AlbumArtist != "Label" || !sorted[5].Compilation || sorted[5].AlbumGroupArtist() != models.VariousArtists {
+		t.Errorf("Album artist and compilation flag not stored: %+v", sorted[5])
+	}
+	if got := sorted[7].AlbumGroupArtist(); got != "Artist D" {
+		t.Errorf("Featured track grouped under %q, want %q", got, "Artist D")
+	}
+
+	stats, err := db.GetLibraryStats(database.StatsScope{All: true})
+	if err != nil {
+		t.Fatalf("Failed to get library stats: %v", err)
+	}
+	if stats.AlbumCount != 5 {
+		t.Errorf("Expected 5 albums, got %d", stats.AlbumCount)
+	}
+}
+
+func TestSortedListings(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "sorted.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	ids, err := db.UpsertTracks([]models.Track{
+		{Title: "Song", Artist: "Bzzz", Album: "B", FilePath: "/lib/1.mp3"},
+		{Title: "Song", Artist: "Björk", Album: "B", FilePath: "/lib/2.mp3"},
+		{Title: "Song", Artist: "The Beatles", Album: "B", FilePath: "/lib/3.mp3"},
+		{Title: "Song", Artist: "Zappa", ArtistSort: "Aaa", Album: "B", FilePath: "/lib/4.mp3"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to upsert tracks: %v", err)
+	}
+
+	artists := func(tracks []models.Track) string {
+		var names []string
+		for _, track := range tracks {
+			names = append(names, track.Artist)
+		}
+		return strings.Join(names, "|")
+	}
+	tracks, err := db.GetMainLibraryTracks()
+	if err != nil || artists(tracks) != "Zappa|The Beatles|Björk|Bzzz" {
+		t.Errorf("Unexpected listing order %q (%v)", artists(tracks), err)
+	}
+	if tracks[0].ArtistSort != "Aaa" {
+		t.Errorf("Sort tag not stored: %+v", tracks[0])
+	}
+	tracks, err = db.SearchMainLibraryTracks("Song")
+	if err != nil || artists(tracks) != "Zappa|The Beatles|Björk|Bzzz" {
+		t.Errorf("Unexpected search order %q (%v)", artists(tracks), err)
+	}
+
+	// An overridden artist no longer sorts by the file's sort tag
+	renamed := "Zappa"
+	if err := db.SetTrackOverride(models.TrackOverride{TrackID: ids[3], Artist: &renamed}, nil); err != nil {
+		t.Fatalf("Failed to set override: %v", err)
+	}
+	tracks, err = db.GetMainLibraryTracks()
+	if err != nil || artists(tracks) != "The Beatles|Björk|Bzzz|Zappa" {
+		t.Errorf("Unexpected order after override %q (%v)", artists(tracks), err)
+	}
+}
+
+func TestTrackArtists(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "artists.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	solo, err := db.InsertTrack(models.Track{Title: "Solo", Artist: "Artist A", Album: "One", FilePath: "/lib/1.mp3"})
+	if err != nil {
+		t.Fatalf("Failed to insert track: %v", err)
+	}
+	ids, err := db.UpsertTracks([]models.Track{
+		{Title: "Duet", Artist: "Artist A feat. Artist B", Album: "One", FilePath: "/lib/2.mp3"},
+		{Title: "Split", Artist: "artist b; Artist C", Album: "Two", Composer: "Writer", FilePath: "/lib/3.mp3"},
+		{Title: "Mine", Artist: "Artist B", Album: "Three", FilePath: "/users/bob/4.mp3", Owner: "bob"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to upsert tracks: %v", err)
+	}
+
+	titles := func(tracks []models.Track) string {
+		var names []string
+		for _, track := range tracks {
+			names = append(names, track.Title)
+		}
+		return strings.Join(names, " ")
+	}
+
+	tracks, err := db.GetTracksByArtist("ARTIST B", "", database.StatsScope{})
+	if err != nil || titles(tracks) != "Duet Split" {
+		t.Errorf("Expected Duet and Split for Artist B, got %q (%v)", titles(tracks), err)
+	}
+	if tracks[0].Artist != "Artist A feat. Artist B" {
+		t.Errorf("Display artist changed: %q", tracks[0].Artist)
+	}
+	tracks, err = db.GetTracksByArtist("Artist B", models.RoleFeatured, database.StatsScope{})
+	if err != nil || titles(tracks) != "Duet" {
+		t.Errorf("Expected Duet for featured Artist B, got %q (%v)", titles(tracks), err)
+	}
+	tracks, err = db.SearchMainLibraryTracks("Writer")
+	if err != nil || titles(tracks) != "Split" {
+		t.Errorf("Expected search to find Split by composer, got %q (%v)", titles(tracks), err)
+	}
+
+	artists, err := db.GetArtists(database.StatsScope{})
+	if err != nil {
+		t.Fatalf("Failed to get artists: %v", err)
+	}
+	got := map[string]string{}
+	for _, a := range artists {
+		got[strings.ToLower(a.Name)] = fmt.Sprintf("%d %v", a.TrackCount, a.Roles)
+	}
+	want := map[string]string{"artist a": "2 [main]", "artist b": "2 [main featured]", "artist c": "1 [main]", "writer": "1 [composer]"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Artists = %v, want %v", got, want)
+	}
+
+	// Overrides are parsed too, and clearing them restores the file's credits
+	overridden := "Artist A ft. Artist D"
+	if err := db.SetTrackOverride(models.TrackOverride{TrackID: solo, Artist: &overridden}, nil); err != nil {
+		t.Fatalf("Failed to set override: %v", err)
+	}
+	if tracks, _ := db.GetTracksByArtist("Artist D", "", database.StatsScope{}); titles(tracks) != "Solo" {
+		t.Errorf("Expected the overridden artist to be credited, got %q", titles(tracks))
+	}
+	if err := db.DeleteTrackOverride(solo); err != nil {
+		t.Fatalf("Failed to delete override: %v", err)
+	}
+	if tracks, _ := db.GetTracksByArtist("Artist D", "", database.StatsScope{}); len(tracks) != 0 {
+		t.Errorf("Expected no tracks for Artist D after clearing the override, got %q", titles(tracks))
+	}
+
+	// Changing the separators takes effect on rebuild
+	db.SetCreditParser(metadata.NewCreditParser([]string{}, []string{}))
+	if _, err := db.RebuildTrackArtists(); err != nil {
+		t.Fatalf("Failed to rebuild credits: %v", err)
+	}
+	credits, err := db.GetTrackArtists(ids[0])
+	if err != nil || len(credits) != 1 || credits[0].Name != "Artist A feat. Artist B" {
+		t.Errorf("Expected the unsplit artist after rebuild, got %v (%v)", credits, err)
+	}
+}
+
+func TestTrackOverrides(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "overrides.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	track := models.Track{Title: "Untitled", Artist: "Unknown Artist", Album: "Unknown Album", TrackNumber: 0, FilePath: "/ro/a.flac", FileSize: 10}
+	id, err := db.InsertTrack(track)
+	if err != nil {
+		t.Fatalf("Failed to insert track: %v", err)
+	}
+
+	title, artist, trackNum := "Real Title", "Real Artist", 4
+	art := []byte("\x89PNG\r\n\x1a\nfake")
+	override := models.TrackOverride{TrackID: id, Title: &title, Artist: &artist, TrackNumber: &trackNum, UpdatedBy: "admin"}
+	if err := db.SetTrackOverride(override, art); err != nil {
+		t.Fatalf("Failed to set override: %v", err)
+	}
+
+	// A rescan rewrites the file values but must not drop the override.
+	if _, err := db.InsertTrack(track); err != nil {
+		t.Fatalf("Failed to re-insert track: %v", err)
+	}
+
+	got, err := db.GetTrackByID(id)
+	if err != nil {
+		t.Fatalf("Failed to get track: %v", err)
+	}
+	if got.Title != title || got.Artist != artist || got.Album != "Unknown Album" || got.TrackNumber != trackNum {
+		t.Errorf("Override not applied: %+v", got)
+	}
+	if !got.HasAlbumArt || got.AlbumArtID == "" {
+		t.Error("Expected override album art")
+	}
+	if data, ok := db.GetOverrideArt(got.AlbumArtID); !ok || string(data) != string(art) {
+		t.Error("Expected override art to be retrievable by ID")
+	}
+
+	results, err := db.SearchTracks("Real Artist")
+	if err != nil || len(results) != 1 {
+		t.Errorf("Expected search to match overridden artist, got %d (%v)", len(results), err)
+	}
+	all, _ := db.GetAllTracks()
+	if len(all) != 1 || all[0].Title != title {
+		t.Errorf("Expected GetAllTracks to apply override, got %+v", all)
+	}
+	stats, err := db.GetLibraryStats(database.StatsScope{All: true})
+	if err != nil || stats.MissingTags != 1 || stats.MissingArt != 0 {
+		t.Errorf("Unexpected stats with override: %+v (%v)", stats, err)
+	}
+
+	exported, err := db.GetOverridesForExport(database.StatsScope{All: true})
+	if err != nil || len(exported) != 1 || exported[0].Path != "/ro/a.flac" || *exported[0].Title != title || len(exported[0].AlbumArt) == 0 {
+		t.Errorf("Unexpected export %+v (%v)", exported, err)
+	}
+
+	if err := db.DeleteTrackOverride(id); err != nil {
+		t.Fatalf("Failed to delete override: %v", err)
+	}
+	if got, _ := db.GetTrackByID(id); got.Title != "Untitled" || got.HasAlbumArt {
+		t.Errorf("Expected file values after deleting override, got %+v", got)
+	}
+	if o, err := db.GetTrackOverride(id); err != nil || o != nil {
+		t.Errorf("Expected no override, got %+v (%v)", o, err)
+	}
+}
+
+// syntheticTracks returns n distinct tracks spread over albums of 12.
+func syntheticTracks(n int) []models.Track {
+	tracks := make([]models.Track, n)
+	for i := range tracks {
+		tracks[i] = models.Track{
+			Title:       fmt.Sprintf("Song %d", i),
+			Artist:      fmt.Sprintf("Artist %d", i/120),
+			Album:       fmt.Sprintf("Album %d", i/12),
+			TrackNumber: i%12 + 1,
+			Duration:    180 + i%60,
+			FilePath:    fmt.Sprintf("/library/artist%d/album%d/%02d.flac", i/120, i/12, i%12+1),
+			FileSize:    int64(20<<20 + i),
+		}
+	}
+	return tracks
+}
+
+func TestBulkIngest(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "ingest.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	tracks := syntheticTracks(1234)
+	existingID, err := db.InsertTrack(tracks[5])
+	if err != nil {
+		t.Fatalf("Failed to insert track: %v", err)
+	}
+
+	// Several producers, as in a library scan
+	ingest := db.NewBulkIngest(100)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(tracks); i += 4 {
+				track := tracks[i]
+				track.Title += " (tagged)"
+				ingest.Add(track)
+			}
+		}(w)
+	}
+	wg.Wait()
+	written, err := ingest.Close()
+	if err != nil || written != len(tracks) {
+		t.Fatalf("Close = %d, %v; want %d", written, err, len(tracks))
+	}
+
+	count, err := db.CountTracks(database.StatsScope{All: true})
+	if err != nil || count != len(tracks) {
+		t.Errorf("Expected %d tracks, got %d (%v)", len(tracks), count, err)
+	}
+	updated, err := db.GetTrackByID(existingID)
+	if err != nil || updated.FilePath != tracks[5].FilePath || updated.Title != "Song 5 (tagged)" {
+		t.Errorf("Existing track not updated in place: %+v (%v)", updated, err)
+	}
+
+	ids, err := db.UpsertTracks(tracks[:3])
+	if err != nil || len(ids) != 3 || ids[0] == 0 {
+		t.Errorf("UpsertTracks = %v, %v", ids, err)
+	}
+	if again, _ := db.UpsertTracks(tracks[:3]); fmt.Sprint(again) != fmt.Sprint(ids) {
+		t.Errorf("Upserting again changed IDs: %v -> %v", ids, again)
+	}
+}
+
+// BenchmarkIngest compares per-track InsertTrack calls from concurrent
+// workers (the previous scan path) with BulkIngest on 10k synthetic files.
+func BenchmarkIngest(b *testing.B) {
+	const workers = 8
+	tracks := syntheticTracks(10000)
+
+	run := func(b *testing.B, ingest func(db *database.Database, feed <-chan models.Track)) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			db, err := database.NewDatabase(filepath.Join(b.TempDir(), fmt.Sprintf("bench%d.db", i)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			feed := make(chan models.Track, 100)
+			b.StartTimer()
+
+			go func() {
+				for _, track := range tracks {
+					feed <- track
+				}
+				close(feed)
+			}()
+			ingest(db, feed)
+
+			b.StopTimer()
+			db.Close()
+			b.StartTimer()
+		}
+		b.ReportMetric(float64(len(tracks)*b.N)/b.Elapsed().Seconds(), "tracks/s")
+	}
+
+	b.Run("InsertTrack", func(b *testing.B) {
+		run(b, func(db *database.Database, feed <-chan models.Track) {
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for track := range feed {
+						if _, err := db.InsertTrack(track); err != nil {
+							b.Error(err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	})
+	b.Run("BulkIngest", func(b *testing.B) {
+		run(b, func(db *database.Database, feed <-chan models.Track) {
+			ingest := db.NewBulkIngest(database.DefaultIngestBatchSize)
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for track := range feed {
+						ingest.Add(track)
+					}
+				}()
+			}
+			wg.Wait()
+			if _, err := ingest.Close(); err != nil {
+				b.Error(err)
+			}
+		})
+	})
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.NewDatabase(filepath.Join(dir, "live.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.UpsertTracks(syntheticTracks(50)); err != nil {
+		t.Fatal(err)
+	}
+	backupDir := filepath.Join(dir, "backups")
+	var first database.BackupInfo
+	for i := 0; i < 4; i++ {
+		info, err := db.BackupToDir(backupDir, 3)
+		if err != nil {
+			t.Fatalf("BackupToDir failed: %v", err)
+		}
+		if i == 0 {
+			first = info
+		}
+	}
+	backups, err := database.ListBackups(backupDir)
+	if err != nil || len(backups) != 3 {
+		t.Fatalf("Expected 3 backups after rotation, got %d (%v)", len(backups), err)
+	}
+	for _, b := range backups {
+		if b.Name == first.Name {
+			t.Error("Oldest backup was not rotated out")
+		}
+	}
+	if !database.IsBackupName(backups[0].Name) || database.IsBackupName("../"+backups[0].Name) {
+		t.Error("IsBackupName accepts the wrong names")
+	}
+
+	newest := filepath.Join(backupDir, backups[0].Name)
+	if version, err := database.ValidateBackup(newest); err != nil || version != database.SchemaVersion {
+		t.Errorf("ValidateBackup = %d, %v", version, err)
+	}
+
+	// Change the live database, then restore the backup over it
+	if err := db.DeleteTracksByOwner(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertTrack(models.Track{Title: "New", Artist: "A", Album: "B", FilePath: "/new.mp3", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Restore(newest); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	tracks, err := db.GetAllTracks()
+	if err != nil || len(tracks) != 50 {
+		t.Errorf("Expected 50 tracks after restore, got %d (%v)", len(tracks), err)
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		garbage := filepath.Join(dir, "garbage.db")
+		os.WriteFile(garbage, []byte("definitely not sqlite"), 0644)
+		if _, err := database.ValidateBackup(garbage); !errors.Is(err, database.ErrInvalidBackup) {
+			t.Errorf("Expected ErrInvalidBackup for garbage, got %v", err)
+		}
+
+		// A future schema version must be refused
+		future := filepath.Join(dir, "future.db")
+		if err := db.Backup(future); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := sql.Open("sqlite3", future)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", database.SchemaVersion+1))
+		conn.Close()
+		if err := db.Restore(future); !errors.Is(err, database.ErrInvalidBackup) {
+			t.Errorf("Expected ErrInvalidBackup for newer schema, got %v", err)
+		}
+		if count, _ := db.CountTracks(database.StatsScope{All: true}); count != 50 {
+			t.Errorf("Refused restore changed the database: %d tracks", count)
+		}
+	})
+}
+
+func TestSmartPlaylists(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "smart.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	ids := map[string]int{}
+	for _, track := range []models.Track{
+		{Title: "So What", Artist: "Miles Davis", Genre: "Jazz", Duration: 545, Year: 1959, FilePath: "/music/so-what.flac"},
+		{Title: "Giant Steps", Artist: "John Coltrane", Genre: "jazz", Duration: 286, Year: 1960, FilePath: "/music/giant-steps.mp3"},
+		{Title: "Long Jam", Artist: "The Band", Genre: "Jazz", Duration: 900, Year: 1975, FilePath: "/music/long-jam.flac"},
+		{Title: "Pop Song", Artist: "Singer", Genre: "Pop", Duration: 200, Year: 2020, FilePath: "/music/pop.flac"},
+		{Title: "100% Mine", Artist: "Owner", Genre: "Jazz", Duration: 700, FilePath: "/users/alice/mine.flac", Owner: "alice"},
+	} {
+		id, err := db.InsertTrack(track)
+		if err != nil {
+			t.Fatalf("Failed to insert track: %v", err)
+		}
+		ids[track.Title] = id
+	}
+
+	rule := func(field, op, value string) models.SmartRule {
+		return models.SmartRule{Field: field, Operator: op, Value: json.RawMessage(value)}
+	}
+	titles := func(rules models.SmartRules, scope database.StatsScope) string {
+		t.Helper()
+		tracks, err := db.GetSmartPlaylistTracks(rules, scope)
+		if err != nil {
+			t.Fatalf("GetSmartPlaylistTracks failed: %v", err)
+		}
+		names := make([]string, len(tracks))
+		for i, track := range tracks {
+			names[i] = track.Title
+		}
+		return strings.Join(names, ", ")
+	}
+
+	longJazz := models.SmartRules{Rules: []models.SmartRule{
+		rule("genre", "is", `"jazz"`),
+		rule("duration", "gt", "500"),
+	}, Sort: "duration", Descending: true}
+	if got := titles(longJazz, database.StatsScope{}); got != "Long Jam, So What" {
+		t.Errorf("Long jazz = %q", got)
+	}
+	if got := titles(longJazz, database.StatsScope{Owner: "alice"}); got != "100% Mine" {
+		t.Errorf("Long jazz for alice = %q", got)
+	}
+
+	// Nested groups, format, recently added, article-aware sorting and limits
+	recentFlacs := models.SmartRules{Match: models.MatchAny, Rules: []models.SmartRule{
+		{Match: models.MatchAll, Rules: []models.SmartRule{
+			rule("format", "is", `".FLAC"`),
+			rule("added", "inTheLast", "30"),
+			rule("year", "lt", "2000"),
+		}},
+		rule("title", "contains", `"Steps"`),
+	}, Sort: "artist"}
+	if got := titles(recentFlacs, database.StatsScope{}); got != "Long Jam, Giant Steps, So What" {
+		t.Errorf("Recent flacs = %q", got)
+	}
+	recentFlacs.Limit = 2
+	if got := titles(recentFlacs, database.StatsScope{}); got != "Long Jam, Giant Steps" {
+		t.Errorf("Recent flacs with limit = %q", got)
+	}
+	if count, err := db.CountSmartPlaylistTracks(recentFlacs, database.StatsScope{}); err != nil || count != 2 {
+		t.Errorf("Count with limit = %d, %v, want 2", count, err)
+	}
+
+	// Plays: never played tracks have no last play
+	for _, title := range []string{"So What", "So What", "Pop Song"} {
+		if err := db.RecordPlay(ids[title]); err != nil {
+			t.Fatalf("RecordPlay failed: %v", err)
+		}
+	}
+	neverPlayed := models.SmartRules{Rules: []models.SmartRule{rule("playCount", "is", "0")}, Sort: "title"}
+	if got := titles(neverPlayed, database.StatsScope{}); got != "Giant Steps, Long Jam" {
+		t.Errorf("Never played = %q", got)
+	}
+	notLately := models.SmartRules{Rules: []models.SmartRule{rule("lastPlayed", "notInTheLast", "7")}, Sort: "title"}
+	if got := titles(notLately, database.StatsScope{}); got != "Giant Steps, Long Jam" {
+		t.Errorf("Not played lately = %q", got)
+	}
+	mostPlayed := models.SmartRules{Rules: []models.SmartRule{rule("lastPlayed", "inTheLast", "7")}, Sort: "playCount", Descending: true}
+	if got := titles(mostPlayed, database.StatsScope{}); got != "So What, Pop Song" {
+		t.Errorf("Most played = %q", got)
+	}
+	if got := titles(models.SmartRules{Rules: []models.SmartRule{rule("title", "startsWith", `"100%"`)}}, database.StatsScope{All: true}); got != "100% Mine" {
+		t.Errorf("Wildcards in values must match literally, got %q", got)
+	}
+	if got := titles(models.SmartRules{Rules: []models.SmartRule{rule("title", "contains", `"_"`)}}, database.StatsScope{All: true}); got != "" {
+		t.Errorf("Underscore matched %q", got)
+	}
+
+	for name, bad := range map[string]models.SmartRules{
+		"unknown field":    {Rules: []models.SmartRule{rule("mood", "is", `"happy"`)}},
+		"wrong operator":   {Rules: []models.SmartRule{rule("year", "contains", "19")}},
+		"wrong value type": {Rules: []models.SmartRule{rule("duration", "gt", `"long"`)}},
+		"bad date":         {Rules: []models.SmartRule{rule("added", "before", `"yesterday"`)}},
+		"bad match":        {Match: "some", Rules: []models.SmartRule{rule("year", "is", "1959")}},
+		"unknown sort":     {Sort: "mood"},
+		"negative limit":   {Limit: -1},
+	} {
+		if err := database.ValidateSmartRules(bad); !errors.Is(err, database.ErrInvalidSmartRules) {
+			t.Errorf("%s: ValidateSmartRules = %v, want ErrInvalidSmartRules", name, err)
+		}
+	}
+	deep := []models.SmartRule{rule("year", "is", "1959")}
+	for i := 0; i < database.MaxSmartRuleDepth; i++ {
+		deep = []models.SmartRule{{Rules: deep}}
+	}
+	if err := database.ValidateSmartRules(models.SmartRules{Rules: deep}); !errors.Is(err, database.ErrInvalidSmartRules) {
+		t.Errorf("Groups nested too deeply: ValidateSmartRules = %v", err)
+	}
+
+	// Rules are stored on the playlist and can be replaced
+	id, err := db.CreateSmartPlaylist("Long jazz", "", "bob", models.VisibilityPublic, longJazz)
+	if err != nil {
+		t.Fatalf("CreateSmartPlaylist failed: %v", err)
+	}
+	playlist, err := db.GetPlaylist(id)
+	if err != nil || !playlist.Smart || playlist.Rules == nil || len(playlist.Rules.Rules) != 2 {
+		t.Fatalf("GetPlaylist = %+v, %v", playlist, err)
+	}
+	if err := db.UpdateSmartRules(id, models.SmartRules{Rules: []models.SmartRule{rule("genre", "is", `"Pop"`)}}); err != nil {
+		t.Fatalf("UpdateSmartRules failed: %v", err)
+	}
+	playlist, _ = db.GetPlaylist(id)
+	if got := titles(*playlist.Rules, database.StatsScope{}); got != "Pop Song" {
+		t.Errorf("Updated rules = %q", got)
+	}
+}
+
+func TestPlaylistFolders(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "folders.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	mustFolder := func(name, owner, visibility string, parent int) int {
+		t.Helper()
+		id, err := db.CreatePlaylistFolder(name, owner, visibility, parent)
+		if err != nil {
+			t.Fatalf("Failed to create folder %q: %v", name, err)
+		}
+		return id
+	}
+	office := mustFolder("Office", "alice", models.VisibilityPublic, 0)
+	moods := mustFolder("Moods", "alice", models.FolderVisibilityInherit, office)
+	drafts := mustFolder("Drafts", "alice", models.VisibilityPrivate, office)
+
+	playlistIn := func(name string, folder int) int {
+		t.Helper()
+		id, err := db.CreatePlaylist(name, "", "alice", models.VisibilityPrivate)
+		if err != nil {
+			t.Fatalf("Failed to create playlist: %v", err)
+		}
+		if folder != 0 {
+			if err := db.MovePlaylist(id, folder, nil); err != nil {
+				t.Fatalf("Failed to move playlist %q: %v", name, err)
+			}
+		}
+		return id
+	}
+	calm := playlistIn("Calm", moods)
+	wip := playlistIn("WIP", drafts)
+	loose := playlistIn("Loose", 0)
+
+	t.Run("VisibilityInherits", func(t *testing.T) {
+		for id, want := range map[int]string{
+			calm:  models.VisibilityPublic,
+			wip:   models.VisibilityPrivate,
+			loose: models.VisibilityPrivate,
+		} {
+			p, err := db.GetPlaylist(id)
+			if err != nil {
+				t.Fatalf("Failed to get playlist: %v", err)
+			}
+			if p.Visibility != want {
+				t.Errorf("Playlist %q visibility = %q, want %q", p.Name, p.Visibility, want)
+			}
+		}
+		folder, err := db.GetPlaylistFolder(moods)
+		if err != nil {
+			t.Fatalf("Failed to get folder: %v", err)
+		}
+		if folder.Visibility != models.VisibilityPublic || !folder.InheritsVisibility || folder.ParentID != office {
+			t.Errorf("Moods = %+v, want public, inherited from Office", folder)
+		}
+
+		listed, _ := db.GetPlaylistsVisibleTo("bob")
+		names := map[string]bool{}
+		for _, p := range listed {
+			names[p.Name] = true
+		}
+		if !names["Calm"] || names["WIP"] || names["Loose"] {
+			t.Errorf("Playlists listed for bob = %v, want only Calm", names)
+		}
+		folders, _ := db.GetPlaylistFoldersVisibleTo("bob")
+		if len(folders) != 2 {
+			t.Errorf("Folders listed for bob = %+v, want Office and Moods", folders)
+		}
+
+		if err := db.UpdatePlaylistFolder(office, "", models.VisibilityPrivate); err != nil {
+			t.Fatalf("Failed to update folder: %v", err)
+		}
+		if p, _ := db.GetPlaylist(calm); p.Visibility != models.VisibilityPrivate {
+			t.Errorf("Calm visibility after making Office private = %q, want private", p.Visibility)
+		}
+		if err := db.UpdatePlaylistFolder(office, "", models.FolderVisibilityInherit); !errors.Is(err, database.ErrInvalidFolderChange) {
+			t.Errorf("Top-level folder inheriting visibility = %v, want ErrInvalidFolderChange", err)
+		}
+	})
+
+	t.Run("Moves", func(t *testing.T) {
+		if err := db.MovePlaylistFolder(office, moods, nil); !errors.Is(err, database.ErrInvalidFolderChange) {
+			t.Errorf("Moving a folder into its subfolder = %v, want ErrInvalidFolderChange", err)
+		}
+		bobs := mustFolder("Bob's", "bob", models.VisibilityPublic, 0)
+		if err := db.MovePlaylist(calm, bobs, nil); !errors.Is(err, database.ErrInvalidFolderChange) {
+			t.Errorf("Filing a playlist in another owner's folder = %v, want ErrInvalidFolderChange", err)
+		}
+
+		parent := drafts
+		for depth := 3; depth <= database.MaxPlaylistFolderDepth; depth++ {
+			parent = mustFolder("Deep", "alice", models.FolderVisibilityInherit, parent)
+		}
+		if _, err := db.CreatePlaylistFolder("Too deep", "alice", models.FolderVisibilityInherit, parent); !errors.Is(err, database.ErrInvalidFolderChange) {
+			t.Errorf("Creating a folder past the depth limit = %v, want ErrInvalidFolderChange", err)
+		}
+		if err := db.MovePlaylistFolder(moods, parent, nil); !errors.Is(err, database.ErrInvalidFolderChange) {
+			t.Errorf("Moving a folder past the depth limit = %v, want ErrInvalidFolderChange", err)
+		}
+
+		first := 0
+		if err := db.MovePlaylistFolder(drafts, office, &first); err != nil {
+			t.Fatalf("Failed to reorder folder: %v", err)
+		}
+		children, _ := db.GetPlaylistFolderChildren(office)
+		if len(children) != 2 || children[0].ID != drafts || children[1].ID != moods {
+			t.Errorf("Office children = %+v, want Drafts before Moods", children)
+		}
+
+		if err := db.MovePlaylistFolder(moods, 0, nil); err != nil {
+			t.Fatalf("Failed to move folder to the top level: %v", err)
+		}
+		folder, _ := db.GetPlaylistFolder(moods)
+		if folder.ParentID != 0 || folder.InheritsVisibility || folder.Visibility != models.VisibilityPrivate {
+			t.Errorf("Moods at the top level = %+v, want its inherited visibility kept", folder)
+		}
+	})
+
+	t.Run("DeleteMovesContentsUp", func(t *testing.T) {
+		if err := db.DeletePlaylistFolder(drafts); err != nil {
+			t.Fatalf("Failed to delete folder: %v", err)
+		}
+		if _, err := db.GetPlaylistFolder(drafts); !errors.Is(err, database.ErrFolderNotFound) {
+			t.Errorf("GetPlaylistFolder of deleted folder = %v, want ErrFolderNotFound", err)
+		}
+		p, err := db.GetPlaylist(wip)
+		if err != nil {
+			t.Fatalf("Playlist of deleted folder is gone: %v", err)
+		}
+		if p.FolderID != office {
+			t.Errorf("WIP folder = %d, want Office (%d)", p.FolderID, office)
+		}
+		inOffice, _ := db.GetPlaylistFolderChildren(office)
+		if len(inOffice) != 1 || inOffice[0].Name != "Deep" {
+			t.Errorf("Office children = %+v, want Drafts' subfolder", inOffice)
+		}
 	})
 }
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"path/filepath"
 	"testing"
 
 	"staccato/internal/auth"
@@ -12,10 +13,10 @@ func TestDisabledAuthNilPointer(t *testing.T) {
 		// Create auth config with auth disabled
 		authConfig := &config.AuthConfig{
 			Enabled:         false,
-			UsersFilePath:   "./users.toml",
+			UsersFilePath:   filepath.Join(t.TempDir(), "users.toml"),
 			SessionDuration: "1h",
 			UserFolders:     false,
-			UserMusicPath:   "./users",
+			UserMusicPath:   filepath.Join(t.TempDir(), "users"),
 		}
 
 		// Create auth service - this should not fail
@@ -45,10 +46,10 @@ func TestDisabledAuthNilPointer(t *testing.T) {
 		// Create disabled auth service
 		authConfig := &config.AuthConfig{
 			Enabled:         false,
-			UsersFilePath:   "./users.toml",
+			UsersFilePath:   filepath.Join(t.TempDir(), "users.toml"),
 			SessionDuration: "1h",
 			UserFolders:     false,
-			UserMusicPath:   "./users",
+			UserMusicPath:   filepath.Join(t.TempDir(), "users"),
 		}
 
 		authService, err := auth.NewService(authConfig)
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"staccato/internal/database"
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+)
+
+// writeTestWAV writes 16-bit PCM samples (interleaved) as a canonical WAV file.
+func writeTestWAV(t *testing.T, path string, samples []int16, sampleRate, channels int) {
+	t.Helper()
+	dataSize := len(samples) * 2
+	buf := make([]byte, 44+dataSize)
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(36+dataSize))
+	copy(buf[8:], "WAVE")
+	copy(buf[12:], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], 1)
+	binary.LittleEndian.PutUint16(buf[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(buf[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(sampleRate*channels*2))
+	binary.LittleEndian.PutUint16(buf[32:], uint16(channels*2))
+	binary.LittleEndian.PutUint16(buf[34:], 16)
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(dataSize))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(buf[44+i*2:], uint16(s))
+	}
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatalf("Failed to write test WAV: %v", err)
+	}
+}
+
+// synthMelody generates a mono test signal stepping through a pseudo-random
+// sequence of notes (seeded) so different seeds yield different "songs".
+func synthMelody(seed int, seconds float64, sampleRate int, gain float64, leadingSilence float64) []int16 {
+	silence := int(leadingSilence * float64(sampleRate))
+	n := int(seconds * float64(sampleRate))
+	out := make([]int16, silence+n)
+	noteLen := sampleRate / 4
+	state := uint32(seed*2654435761 + 1)
+	freq := 440.0
+	for i := 0; i < n; i++ {
+		if i%noteLen == 0 {
+			state = state*1664525 + 1013904223
+			freq = 220 * math.Pow(2, float64(state>>28)/12)
+		}
+		t := float64(i) / float64(sampleRate)
+		v := 0.6*math.Sin(2*math.Pi*freq*t) + 0.3*math.Sin(2*math.Pi*freq*2*t)
+		out[silence+i] = int16(v * gain * 32767 * 0.5)
+	}
+	return out
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "original.wav")
+	quieter := filepath.Join(dir, "quieter.wav")
+	different := filepath.Join(dir, "different.wav")
+
+	writeTestWAV(t, original, synthMelody(1, 30, 44100, 1.0, 0), 44100, 1)
+	writeTestWAV(t, quieter, synthMelody(1, 30, 22050, 0.5, 1.5), 22050, 1)
+	writeTestWAV(t, different, synthMelody(7, 30, 44100, 1.0, 0), 44100, 1)
+
+	fpOriginal, err := metadata.ComputeFingerprint(original)
+	if err != nil {
+		t.Fatalf("Failed to fingerprint original: %v", err)
+	}
+	fpQuieter, err := metadata.ComputeFingerprint(quieter)
+	if err != nil {
+		t.Fatalf("Failed to fingerprint quieter copy: %v", err)
+	}
+	fpDifferent, err := metadata.ComputeFingerprint(different)
+	if err != nil {
+		t.Fatalf("Failed to fingerprint different song: %v", err)
+	}
+
+	maxShift := int(math.Round(5 * metadata.FingerprintFramesPerSecond))
+	same := metadata.CompareFingerprints(fpOriginal, fpQuieter, maxShift)
+	other := metadata.CompareFingerprints(fpOriginal, fpDifferent, maxShift)
+	if same < 0.8 {
+		t.Errorf("Expected re-encoded copy to score >= 0.8, got %.3f", same)
+	}
+	if other >= 0.8 {
+		t.Errorf("Expected different song to score < 0.8, got %.3f", other)
+	}
+
+	decoded := metadata.DecodeFingerprint(metadata.EncodeFingerprint(fpOriginal))
+	if len(decoded) != len(fpOriginal) || decoded[10] != fpOriginal[10] {
+		t.Error("Fingerprint encode/decode round trip mismatch")
+	}
+
+	if _, err := metadata.ComputeFingerprint(filepath.Join(dir, "song.m4a")); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
+
+func TestFingerprintStorage(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "fp.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	id, err := db.InsertTrack(models.Track{Title: "A", Artist: "B", Album: "C", FilePath: "/fp/a.flac", FileSize: 100})
+	if err != nil {
+		t.Fatalf("Failed to insert track: %v", err)
+	}
+
+	pending, err := db.GetTracksNeedingFingerprint(10)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Expected 1 pending track, got %d (%v)", len(pending), err)
+	}
+
+	if err := db.SaveFingerprint(id, 100, metadata.EncodeFingerprint([]uint32{1, 2, 3}), ""); err != nil {
+		t.Fatalf("Failed to save fingerprint: %v", err)
+	}
+	if count, _ := db.CountPendingFingerprints(); count != 0 {
+		t.Errorf("Expected no pending tracks, got %d", count)
+	}
+
+	fps, err := db.GetAllFingerprints()
+	if err != nil || len(fps) != 1 || len(fps[0].Fingerprint) != 12 {
+		t.Fatalf("Unexpected fingerprints %v (%v)", fps, err)
+	}
+
+	// A changed file size invalidates the stored fingerprint
+	if _, err := db.InsertTrack(models.Track{Title: "A", Artist: "B", Album: "C", FilePath: "/fp/a.flac", FileSize: 200}); err != nil {
+		t.Fatalf("Failed to update track: %v", err)
+	}
+	if count, _ := db.CountPendingFingerprints(); count != 1 {
+		t.Errorf("Expected stale fingerprint to be pending, got %d", count)
+	}
+}
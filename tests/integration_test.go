@@ -241,12 +241,12 @@ func TestFullWorkflow(t *testing.T) {
 		}
 
 		// 5. Test playlist operations
-		playlistID, err := db.CreatePlaylist("Workflow Playlist", "Test playlist for workflow")
+		playlistID, err := db.CreatePlaylist("Workflow Playlist", "Test playlist for workflow", "", models.VisibilityPrivate)
 		if err != nil {
 			t.Fatalf("Failed to create playlist: %v", err)
 		}
 
-		err = db.AddTrackToPlaylist(playlistID, trackID)
+		err = db.AddTrackToPlaylist(playlistID, trackID, "")
 		if err != nil {
 			t.Fatalf("Failed to add track to playlist: %v", err)
 		}
@@ -0,0 +1,540 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"staccato/internal/auth"
+	"staccato/internal/database"
+	"staccato/internal/library"
+	"staccato/internal/metadata"
+	"staccato/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestIngestor returns an ingestor over a fresh database with user folders
+// rooted at root.
+func newTestIngestor(t *testing.T, root string) (*library.Ingestor, *database.Database) {
+	t.Helper()
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "library.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	extractor := metadata.NewExtractor([]string{".wav", ".flac"})
+	return library.NewIngestor(db, extractor, auth.NewUserFolderManager(true, root), logger), db
+}
+
+func TestLibraryScanner(t *testing.T) {
+	root := t.TempDir()
+	samples := synthMelody(3, 10, 8000, 1, 0)
+	for _, dir := range []string{"alice/Live", "bob"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestWAV(t, filepath.Join(root, "alice", "song.wav"), samples[:8000], 8000, 1)
+	writeTestWAV(t, filepath.Join(root, "alice", "Live", "album.wav"), samples, 8000, 1)
+	cuePath := filepath.Join(root, "alice", "Live", "album.cue")
+	if err := os.WriteFile(cuePath, []byte(testCueSheet), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestWAV(t, filepath.Join(root, "bob", "tune.wav"), samples[:16000], 8000, 1)
+
+	t.Run("Scan", func(t *testing.T) {
+		ingestor, db := newTestIngestor(t, root)
+		scanner := library.NewScanner(ingestor, 2)
+		var events []library.Progress
+		scanner.OnProgress = func(p library.Progress) { events = append(events, p) }
+
+		result, err := scanner.Scan(context.Background(), root)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if result.Files != 2 || result.Tracks != 2 || result.CueSheets != 1 || result.Failed != 0 {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+		last := events[len(events)-1]
+		if !last.Walked || last.Found != 2 || last.Processed != 2 || last.CueSheets != 1 {
+			t.Errorf("Unexpected final progress: %+v", last)
+		}
+
+		alice, _ := db.GetTracksByOwner("alice")
+		bob, _ := db.GetTracksByOwner("bob")
+		if len(alice) != 4 || len(bob) != 1 {
+			t.Errorf("Expected 4 tracks for alice and 1 for bob, got %d and %d", len(alice), len(bob))
+		}
+		for _, track := range alice {
+			if track.FilePath == filepath.Join(root, "alice", "Live", "album.wav") {
+				t.Error("Audio covered by a cue sheet was added whole")
+			}
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ingestor, db := newTestIngestor(t, root)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := library.NewScanner(ingestor, 1).Scan(ctx, root); !errors.Is(err, context.Canceled) {
+			t.Errorf("Scan error = %v, want context.Canceled", err)
+		}
+		if count, _ := db.CountTracks(database.StatsScope{All: true}); count != 0 {
+			t.Errorf("Cancelled scan added %d tracks", count)
+		}
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		ingestor, db := newTestIngestor(t, root)
+		ctx := context.Background()
+
+		song := filepath.Join(root, "alice", "song.wav")
+		track, err := ingestor.IngestFile(ctx, song)
+		if err != nil || track == nil || track.ID == 0 || track.Owner != "alice" {
+			t.Fatalf("IngestFile = %+v, %v", track, err)
+		}
+		track, err = ingestor.IngestFileAs(ctx, song, "Alice Smith")
+		if err != nil || track.Owner != "Alice Smith" {
+			t.Errorf("IngestFileAs = %+v, %v", track, err)
+		}
+		if got := ingestor.UserFolder("bob"); got != filepath.Join(root, "bob") {
+			t.Errorf("UserFolder = %q", got)
+		}
+
+		// Audio covered by a cue sheet is ingested as the sheet's tracks
+		if track, err := ingestor.IngestFile(ctx, filepath.Join(root, "alice", "Live", "album.wav")); err != nil || track != nil {
+			t.Errorf("IngestFile of covered audio = %+v, %v", track, err)
+		}
+		if count, _ := db.CountTracks(database.StatsScope{All: true}); count != 4 {
+			t.Errorf("Expected 4 tracks, got %d", count)
+		}
+
+		if err := ingestor.RemoveFile(song); err != nil {
+			t.Fatalf("RemoveFile failed: %v", err)
+		}
+		if err := os.Remove(cuePath); err != nil {
+			t.Fatal(err)
+		}
+		if err := ingestor.RemoveCueSheet(ctx, cuePath); err != nil {
+			t.Fatalf("RemoveCueSheet failed: %v", err)
+		}
+		// Only the formerly covered audio file remains, now standalone
+		tracks, _ := db.GetAllTracks()
+		if len(tracks) != 1 || tracks[0].FilePath != filepath.Join(root, "alice", "Live", "album.wav") {
+			t.Errorf("Expected the standalone album file, got %+v", tracks)
+		}
+	})
+}
+
+func TestLibraryExportImport(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	extractor := metadata.NewExtractor([]string{".wav"})
+
+	// newInstance returns a database, ingestor and portable for a library at
+	// root/music with user folders under root/users.
+	newInstance := func(root string) (*database.Database, *library.Ingestor, *library.Portable) {
+		db, err := database.NewDatabase(filepath.Join(t.TempDir(), "library.db"))
+		if err != nil {
+			t.Fatalf("Failed to create test database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		folders := auth.NewUserFolderManager(true, filepath.Join(root, "users"))
+		return db, library.NewIngestor(db, extractor, folders, logger),
+			library.NewPortable(db, library.NewRoots(folders, filepath.Join(root, "music")))
+	}
+	write := func(path string, seed int) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestWAV(t, path, synthMelody(seed, 2, 8000, 1, 0), 8000, 1)
+	}
+	ingest := func(in *library.Ingestor, path string) int {
+		track, err := in.IngestFile(ctx, path)
+		if err != nil || track == nil {
+			t.Fatalf("IngestFile(%s) = %+v, %v", path, track, err)
+		}
+		return track.ID
+	}
+
+	// Old instance: a playlist over four tracks, an override and a download
+	oldRoot := t.TempDir()
+	oldDB, oldIn, oldPortable := newInstance(oldRoot)
+	write(filepath.Join(oldRoot, "music", "a.wav"), 1)
+	write(filepath.Join(oldRoot, "music", "b.wav"), 2)
+	write(filepath.Join(oldRoot, "users", "alice", "c.wav"), 3)
+	write(filepath.Join(oldRoot, "music", "d.wav"), 4)
+	a := ingest(oldIn, filepath.Join(oldRoot, "music", "a.wav"))
+	b := ingest(oldIn, filepath.Join(oldRoot, "music", "b.wav"))
+	c := ingest(oldIn, filepath.Join(oldRoot, "users", "alice", "c.wav"))
+	d := ingest(oldIn, filepath.Join(oldRoot, "music", "d.wav"))
+
+	playlistID, _ := oldDB.CreatePlaylist("Mix", "road trip", "", models.VisibilityPrivate)
+	for _, id := range []int{c, a, d, b} {
+		addedBy := ""
+		if id == a {
+			addedBy = "bob"
+		}
+		if err := oldDB.AddTrackToPlaylist(playlistID, id, addedBy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := oldDB.SetPlaylistCollaborator(playlistID, "bob", models.PlaylistRoleEditor, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	trips, _ := oldDB.CreatePlaylistFolder("Trips", "", models.VisibilityPublic, 0)
+	summer, _ := oldDB.CreatePlaylistFolder("Summer", "", models.FolderVisibilityInherit, trips)
+	if err := oldDB.MovePlaylist(playlistID, summer, nil); err != nil {
+		t.Fatal(err)
+	}
+	title := "B Side"
+	if err := oldDB.SetTrackOverride(models.TrackOverride{TrackID: b, Title: &title, UpdatedBy: "admin"}, []byte("art")); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := oldDB.UpsertDownloadJob("job-1", "https://example.com/a", "A", "Artist", "completed", 100, "",
+		filepath.Join(oldRoot, "music", "a.wav"), "", 0, &created, &created); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := oldPortable.Export()
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if doc.Version != models.LibraryExportVersion || len(doc.Tracks) != 4 || len(doc.Playlists) != 1 ||
+		len(doc.Folders) != 2 || len(doc.Overrides) != 1 || len(doc.Downloads) != 1 {
+		t.Fatalf("Unexpected export: %+v", doc)
+	}
+	if doc.Downloads[0].Path != "a.wav" {
+		t.Errorf("Download path = %q, want library-relative", doc.Downloads[0].Path)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range doc.Tracks {
+		if ref.Hash == "" || strings.Contains(ref.Path, oldRoot) {
+			t.Errorf("Track ref not portable: %+v", ref)
+		}
+	}
+
+	// New instance rooted elsewhere: a.wav in place, b.wav renamed, c.wav
+	// moved out of alice's folder, d.wav missing
+	newRoot := t.TempDir()
+	newDB, newIn, newPortable := newInstance(newRoot)
+	write(filepath.Join(newRoot, "music", "a.wav"), 1)
+	write(filepath.Join(newRoot, "music", "Singles", "renamed.wav"), 2)
+	write(filepath.Join(newRoot, "music", "Moved", "c.wav"), 5)
+	newA := ingest(newIn, filepath.Join(newRoot, "music", "a.wav"))
+	newB := ingest(newIn, filepath.Join(newRoot, "music", "Singles", "renamed.wav"))
+	newC := ingest(newIn, filepath.Join(newRoot, "music", "Moved", "c.wav"))
+
+	var imported models.LibraryExport
+	if err := json.Unmarshal(data, &imported); err != nil {
+		t.Fatal(err)
+	}
+	report, err := newPortable.Import(&imported)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.MatchedByPath != 1 || report.MatchedByHash != 1 || report.MatchedByTags != 1 {
+		t.Errorf("Unexpected match counts: %+v", report)
+	}
+	if report.PlaylistsCreated != 1 || report.PlaylistEntries != 3 || report.FoldersCreated != 2 ||
+		report.Collaborators != 1 || report.Overrides != 1 || report.Downloads != 1 {
+		t.Errorf("Unexpected import counts: %+v", report)
+	}
+	if len(report.Unmatched) != 1 || report.Unmatched[0].Path != "d.wav" ||
+		len(report.Unmatched[0].Playlists) != 1 || report.Unmatched[0].Playlists[0] != "Mix" {
+		t.Errorf("Unexpected unmatched report: %+v", report.Unmatched)
+	}
+
+	playlists, _ := newDB.GetAllPlaylists()
+	if len(playlists) != 1 || playlists[0].Description != "road trip" || !playlists[0].CreatedAt.Equal(doc.Playlists[0].CreatedAt) {
+		t.Fatalf("Unexpected playlists: %+v", playlists)
+	}
+	tracks, _ := newDB.GetPlaylistTracks(playlists[0].ID)
+	if len(tracks) != 3 || tracks[0].ID != newC || tracks[1].ID != newA || tracks[2].ID != newB {
+		t.Errorf("Playlist order not kept: %+v", tracks)
+	}
+	// Folders, visibility inherited through them, collaborators and who
+	// added entries come along
+	if folder, err := newDB.GetPlaylistFolder(playlists[0].FolderID); err != nil || folder.Name != "Summer" ||
+		!folder.InheritsVisibility || playlists[0].Visibility != models.VisibilityPublic {
+		t.Errorf("Playlist filed in %+v (%v) with visibility %s", folder, err, playlists[0].Visibility)
+	} else if parent, _ := newDB.GetPlaylistFolder(folder.ParentID); parent == nil || parent.Name != "Trips" {
+		t.Errorf("Folder parent = %+v", parent)
+	}
+	if role, _ := newDB.GetPlaylistRole(playlists[0].ID, "bob"); role != models.PlaylistRoleEditor {
+		t.Errorf("Collaborator role = %q, want editor", role)
+	}
+	if entries, _ := newDB.GetPlaylistEntries(playlists[0].ID); len(entries) != 3 || entries[1].AddedBy != "bob" || entries[0].AddedBy != "" {
+		t.Errorf("Entries added by = %+v", entries)
+	}
+	if track, _ := newDB.GetTrackByID(newB); track.Title != "B Side" || !track.HasAlbumArt {
+		t.Errorf("Override not applied: %+v", track)
+	}
+	jobs, _ := newDB.GetDownloadJobsForExport()
+	if len(jobs) != 1 || jobs[0].Path != filepath.Join(newRoot, "music", "a.wav") {
+		t.Errorf("Download not re-rooted: %+v", jobs)
+	}
+
+	// Importing again merges into the existing playlist without duplicates
+	report, err = newPortable.Import(&imported)
+	if err != nil || report.PlaylistsMerged != 1 || report.PlaylistsCreated != 0 || report.FoldersMerged != 2 || report.FoldersCreated != 0 {
+		t.Fatalf("Re-import = %+v, %v", report, err)
+	}
+	if tracks, _ := newDB.GetPlaylistTracks(playlists[0].ID); len(tracks) != 3 {
+		t.Errorf("Re-import duplicated playlist entries: %d", len(tracks))
+	}
+
+	imported.Version = models.LibraryExportVersion + 1
+	if _, err := newPortable.Import(&imported); !errors.Is(err, library.ErrUnsupportedExport) {
+		t.Errorf("Import of newer version = %v, want ErrUnsupportedExport", err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	ingestor, db := newTestIngestor(t, root)
+	trash := library.NewTrash(ingestor, library.NewRoots(auth.NewUserFolderManager(true, root), filepath.Join(root, "main")))
+
+	song := filepath.Join(root, "alice", "Album", "song.wav")
+	if err := os.MkdirAll(filepath.Dir(song), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestWAV(t, song, synthMelody(1, 2, 8000, 1, 0), 8000, 1)
+	track, err := ingestor.IngestFile(ctx, song)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := trash.Delete(track, "alice")
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if item.Path != "Album/song.wav" || item.Owner != "alice" || item.DeletedBy != "alice" {
+		t.Errorf("Unexpected trash item: %+v", item)
+	}
+	if _, err := os.Stat(song); !errors.Is(err, os.ErrNotExist) {
+		t.Error("File still at its original path")
+	}
+	if filepath.Dir(item.TrashPath) != filepath.Join(root, "alice", library.TrashDir) || !library.InTrash(item.TrashPath) {
+		t.Errorf("File trashed to %s", item.TrashPath)
+	}
+	if found, _ := db.TrackExists(song); found {
+		t.Error("Track row not removed")
+	}
+
+	// Scans don't pick trashed files back up
+	if result, err := library.NewScanner(ingestor, 1).Scan(ctx, root); err != nil || result.Files != 0 {
+		t.Errorf("Scan = %+v, %v; want no files", result, err)
+	}
+
+	if items, _ := trash.List(database.StatsScope{Owner: "bob"}); len(items) != 0 {
+		t.Errorf("bob sees alice's trash: %+v", items)
+	}
+	items, err := trash.List(database.StatsScope{Owner: "alice"})
+	if err != nil || len(items) != 1 || items[0].ID != item.ID || items[0].Path != item.Path {
+		t.Fatalf("List = %+v, %v", items, err)
+	}
+
+	// Restoring onto an existing file fails and leaves the trash intact
+	writeTestWAV(t, song, synthMelody(2, 1, 8000, 1, 0), 8000, 1)
+	if _, err := trash.Restore(ctx, item); !errors.Is(err, library.ErrRestoreConflict) {
+		t.Errorf("Restore over existing file = %v, want ErrRestoreConflict", err)
+	}
+	os.Remove(song)
+	restored, err := trash.Restore(ctx, item)
+	if err != nil || restored == nil || restored.Owner != "alice" || restored.FilePath != song {
+		t.Fatalf("Restore = %+v, %v", restored, err)
+	}
+	if got, _ := trash.Get(item.ID); got != nil {
+		t.Error("Restored item still listed")
+	}
+
+	// Purge
+	item, err = trash.Delete(restored, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := trash.PurgeOlderThan(time.Hour); err != nil || purged != 0 {
+		t.Errorf("PurgeOlderThan(1h) = %d, %v; want nothing purged", purged, err)
+	}
+	if purged, err := trash.PurgeOlderThan(0); err != nil || purged != 1 {
+		t.Errorf("PurgeOlderThan(0) = %d, %v", purged, err)
+	}
+	if _, err := os.Stat(item.TrashPath); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Purged file still on disk")
+	}
+
+	if _, err := trash.Delete(&models.Track{FilePath: "a.cue#01", SourcePath: "a.wav"}, "admin"); !errors.Is(err, library.ErrVirtualTrack) {
+		t.Errorf("Delete of cue sheet track = %v, want ErrVirtualTrack", err)
+	}
+}
+
+func TestTrashDeleteRollback(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "library.db")
+	db, err := database.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	ingestor := library.NewIngestor(db, metadata.NewExtractor([]string{".wav"}), nil, logger)
+	trash := library.NewTrash(ingestor, library.NewRoots(nil, root))
+
+	song := filepath.Join(root, "song.wav")
+	writeTestWAV(t, song, synthMelody(1, 2, 8000, 1, 0), 8000, 1)
+	track, err := ingestor.IngestFile(ctx, song)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make removing the track row fail after the file was moved
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`CREATE TRIGGER keep_tracks BEFORE DELETE ON tracks BEGIN SELECT RAISE(ABORT, 'tracks are locked'); END`); err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+
+	if _, err := trash.Delete(track, "admin"); err == nil {
+		t.Fatal("Delete succeeded although the track could not be removed")
+	}
+	if _, err := os.Stat(song); err != nil {
+		t.Errorf("File not moved back: %v", err)
+	}
+	if items, err := trash.List(database.StatsScope{All: true}); err != nil || len(items) != 0 {
+		t.Errorf("Trash = %+v, %v; want empty", items, err)
+	}
+	if found, _ := db.TrackExists(song); !found {
+		t.Error("Track row removed")
+	}
+}
+
+func TestOrganizer(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "library.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	extractor := metadata.NewExtractor([]string{".wav"})
+	inference, _ := metadata.ParsePathTemplate("{artist} - {year} - {title}")
+	roots := library.NewRoots(nil, root)
+	extractor.SetPathTemplates([]*metadata.PathTemplate{inference}, func(filePath string) string {
+		return roots.Relative(filePath, "")
+	})
+	ingestor := library.NewIngestor(db, extractor, nil, logger)
+
+	// Download-style names, one nested and one duplicate
+	files := []string{
+		"Artist A - 1999 - Song One.wav",
+		"Artist A - 1999 - Song Two.wav",
+		"old/nested/Artist B - 2005 - Solo.wav",
+		"dup/Artist A - 1999 - Song One.wav",
+	}
+	ids := map[string]int{}
+	for i, name := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestWAV(t, path, synthMelody(i, 1, 8000, 1, 0), 8000, 1)
+		track, err := ingestor.IngestFile(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = track.ID
+	}
+	playlistID, _ := db.CreatePlaylist("Mix", "", "", models.VisibilityPrivate)
+	db.AddTrackToPlaylist(playlistID, ids["old/nested/Artist B - 2005 - Solo.wav"], "")
+
+	tmpl, err := metadata.ParseNamingTemplate("{albumartist}/{year} - {album}/{track:02} {title}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	organizer := library.NewOrganizer(ingestor, library.NewRoots(nil, root), tmpl)
+	tracks, _ := db.GetAllTracks()
+	moves := organizer.Plan(tracks)
+	if len(moves) != 4 {
+		t.Fatalf("Expected 4 moves, got %+v", moves)
+	}
+	targets := map[string]library.Move{}
+	for _, move := range moves {
+		targets[move.From] = move
+	}
+	if move := targets["old/nested/Artist B - 2005 - Solo.wav"]; move.To != "Artist B/2005 - Unknown Album/Solo.wav" || move.Conflict != "" {
+		t.Errorf("Unexpected move %+v", move)
+	}
+	conflicts := 0
+	for _, move := range moves {
+		if move.Conflict != "" {
+			conflicts++
+		}
+	}
+	if conflicts != 1 {
+		t.Errorf("Expected the duplicate to conflict, got %+v", moves)
+	}
+	// Planning is a dry run
+	if _, err := os.Stat(filepath.Join(root, "Artist A - 1999 - Song One.wav")); err != nil {
+		t.Error("Plan moved a file")
+	}
+
+	result, err := organizer.Apply(ctx, moves)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if result.Moved != 3 || result.Skipped != 1 || result.Failed != 0 || result.RemovedDirs != 2 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	solo := filepath.Join(root, "Artist B", "2005 - Unknown Album", "Solo.wav")
+	if _, err := os.Stat(solo); err != nil {
+		t.Errorf("Solo not moved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Emptied directories not removed")
+	}
+	if _, err := os.Stat(filepath.Join(root, "dup")); err != nil {
+		t.Error("Directory of the skipped file was removed")
+	}
+
+	// Rows are repointed in place
+	track, err := db.GetTrackByID(ids["old/nested/Artist B - 2005 - Solo.wav"])
+	if err != nil || track.FilePath != solo {
+		t.Errorf("Track row = %+v, %v", track, err)
+	}
+	if entries, _ := db.GetPlaylistTracks(playlistID); len(entries) != 1 || entries[0].FilePath != solo {
+		t.Errorf("Playlist lost the moved track: %+v", entries)
+	}
+
+	// Organizing an organized library is a no-op
+	tracks, _ = db.GetAllTracks()
+	for _, move := range organizer.Plan(tracks) {
+		if move.Conflict == "" {
+			t.Errorf("Unexpected second move %+v", move)
+		}
+	}
+}
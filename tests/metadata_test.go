@@ -1,11 +1,22 @@
 package tests
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"staccato/internal/library"
 	"staccato/internal/metadata"
+	"staccato/pkg/models"
+
+	"github.com/tcolgate/mp3"
 )
 
 func TestMetadataExtractor(t *testing.T) {
@@ -51,6 +62,12 @@ func TestMetadataExtractor(t *testing.T) {
 			{"song.WAV", "audio/wav"},
 			{"song.m4a", "audio/mp4"},
 			{"song.M4A", "audio/mp4"},
+			{"song.ogg", "audio/ogg"},
+			{"song.oga", "audio/ogg"},
+			{"song.opus", "audio/ogg"},
+			{"song.aiff", "audio/aiff"},
+			{"song.AIF", "audio/aiff"},
+			{"song.aac", "audio/aac"},
 			{"song.txt", "application/octet-stream"},
 			{"song.unknown", "application/octet-stream"},
 		}
@@ -150,3 +167,504 @@ func TestMetadataExtractionFallback(t *testing.T) {
 		}
 	})
 }
+
+func TestPathTemplates(t *testing.T) {
+	tmpl, err := metadata.ParsePathTemplate("{artist}/{album}/{track} - {title}")
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+
+	inf, ok := tmpl.Infer("/srv/music/Boards of Canada/Geogaddi/03 - Music Is Math.flac")
+	if !ok {
+		t.Fatal("Expected template to match")
+	}
+	if inf.Artist != "Boards of Canada" || inf.Album != "Geogaddi" || inf.TrackNumber != 3 || inf.Title != "Music Is Math" {
+		t.Errorf("Unexpected inference %+v", inf)
+	}
+	if _, ok := tmpl.Infer("Loose Song.mp3"); ok {
+		t.Error("Expected no match for a file without artist/album folders")
+	}
+
+	yearTmpl, err := metadata.ParsePathTemplate("{artist}/{year} - {album}/{disc}-{track} {title}")
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+	if inf, ok := yearTmpl.Infer("Artist/1999 - Album/2-07 Title (Live).mp3"); !ok || inf.Year != 1999 || inf.Disc != 2 || inf.TrackNumber != 7 || inf.Title != "Title (Live)" {
+		t.Errorf("Unexpected inference %+v (%v)", inf, ok)
+	}
+
+	for _, bad := range []string{"", "{artist}/{bogus}", "{title} - {title}", "no placeholders"} {
+		if _, err := metadata.ParsePathTemplate(bad); err == nil {
+			t.Errorf("Expected error for template %q", bad)
+		}
+	}
+
+	// Untagged files pick up inferred values during extraction, matched
+	// against their path in the library
+	root := filepath.Join(t.TempDir(), "music")
+	dir := filepath.Join(root, "Some Artist", "Some Album")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	path := filepath.Join(dir, "05 - Inferred Title.wav")
+	writeTestWAV(t, path, make([]int16, 8000), 8000, 1)
+
+	roots := library.NewRoots(nil, root)
+	extractor := metadata.NewExtractor([]string{".wav"})
+	extractor.SetPathTemplates([]*metadata.PathTemplate{tmpl}, func(filePath string) string {
+		return roots.Relative(filePath, "")
+	})
+	track, err := extractor.ExtractFromFile(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+	if track.Artist != "Some Artist" || track.Album != "Some Album" || track.Title != "Inferred Title" || track.TrackNumber != 5 {
+		t.Errorf("Expected path-inferred metadata, got %+v", track)
+	}
+
+	// A file shallower than the template does not match, even though the
+	// directories above the library root would fill it
+	shallow := filepath.Join(root, "Loose", "07 - Shallow.wav")
+	if err := os.MkdirAll(filepath.Dir(shallow), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	writeTestWAV(t, shallow, make([]int16, 8000), 8000, 1)
+	track, err = extractor.ExtractFromFile(shallow, 0)
+	if err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+	if track.Artist != "Unknown Artist" || track.Album != "Unknown Album" || track.TrackNumber != 0 {
+		t.Errorf("Shallow file took fields from outside the library: %+v", track)
+	}
+}
+
+func TestNamingTemplates(t *testing.T) {
+	tmpl, err := metadata.ParseNamingTemplate("{albumartist}/{year} - {album}/{disc}{track:02} {title}")
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+
+	cases := []struct {
+		track models.Track
+		want  string
+	}{
+		{
+			models.Track{Artist: "Boards of Canada", Album: "Geogaddi", Title: "Music Is Math", TrackNumber: 3, Disc: 1, Year: 2002},
+			"Boards of Canada/2002 - Geogaddi/103 Music Is Math",
+		},
+		// Missing values leave no dangling separators
+		{
+			models.Track{Artist: "Artist", Album: "Album", Title: "Song"},
+			"Artist/Album/Song",
+		},
+		// Values cannot add directories or reserved characters
+		{
+			models.Track{Artist: "AC/DC", Album: "What?", Title: "...Ready", TrackNumber: 1},
+			"AC_DC/What/01 ...Ready",
+		},
+		{
+			models.Track{Title: "Untitled"},
+			"Unknown/Unknown/Untitled",
+		},
+		// Albums are filed under the album artist, compilations together
+		{
+			models.Track{Artist: "Artist feat. Guest", AlbumArtist: "Artist", Album: "Album", Title: "Duet", TrackNumber: 2},
+			"Artist/Album/02 Duet",
+		},
+		{
+			models.Track{Artist: "Someone", AlbumArtist: "DJ", Compilation: true, Album: "Hits", Title: "Hit", Year: 1999},
+			"Various Artists/1999 - Hits/Hit",
+		},
+	}
+	for _, c := range cases {
+		if got := tmpl.Render(c.track); got != c.want {
+			t.Errorf("Render(%+v) = %q, want %q", c.track, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"", "{artist}/{bogus} {title}", "{artist}/{album}", "{artist:02}/{title}", "{artist}//{title}", "../{title}", "{artist\\{title}"} {
+		if _, err := metadata.ParseNamingTemplate(bad); err == nil {
+			t.Errorf("Expected error for template %q", bad)
+		}
+	}
+}
+
+func TestAlbumArtistTags(t *testing.T) {
+	dir := t.TempDir()
+	ident := make([]byte, 30)
+	copy(ident, "\x01vorbis")
+	ident[11] = 2
+	binary.LittleEndian.PutUint32(ident[12:], 44100)
+
+	buildTestOgg(t, filepath.Join(dir, "comp.ogg"), ident, vorbisComments("\x03vorbis",
+		"TITLE=Song", "ARTIST=Someone", "ALBUM=Hits", "ALBUMARTIST=Various", "COMPILATION=1"), 44100*10)
+	buildTestOgg(t, filepath.Join(dir, "plain.ogg"), ident, vorbisComments("\x03vorbis",
+		"TITLE=Song", "ARTIST=Artist feat. Guest", "ALBUM=Album", "COMPILATION=0"), 44100*10)
+
+	extractor := metadata.NewExtractor([]string{".ogg"})
+	comp, err := extractor.ExtractFromFile(filepath.Join(dir, "comp.ogg"), 0)
+	if err != nil {
+		t.Fatalf("Failed to extract metadata: %v", err)
+	}
+	if comp.AlbumArtist != "Various" || !comp.Compilation || comp.AlbumGroupArtist() != models.VariousArtists {
+		t.Errorf("Unexpected compilation track: %+v", comp)
+	}
+
+	plain, err := extractor.ExtractFromFile(filepath.Join(dir, "plain.ogg"), 0)
+	if err != nil {
+		t.Fatalf("Failed to extract metadata: %v", err)
+	}
+	if plain.AlbumArtist != "" || plain.Compilation || plain.AlbumGroupArtist() != "Artist feat. Guest" {
+		t.Errorf("Unexpected plain track: %+v", plain)
+	}
+}
+
+func TestCreditParser(t *testing.T) {
+	parser := metadata.NewCreditParser(nil, nil)
+	format := func(credits []models.ArtistCredit) string {
+		var parts []string
+		for _, c := range credits {
+			parts = append(parts, c.Role+":"+c.Name)
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	cases := []struct {
+		track models.Track
+		want  string
+	}{
+		{models.Track{Artist: "Simon & Garfunkel", Title: "Song"}, "main:Simon & Garfunkel"},
+		{models.Track{Artist: "Artist A; Artist B / Artist C", Title: "Song"}, "main:Artist A, main:Artist B, main:Artist C"},
+		{models.Track{Artist: "Artist A feat. Guest 1 & Guest 2", Title: "Song"}, "main:Artist A, featured:Guest 1, featured:Guest 2"},
+		{models.Track{Artist: "Artist A (Ft. Guest)", Title: "Song"}, "main:Artist A, featured:Guest"},
+		{
+			models.Track{Artist: "Artist A", Title: "Song (featuring Guest, Artist A) [DJ X Remix]", Composer: "Writer 1; Writer 2"},
+			"main:Artist A, featured:Guest, remixer:DJ X, composer:Writer 1, composer:Writer 2",
+		},
+		{models.Track{Artist: "Left Feather", Title: "Song (Extended Remix)", Remixer: "DJ Y"}, "main:Left Feather, remixer:DJ Y"},
+	}
+	for _, c := range cases {
+		if got := format(parser.Parse(c.track)); got != c.want {
+			t.Errorf("Parse(%q, %q) = %q, want %q", c.track.Artist, c.track.Title, got, c.want)
+		}
+	}
+
+	custom := metadata.NewCreditParser([]string{" & "}, []string{"with"})
+	if got := format(custom.Parse(models.Track{Artist: "A & B with C"})); got != "main:A, main:B, featured:C" {
+		t.Errorf("Custom parser gave %q", got)
+	}
+}
+
+func TestSortNames(t *testing.T) {
+	sorter := metadata.NewSorter(nil)
+	for _, c := range []struct{ name, tag, want string }{
+		{"The Beatles", "", "Beatles"},
+		{"the the", "", "the"},
+		{"Theatre", "", "Theatre"},
+		{"L'Impératrice", "", "Impératrice"},
+		{"A", "", "A"},
+		{"The Beatles", "Beatles, The", "Beatles, The"},
+	} {
+		if got := sorter.SortName(c.name, c.tag); got != c.want {
+			t.Errorf("SortName(%q, %q) = %q, want %q", c.name, c.tag, got, c.want)
+		}
+	}
+
+	names := []string{"Bzzz", "The Beatles", "björk", "Abba", "10cc", "2Pac", "Die Ärzte"}
+	sorter.SortStrings(names)
+	if got := strings.Join(names, "|"); got != "2Pac|10cc|Abba|Die Ärzte|The Beatles|björk|Bzzz" {
+		t.Errorf("SortStrings = %q", got)
+	}
+
+	tracks := []models.Track{
+		{ID: 1, Artist: "Zed", ArtistSort: "Aardvark", Album: "X", Title: "One"},
+		{ID: 2, Artist: "Björk", Album: "Post", Title: "Army of Me", TrackNumber: 1},
+		{ID: 3, Artist: "The Beatles", Album: "Help!", Title: "Help!", TrackNumber: 1},
+		{ID: 4, Artist: "Bzzz", Album: "Noise", Title: "Buzz"},
+		{ID: 5, Artist: "björk", Album: "Debut", Title: "Human Behaviour", TrackNumber: 1},
+	}
+	sorter.SortTracks(tracks, metadata.ByArtist)
+	var ids []int
+	for _, track := range tracks {
+		ids = append(ids, track.ID)
+	}
+	if fmt.Sprint(ids) != "[1 3 5 2 4]" {
+		t.Errorf("SortTracks(ByArtist) order = %v", ids)
+	}
+
+	sorter.SortTracks(tracks, metadata.ByAlbum)
+	ids = ids[:0]
+	for _, track := range tracks {
+		ids = append(ids, track.ID)
+	}
+	if fmt.Sprint(ids) != "[5 3 4 2 1]" {
+		t.Errorf("SortTracks(ByAlbum) order = %v", ids)
+	}
+
+	custom := metadata.NewSorter([]string{"Los"})
+	if got := custom.SortName("The Beatles", ""); got != "The Beatles" {
+		t.Errorf("Custom articles gave %q", got)
+	}
+}
+
+func TestSource(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 5<<20+123) // spans the head blocks and the cache limit
+	for i := range data {
+		data[i] = byte(i*7 + i>>11)
+	}
+	path := filepath.Join(dir, "blob.mp3")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := metadata.OpenSource(path)
+	if err != nil {
+		t.Fatalf("OpenSource failed: %v", err)
+	}
+	defer src.Close()
+	if src.Size() != int64(len(data)) || src.Ext() != ".mp3" {
+		t.Errorf("Size = %d, Ext = %q", src.Size(), src.Ext())
+	}
+
+	for _, r := range []struct{ off, n int }{{0, 10}, {65530, 20}, {3 << 20, 200000}, {len(data) - 5, 5}, {100, 5 << 20}} {
+		buf := make([]byte, r.n)
+		n, err := src.ReadAt(buf, int64(r.off))
+		if err != nil || n != r.n || !bytes.Equal(buf, data[r.off:r.off+r.n]) {
+			t.Errorf("ReadAt(%d, %d) = %d, %v", r.off, r.n, n, err)
+		}
+	}
+	if n, err := src.ReadAt(make([]byte, 10), int64(len(data)-4)); n != 4 || err != io.EOF {
+		t.Errorf("ReadAt past end = %d, %v; want 4, EOF", n, err)
+	}
+
+	if _, err := src.Seek(-1000, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(src)
+	if err != nil || !bytes.Equal(tail, data[len(data)-1000:]) {
+		t.Errorf("Read after Seek returned %d bytes, %v", len(tail), err)
+	}
+}
+
+func TestExtractFromSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	buildTestMP3Stream(t, path, mp3TestOptions{frames: 500, bitrates: []int{128}, xing: true, id3v2: true, id3v1: true})
+	extractor := metadata.NewExtractor([]string{".mp3"})
+
+	fromFile, err := extractor.ExtractFromFile(path, 7)
+	if err != nil {
+		t.Fatalf("ExtractFromFile failed: %v", err)
+	}
+	src, err := metadata.OpenSource(path)
+	if err != nil {
+		t.Fatalf("OpenSource failed: %v", err)
+	}
+	defer src.Close()
+	fromSource, err := extractor.ExtractFromSource(src, 7)
+	if err != nil {
+		t.Fatalf("ExtractFromSource failed: %v", err)
+	}
+	if fromFile != fromSource {
+		t.Errorf("ExtractFromSource = %+v, want %+v", fromSource, fromFile)
+	}
+	if fromSource.Duration != 13 || fromSource.FileSize == 0 {
+		t.Errorf("Unexpected track: %+v", fromSource)
+	}
+}
+
+// mp3TestOptions describes a synthetic MPEG-1 layer III stream at 44.1 kHz.
+type mp3TestOptions struct {
+	frames      int
+	bitrates    []int // kbps, cycled per frame; index into the MPEG-1 layer III table
+	xing        bool
+	lameDelay   int
+	lamePadding int
+	vbri        bool
+	id3v2       bool
+	ape         bool
+	emptyAPE    bool // an APE footer claiming a size of 0, as in corrupt files
+	id3v1       bool
+}
+
+var mp3TestBitrateIndex = map[int]byte{32: 1, 64: 5, 128: 9, 160: 10, 192: 11, 256: 13, 320: 14}
+
+// buildTestMP3Stream writes silent frames, padding them the way encoders do
+// so the average frame length matches the nominal bitrate.
+func buildTestMP3Stream(tb testing.TB, path string, o mp3TestOptions) {
+	tb.Helper()
+	var buf bytes.Buffer
+	if o.id3v2 {
+		// Tag body full of fake frame syncs that must not be mistaken for audio
+		body := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 64)
+		buf.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(len(body) >> 7), byte(len(body) & 0x7F)})
+		buf.Write(body)
+	}
+
+	remainder := 0
+	frame := func(kbps int) []byte {
+		bitrate := kbps * 1000
+		size := 144 * bitrate / 44100
+		remainder += 144 * bitrate % 44100
+		pad := byte(0)
+		if remainder >= 44100 {
+			remainder -= 44100
+			size++
+			pad = 1
+		}
+		f := make([]byte, size)
+		f[0], f[1], f[2], f[3] = 0xFF, 0xFB, mp3TestBitrateIndex[kbps]<<4|pad<<1, 0x00
+		return f
+	}
+
+	if o.xing {
+		f := frame(128)
+		x := f[4+32:]
+		copy(x, "Xing")
+		binary.BigEndian.PutUint32(x[4:], 0x0F)
+		binary.BigEndian.PutUint32(x[8:], uint32(o.frames))
+		copy(x[120:], "LAME3.100")
+		d := x[120+21:]
+		d[0] = byte(o.lameDelay >> 4)
+		d[1] = byte(o.lameDelay&0x0F<<4 | o.lamePadding>>8)
+		d[2] = byte(o.lamePadding)
+		buf.Write(f)
+	}
+	if o.vbri {
+		f := frame(128)
+		v := f[4+32:]
+		copy(v, "VBRI")
+		binary.BigEndian.PutUint16(v[4:], 1)
+		binary.BigEndian.PutUint32(v[14:], uint32(o.frames))
+		buf.Write(f)
+	}
+	for i := 0; i < o.frames; i++ {
+		buf.Write(frame(o.bitrates[i%len(o.bitrates)]))
+	}
+
+	if o.ape {
+		footer := make([]byte, 32)
+		copy(footer, "APETAGEX")
+		binary.LittleEndian.PutUint32(footer[8:], 2000)
+		binary.LittleEndian.PutUint32(footer[12:], 32+16)
+		item := append([]byte{5, 0, 0, 0, 0, 0, 0, 0}, "Title\x00Hello"...)
+		buf.Write(item[:16])
+		buf.Write(footer)
+	}
+	if o.emptyAPE {
+		footer := make([]byte, 32)
+		copy(footer, "APETAGEX")
+		binary.LittleEndian.PutUint32(footer[8:], 2000)
+		buf.Write(footer)
+	}
+	if o.id3v1 {
+		tag := make([]byte, 128)
+		copy(tag, "TAGSome Title")
+		buf.Write(tag)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		tb.Fatalf("Failed to write test MP3: %v", err)
+	}
+}
+
+// mp3FramesDuration is the duration of n MPEG-1 layer III frames at 44.1 kHz.
+func mp3FramesDuration(n int) time.Duration {
+	return time.Duration(n) * 1152 * time.Second / 44100
+}
+
+func TestMP3Duration(t *testing.T) {
+	dir := t.TempDir()
+	const frames = 2000
+
+	testCases := []struct {
+		name string
+		opts mp3TestOptions
+		want time.Duration
+	}{
+		{"XingLAME", mp3TestOptions{frames: frames, bitrates: []int{128, 320, 64}, xing: true, lameDelay: 576, lamePadding: 1000, id3v2: true},
+			time.Duration(frames*1152-1576) * time.Second / 44100},
+		{"VBRI", mp3TestOptions{frames: frames, bitrates: []int{192, 256}, vbri: true}, mp3FramesDuration(frames)},
+		{"CBRWithTags", mp3TestOptions{frames: frames, bitrates: []int{160}, id3v2: true, ape: true, id3v1: true}, mp3FramesDuration(frames)},
+		{"HeaderlessVBR", mp3TestOptions{frames: frames, bitrates: []int{128, 320, 32, 192}, id3v2: true, id3v1: true}, mp3FramesDuration(frames)},
+		// Must not loop re-reading the footer
+		{"ZeroSizeAPE", mp3TestOptions{frames: frames, bitrates: []int{128, 320, 32, 192}, emptyAPE: true}, mp3FramesDuration(frames)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name+".mp3")
+			buildTestMP3Stream(t, path, tc.opts)
+			info, err := metadata.ReadAudioInfo(path)
+			if err != nil {
+				t.Fatalf("ReadAudioInfo failed: %v", err)
+			}
+			if diff := info.Duration - tc.want; diff < -time.Millisecond || diff > time.Millisecond {
+				t.Errorf("Duration = %v, want %v", info.Duration, tc.want)
+			}
+			if info.Codec != metadata.CodecMP3 || info.SampleRate != 44100 || info.Channels != 2 {
+				t.Errorf("Unexpected info: %+v", info)
+			}
+		})
+	}
+
+	t.Run("Extractor", func(t *testing.T) {
+		path := filepath.Join(dir, "HeaderlessVBR.mp3")
+		track, err := metadata.NewExtractor([]string{".mp3"}).ExtractFromFile(path, 0)
+		if err != nil {
+			t.Fatalf("ExtractFromFile failed: %v", err)
+		}
+		if want := int(mp3FramesDuration(frames).Seconds() + 0.5); track.Duration != want {
+			t.Errorf("Duration = %d, want %d", track.Duration, want)
+		}
+	})
+}
+
+// BenchmarkMP3Duration compares header-based duration reading with frame
+// scanning and with decoding every frame (the previous approach) on a
+// four-minute VBR file.
+func BenchmarkMP3Duration(b *testing.B) {
+	dir := b.TempDir()
+	const frames = 9200 // ~4 minutes
+	bitrates := []int{128, 192, 256, 320, 160}
+	withHeader := filepath.Join(dir, "xing.mp3")
+	headerless := filepath.Join(dir, "headerless.mp3")
+	buildTestMP3Stream(b, withHeader, mp3TestOptions{frames: frames, bitrates: bitrates, xing: true, id3v2: true})
+	buildTestMP3Stream(b, headerless, mp3TestOptions{frames: frames, bitrates: bitrates, id3v2: true})
+
+	b.Run("XingHeader", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := metadata.ReadAudioInfo(withHeader); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("FrameScan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := metadata.ReadAudioInfo(headerless); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("FullDecode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			f, err := os.Open(withHeader)
+			if err != nil {
+				b.Fatal(err)
+			}
+			dec := mp3.NewDecoder(f)
+			var fr mp3.Frame
+			var skipped int
+			var total time.Duration
+			for {
+				if err := dec.Decode(&fr, &skipped); err != nil {
+					if !errors.Is(err, io.EOF) {
+						b.Fatal(err)
+					}
+					break
+				}
+				total += fr.Duration()
+			}
+			f.Close()
+		}
+	})
+}
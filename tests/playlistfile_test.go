@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"staccato/internal/database"
+	"staccato/internal/library"
+	"staccato/internal/playlistfile"
+	"staccato/pkg/models"
+)
+
+func TestPlaylistFileFormats(t *testing.T) {
+	doc := playlistfile.Playlist{
+		Title: "Road Trip",
+		Entries: []playlistfile.Entry{
+			{Location: "Artist/Album/01 Song.flac", Artist: "Artist", Title: "Song", Duration: 240},
+			{Location: "http://host:8080/stream/7", Title: "Untitled"},
+			{Location: "Other/Ünïcode & more.mp3", Artist: "A & B", Title: "C", Duration: 5},
+		},
+	}
+	for _, format := range []playlistfile.Format{playlistfile.M3U8, playlistfile.PLS, playlistfile.XSPF} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := playlistfile.Write(&buf, format, doc); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if got := playlistfile.Sniff(buf.Bytes()); got != format {
+				t.Errorf("Sniff = %s, want %s", got, format)
+			}
+			parsed, err := playlistfile.Parse(&buf, format)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if parsed.Title != doc.Title || len(parsed.Entries) != len(doc.Entries) {
+				t.Fatalf("Parsed %q with %d entries, want %q with %d", parsed.Title, len(parsed.Entries), doc.Title, len(doc.Entries))
+			}
+			for i, want := range doc.Entries {
+				got := parsed.Entries[i]
+				if got.Location != want.Location || got.Title != want.Title || got.Duration != want.Duration {
+					t.Errorf("Entry %d = %+v, want %+v", i, got, want)
+				}
+				if got.Line == 0 {
+					t.Errorf("Entry %d has no line number", i)
+				}
+			}
+		})
+	}
+
+	t.Run("ForeignM3U", func(t *testing.T) {
+		data := "\ufeff#EXTM3U\r\n#EXTINF:185 tvg-id=\"x\",Band - Tune\r\nC:\\Users\\me\\Music\\Band\\Tune.mp3\r\n\r\n# comment\r\nplain.mp3\r\n"
+		parsed, err := playlistfile.Parse(strings.NewReader(data), playlistfile.M3U8)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		want := []playlistfile.Entry{
+			{Location: `C:\Users\me\Music\Band\Tune.mp3`, Artist: "Band", Title: "Tune", Duration: 185, Line: 3},
+			{Location: "plain.mp3", Line: 6},
+		}
+		if len(parsed.Entries) != len(want) {
+			t.Fatalf("Parsed %d entries, want %d", len(parsed.Entries), len(want))
+		}
+		for i := range want {
+			if parsed.Entries[i] != want[i] {
+				t.Errorf("Entry %d = %+v, want %+v", i, parsed.Entries[i], want[i])
+			}
+		}
+	})
+
+	if _, err := playlistfile.Parse(strings.NewReader("<playlist><trackList><track>"), playlistfile.XSPF); err == nil {
+		t.Error("Expected an error for truncated XSPF")
+	}
+	if f, ok := playlistfile.FormatOf("Mix.M3U"); !ok || f != playlistfile.M3U8 {
+		t.Errorf("FormatOf(Mix.M3U) = %s, %v", f, ok)
+	}
+}
+
+func TestPlaylistFileImportExport(t *testing.T) {
+	root := t.TempDir()
+	db, err := database.NewDatabase(filepath.Join(root, "files.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	music := filepath.Join(root, "music")
+	for _, track := range []models.Track{
+		{Title: "Song", Artist: "Artist", Duration: 240, FilePath: filepath.Join(music, "Artist", "Album", "01 Song.flac")},
+		{Title: "Tune", Artist: "Band", Duration: 185, FilePath: filepath.Join(music, "Band", "x.mp3")},
+		{Title: "Solo", Artist: "Someone", Duration: 100, FilePath: filepath.Join(music, "Someone", "solo.mp3")},
+		{Title: "Mine", Artist: "Me", Duration: 100, FilePath: filepath.Join(root, "users", "alice", "mine.mp3"), Owner: "alice"},
+	} {
+		if _, err := db.InsertTrack(track); err != nil {
+			t.Fatalf("Failed to insert track: %v", err)
+		}
+	}
+	files := library.NewPlaylistFiles(db, library.NewRoots(nil, music))
+
+	playlist := "#EXTM3U\n" +
+		"#EXTINF:240,Artist - Song\n/home/old/Music/Artist/Album/01 Song.flac\n" +
+		"#EXTINF:186,Band - Tune\nD:\\elsewhere\\renamed.mp3\n" +
+		"Someone - Solo.mp3\n" +
+		"http://old-server/stream/999\n" +
+		"#EXTINF:100,Me - Mine\nmine.mp3\n"
+	report, err := files.Import(strings.NewReader(playlist), playlistfile.M3U8,
+		models.Playlist{Visibility: models.VisibilityPrivate}, "Car.m3u8", database.StatsScope{})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.Playlist.Name != "Car" || report.Entries != 5 {
+		t.Errorf("Imported %q with %d entries, want Car with 5", report.Playlist.Name, report.Entries)
+	}
+	if report.MatchedByPath != 1 || report.MatchedByTags != 2 {
+		t.Errorf("Matched %d by path and %d by tags, want 1 and 2", report.MatchedByPath, report.MatchedByTags)
+	}
+	// The stream URL names no track and alice's track is outside the main library
+	if len(report.Unmatched) != 2 || report.Unmatched[0].Line != 7 || report.Unmatched[1].Title != "Mine" {
+		t.Errorf("Unmatched = %+v, want lines 7 and 9", report.Unmatched)
+	}
+	if got := entryTitles(t, db, report.Playlist.ID); got != "Song Tune Solo" {
+		t.Errorf("Imported entries = %q, want %q", got, "Song Tune Solo")
+	}
+
+	tracks, _ := db.GetPlaylistTracks(report.Playlist.ID)
+	var buf bytes.Buffer
+	if err := files.Export(&buf, playlistfile.M3U8, "Car", tracks, ""); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "#EXTINF:240,Artist - Song\nArtist/Album/01 Song.flac\n") {
+		t.Errorf("Export with paths = %q", buf.String())
+	}
+	buf.Reset()
+	if err := files.Export(&buf, playlistfile.PLS, "Car", tracks, "https://music.example"); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	// Stream URLs of this server resolve back to their tracks
+	report, err = files.Import(&buf, playlistfile.PLS, models.Playlist{Name: "Again", Visibility: models.VisibilityPrivate}, "", database.StatsScope{})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.MatchedByPath != 3 || len(report.Unmatched) != 0 || report.Playlist.Name != "Again" {
+		t.Errorf("Reimport report = %+v", report)
+	}
+}
+
+func TestPlaylistFileSync(t *testing.T) {
+	root := t.TempDir()
+	db, err := database.NewDatabase(filepath.Join(root, "sync.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	music := filepath.Join(root, "music")
+	ids := map[string]int{}
+	for _, track := range []models.Track{
+		{Title: "One", Artist: "A", FilePath: filepath.Join(music, "A", "one.mp3")},
+		{Title: "Two", Artist: "A", FilePath: filepath.Join(music, "A", "two.mp3")},
+		{Title: "Three", Artist: "B", FilePath: filepath.Join(music, "B", "three.mp3")},
+	} {
+		id, err := db.InsertTrack(track)
+		if err != nil {
+			t.Fatalf("Failed to insert track: %v", err)
+		}
+		ids[track.Title] = id
+	}
+	files := library.NewPlaylistFiles(db, library.NewRoots(nil, music))
+
+	listPath := filepath.Join(music, "Lists", "Mix.m3u")
+	if err := os.MkdirAll(filepath.Dir(listPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(listPath, []byte("../A/two.mp3\n../B/three.mp3\nmissing.mp3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	playlist, changed, err := files.SyncFile(listPath)
+	if err != nil {
+		t.Fatalf("SyncFile failed: %v", err)
+	}
+	if !changed || !playlist.FileBacked || playlist.Name != "Mix" || playlist.Visibility != models.VisibilityPublic {
+		t.Errorf("Synced playlist = %+v, changed %v", playlist, changed)
+	}
+	if got := entryTitles(t, db, playlist.ID); got != "Two Three" {
+		t.Errorf("Synced entries = %q, want %q", got, "Two Three")
+	}
+
+	// Syncing an unchanged file changes nothing; an edited one refills the playlist
+	if _, changed, _ = files.SyncFile(listPath); changed {
+		t.Error("Resyncing an unchanged file reported a change")
+	}
+	os.WriteFile(listPath, []byte("#EXTM3U\n#PLAYLIST:Road\n../A/one.mp3\n#EXTINF:60,Gone - Lost\nlost.mp3\n../B/three.mp3\n"), 0644)
+	if again, changed, err := files.SyncFile(listPath); err != nil || !changed || again.ID != playlist.ID || again.Name != "Road" {
+		t.Errorf("Resync = %+v, %v, %v", again, changed, err)
+	}
+	if got := entryTitles(t, db, playlist.ID); got != "One Three" {
+		t.Errorf("Resynced entries = %q, want %q", got, "One Three")
+	}
+
+	// Edits are written back relative to the file, keeping unmatched entries
+	// in place, and read back unchanged
+	if err := db.AddTrackToPlaylist(playlist.ID, ids["Two"], ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := files.WriteBack(playlist.ID); err != nil {
+		t.Fatalf("WriteBack failed: %v", err)
+	}
+	data, _ := os.ReadFile(listPath)
+	if !strings.Contains(string(data), "#PLAYLIST:Road\n") ||
+		!strings.Contains(string(data), "\n../A/one.mp3\n#EXTINF:60,Gone - Lost\nlost.mp3\n") ||
+		!strings.HasSuffix(string(data), "\n../A/two.mp3\n") {
+		t.Errorf("Written file = %q", data)
+	}
+	if _, changed, _ = files.SyncFile(listPath); changed {
+		t.Error("Resyncing a written-back file reported a change")
+	}
+
+	// Playlists of deleted files are pruned
+	os.Remove(listPath)
+	if removed, err := files.Prune(music); err != nil || removed != 1 {
+		t.Errorf("Prune = %d, %v, want 1", removed, err)
+	}
+	if _, err := db.GetPlaylist(playlist.ID); err == nil {
+		t.Error("Playlist of a deleted file was kept")
+	}
+}
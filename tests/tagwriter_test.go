@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"staccato/internal/metadata"
+
+	"github.com/dhowden/tag"
+)
+
+// testAudioPayload stands in for encoded audio; writers must copy it verbatim.
+var testAudioPayload = bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64, 0x01, 0x02, 0x03, 0x04}, 256)
+
+func mp4TestAtom(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], kind)
+	return append(out, body...)
+}
+
+// buildTestM4A creates ftyp + moov (with an stco pointing at the audio) +
+// mdat, mirroring a "fast start" file whose chunk offsets follow moov.
+func buildTestM4A(t *testing.T, path string) {
+	t.Helper()
+	ftyp := mp4TestAtom("ftyp", []byte("M4A \x00\x00\x00\x00M4A isom"))
+	stco := func(offset uint32) []byte {
+		b := make([]byte, 12)
+		binary.BigEndian.PutUint32(b[4:], 1)
+		binary.BigEndian.PutUint32(b[8:], offset)
+		return mp4TestAtom("stco", b)
+	}
+	build := func(offset uint32) []byte {
+		return mp4TestAtom("moov", mp4TestAtom("trak", mp4TestAtom("mdia", mp4TestAtom("minf", mp4TestAtom("stbl", stco(offset))))))
+	}
+	moovLen := len(build(0))
+	offset := uint32(len(ftyp) + moovLen + 8)
+	file := bytes.Join([][]byte{ftyp, build(offset), mp4TestAtom("mdat", testAudioPayload)}, nil)
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatalf("Failed to write test M4A: %v", err)
+	}
+}
+
+// buildTestMP3 creates an untagged "MP3" consisting of the audio payload.
+func buildTestMP3(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, testAudioPayload, 0644); err != nil {
+		t.Fatalf("Failed to write test MP3: %v", err)
+	}
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		img.Set(x, x, color.RGBA{R: 200, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readTestTags(t *testing.T, path string) tag.Metadata {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	m, err := tag.ReadFrom(f)
+	if err != nil {
+		t.Fatalf("Failed to read tags from %s: %v", path, err)
+	}
+	return m
+}
+
+func TestWriteTags(t *testing.T) {
+	cover := testJPEG(t)
+	builders := map[string]func(*testing.T, string){
+		"song.mp3":  buildTestMP3,
+		"song.flac": func(t *testing.T, path string) { writeTestFLAC(t, path, synthMelody(1, 1, 8000, 1, 0), 8000) },
+		"song.m4a":  buildTestM4A,
+	}
+
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			build(t, path)
+			audio := testAudioPayload
+			if filepath.Ext(path) == ".flac" {
+				original, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("Failed to read file: %v", err)
+				}
+				audio = original[4+4+34:] // the frames after fLaC and STREAMINFO
+			}
+
+			title, artist, album, trackNum := "Héllo Wörld", "The Artist", "First Album", 3
+			err := metadata.WriteTags(path, metadata.TagUpdate{
+				Title: &title, Artist: &artist, Album: &album, TrackNumber: &trackNum, Picture: cover,
+			})
+			if err != nil {
+				t.Fatalf("WriteTags failed: %v", err)
+			}
+
+			// A second edit must keep untouched fields.
+			artist = "Fixed Artist"
+			if err := metadata.WriteTags(path, metadata.TagUpdate{Artist: &artist}); err != nil {
+				t.Fatalf("Second WriteTags failed: %v", err)
+			}
+
+			m := readTestTags(t, path)
+			if m.Title() != title || m.Artist() != artist || m.Album() != album {
+				t.Errorf("Unexpected tags %q / %q / %q", m.Title(), m.Artist(), m.Album())
+			}
+			if n, _ := m.Track(); n != trackNum {
+				t.Errorf("Expected track number %d, got %d", trackNum, n)
+			}
+			if pic := m.Picture(); pic == nil || !bytes.Equal(pic.Data, cover) {
+				t.Error("Expected album art to be written")
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read file: %v", err)
+			}
+			if !bytes.HasSuffix(data, audio) {
+				t.Error("Audio data was not preserved")
+			}
+			if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*tagtmp*")); len(leftovers) > 0 {
+				t.Errorf("Temporary files left behind: %v", leftovers)
+			}
+		})
+	}
+
+	t.Run("ChunkOffsetsShifted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "offsets.m4a")
+		buildTestM4A(t, path)
+		title := "Shifted"
+		if err := metadata.WriteTags(path, metadata.TagUpdate{Title: &title}); err != nil {
+			t.Fatalf("WriteTags failed: %v", err)
+		}
+		data, _ := os.ReadFile(path)
+		idx := bytes.Index(data, []byte("stco"))
+		if idx < 0 {
+			t.Fatal("stco atom missing after rewrite")
+		}
+		offset := binary.BigEndian.Uint32(data[idx+12:])
+		if int(offset)+len(testAudioPayload) > len(data) || !bytes.Equal(data[offset:int(offset)+len(testAudioPayload)], testAudioPayload) {
+			t.Errorf("Chunk offset %d no longer points at the audio data", offset)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		bad := "line\nbreak"
+		if err := metadata.WriteTags("song.mp3", metadata.TagUpdate{Title: &bad}); err == nil {
+			t.Error("Expected error for title containing a newline")
+		}
+		if err := metadata.WriteTags("song.mp3", metadata.TagUpdate{Picture: []byte("GIF89a")}); err == nil {
+			t.Error("Expected error for non JPEG/PNG album art")
+		}
+		title := "x"
+		if err := metadata.WriteTags("song.wav", metadata.TagUpdate{Title: &title}); err != metadata.ErrTagWriteUnsupported {
+			t.Errorf("Expected ErrTagWriteUnsupported for WAV, got %v", err)
+		}
+	})
+}